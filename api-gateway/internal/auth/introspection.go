@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gauss2302/microtest/pkg/correlation"
+	"github.com/gauss2302/microtest/pkg/telemetry"
+)
+
+// ErrInvalidToken is returned for tokens that are unknown or expired.
+var ErrInvalidToken = errors.New("invalid token")
+
+// Identity is who a valid token was issued to. Tokens from the client
+// credentials grant have a ClientID but no user.
+type Identity struct {
+	UserID   int
+	Roles    []string
+	ClientID string
+	Scopes   []string
+}
+
+type Validator interface {
+	Validate(ctx context.Context, token string) (*Identity, error)
+}
+
+// introspectionResponse is the part of auth-service's RFC 7662 response
+// the gateway uses.
+type introspectionResponse struct {
+	Active    bool     `json:"active"`
+	UserID    int      `json:"user_id"`
+	Roles     []string `json:"roles"`
+	ClientID  string   `json:"client_id"`
+	Scope     string   `json:"scope"`
+	ExpiresAt int64    `json:"exp"`
+}
+
+type cacheEntry struct {
+	identity  *Identity // nil for invalid tokens
+	expiresAt time.Time
+}
+
+// maxCacheEntries bounds the cache; expired entries are dropped when it
+// is reached, and everything if that is not enough.
+const maxCacheEntries = 10000
+
+// Introspector validates tokens through auth-service's introspection
+// endpoint, authenticating as an OAuth client. Answers are cached for
+// cacheTTL, so a revoked token keeps working at the gateway for up to that
+// long.
+type Introspector struct {
+	introspectionURL string
+	clientID         string
+	clientSecret     string
+	httpClient       *http.Client
+	cacheTTL         time.Duration
+
+	mutex sync.Mutex
+	cache map[[sha256.Size]byte]cacheEntry
+	now   func() time.Time
+}
+
+func NewIntrospector(authServiceURL, clientID, clientSecret string, cacheTTL time.Duration) *Introspector {
+	return &Introspector{
+		introspectionURL: strings.TrimSuffix(authServiceURL, "/") + "/auth/introspect",
+		clientID:         clientID,
+		clientSecret:     clientSecret,
+		httpClient:       &http.Client{Timeout: 5 * time.Second, Transport: telemetry.Transport(nil)},
+		cacheTTL:         cacheTTL,
+		cache:            make(map[[sha256.Size]byte]cacheEntry),
+		now:              time.Now,
+	}
+}
+
+func (i *Introspector) Validate(ctx context.Context, token string) (*Identity, error) {
+	// Tokens are kept hashed so a memory dump does not leak them
+	key := sha256.Sum256([]byte(token))
+
+	if identity, ok := i.cached(key); ok {
+		if identity == nil {
+			return nil, ErrInvalidToken
+		}
+		return identity, nil
+	}
+
+	response, err := i.introspect(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	var identity *Identity
+	expiresAt := i.now().Add(i.cacheTTL)
+	if response.Active {
+		identity = &Identity{
+			UserID:   response.UserID,
+			Roles:    response.Roles,
+			ClientID: response.ClientID,
+			Scopes:   strings.Fields(response.Scope),
+		}
+		if response.ExpiresAt != 0 {
+			if tokenExpiry := time.Unix(response.ExpiresAt, 0); tokenExpiry.Before(expiresAt) {
+				expiresAt = tokenExpiry
+			}
+		}
+	}
+	i.store(key, cacheEntry{identity: identity, expiresAt: expiresAt})
+
+	if identity == nil {
+		return nil, ErrInvalidToken
+	}
+	return identity, nil
+}
+
+func (i *Introspector) introspect(ctx context.Context, token string) (*introspectionResponse, error) {
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		i.introspectionURL,
+		strings.NewReader(url.Values{"token": {token}}.Encode()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(i.clientID, i.clientSecret)
+	correlation.Inject(ctx, req.Header)
+
+	resp, err := i.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("introspection failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("introspection failed: status %d", resp.StatusCode)
+	}
+
+	var response introspectionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("invalid introspection response: %w", err)
+	}
+	return &response, nil
+}
+
+func (i *Introspector) cached(key [sha256.Size]byte) (*Identity, bool) {
+	if i.cacheTTL <= 0 {
+		return nil, false
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	entry, ok := i.cache[key]
+	if !ok || !i.now().Before(entry.expiresAt) {
+		return nil, false
+	}
+	return entry.identity, true
+}
+
+func (i *Introspector) store(key [sha256.Size]byte, entry cacheEntry) {
+	if i.cacheTTL <= 0 {
+		return
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if len(i.cache) >= maxCacheEntries {
+		now := i.now()
+		for k, e := range i.cache {
+			if !now.Before(e.expiresAt) {
+				delete(i.cache, k)
+			}
+		}
+		if len(i.cache) >= maxCacheEntries {
+			i.cache = make(map[[sha256.Size]byte]cacheEntry)
+		}
+	}
+	i.cache[key] = entry
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gauss2302/microtest/pkg/logging"
+	"github.com/gauss2302/microtest/pkg/respond"
+)
+
+// Headers the gateway sets for upstreams. Values sent by clients are
+// always removed, so upstreams can trust them.
+const (
+	HeaderUserID    = "X-User-ID"
+	HeaderUserRoles = "X-User-Roles"
+	HeaderClientID  = "X-Client-ID"
+	HeaderScopes    = "X-Token-Scopes"
+)
+
+var identityHeaders = []string{HeaderUserID, HeaderUserRoles, HeaderClientID, HeaderScopes}
+
+// Authenticate enforces policy on requests before they are proxied.
+// Protected routes need a bearer token that validator accepts; the
+// identity it belongs to is passed on in the identity headers. Internal
+// routes are answered with 404 as if they did not exist.
+func Authenticate(policy *Policy, validator Validator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, header := range identityHeaders {
+				r.Header.Del(header)
+			}
+
+			switch policy.Access(r.Method, r.URL.Path) {
+			case Public:
+				next.ServeHTTP(w, r)
+				return
+			case Internal:
+				http.NotFound(w, r)
+				return
+			}
+
+			token := bearerToken(r)
+			if token == "" {
+				w.Header().Set("WWW-Authenticate", `Bearer`)
+				respond.Error(w, r, http.StatusUnauthorized, "Authorization required")
+				return
+			}
+
+			identity, err := validator.Validate(r.Context(), token)
+			if errors.Is(err, ErrInvalidToken) {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				respond.Error(w, r, http.StatusUnauthorized, "Invalid token")
+				return
+			}
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Token validation failed", "error", err)
+				respond.Error(w, r, http.StatusServiceUnavailable, "Authentication unavailable")
+				return
+			}
+
+			if identity.UserID != 0 {
+				r.Header.Set(HeaderUserID, strconv.Itoa(identity.UserID))
+				logging.SetUserID(r.Context(), strconv.Itoa(identity.UserID))
+				r.Header.Set(HeaderUserRoles, strings.Join(identity.Roles, ","))
+			}
+			if identity.ClientID != "" {
+				r.Header.Set(HeaderClientID, identity.ClientID)
+			}
+			if len(identity.Scopes) > 0 {
+				r.Header.Set(HeaderScopes, strings.Join(identity.Scopes, " "))
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newAuthService fakes auth-service's introspection endpoint, which knows
+// a single user token.
+func newAuthService(t *testing.T, calls *atomic.Int32) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if clientID, clientSecret, ok := r.BasicAuth(); !ok || clientID != "api-gateway" || clientSecret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		response := introspectionResponse{}
+		if r.URL.Path == "/auth/introspect" && r.PostFormValue("token") == "valid" {
+			response = introspectionResponse{
+				Active:    true,
+				UserID:    7,
+				Roles:     []string{"user", "admin"},
+				ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			}
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestAuthenticate(t *testing.T) {
+	var calls atomic.Int32
+	authService := newAuthService(t, &calls)
+
+	var upstream http.Header
+	handler := Authenticate(testPolicy(), NewIntrospector(authService.URL, "api-gateway", "s3cret", time.Minute))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			upstream = r.Header.Clone()
+		}),
+	)
+
+	request := func(method, path, token string) *httptest.ResponseRecorder {
+		upstream = nil
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set(HeaderUserID, "1")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("public route skips validation", func(t *testing.T) {
+		rec := request(http.MethodGet, "/products/1", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, upstream.Get(HeaderUserID), "spoofed identity is stripped")
+	})
+
+	t.Run("protected route needs a token", func(t *testing.T) {
+		rec := request(http.MethodPost, "/products", "")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Equal(t, "Bearer", rec.Header().Get("WWW-Authenticate"))
+		assert.Nil(t, upstream)
+	})
+
+	t.Run("invalid token is rejected", func(t *testing.T) {
+		rec := request(http.MethodPost, "/products", "forged")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Contains(t, rec.Header().Get("WWW-Authenticate"), "invalid_token")
+	})
+
+	t.Run("valid token passes identity on", func(t *testing.T) {
+		calls.Store(0)
+		rec := request(http.MethodPost, "/products", "valid")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "7", upstream.Get(HeaderUserID))
+		assert.Equal(t, "user,admin", upstream.Get(HeaderUserRoles))
+
+		// The answer is cached
+		request(http.MethodPut, "/products/1", "valid")
+		assert.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("internal route is hidden", func(t *testing.T) {
+		rec := request(http.MethodPost, "/users/identities", "valid")
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Nil(t, upstream)
+	})
+
+	t.Run("auth-service outage", func(t *testing.T) {
+		handler := Authenticate(testPolicy(), NewIntrospector("http://127.0.0.1:1", "api-gateway", "s3cret", time.Minute))(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		)
+		req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+		req.Header.Set("Authorization", "Bearer valid")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	})
+}
+
+func TestIntrospector_CacheExpiry(t *testing.T) {
+	var calls atomic.Int32
+	authService := newAuthService(t, &calls)
+
+	now := time.Now()
+	introspector := NewIntrospector(authService.URL, "api-gateway", "s3cret", time.Minute)
+	introspector.now = func() time.Time { return now }
+
+	_, err := introspector.Validate(context.Background(), "forged")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, err = introspector.Validate(context.Background(), "forged")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.Equal(t, int32(1), calls.Load())
+
+	now = now.Add(2 * time.Minute)
+	_, err = introspector.Validate(context.Background(), "forged")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.Equal(t, int32(2), calls.Load())
+}
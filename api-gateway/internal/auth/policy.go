@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Access says who may call a route through the gateway.
+type Access int
+
+const (
+	// Protected routes need a valid bearer token
+	Protected Access = iota
+	// Public routes are forwarded without checking credentials
+	Public
+	// Internal routes are only for service-to-service calls and are not
+	// reachable through the gateway at all
+	Internal
+)
+
+func (a Access) String() string {
+	switch a {
+	case Public:
+		return "public"
+	case Internal:
+		return "internal"
+	default:
+		return "protected"
+	}
+}
+
+// ParseAccess reads an access level as written by String.
+func ParseAccess(s string) (Access, error) {
+	switch strings.ToLower(s) {
+	case "public":
+		return Public, nil
+	case "protected":
+		return Protected, nil
+	case "internal":
+		return Internal, nil
+	default:
+		return Protected, fmt.Errorf("unknown access %q", s)
+	}
+}
+
+// Rule sets the access to requests matching Methods and Path. No methods
+// means any method. In Path, "*" matches a single segment and a trailing
+// "/**" matches the prefix itself and everything below it.
+type Rule struct {
+	Methods []string
+	Path    string
+	Access  Access
+}
+
+func (r Rule) matches(method, path string) bool {
+	if len(r.Methods) > 0 {
+		found := false
+		for _, m := range r.Methods {
+			if strings.EqualFold(m, method) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return matchPath(r.Path, path)
+}
+
+// Policy is an ordered route table; the first matching rule wins and
+// unmatched routes are protected.
+type Policy struct {
+	Rules []Rule
+}
+
+func (p *Policy) Access(method, path string) Access {
+	path = strings.TrimSuffix(path, "/")
+	if path == "" {
+		path = "/"
+	}
+	for _, rule := range p.Rules {
+		if rule.matches(method, path) {
+			return rule.Access
+		}
+	}
+	return Protected
+}
+
+func matchPath(pattern, path string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "/**"); ok {
+		patternSegments := strings.Split(prefix, "/")
+		pathSegments := strings.Split(path, "/")
+		return len(pathSegments) >= len(patternSegments) &&
+			matchSegments(patternSegments, pathSegments[:len(patternSegments)])
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(path, "/"))
+}
+
+func matchSegments(pattern, path []string) bool {
+	if len(pattern) != len(path) {
+		return false
+	}
+	for i := range pattern {
+		if pattern[i] != "*" && pattern[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testPolicy mirrors the policy of the shipped route configuration.
+func testPolicy() *Policy {
+	return &Policy{Rules: []Rule{
+		{Path: "/auth/**", Access: Public},
+		{Methods: []string{"GET", "HEAD"}, Path: "/products", Access: Public},
+		{Methods: []string{"GET", "HEAD"}, Path: "/products/*", Access: Public},
+		{Path: "/users/identities", Access: Internal},
+		{Path: "/users/verify", Access: Internal},
+		{Path: "/users/*/mfa", Access: Internal},
+		{Path: "/users/*/verify-email", Access: Internal},
+	}}
+}
+
+func TestPolicy_Access(t *testing.T) {
+	policy := testPolicy()
+
+	tests := []struct {
+		method string
+		path   string
+		want   Access
+	}{
+		{"POST", "/auth/login", Public},
+		{"GET", "/auth/oidc/google/start", Public},
+		{"GET", "/products", Public},
+		{"GET", "/products/", Public},
+		{"GET", "/products/5", Public},
+		{"POST", "/products", Protected},
+		{"DELETE", "/products/5", Protected},
+		{"GET", "/users/5", Protected},
+		{"POST", "/users/verify", Internal},
+		{"POST", "/users/identities", Internal},
+		{"GET", "/users/5/mfa", Internal},
+		{"POST", "/users/5/verify-email/", Internal},
+		{"POST", "/payment", Protected},
+		{"GET", "/authx", Protected},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, policy.Access(tt.method, tt.path), "%s %s", tt.method, tt.path)
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	assert.True(t, matchPath("/auth/**", "/auth"))
+	assert.True(t, matchPath("/auth/**", "/auth/a/b"))
+	assert.False(t, matchPath("/auth/**", "/author"))
+	assert.True(t, matchPath("/users/*/mfa/**", "/users/1/mfa"))
+	assert.True(t, matchPath("/users/*/mfa/**", "/users/1/mfa/recovery-codes/x"))
+	assert.True(t, matchPath("/users/*/mfa", "/users/1/mfa"))
+	assert.False(t, matchPath("/users/*/mfa", "/users/1/2/mfa"))
+	assert.False(t, matchPath("/users/*", "/users"))
+}
+
+func TestParseAccess(t *testing.T) {
+	for _, access := range []Access{Public, Protected, Internal} {
+		parsed, err := ParseAccess(access.String())
+		assert.NoError(t, err)
+		assert.Equal(t, access, parsed)
+	}
+
+	_, err := ParseAccess("open")
+	assert.Error(t, err)
+}
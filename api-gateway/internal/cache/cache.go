@@ -0,0 +1,227 @@
+// Package cache is a shared HTTP cache for the gateway's public GET
+// routes. It follows Cache-Control, ETag and Vary, keeps entries in memory
+// within a byte budget, and lets only one of several concurrent requests
+// for a missing entry through to the upstream.
+package cache
+
+import (
+	"container/list"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type entry struct {
+	key string
+	// path is the request path, for purging
+	path      string
+	status    int
+	header    http.Header
+	body      []byte
+	storedAt  time.Time
+	expiresAt time.Time
+	size      int64
+}
+
+func (e *entry) fresh(now time.Time) bool {
+	return now.Before(e.expiresAt)
+}
+
+// Cache holds responses in least recently used order and evicts the
+// oldest when it outgrows maxBytes.
+type Cache struct {
+	maxBytes      int64
+	maxEntryBytes int64
+
+	mutex   sync.Mutex
+	size    int64
+	lru     *list.List // of *entry, most recent first
+	entries map[string]*list.Element
+	// varies holds the request headers named by Vary for each URL
+	varies  map[string][]string
+	flights map[string]*flight
+	now     func() time.Time
+}
+
+// New creates a cache of at most maxBytes that stores no response larger
+// than maxEntryBytes.
+func New(maxBytes, maxEntryBytes int64) *Cache {
+	return &Cache{
+		maxBytes:      maxBytes,
+		maxEntryBytes: maxEntryBytes,
+		lru:           list.New(),
+		entries:       make(map[string]*list.Element),
+		varies:        make(map[string][]string),
+		flights:       make(map[string]*flight),
+		now:           time.Now,
+	}
+}
+
+// key identifies the variant of the resource that r asks for.
+func (c *Cache) key(r *http.Request) string {
+	base := r.URL.RequestURI()
+
+	c.mutex.Lock()
+	vary := c.varies[base]
+	c.mutex.Unlock()
+
+	return variantKey(base, vary, r.Header)
+}
+
+func variantKey(base string, vary []string, header http.Header) string {
+	if len(vary) == 0 {
+		return base
+	}
+	var key strings.Builder
+	key.WriteString(base)
+	for _, name := range vary {
+		key.WriteString("\n")
+		key.WriteString(name)
+		key.WriteString(": ")
+		key.WriteString(strings.Join(header.Values(name), ","))
+	}
+	return key.String()
+}
+
+func (c *Cache) get(key string) *entry {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(element)
+	return element.Value.(*entry)
+}
+
+// store adds e as the response to r, which was sent with the varying
+// headers in vary.
+func (c *Cache) store(r *http.Request, vary []string, e *entry) {
+	base := r.URL.RequestURI()
+	e.key = variantKey(base, vary, r.Header)
+	e.path = r.URL.Path
+	e.size = int64(len(e.key) + len(e.body))
+	for name, values := range e.header {
+		e.size += int64(len(name))
+		for _, value := range values {
+			e.size += int64(len(value))
+		}
+	}
+	if e.size > c.maxEntryBytes || e.size > c.maxBytes {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !equalVary(c.varies[base], vary) {
+		// The variants stored so far were keyed differently
+		c.removeMatching(func(old *entry) bool { return strings.HasPrefix(old.key, base) })
+		if len(vary) > 0 {
+			c.varies[base] = vary
+		} else {
+			delete(c.varies, base)
+		}
+	}
+
+	if element, ok := c.entries[e.key]; ok {
+		c.remove(element)
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	c.size += e.size
+
+	for c.size > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+// refresh replaces a revalidated entry with one that has the freshness of
+// the 304. Entries are never changed once stored.
+func (c *Cache) refresh(e *entry, expiresAt time.Time) *entry {
+	refreshed := *e
+	refreshed.storedAt = c.now()
+	refreshed.expiresAt = expiresAt
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, ok := c.entries[e.key]; ok && element.Value == e {
+		element.Value = &refreshed
+	}
+	return &refreshed
+}
+
+// Purge removes the entries for path, or for every path beneath it too
+// when prefix is set. It returns how many were removed.
+func (c *Cache) Purge(path string, prefix bool) int {
+	path = strings.TrimSuffix(path, "/")
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.removeMatching(func(e *entry) bool {
+		entryPath := strings.TrimSuffix(e.path, "/")
+		return entryPath == path || (prefix && strings.HasPrefix(entryPath, path+"/"))
+	})
+}
+
+// Stats reports the number of entries and bytes used.
+func (c *Cache) Stats() (entries int, bytes int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return len(c.entries), c.size
+}
+
+func (c *Cache) removeMatching(match func(*entry) bool) int {
+	removed := 0
+	for element := c.lru.Front(); element != nil; {
+		next := element.Next()
+		if match(element.Value.(*entry)) {
+			c.remove(element)
+			removed++
+		}
+		element = next
+	}
+	return removed
+}
+
+func (c *Cache) remove(element *list.Element) {
+	e := c.lru.Remove(element).(*entry)
+	delete(c.entries, e.key)
+	c.size -= e.size
+}
+
+// varyHeaders returns the canonical header names a response varies on, or
+// false if it varies on everything.
+func varyHeaders(header http.Header) ([]string, bool) {
+	var names []string
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" {
+				return nil, false
+			}
+			if name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, true
+}
+
+func equalVary(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
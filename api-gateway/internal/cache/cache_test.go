@@ -0,0 +1,278 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingUpstream answers with the number of requests it has seen,
+// setting header on every response.
+func countingUpstream(header http.Header) (http.Handler, *atomic.Int32) {
+	var calls atomic.Int32
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		for name, values := range header {
+			w.Header()[name] = values
+		}
+		fmt.Fprintf(w, "response %d for %s", n, r.Header.Get("Accept-Language"))
+	}), &calls
+}
+
+func get(handler http.Handler, path string, header http.Header) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Run("fresh responses are shared", func(t *testing.T) {
+		c := New(1<<20, 1<<10)
+		upstream, calls := countingUpstream(nil)
+		handler := c.Middleware(time.Minute)(upstream)
+
+		first := get(handler, "/products?page=1", nil)
+		assert.Equal(t, statusMiss, first.Header().Get("X-Cache"))
+		second := get(handler, "/products?page=1", nil)
+		assert.Equal(t, statusHit, second.Header().Get("X-Cache"))
+		assert.Equal(t, first.Body.String(), second.Body.String())
+		assert.Equal(t, "0", second.Header().Get("Age"))
+
+		get(handler, "/products?page=2", nil)
+		assert.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("hits keep the headers of their own request", func(t *testing.T) {
+		c := New(1<<20, 1<<10)
+		cached := c.Middleware(time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Request-ID", r.Header.Get("X-Request-ID"))
+			w.Header().Set("Date", "Mon, 19 Oct 2026 10:00:00 GMT")
+			w.Header().Set("Connection", "X-Upstream-Hop")
+			w.Header().Set("X-Upstream-Hop", "1")
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte("product"))
+		}))
+		// The gateway sets the request ID before the cache sees the request
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Request-ID", r.Header.Get("X-Request-ID"))
+			cached.ServeHTTP(w, r)
+		})
+
+		get(handler, "/products", http.Header{"X-Request-Id": {"first"}})
+		rec := get(handler, "/products", http.Header{"X-Request-Id": {"second"}})
+		assert.Equal(t, statusHit, rec.Header().Get("X-Cache"))
+		assert.Equal(t, "second", rec.Header().Get("X-Request-ID"))
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+		assert.Empty(t, rec.Header().Get("Date"))
+		assert.Empty(t, rec.Header().Get("Connection"))
+		assert.Empty(t, rec.Header().Get("X-Upstream-Hop"))
+	})
+
+	t.Run("authorized and no-store requests bypass the cache", func(t *testing.T) {
+		c := New(1<<20, 1<<10)
+		upstream, calls := countingUpstream(nil)
+		handler := c.Middleware(time.Minute)(upstream)
+
+		get(handler, "/products", http.Header{"Authorization": {"Bearer token"}})
+		get(handler, "/products", http.Header{"Cache-Control": {"no-store"}})
+		assert.Equal(t, int32(2), calls.Load())
+		entries, _ := c.Stats()
+		assert.Zero(t, entries)
+	})
+
+	t.Run("cache-control decides freshness", func(t *testing.T) {
+		c := New(1<<20, 1<<10)
+		now := time.Now()
+		c.now = func() time.Time { return now }
+
+		for _, test := range []struct {
+			header http.Header
+			stored bool
+			ttl    time.Duration
+		}{
+			{http.Header{}, true, time.Minute},
+			{http.Header{"Cache-Control": {"max-age=10"}}, true, 10 * time.Second},
+			{http.Header{"Cache-Control": {"max-age=10, s-maxage=20"}}, true, 20 * time.Second},
+			{http.Header{"Cache-Control": {"private, max-age=10"}}, false, 0},
+			{http.Header{"Cache-Control": {"no-store"}}, false, 0},
+			{http.Header{"Set-Cookie": {"session=1"}}, false, 0},
+			{http.Header{"Vary": {"*"}}, false, 0},
+		} {
+			e := &entry{status: http.StatusOK, header: test.header}
+			_, stored := c.storable(e, now, time.Minute)
+			assert.Equal(t, test.stored, stored, "%v", test.header)
+			if stored {
+				assert.Equal(t, now.Add(test.ttl), e.expiresAt, "%v", test.header)
+			}
+		}
+	})
+
+	t.Run("stale entries are revalidated", func(t *testing.T) {
+		c := New(1<<20, 1<<10)
+		now := time.Now()
+		c.now = func() time.Time { return now }
+
+		var calls int
+		handler := c.Middleware(0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Write([]byte("product"))
+		}))
+
+		get(handler, "/products/1", nil)
+		now = now.Add(2 * time.Minute)
+		rec := get(handler, "/products/1", nil)
+		assert.Equal(t, statusRevalidated, rec.Header().Get("X-Cache"))
+		assert.Equal(t, "product", rec.Body.String())
+
+		rec = get(handler, "/products/1", nil)
+		assert.Equal(t, statusHit, rec.Header().Get("X-Cache"))
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("clients revalidate with etags", func(t *testing.T) {
+		c := New(1<<20, 1<<10)
+		upstream, _ := countingUpstream(nil)
+		handler := c.Middleware(time.Minute)(upstream)
+
+		etag := get(handler, "/products", nil).Header().Get("ETag")
+		assert.NotEmpty(t, etag)
+
+		rec := get(handler, "/products", http.Header{"If-None-Match": {etag}})
+		assert.Equal(t, http.StatusNotModified, rec.Code)
+		assert.Empty(t, rec.Body.String())
+	})
+
+	t.Run("responses vary on the named headers", func(t *testing.T) {
+		c := New(1<<20, 1<<10)
+		upstream, calls := countingUpstream(http.Header{"Vary": {"Accept-Language"}})
+		handler := c.Middleware(time.Minute)(upstream)
+
+		english := http.Header{"Accept-Language": {"en"}}
+		german := http.Header{"Accept-Language": {"de"}}
+		assert.Equal(t, "response 1 for en", get(handler, "/products", english).Body.String())
+		assert.Equal(t, "response 2 for de", get(handler, "/products", german).Body.String())
+		assert.Equal(t, "response 1 for en", get(handler, "/products", english).Body.String())
+		assert.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("Vary set in front of the cache is kept", func(t *testing.T) {
+		c := New(1<<20, 1<<10)
+		upstream, _ := countingUpstream(http.Header{"Vary": {"Accept-Language"}})
+		cached := c.Middleware(time.Minute)(upstream)
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Origin")
+			cached.ServeHTTP(w, r)
+		})
+
+		get(handler, "/products", nil)
+		rec := get(handler, "/products", nil)
+		assert.Equal(t, "HIT", rec.Header().Get("X-Cache"))
+		assert.Equal(t, []string{"Origin", "Accept-Language"}, rec.Header().Values("Vary"))
+	})
+
+	t.Run("concurrent misses share one upstream request", func(t *testing.T) {
+		c := New(1<<20, 1<<10)
+		release := make(chan struct{})
+		var calls atomic.Int32
+		handler := c.Middleware(time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			<-release
+			w.Write([]byte("product"))
+		}))
+
+		var wg sync.WaitGroup
+		bodies := make([]string, 10)
+		for i := range bodies {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				bodies[i] = get(handler, "/products", nil).Body.String()
+			}()
+		}
+		// Let the requests pile up behind the first
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		assert.Equal(t, int32(1), calls.Load())
+		for _, body := range bodies {
+			assert.Equal(t, "product", body)
+		}
+	})
+
+	t.Run("large responses are passed through", func(t *testing.T) {
+		c := New(1<<20, 1<<10)
+		large := strings.Repeat("x", 4<<10)
+		handler := c.Middleware(time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(large[:1<<9]))
+			w.Write([]byte(large[1<<9:]))
+		}))
+
+		rec := get(handler, "/products", nil)
+		assert.Equal(t, large, rec.Body.String())
+		entries, _ := c.Stats()
+		assert.Zero(t, entries)
+	})
+
+	t.Run("changes invalidate the resource", func(t *testing.T) {
+		c := New(1<<20, 1<<10)
+		upstream, calls := countingUpstream(nil)
+		handler := c.Middleware(time.Minute)(upstream)
+
+		get(handler, "/products/1", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/products/1", nil))
+		get(handler, "/products/1", nil)
+		assert.Equal(t, int32(3), calls.Load())
+	})
+}
+
+func TestCache_Eviction(t *testing.T) {
+	c := New(120, 100)
+	upstream, _ := countingUpstream(nil)
+	handler := c.Middleware(time.Minute)(upstream)
+
+	get(handler, "/a", nil)
+	get(handler, "/b", nil)
+	get(handler, "/a", nil) // now the most recently used
+	get(handler, "/c", nil)
+
+	assert.NotNil(t, c.get("/a"))
+	assert.Nil(t, c.get("/b"))
+	assert.NotNil(t, c.get("/c"))
+	_, size := c.Stats()
+	assert.LessOrEqual(t, size, int64(120))
+}
+
+func TestCache_Purge(t *testing.T) {
+	c := New(1<<20, 1<<10)
+	upstream, _ := countingUpstream(nil)
+	handler := c.Middleware(time.Minute)(upstream)
+
+	for _, path := range []string{"/products", "/products?page=2", "/products/1", "/productsale"} {
+		get(handler, path, nil)
+	}
+
+	assert.Equal(t, 2, c.Purge("/products", false))
+	assert.Equal(t, 1, c.Purge("/products", true))
+	entries, _ := c.Stats()
+	assert.Equal(t, 1, entries)
+	assert.Equal(t, 1, c.Purge("/", true))
+}
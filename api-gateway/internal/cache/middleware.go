@@ -0,0 +1,358 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gauss2302/microtest/pkg/correlation"
+)
+
+// Values of the X-Cache response header
+const (
+	statusHit         = "HIT"
+	statusMiss        = "MISS"
+	statusRevalidated = "REVALIDATED"
+)
+
+// cacheableStatus lists the responses stored without being asked to
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusGone:                 true,
+}
+
+// perRequestHeaders describe a single response rather than the resource.
+// They are not stored, so that a hit keeps the ones set for the request it
+// answers.
+var perRequestHeaders = []string{
+	correlation.HeaderRequestID,
+	correlation.HeaderTraceparent,
+	"Date",
+	"Set-Cookie",
+	// Hop-by-hop headers
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Proxy-Authenticate",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// flight is a request to the upstream that others wait for.
+type flight struct {
+	done chan struct{}
+}
+
+// Middleware serves anonymous GET requests from the cache. Responses that
+// do not say how long they stay fresh are kept for ttl.
+func (c *Cache) Middleware(ttl time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if unsafeMethod(r.Method) {
+				// A change to the resource makes what is stored stale
+				next.ServeHTTP(w, r)
+				c.Purge(r.URL.Path, false)
+				return
+			}
+
+			requestDirectives := parseCacheControl(r.Header.Values("Cache-Control"))
+			if r.Method != http.MethodGet || r.Header.Get("Authorization") != "" || requestDirectives.has("no-store") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			revalidate := requestDirectives.has("no-cache")
+
+			key := c.key(r)
+			e := c.get(key)
+			if e != nil && !revalidate && e.fresh(c.now()) {
+				c.serve(w, r, e, statusHit)
+				return
+			}
+
+			c.mutex.Lock()
+			if f, ok := c.flights[key]; ok {
+				c.mutex.Unlock()
+				select {
+				case <-f.done:
+				case <-r.Context().Done():
+					return
+				}
+				// The leader may have stored what we need, or found that
+				// there is nothing to share
+				if e := c.get(c.key(r)); e != nil && e.fresh(c.now()) {
+					c.serve(w, r, e, statusHit)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			f := &flight{done: make(chan struct{})}
+			c.flights[key] = f
+			c.mutex.Unlock()
+
+			defer func() {
+				c.mutex.Lock()
+				delete(c.flights, key)
+				c.mutex.Unlock()
+				close(f.done)
+			}()
+			c.fetch(w, r, e, ttl, next)
+		})
+	}
+}
+
+// fetch asks the upstream for r, revalidating stale if it can, and stores
+// what it may.
+func (c *Cache) fetch(w http.ResponseWriter, r *http.Request, stale *entry, ttl time.Duration, next http.Handler) {
+	// The cache needs the full response, whatever the client has
+	upstream := r.Clone(r.Context())
+	upstream.Header.Del("If-None-Match")
+	upstream.Header.Del("If-Modified-Since")
+	if stale != nil {
+		if etag := stale.header.Get("ETag"); etag != "" {
+			upstream.Header.Set("If-None-Match", etag)
+		}
+		if modified := stale.header.Get("Last-Modified"); modified != "" {
+			upstream.Header.Set("If-Modified-Since", modified)
+		}
+	}
+
+	recorder := newRecorder(w, c.maxEntryBytes)
+	next.ServeHTTP(recorder, upstream)
+	if recorder.passthrough {
+		return
+	}
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+
+	now := c.now()
+	if recorder.status == http.StatusNotModified && stale != nil {
+		if expiresAt, ok := expiry(recorder.header, now, ttl); ok {
+			stale = c.refresh(stale, expiresAt)
+		}
+		c.serve(w, r, stale, statusRevalidated)
+		return
+	}
+
+	e := &entry{
+		status:   recorder.status,
+		header:   recorder.header,
+		body:     recorder.body.Bytes(),
+		storedAt: now,
+	}
+	if vary, ok := c.storable(e, now, ttl); ok {
+		stored := *e
+		stored.header = sharedHeader(e.header)
+		c.store(r, vary, &stored)
+	}
+	c.serve(w, r, e, statusMiss)
+}
+
+// storable decides whether e may be shared and sets its expiry.
+func (c *Cache) storable(e *entry, now time.Time, ttl time.Duration) ([]string, bool) {
+	if !cacheableStatus[e.status] || e.header.Get("Set-Cookie") != "" {
+		return nil, false
+	}
+	vary, ok := varyHeaders(e.header)
+	if !ok {
+		return nil, false
+	}
+	expiresAt, ok := expiry(e.header, now, ttl)
+	if !ok {
+		return nil, false
+	}
+	e.expiresAt = expiresAt
+
+	if e.header.Get("ETag") == "" && e.status == http.StatusOK {
+		sum := sha256.Sum256(e.body)
+		e.header.Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	}
+	return vary, true
+}
+
+// serve writes e to the client, or 304 if the client already has it.
+func (c *Cache) serve(w http.ResponseWriter, r *http.Request, e *entry, status string) {
+	copyHeader(w.Header(), e.header)
+	header := w.Header()
+	header.Set("X-Cache", status)
+	if status == statusHit {
+		age := c.now().Sub(e.storedAt)
+		header.Set("Age", strconv.Itoa(int(age/time.Second)))
+	}
+
+	if etag := e.header.Get("ETag"); etag != "" && e.status == http.StatusOK && matchETag(r.Header.Get("If-None-Match"), etag) {
+		header.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.WriteHeader(e.status)
+	w.Write(e.body)
+}
+
+func unsafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+	return true
+}
+
+// matchETag compares an If-None-Match list with etag, weakly.
+func matchETag(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// expiry works out when a response stops being fresh: s-maxage first,
+// then max-age, then Expires, and ttl if it says nothing. It reports false
+// for responses that must not be shared.
+func expiry(header http.Header, now time.Time, ttl time.Duration) (time.Time, bool) {
+	directives := parseCacheControl(header.Values("Cache-Control"))
+	if directives.has("no-store") || directives.has("private") {
+		return time.Time{}, false
+	}
+	if directives.has("no-cache") {
+		// Stored, but checked with the upstream every time
+		return now, true
+	}
+	for _, name := range []string{"s-maxage", "max-age"} {
+		if value, ok := directives[name]; ok {
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds < 0 {
+				return time.Time{}, false
+			}
+			return now.Add(time.Duration(seconds) * time.Second), true
+		}
+	}
+	if expires := header.Get("Expires"); expires != "" {
+		expiresAt, err := http.ParseTime(expires)
+		if err != nil {
+			return time.Time{}, false
+		}
+		if date, err := http.ParseTime(header.Get("Date")); err == nil {
+			// Trust the upstream's lifetime rather than its clock
+			return now.Add(expiresAt.Sub(date)), true
+		}
+		return expiresAt, true
+	}
+	return now.Add(ttl), ttl > 0
+}
+
+type cacheControl map[string]string
+
+func parseCacheControl(values []string) cacheControl {
+	directives := make(cacheControl)
+	for _, value := range values {
+		for _, directive := range strings.Split(value, ",") {
+			name, argument, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name == "" {
+				continue
+			}
+			directives[strings.ToLower(name)] = strings.Trim(argument, `"`)
+		}
+	}
+	return directives
+}
+
+func (d cacheControl) has(name string) bool {
+	_, ok := d[name]
+	return ok
+}
+
+// recorder buffers a response for the cache. One too large to store is
+// passed through to the client as it arrives.
+type recorder struct {
+	w           http.ResponseWriter
+	limit       int64
+	header      http.Header
+	status      int
+	body        bytes.Buffer
+	passthrough bool
+}
+
+func newRecorder(w http.ResponseWriter, limit int64) *recorder {
+	return &recorder{w: w, limit: limit, header: make(http.Header)}
+}
+
+func (r *recorder) Header() http.Header {
+	if r.passthrough {
+		return r.w.Header()
+	}
+	return r.header
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if r.passthrough {
+		return r.w.Write(p)
+	}
+	if int64(r.body.Len()+len(p)) <= r.limit {
+		return r.body.Write(p)
+	}
+
+	r.passthrough = true
+	copyHeader(r.w.Header(), r.header)
+	r.w.WriteHeader(r.status)
+	if _, err := r.w.Write(r.body.Bytes()); err != nil {
+		return 0, err
+	}
+	r.body.Reset()
+	return r.w.Write(p)
+}
+
+// sharedHeader returns the headers of a response that may be stored and
+// served to other requests.
+func sharedHeader(header http.Header) http.Header {
+	shared := header.Clone()
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			shared.Del(strings.TrimSpace(name))
+		}
+	}
+	for _, name := range perRequestHeaders {
+		shared.Del(name)
+	}
+	return shared
+}
+
+// copyHeader copies the headers of a response to dst, keeping the Vary
+// values already in dst, such as the Origin the gateway's CORS headers
+// depend on.
+func copyHeader(dst, src http.Header) {
+	for name, values := range src {
+		if name == "Vary" {
+			dst[name] = append(dst[name], values...)
+			continue
+		}
+		dst[name] = append([]string(nil), values...)
+	}
+}
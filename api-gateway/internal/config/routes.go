@@ -0,0 +1,383 @@
+// Package config loads the gateway's route table.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/gauss2302/microtest/api-gateway/internal/auth"
+	"github.com/gauss2302/microtest/api-gateway/internal/cors"
+	"github.com/gauss2302/microtest/api-gateway/internal/ratelimit"
+	"github.com/gauss2302/microtest/api-gateway/internal/upstream"
+)
+
+// Routes is the gateway's route table. It is read from YAML, or from JSON,
+// which is valid YAML.
+type Routes struct {
+	Routes []Route `yaml:"routes"`
+	// CORS lets browsers on other origins call the routes; without it
+	// they may not
+	CORS *CORS `yaml:"cors"`
+}
+
+// Route forwards requests under Path to one of Upstreams.
+type Route struct {
+	Name string `yaml:"name"`
+	// Path prefix the route serves, e.g. /products
+	Path string `yaml:"path"`
+	// Upstreams are http(s) URLs, or dns+http(s) URLs standing for every
+	// address the host resolves to
+	Upstreams []string `yaml:"upstreams"`
+	// LoadBalancing is round_robin (the default), least_connections or
+	// consistent_hash, which keys on HashHeader or the client address
+	LoadBalancing string        `yaml:"load_balancing"`
+	HashHeader    string        `yaml:"hash_header"`
+	DNSRefresh    time.Duration `yaml:"dns_refresh"`
+	// HealthCheck enables active health checks of each upstream
+	HealthCheck *HealthCheck `yaml:"health_check"`
+	// PassiveEjection takes upstreams that keep failing out of rotation
+	PassiveEjection PassiveEjection `yaml:"passive_ejection"`
+	// Retries of idempotent requests; two by default
+	Retries *Retries `yaml:"retries"`
+	// CircuitBreaker stops calls to the route's upstreams while they fail
+	CircuitBreaker CircuitBreaker `yaml:"circuit_breaker"`
+	// StripPrefix removes Path before forwarding; RewritePrefix replaces
+	// it with another prefix
+	StripPrefix   bool   `yaml:"strip_prefix"`
+	RewritePrefix string `yaml:"rewrite_prefix"`
+	// Methods the route accepts; all when empty
+	Methods []string `yaml:"methods"`
+	// Timeout bounds the upstream call; none when zero
+	Timeout time.Duration `yaml:"timeout"`
+	// Auth is the access to the route: public, protected (the default) or
+	// internal. AuthRules override it for parts of the route.
+	Auth      string     `yaml:"auth"`
+	AuthRules []AuthRule `yaml:"auth_rules"`
+	// RateLimit is a per-client limit such as 100/m; none when empty
+	RateLimit string `yaml:"rate_limit"`
+	// Cache shares anonymous GET responses between clients
+	Cache *Cache `yaml:"cache"`
+}
+
+// HealthCheck requests Path on every upstream each Interval.
+type HealthCheck struct {
+	Path     string        `yaml:"path"`
+	Interval time.Duration `yaml:"interval"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+// PassiveEjection ejects an upstream for Duration after
+// ConsecutiveFailures transport errors or 5xx responses in a row.
+type PassiveEjection struct {
+	ConsecutiveFailures int           `yaml:"consecutive_failures"`
+	Duration            time.Duration `yaml:"duration"`
+}
+
+// Retries repeats a failed idempotent request up to Attempts more times,
+// waiting Backoff, then twice as long, and so on in between.
+type Retries struct {
+	Attempts int           `yaml:"attempts"`
+	Backoff  time.Duration `yaml:"backoff"`
+}
+
+// CircuitBreaker opens after FailureThreshold failures in a row, refuses
+// requests for OpenTimeout, then lets HalfOpenRequests probes through.
+type CircuitBreaker struct {
+	FailureThreshold int           `yaml:"failure_threshold"`
+	OpenTimeout      time.Duration `yaml:"open_timeout"`
+	HalfOpenRequests int           `yaml:"half_open_requests"`
+}
+
+// Cache keeps responses for as long as their Cache-Control or Expires
+// header allows, or for TTL if they have neither.
+type Cache struct {
+	TTL time.Duration `yaml:"ttl"`
+}
+
+// CORS is the gateway's cross-origin policy; see cors.Policy.
+type CORS struct {
+	AllowedOrigins   []string      `yaml:"allowed_origins"`
+	AllowedMethods   []string      `yaml:"allowed_methods"`
+	AllowedHeaders   []string      `yaml:"allowed_headers"`
+	ExposedHeaders   []string      `yaml:"exposed_headers"`
+	AllowCredentials bool          `yaml:"allow_credentials"`
+	MaxAge           time.Duration `yaml:"max_age"`
+}
+
+// AuthRule sets the access to requests matching Methods and Path, using
+// the patterns of auth.Rule.
+type AuthRule struct {
+	Methods []string `yaml:"methods"`
+	Path    string   `yaml:"path"`
+	Access  string   `yaml:"access"`
+}
+
+// Load reads and validates the route table in path.
+func Load(path string) (*Routes, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse reads and validates a route table. ${VAR} and ${VAR:-default} are
+// replaced with environment variables first.
+func Parse(data []byte) (*Routes, error) {
+	var routes Routes
+	decoder := yaml.NewDecoder(strings.NewReader(expandEnv(string(data))))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&routes); err != nil {
+		return nil, fmt.Errorf("invalid route configuration: %w", err)
+	}
+
+	if err := routes.Validate(); err != nil {
+		return nil, err
+	}
+	return &routes, nil
+}
+
+var methodPattern = regexp.MustCompile(`^[A-Z]+$`)
+
+// Validate reports all problems in the route table at once.
+func (r *Routes) Validate() error {
+	var errs []error
+	if len(r.Routes) == 0 {
+		errs = append(errs, errors.New("no routes configured"))
+	}
+
+	names := make(map[string]bool)
+	paths := make(map[string]bool)
+	for i, route := range r.Routes {
+		fail := func(format string, args ...any) {
+			errs = append(errs, fmt.Errorf("route %d (%s): %s", i, route.Name, fmt.Sprintf(format, args...)))
+		}
+
+		if route.Name == "" {
+			fail("name is required")
+		} else if names[route.Name] {
+			fail("duplicate name")
+		}
+		names[route.Name] = true
+
+		if !strings.HasPrefix(route.Path, "/") || route.Path == "/" || strings.HasSuffix(route.Path, "/") {
+			fail("path %q must start and not end with /", route.Path)
+		} else if paths[route.Path] {
+			fail("duplicate path %q", route.Path)
+		}
+		paths[route.Path] = true
+
+		if len(route.Upstreams) == 0 {
+			fail("at least one upstream is required")
+		}
+		for _, upstream := range route.Upstreams {
+			u, err := url.Parse(upstream)
+			if err != nil || u.Host == "" {
+				fail("upstream %q is not an http(s) URL", upstream)
+				continue
+			}
+			switch u.Scheme {
+			case "http", "https":
+			case "dns+http", "dns+https":
+				if u.Port() == "" {
+					fail("upstream %q needs a port", upstream)
+				}
+			default:
+				fail("upstream %q is not an http(s) URL", upstream)
+			}
+		}
+
+		if _, err := upstream.ParseStrategy(route.LoadBalancing); err != nil {
+			fail("load_balancing: %v", err)
+		}
+		if route.HashHeader != "" && route.LoadBalancing != string(upstream.ConsistentHash) {
+			fail("hash_header needs consistent_hash load balancing")
+		}
+		if route.DNSRefresh < 0 {
+			fail("dns_refresh must not be negative")
+		}
+		if check := route.HealthCheck; check != nil {
+			if check.Path != "" && !strings.HasPrefix(check.Path, "/") {
+				fail("health_check path %q must start with /", check.Path)
+			}
+			if check.Interval < 0 || check.Timeout < 0 {
+				fail("health_check interval and timeout must not be negative")
+			}
+		}
+		if route.PassiveEjection.ConsecutiveFailures < 0 || route.PassiveEjection.Duration < 0 {
+			fail("passive_ejection values must not be negative")
+		}
+		if route.Retries != nil && (route.Retries.Attempts < 0 || route.Retries.Backoff < 0) {
+			fail("retries values must not be negative")
+		}
+		if breaker := route.CircuitBreaker; breaker.FailureThreshold < 0 || breaker.OpenTimeout < 0 || breaker.HalfOpenRequests < 0 {
+			fail("circuit_breaker values must not be negative")
+		}
+
+		if route.StripPrefix && route.RewritePrefix != "" {
+			fail("strip_prefix and rewrite_prefix are exclusive")
+		}
+		if route.RewritePrefix != "" && !strings.HasPrefix(route.RewritePrefix, "/") {
+			fail("rewrite_prefix %q must start with /", route.RewritePrefix)
+		}
+
+		for _, method := range route.Methods {
+			if !methodPattern.MatchString(method) {
+				fail("invalid method %q", method)
+			}
+		}
+
+		if route.Timeout < 0 {
+			fail("timeout must not be negative")
+		}
+
+		if route.Auth != "" {
+			if _, err := auth.ParseAccess(route.Auth); err != nil {
+				fail("auth: %v", err)
+			}
+		}
+		for _, rule := range route.AuthRules {
+			if _, err := auth.ParseAccess(rule.Access); err != nil {
+				fail("auth rule %s: %v", rule.Path, err)
+			}
+			if rule.Path != route.Path && !strings.HasPrefix(rule.Path, route.Path+"/") {
+				fail("auth rule path %q is outside the route", rule.Path)
+			}
+		}
+
+		if route.RateLimit != "" {
+			if _, err := ratelimit.ParseLimit(route.RateLimit); err != nil {
+				fail("rate_limit: %v", err)
+			}
+		}
+		if route.Cache != nil && route.Cache.TTL < 0 {
+			fail("cache ttl must not be negative")
+		}
+	}
+
+	if r.CORS != nil {
+		policy := r.CORSPolicy()
+		if err := policy.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("cors: %w", err))
+		}
+		for _, method := range r.CORS.AllowedMethods {
+			if !methodPattern.MatchString(method) {
+				errs = append(errs, fmt.Errorf("cors: invalid method %q", method))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// CORSPolicy translates the cross-origin settings.
+func (r *Routes) CORSPolicy() cors.Policy {
+	return cors.Policy{
+		AllowedOrigins:   r.CORS.AllowedOrigins,
+		AllowedMethods:   r.CORS.AllowedMethods,
+		AllowedHeaders:   r.CORS.AllowedHeaders,
+		ExposedHeaders:   r.CORS.ExposedHeaders,
+		AllowCredentials: r.CORS.AllowCredentials,
+		MaxAge:           r.CORS.MaxAge,
+	}
+}
+
+// Policy builds the gateway's access policy: each route's rules, then its
+// default access for everything under it.
+func (r *Routes) Policy() *auth.Policy {
+	policy := &auth.Policy{}
+	for _, route := range r.Routes {
+		for _, rule := range route.AuthRules {
+			access, _ := auth.ParseAccess(rule.Access)
+			policy.Rules = append(policy.Rules, auth.Rule{
+				Methods: rule.Methods,
+				Path:    rule.Path,
+				Access:  access,
+			})
+		}
+
+		access := auth.Protected
+		if route.Auth != "" {
+			access, _ = auth.ParseAccess(route.Auth)
+		}
+		policy.Rules = append(policy.Rules, auth.Rule{Path: route.Path + "/**", Access: access})
+	}
+	return policy
+}
+
+// Allows reports whether the route accepts method.
+func (r *Route) Allows(method string) bool {
+	if len(r.Methods) == 0 {
+		return true
+	}
+	for _, m := range r.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+var envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+func expandEnv(s string) string {
+	return envPattern.ReplaceAllStringFunc(s, func(match string) string {
+		groups := envPattern.FindStringSubmatch(match)
+		if value := os.Getenv(groups[1]); value != "" {
+			return value
+		}
+		return groups[3]
+	})
+}
+
+// PoolOptions translates the route's balancing settings.
+func (r *Route) PoolOptions() upstream.Options {
+	strategy, _ := upstream.ParseStrategy(r.LoadBalancing)
+	options := upstream.Options{
+		Strategy:         strategy,
+		HashHeader:       r.HashHeader,
+		MaxFailures:      r.PassiveEjection.ConsecutiveFailures,
+		EjectionDuration: r.PassiveEjection.Duration,
+		DNSRefresh:       r.DNSRefresh,
+	}
+	if r.HealthCheck != nil {
+		options.HealthCheck = &upstream.HealthCheck{
+			Path:     r.HealthCheck.Path,
+			Interval: r.HealthCheck.Interval,
+			Timeout:  r.HealthCheck.Timeout,
+		}
+	}
+	return options
+}
+
+const (
+	defaultRetryAttempts = 2
+	defaultRetryBackoff  = 100 * time.Millisecond
+)
+
+// RetryPolicy returns the number of retries and the first backoff.
+func (r *Route) RetryPolicy() (attempts int, backoff time.Duration) {
+	if r.Retries == nil {
+		return defaultRetryAttempts, defaultRetryBackoff
+	}
+	if r.Retries.Backoff == 0 {
+		return r.Retries.Attempts, defaultRetryBackoff
+	}
+	return r.Retries.Attempts, r.Retries.Backoff
+}
+
+// BreakerOptions translates the route's circuit breaker settings.
+func (r *Route) BreakerOptions() upstream.BreakerOptions {
+	return upstream.BreakerOptions{
+		FailureThreshold: r.CircuitBreaker.FailureThreshold,
+		OpenTimeout:      r.CircuitBreaker.OpenTimeout,
+		HalfOpenRequests: r.CircuitBreaker.HalfOpenRequests,
+	}
+}
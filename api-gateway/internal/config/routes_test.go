@@ -0,0 +1,161 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gauss2302/microtest/api-gateway/internal/auth"
+	"github.com/gauss2302/microtest/api-gateway/internal/upstream"
+)
+
+func TestLoad_ShippedRoutes(t *testing.T) {
+	t.Setenv("USER_SERVICE_URL", "http://localhost:8083")
+
+	routes, err := Load("../../config/routes.yaml")
+	require.NoError(t, err)
+
+	upstreams := make(map[string][]string)
+	for _, route := range routes.Routes {
+		upstreams[route.Name] = route.Upstreams
+	}
+	assert.Equal(t, []string{"http://localhost:8083"}, upstreams["users"])
+	assert.Equal(t, []string{"http://product-service:8080"}, upstreams["products"])
+
+	policy := routes.Policy()
+	assert.Equal(t, auth.Public, policy.Access("GET", "/products/1"))
+	assert.Equal(t, auth.Protected, policy.Access("POST", "/products"))
+	assert.Equal(t, auth.Internal, policy.Access("GET", "/users/1/mfa"))
+	assert.Equal(t, auth.Internal, policy.Access("PUT", "/users/1/password"))
+	assert.Equal(t, auth.Internal, policy.Access("DELETE", "/users/1/mfa/recovery-codes/abc$def"))
+	assert.Equal(t, auth.Protected, policy.Access("GET", "/users/1"))
+	assert.Equal(t, auth.Internal, policy.Access("GET", "/users"))
+	assert.Equal(t, auth.Public, policy.Access("POST", "/auth/login"))
+	assert.Equal(t, auth.Internal, policy.Access("POST", "/auth/introspect"))
+	assert.Equal(t, auth.Internal, policy.Access("POST", "/auth/email/verify/send"))
+
+	require.NotNil(t, routes.CORS)
+	assert.Equal(t, []string{"http://localhost:3000"}, routes.CORSPolicy().AllowedOrigins)
+}
+
+func TestParse_Validation(t *testing.T) {
+	_, err := Parse([]byte(`
+routes:
+  - name: products
+    path: /products/
+    upstreams: ["ftp://example.com"]
+    methods: [get]
+    auth: open
+    auth_rules:
+      - path: /users/*
+        access: public
+    rate_limit: often
+    load_balancing: random
+    hash_header: X-User-ID
+    health_check:
+      path: health
+    retries:
+      attempts: -1
+    circuit_breaker:
+      open_timeout: -1s
+    cache:
+      ttl: -1s
+  - name: products
+    path: /users
+cors:
+  allowed_origins: ["*"]
+  allowed_methods: [get]
+  allow_credentials: true
+`))
+	require.Error(t, err)
+
+	// All problems are reported together
+	for _, problem := range []string{
+		`path "/products/" must start and not end with /`,
+		`upstream "ftp://example.com" is not an http(s) URL`,
+		`invalid method "get"`,
+		`auth: unknown access "open"`,
+		`auth rule path "/users/*" is outside the route`,
+		`rate_limit: invalid limit "often"`,
+		`load_balancing: unknown load balancing strategy "random"`,
+		`hash_header needs consistent_hash load balancing`,
+		`health_check path "health" must start with /`,
+		`retries values must not be negative`,
+		`circuit_breaker values must not be negative`,
+		`cache ttl must not be negative`,
+		`route 1 (products): duplicate name`,
+		`route 1 (products): at least one upstream is required`,
+		`cors: origin "*" cannot be allowed with credentials`,
+		`cors: invalid method "get"`,
+	} {
+		assert.Contains(t, err.Error(), problem)
+	}
+}
+
+func TestParse_UnknownField(t *testing.T) {
+	_, err := Parse([]byte(`
+routes:
+  - name: products
+    path: /products
+    upstream: http://product-service:8080
+`))
+	assert.ErrorContains(t, err, "field upstream not found")
+}
+
+func TestParse_JSON(t *testing.T) {
+	routes, err := Parse([]byte(`{"routes": [{"name": "products", "path": "/products", "upstreams": ["http://product-service:8080"], "timeout": "2s"}]}`))
+	require.NoError(t, err)
+	assert.Equal(t, "2s", routes.Routes[0].Timeout.String())
+}
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("GATEWAY_TEST_SET", "value")
+
+	assert.Equal(t, "value", expandEnv("${GATEWAY_TEST_SET:-default}"))
+	assert.Equal(t, "default", expandEnv("${GATEWAY_TEST_UNSET:-default}"))
+	assert.Equal(t, "", expandEnv("${GATEWAY_TEST_UNSET}"))
+	assert.Equal(t, "$HOME", expandEnv("$HOME"))
+}
+
+func TestRoute_PoolOptions(t *testing.T) {
+	routes, err := Parse([]byte(`
+routes:
+  - name: users
+    path: /users
+    upstreams: ["dns+http://user-service-headless:8080"]
+    load_balancing: consistent_hash
+    hash_header: X-User-ID
+    health_check:
+      interval: 5s
+    passive_ejection:
+      consecutive_failures: 3
+      duration: 1m
+`))
+	require.NoError(t, err)
+
+	options := routes.Routes[0].PoolOptions()
+	assert.Equal(t, upstream.ConsistentHash, options.Strategy)
+	assert.Equal(t, "X-User-ID", options.HashHeader)
+	assert.Equal(t, 5*time.Second, options.HealthCheck.Interval)
+	assert.Equal(t, 3, options.MaxFailures)
+	assert.Equal(t, time.Minute, options.EjectionDuration)
+
+	_, err = Parse([]byte(`
+routes:
+  - name: users
+    path: /users
+    upstreams: ["dns+http://user-service-headless"]
+`))
+	assert.ErrorContains(t, err, "needs a port")
+}
+
+func TestRoute_RetryPolicy(t *testing.T) {
+	attempts, backoff := (&Route{}).RetryPolicy()
+	assert.Equal(t, 2, attempts)
+	assert.Equal(t, 100*time.Millisecond, backoff)
+
+	attempts, _ = (&Route{Retries: &Retries{Attempts: 0}}).RetryPolicy()
+	assert.Equal(t, 0, attempts, "retries can be turned off")
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// Watch polls the route table in path every interval and calls apply with
+// each new version that is valid. Invalid versions are logged and the
+// routes in use are kept. Polling, unlike file events, also notices
+// Kubernetes ConfigMap updates, which swap a symlink.
+func Watch(ctx context.Context, path string, interval time.Duration, apply func(*Routes) error) error {
+	last, err := os.ReadFile(path)
+	if err != nil {
+		slog.Error("Failed to read routes", "path", path, "error", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			slog.Error("Failed to read routes", "path", path, "error", err)
+			continue
+		}
+		if bytes.Equal(data, last) {
+			continue
+		}
+		last = data
+
+		routes, err := Parse(data)
+		if err == nil {
+			err = apply(routes)
+		}
+		if err != nil {
+			slog.Error("Keeping current routes, the file is invalid", "path", path, "error", err)
+			continue
+		}
+		slog.Info("Reloaded routes", "path", path, "routes", len(routes.Routes))
+	}
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routes.yaml")
+	write := func(content string) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	write(fmtRoutes("/products"))
+
+	applied := make(chan *Routes, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- Watch(ctx, path, 10*time.Millisecond, func(routes *Routes) error {
+			applied <- routes
+			return nil
+		})
+	}()
+
+	// Invalid versions are skipped
+	write(fmtRoutes("products"))
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, applied)
+
+	write(fmtRoutes("/goods"))
+	select {
+	case routes := <-applied:
+		assert.Equal(t, "/goods", routes.Routes[0].Path)
+	case <-time.After(time.Second):
+		t.Fatal("routes were not reloaded")
+	}
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func fmtRoutes(path string) string {
+	return "routes:\n  - name: products\n    path: " + path + "\n    upstreams: [\"http://product-service:8080\"]\n"
+}
@@ -0,0 +1,172 @@
+// Package cors lets browsers on other origins call the gateway, within a
+// configured policy.
+package cors
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gauss2302/microtest/pkg/respond"
+)
+
+// Defaults for the parts of a policy left empty
+var (
+	DefaultMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	DefaultHeaders = []string{"Accept", "Authorization", "Content-Type", "X-Request-ID", "traceparent"}
+)
+
+// Policy decides which origins may call the gateway and how.
+type Policy struct {
+	// AllowedOrigins are origins such as https://app.example.com, or
+	// patterns such as https://*.example.com matching every subdomain.
+	// "*" allows any origin, but not with credentials.
+	AllowedOrigins []string
+	// AllowedMethods and AllowedHeaders may be used by requests;
+	// DefaultMethods and DefaultHeaders when empty
+	AllowedMethods []string
+	AllowedHeaders []string
+	// ExposedHeaders are response headers scripts may read
+	ExposedHeaders []string
+	// AllowCredentials lets requests carry cookies and authorization
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache the answer to a preflight
+	// request
+	MaxAge time.Duration
+}
+
+// Validate reports all problems in the policy at once.
+func (p *Policy) Validate() error {
+	var errs []error
+	if len(p.AllowedOrigins) == 0 {
+		errs = append(errs, errors.New("at least one allowed origin is required"))
+	}
+	for _, origin := range p.AllowedOrigins {
+		if origin == "*" {
+			if p.AllowCredentials {
+				errs = append(errs, errors.New(`origin "*" cannot be allowed with credentials`))
+			}
+			continue
+		}
+		if err := validateOrigin(origin); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if p.MaxAge < 0 {
+		errs = append(errs, errors.New("max_age must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// validateOrigin checks that origin is a scheme and host, the host
+// optionally starting with "*.".
+func validateOrigin(origin string) error {
+	u, err := url.Parse(strings.Replace(origin, "://*.", "://wildcard.", 1))
+	if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" || u.RawQuery != "" || u.User != nil {
+		return fmt.Errorf("origin %q must be a scheme and host such as https://app.example.com", origin)
+	}
+	if strings.Count(origin, "*") > 1 || (strings.Contains(origin, "*") && !strings.Contains(origin, "://*.")) {
+		return fmt.Errorf("origin %q may only start its host with *.", origin)
+	}
+	return nil
+}
+
+// allows reports whether requests from origin may be answered.
+func (p *Policy) allows(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range p.AllowedOrigins {
+		allowed = strings.ToLower(allowed)
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+
+		// https://*.example.com matches https://app.example.com and
+		// https://eu.app.example.com, but not https://example.com
+		prefix, suffix, wildcard := strings.Cut(allowed, "://*.")
+		if !wildcard {
+			continue
+		}
+		prefix += "://"
+		suffix = "." + suffix
+		if strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+			subdomain := origin[len(prefix) : len(origin)-len(suffix)]
+			if subdomain != "" && !strings.ContainsAny(subdomain, "/:@") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Middleware answers preflight requests from allowed origins and marks
+// the responses to their requests as readable by them. Responses to other
+// origins carry no CORS headers, so browsers keep them from scripts.
+func Middleware(policy Policy) func(http.Handler) http.Handler {
+	methods := policy.AllowedMethods
+	if len(methods) == 0 {
+		methods = DefaultMethods
+	}
+	headers := policy.AllowedHeaders
+	if len(headers) == 0 {
+		headers = DefaultHeaders
+	}
+	allowMethods := strings.Join(methods, ", ")
+	allowHeaders := strings.Join(headers, ", ")
+	exposeHeaders := strings.Join(policy.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(policy.MaxAge.Seconds()))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Answers differ by origin, so caches must keep them apart
+			w.Header().Add("Vary", "Origin")
+
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			allowed := policy.allows(origin)
+			if preflight {
+				w.Header().Add("Vary", "Access-Control-Request-Method")
+				w.Header().Add("Vary", "Access-Control-Request-Headers")
+				if !allowed || !slices.Contains(methods, r.Header.Get("Access-Control-Request-Method")) {
+					respond.Error(w, r, http.StatusForbidden, "Cross-origin request not allowed")
+					return
+				}
+
+				allowOrigin(w, policy, origin)
+				w.Header().Set("Access-Control-Allow-Methods", allowMethods)
+				w.Header().Set("Access-Control-Allow-Headers", allowHeaders)
+				if policy.MaxAge > 0 {
+					w.Header().Set("Access-Control-Max-Age", maxAge)
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			if allowed {
+				allowOrigin(w, policy, origin)
+				if exposeHeaders != "" {
+					w.Header().Set("Access-Control-Expose-Headers", exposeHeaders)
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// allowOrigin names origin as allowed. The origin is echoed rather than
+// answered with "*" so that credentials keep working.
+func allowOrigin(w http.ResponseWriter, policy Policy, origin string) {
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	if policy.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
@@ -0,0 +1,115 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy_Validate(t *testing.T) {
+	valid := Policy{AllowedOrigins: []string{"https://app.example.com", "https://*.example.com", "http://localhost:3000"}}
+	assert.NoError(t, valid.Validate())
+
+	err := (&Policy{
+		AllowedOrigins:   []string{"*", "app.example.com", "https://app.example.com/", "https://app.*.com"},
+		AllowCredentials: true,
+		MaxAge:           -time.Second,
+	}).Validate()
+	for _, problem := range []string{
+		`origin "*" cannot be allowed with credentials`,
+		`origin "app.example.com" must be a scheme and host`,
+		`origin "https://app.example.com/" must be a scheme and host`,
+		`origin "https://app.*.com" may only start its host with *.`,
+		`max_age must not be negative`,
+	} {
+		assert.Contains(t, err.Error(), problem)
+	}
+
+	assert.Error(t, (&Policy{}).Validate())
+}
+
+func TestPolicy_Allows(t *testing.T) {
+	policy := Policy{AllowedOrigins: []string{"https://app.example.com", "https://*.example.org"}}
+
+	for origin, allowed := range map[string]bool{
+		"https://app.example.com":       true,
+		"https://APP.example.com":       true,
+		"http://app.example.com":        false,
+		"https://app.example.com:8443":  false,
+		"https://evil.com":              false,
+		"https://eu.example.org":        true,
+		"https://a.eu.example.org":      true,
+		"https://example.org":           false,
+		"https://evilexample.org":       false,
+		"https://evil.com/.example.org": false,
+	} {
+		assert.Equal(t, allowed, policy.allows(origin), origin)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	handler := Middleware(Policy{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		ExposedHeaders:   []string{"X-Request-ID"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("served"))
+	}))
+
+	serve := func(method, origin, requestMethod string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/products", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if requestMethod != "" {
+			r.Header.Set("Access-Control-Request-Method", requestMethod)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("answers preflight requests", func(t *testing.T) {
+		w := serve(http.MethodOptions, "https://app.example.com", http.MethodPost)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+		assert.Contains(t, w.Header().Get("Access-Control-Allow-Methods"), http.MethodPost)
+		assert.Contains(t, w.Header().Get("Access-Control-Allow-Headers"), "Authorization")
+		assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+		assert.Contains(t, w.Header().Values("Vary"), "Origin")
+		assert.Empty(t, w.Body.String())
+	})
+
+	t.Run("rejects preflight requests from other origins", func(t *testing.T) {
+		w := serve(http.MethodOptions, "https://evil.com", http.MethodPost)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+
+		w = serve(http.MethodOptions, "https://app.example.com", "PROPFIND")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("marks responses to allowed origins", func(t *testing.T) {
+		w := serve(http.MethodGet, "https://app.example.com", "")
+		assert.Equal(t, "served", w.Body.String())
+		assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "X-Request-ID", w.Header().Get("Access-Control-Expose-Headers"))
+		assert.Equal(t, []string{"Origin"}, w.Header().Values("Vary"))
+	})
+
+	t.Run("serves other origins without CORS headers", func(t *testing.T) {
+		w := serve(http.MethodGet, "https://evil.com", "")
+		assert.Equal(t, "served", w.Body.String())
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, []string{"Origin"}, w.Header().Values("Vary"))
+
+		w = serve(http.MethodGet, "", "")
+		assert.Equal(t, "served", w.Body.String())
+		assert.Equal(t, []string{"Origin"}, w.Header().Values("Vary"))
+	})
+}
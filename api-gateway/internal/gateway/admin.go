@@ -0,0 +1,80 @@
+package gateway
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gauss2302/microtest/api-gateway/internal/cache"
+	"github.com/gauss2302/microtest/pkg/respond"
+)
+
+// MethodPurge removes responses from the cache.
+const MethodPurge = "PURGE"
+
+// NewAdmin serves the gateway's admin API, which must not be reachable
+// from outside:
+//
+//	GET   /admin/breakers        state of each upstream's circuit breaker
+//	GET   /admin/cache           size of the response cache
+//	PURGE /admin/cache/{path}    drop the cached responses for path, and
+//	                             with ?prefix=true everything below it
+//
+// With a token, requests must carry it as a bearer token.
+func NewAdmin(handler *Handler, responses *cache.Cache, token string) http.Handler {
+	admin := http.NewServeMux()
+
+	admin.HandleFunc("/admin/breakers", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			respond.Error(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(handler.Current().Breakers())
+	})
+
+	admin.HandleFunc("/admin/cache", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			respond.Error(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		entries, bytes := responses.Stats()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int64{"entries": int64(entries), "bytes": bytes})
+	})
+
+	admin.HandleFunc("/admin/cache/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != MethodPurge {
+			w.Header().Set("Allow", MethodPurge)
+			respond.Error(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		path := strings.TrimPrefix(r.URL.Path, "/admin/cache")
+		purged := responses.Purge(path, r.URL.Query().Get("prefix") == "true")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"purged": purged})
+	})
+
+	return requireToken(token, admin)
+}
+
+func requireToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api-gateway admin"`)
+			respond.Error(w, r, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
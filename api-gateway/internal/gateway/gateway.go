@@ -0,0 +1,194 @@
+// Package gateway builds the gateway's HTTP handler from its route table.
+package gateway
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gauss2302/microtest/api-gateway/internal/auth"
+	"github.com/gauss2302/microtest/api-gateway/internal/cache"
+	"github.com/gauss2302/microtest/api-gateway/internal/config"
+	"github.com/gauss2302/microtest/api-gateway/internal/cors"
+	"github.com/gauss2302/microtest/api-gateway/internal/ratelimit"
+	"github.com/gauss2302/microtest/api-gateway/internal/upstream"
+	"github.com/gauss2302/microtest/pkg/health"
+	"github.com/gauss2302/microtest/pkg/metrics"
+	"github.com/gauss2302/microtest/pkg/respond"
+	"github.com/gauss2302/microtest/pkg/telemetry"
+)
+
+// Gateway serves one version of the route table.
+type Gateway struct {
+	mux      *http.ServeMux
+	handler  http.Handler
+	pools    []*upstream.Pool
+	breakers *upstream.Breakers
+}
+
+// New builds a gateway serving routes. Rate limit state lives in limiter,
+// cached responses in responses and circuit breakers in breakers so that
+// they survive reloads; without breakers the gateway keeps its own. The
+// gateway checks the health of upstreams until it is closed.
+func New(routes *config.Routes, validator auth.Validator, limiter *ratelimit.Limiter, responses *cache.Cache, breakers *upstream.Breakers, resolver upstream.Resolver) (*Gateway, error) {
+	authenticate := auth.Authenticate(routes.Policy(), validator)
+
+	if breakers == nil {
+		breakers = upstream.NewBreakers()
+	}
+	g := &Gateway{mux: http.NewServeMux(), breakers: breakers}
+	mux := g.mux
+	// One unreachable upstream degrades the gateway, but the others are
+	// still worth routing to
+	checks := health.NewRegistry(5 * time.Second)
+	for _, route := range routes.Routes {
+		pool, err := upstream.NewPool(route.Name, route.Upstreams, route.PoolOptions(), resolver)
+		if err != nil {
+			g.Close()
+			return nil, fmt.Errorf("route %s: %w", route.Name, err)
+		}
+		g.pools = append(g.pools, pool)
+		checks.RegisterOptional("upstream:"+route.Name, 0, pool.Check)
+
+		retries, backoff := route.RetryPolicy()
+		proxy := newProxy(route, &upstreamTransport{
+			name:           route.Name,
+			pool:           pool,
+			breakers:       breakers,
+			breakerOptions: route.BreakerOptions(),
+			base:           telemetry.Transport(http.DefaultTransport),
+			retries:        retries,
+			backoff:        backoff,
+		})
+
+		var handler http.Handler = proxy
+		if route.Cache != nil && responses != nil {
+			handler = responses.Middleware(route.Cache.TTL)(handler)
+		}
+		handler = authenticate(handler)
+		if route.RateLimit != "" {
+			limit, err := ratelimit.ParseLimit(route.RateLimit)
+			if err != nil {
+				g.Close()
+				return nil, fmt.Errorf("route %s: %w", route.Name, err)
+			}
+			handler = rateLimit(limiter, "route:"+route.Name+":", limit, handler)
+		}
+		handler = allowMethods(route, handler)
+		handler = named(route.Path, handler)
+
+		mux.Handle(route.Path, handler)
+		mux.Handle(route.Path+"/", handler)
+	}
+
+	// Probes
+	mux.Handle(health.PathLive, health.LiveHandler())
+	mux.Handle(health.Path, health.LiveHandler())
+	mux.Handle(health.PathReady, checks.Handler())
+
+	// Root handler
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprintf(w, "Welcome to the API Gateway!")
+			return
+		}
+		http.NotFound(w, r)
+	})
+
+	// Preflight requests are answered before routing, for every route
+	g.handler = mux
+	if routes.CORS != nil {
+		g.handler = cors.Middleware(routes.CORSPolicy())(mux)
+	}
+
+	return g, nil
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.handler.ServeHTTP(w, r)
+}
+
+// Close stops the health checks of the gateway's upstreams.
+func (g *Gateway) Close() error {
+	for _, pool := range g.pools {
+		pool.Close()
+	}
+	return nil
+}
+
+// Breakers returns the state of each upstream's circuit breaker, by
+// address.
+func (g *Gateway) Breakers() map[string]upstream.BreakerStatus {
+	return g.breakers.Status()
+}
+
+// Handler serves through the gateway stored last. Replacing it does not
+// affect requests already being served.
+type Handler struct {
+	current atomic.Pointer[Gateway]
+}
+
+func (h *Handler) Store(g *Gateway) {
+	h.current.Store(g)
+}
+
+func (h *Handler) Current() *Gateway {
+	return h.current.Load()
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.current.Load().ServeHTTP(w, r)
+}
+
+func allowMethods(route config.Route, next http.Handler) http.Handler {
+	if len(route.Methods) == 0 {
+		return next
+	}
+
+	allow := strings.Join(route.Methods, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !route.Allows(r.Method) {
+			w.Header().Set("Allow", allow)
+			respond.Error(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// named labels a route's requests with its path in metrics and logs, the
+// same for the path itself and everything below it.
+func named(path string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metrics.SetRoute(r, path)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimit limits each client address to limit. The gateway is the edge,
+// so the connection's peer is the client.
+func rateLimit(limiter *ratelimit.Limiter, prefix string, limit ratelimit.Limit, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+
+		result := limiter.Allow(prefix+client, limit)
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		if !result.Allowed {
+			metrics.RateLimited("route")
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+			respond.Error(w, r, http.StatusTooManyRequests, "Rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
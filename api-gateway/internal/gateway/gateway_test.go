@@ -0,0 +1,262 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gauss2302/microtest/api-gateway/internal/auth"
+	"github.com/gauss2302/microtest/api-gateway/internal/cache"
+	"github.com/gauss2302/microtest/api-gateway/internal/config"
+	"github.com/gauss2302/microtest/api-gateway/internal/ratelimit"
+	"github.com/gauss2302/microtest/pkg/correlation"
+	"github.com/gauss2302/microtest/pkg/health"
+)
+
+// denyAll rejects every token; the tests only use public routes.
+type denyAll struct{}
+
+func (denyAll) Validate(ctx context.Context, token string) (*auth.Identity, error) {
+	return nil, auth.ErrInvalidToken
+}
+
+// newUpstream echoes the path it was asked for.
+func newUpstream(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		w.Write([]byte(name + " " + r.URL.Path))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func serve(handler http.Handler, method, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+	return rec
+}
+
+func TestNew(t *testing.T) {
+	first := newUpstream(t, "first")
+	second := newUpstream(t, "second")
+
+	limiter := ratelimit.NewLimiter(time.Hour)
+	defer limiter.Close()
+
+	handler, err := New(&config.Routes{Routes: []config.Route{
+		{Name: "plain", Path: "/plain", Upstreams: []string{first.URL}, Auth: "public"},
+		{Name: "stripped", Path: "/stripped", Upstreams: []string{first.URL}, StripPrefix: true, Auth: "public"},
+		{Name: "rewritten", Path: "/v1/items", Upstreams: []string{first.URL}, RewritePrefix: "/items", Auth: "public"},
+		{Name: "balanced", Path: "/balanced", Upstreams: []string{first.URL, second.URL}, Auth: "public"},
+		{Name: "readonly", Path: "/readonly", Upstreams: []string{first.URL}, Methods: []string{"GET"}, Auth: "public"},
+		{Name: "limited", Path: "/limited", Upstreams: []string{first.URL}, RateLimit: "1/m", Auth: "public"},
+		{Name: "slow", Path: "/slow", Upstreams: []string{first.URL}, Timeout: 10 * time.Millisecond, Auth: "public"},
+		{Name: "protected", Path: "/protected", Upstreams: []string{first.URL}},
+	}}, denyAll{}, limiter, nil, nil, net.DefaultResolver)
+	require.NoError(t, err)
+	defer handler.Close()
+
+	assert.Equal(t, "first /plain/1", serve(handler, "GET", "/plain/1").Body.String())
+	assert.Equal(t, "first /1", serve(handler, "GET", "/stripped/1").Body.String())
+	assert.Equal(t, "first /", serve(handler, "GET", "/stripped").Body.String())
+	assert.Equal(t, "first /items/1", serve(handler, "GET", "/v1/items/1").Body.String())
+
+	assert.Equal(t, "first /balanced", serve(handler, "GET", "/balanced").Body.String())
+	assert.Equal(t, "second /balanced", serve(handler, "GET", "/balanced").Body.String())
+
+	rec := serve(handler, "DELETE", "/readonly/1")
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, "GET", rec.Header().Get("Allow"))
+
+	assert.Equal(t, http.StatusOK, serve(handler, "GET", "/limited").Code)
+	rec = serve(handler, "GET", "/limited")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "60", rec.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusGatewayTimeout, serve(handler, "GET", "/slow").Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(handler, "GET", "/protected").Code)
+	assert.Equal(t, http.StatusNotFound, serve(handler, "GET", "/unknown").Code)
+}
+
+func TestHandler_Store(t *testing.T) {
+	upstreamServer := newUpstream(t, "upstream")
+	limiter := ratelimit.NewLimiter(time.Hour)
+	defer limiter.Close()
+
+	build := func(path string) *Gateway {
+		g, err := New(&config.Routes{Routes: []config.Route{
+			{Name: "route", Path: path, Upstreams: []string{upstreamServer.URL}, Auth: "public"},
+		}}, denyAll{}, limiter, nil, nil, net.DefaultResolver)
+		require.NoError(t, err)
+		t.Cleanup(func() { g.Close() })
+		return g
+	}
+
+	handler := &Handler{}
+	handler.Store(build("/old"))
+	assert.Equal(t, http.StatusOK, serve(handler, "GET", "/old").Code)
+
+	handler.Store(build("/new"))
+	assert.Equal(t, http.StatusNotFound, serve(handler, "GET", "/old").Code)
+	assert.Equal(t, http.StatusOK, serve(handler, "GET", "/new").Code)
+}
+
+func TestNew_Cache(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte("product"))
+	}))
+	t.Cleanup(server.Close)
+
+	limiter := ratelimit.NewLimiter(time.Hour)
+	defer limiter.Close()
+	responses := cache.New(1<<20, 1<<10)
+
+	g, err := New(&config.Routes{Routes: []config.Route{
+		{Name: "products", Path: "/products", Upstreams: []string{server.URL}, Auth: "public", Cache: &config.Cache{TTL: time.Minute}},
+	}}, denyAll{}, limiter, responses, nil, net.DefaultResolver)
+	require.NoError(t, err)
+	defer g.Close()
+
+	serve(g, "GET", "/products/1")
+	rec := serve(g, "GET", "/products/1")
+	assert.Equal(t, "product", rec.Body.String())
+	assert.Equal(t, "HIT", rec.Header().Get("X-Cache"))
+	assert.Equal(t, int32(1), calls.Load())
+
+	handler := &Handler{}
+	handler.Store(g)
+	rec = serve(NewAdmin(handler, responses, ""), MethodPurge, "/admin/cache/products?prefix=true")
+	assert.JSONEq(t, `{"purged": 1}`, rec.Body.String())
+
+	serve(g, "GET", "/products/1")
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestNewAdmin_Token(t *testing.T) {
+	admin := NewAdmin(&Handler{}, cache.New(1<<20, 1<<10), "s3cret")
+
+	rec := serve(admin, MethodPurge, "/admin/cache/products")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("WWW-Authenticate"))
+
+	req := httptest.NewRequest(MethodPurge, "/admin/cache/products", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestNew_Correlation(t *testing.T) {
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	t.Cleanup(server.Close)
+
+	limiter := ratelimit.NewLimiter(time.Hour)
+	defer limiter.Close()
+
+	g, err := New(&config.Routes{Routes: []config.Route{
+		{Name: "products", Path: "/products", Upstreams: []string{server.URL}, Auth: "public"},
+		{Name: "down", Path: "/down", Upstreams: []string{"http://127.0.0.1:1"}, Auth: "public", Retries: &config.Retries{}},
+	}}, denyAll{}, limiter, nil, nil, net.DefaultResolver)
+	require.NoError(t, err)
+	defer g.Close()
+	handler := correlation.Middleware(g)
+
+	req := httptest.NewRequest(http.MethodGet, "/products", nil)
+	req.Header.Set(correlation.HeaderTraceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	requestID := rec.Header().Get(correlation.HeaderRequestID)
+	assert.NotEmpty(t, requestID)
+	assert.Equal(t, requestID, received.Get(correlation.HeaderRequestID))
+	assert.Regexp(t, `^00-4bf92f3577b34da6a3ce929d0e0e4736-[0-9a-f]{16}-01$`, received.Get(correlation.HeaderTraceparent))
+	assert.NotContains(t, received.Get(correlation.HeaderTraceparent), "00f067aa0ba902b7")
+
+	// Errors name the request
+	req = httptest.NewRequest(http.MethodGet, "/down", nil)
+	req.Header.Set(correlation.HeaderRequestID, "req-42")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	assert.Equal(t, "req-42", rec.Header().Get(correlation.HeaderRequestID))
+	assert.JSONEq(t, `{"error": "upstream_unavailable", "message": "down is unavailable", "request_id": "req-42"}`, rec.Body.String())
+}
+
+func TestNew_CORS(t *testing.T) {
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte("product"))
+	}))
+	defer upstream.Close()
+
+	handler, err := New(&config.Routes{
+		Routes: []config.Route{
+			{Name: "products", Path: "/products", Upstreams: []string{upstream.URL}, Methods: []string{"GET"}},
+		},
+		CORS: &config.CORS{AllowedOrigins: []string{"https://app.example.com"}},
+	}, denyAll{}, nil, nil, nil, net.DefaultResolver)
+	require.NoError(t, err)
+	defer handler.Close()
+
+	// Preflight requests are answered before methods are checked and
+	// credentials required, and never reach the upstream
+	r := httptest.NewRequest(http.MethodOptions, "/products", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	r.Header.Set("Access-Control-Request-Method", "GET")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "https://app.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Zero(t, calls.Load())
+
+	// Other OPTIONS requests are routed like any other request
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(handler, http.MethodOptions, "/products").Code)
+}
+
+func TestNew_Readiness(t *testing.T) {
+	up := newUpstream(t, "up")
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	handler, err := New(&config.Routes{Routes: []config.Route{
+		{Name: "up", Path: "/up", Upstreams: []string{up.URL}, Auth: "public"},
+		{Name: "down", Path: "/down", Upstreams: []string{down.URL}, Auth: "public"},
+	}}, denyAll{}, nil, nil, nil, net.DefaultResolver)
+	require.NoError(t, err)
+	defer handler.Close()
+
+	assert.Equal(t, http.StatusOK, serve(handler, "GET", "/livez").Code)
+
+	// An unreachable upstream degrades the gateway without taking it out
+	// of rotation
+	rec := serve(handler, "GET", "/readyz")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var report health.Report
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	assert.Equal(t, health.StatusDegraded, report.Status)
+	assert.Equal(t, health.StatusOK, report.Checks["upstream:up"].Status)
+	assert.Equal(t, health.StatusFail, report.Checks["upstream:down"].Status)
+}
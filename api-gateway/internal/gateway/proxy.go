@@ -0,0 +1,70 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+
+	"github.com/gauss2302/microtest/api-gateway/internal/config"
+	"github.com/gauss2302/microtest/api-gateway/internal/upstream"
+	"github.com/gauss2302/microtest/pkg/correlation"
+	"github.com/gauss2302/microtest/pkg/respond"
+)
+
+// newProxy forwards requests for route to its upstreams through transport.
+func newProxy(route config.Route, transport *upstreamTransport) http.Handler {
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			// The transport picks the upstream for every attempt
+			req.URL.Path = rewritePath(route, req.URL.Path)
+			req.URL.RawPath = ""
+			correlation.Inject(req.Context(), req.Header)
+		},
+		Transport: transport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			slog.ErrorContext(r.Context(), "Upstream failed", "route", route.Name, "error", err)
+			var open *circuitOpenError
+			switch {
+			case errors.As(err, &open):
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(open.retryAfter.Seconds()))))
+				respond.ErrorCode(w, r, http.StatusServiceUnavailable, "circuit_open", route.Name+" is failing, try again later")
+			case errors.Is(err, upstream.ErrNoTarget):
+				respond.ErrorCode(w, r, http.StatusServiceUnavailable, "no_upstream", route.Name+" has no healthy instance")
+			case errors.Is(err, context.DeadlineExceeded):
+				respond.ErrorCode(w, r, http.StatusGatewayTimeout, "upstream_timeout", route.Name+" did not answer in time")
+			default:
+				respond.ErrorCode(w, r, http.StatusBadGateway, "upstream_unavailable", route.Name+" is unavailable")
+			}
+		},
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The timeout covers all attempts
+		if route.Timeout > 0 {
+			ctx, cancel := context.WithTimeout(r.Context(), route.Timeout)
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+
+		proxy.ServeHTTP(w, r)
+	})
+}
+
+// rewritePath applies the route's prefix handling to path.
+func rewritePath(route config.Route, path string) string {
+	if !route.StripPrefix && route.RewritePrefix == "" {
+		return path
+	}
+
+	rest := strings.TrimPrefix(path, route.Path)
+	prefix := strings.TrimSuffix(route.RewritePrefix, "/")
+	if prefix+rest == "" {
+		return "/"
+	}
+	return prefix + rest
+}
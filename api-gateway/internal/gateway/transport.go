@@ -0,0 +1,213 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gauss2302/microtest/api-gateway/internal/upstream"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// upstreamDuration times each attempt up to the upstream's response
+// headers. Attempts that got no response are labelled with code "error".
+var upstreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "gateway_upstream_request_duration_seconds",
+	Help:    "Time until an upstream answered an attempt, by route, upstream and status code.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"route", "upstream", "code"})
+
+// maxRetryBody is the largest request body kept for retries. Requests
+// with larger bodies are sent once.
+const maxRetryBody = 64 << 10
+
+// upstreamTransport sends each attempt of a request to the target the
+// pool picks, through that target's circuit breaker. Failed idempotent
+// requests are retried with exponential backoff.
+type upstreamTransport struct {
+	name           string
+	pool           *upstream.Pool
+	breakers       *upstream.Breakers
+	breakerOptions upstream.BreakerOptions
+	base           http.RoundTripper
+	retries        int
+	backoff        time.Duration
+}
+
+// circuitOpenError is returned for attempts an open breaker refused.
+type circuitOpenError struct {
+	retryAfter time.Duration
+}
+
+func (e *circuitOpenError) Error() string {
+	return upstream.ErrCircuitOpen.Error()
+}
+
+func (e *circuitOpenError) Unwrap() error {
+	return upstream.ErrCircuitOpen
+}
+
+func (t *upstreamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	retries := 0
+	var body []byte
+	if idempotent(req.Method) {
+		var ok bool
+		if body, ok = bufferBody(req); ok {
+			retries = t.retries
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := t.try(req, body)
+		if !retryable(resp, err) || attempt >= retries {
+			return resp, err
+		}
+
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+			resp.Body.Close()
+		}
+		slog.WarnContext(req.Context(), "Retrying upstream request", "route", t.name, "method", req.Method, "path", req.URL.Path, "attempt", attempt+2, "reason", describe(resp, err))
+
+		if err := sleep(req.Context(), t.backoff<<attempt); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// try sends one attempt.
+func (t *upstreamTransport) try(req *http.Request, body []byte) (*http.Response, error) {
+	target, err := t.pool.Pick(req)
+	if err != nil {
+		return nil, err
+	}
+	breaker := t.breakers.For(target, t.breakerOptions)
+	if err := breaker.Allow(); err != nil {
+		// The attempt never reached the target, so it has no outcome
+		t.pool.Release(target)
+		return nil, &circuitOpenError{retryAfter: breaker.RetryAfter()}
+	}
+
+	outreq := req.Clone(req.Context())
+	outreq.URL.Scheme = target.URL.Scheme
+	outreq.URL.Host = target.URL.Host
+	if body != nil {
+		outreq.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(outreq)
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	upstreamDuration.WithLabelValues(t.name, target.URL.Host, code).Observe(time.Since(start).Seconds())
+	if errors.Is(err, context.Canceled) {
+		// A client that went away says nothing about the upstream
+		t.pool.Release(target)
+		breaker.Skip()
+		return nil, err
+	}
+
+	failed := err != nil || resp.StatusCode >= http.StatusInternalServerError
+	breaker.Record(!failed)
+	if err != nil {
+		t.pool.Done(target, true)
+		return nil, err
+	}
+
+	// The target stays busy until the response has been streamed
+	resp.Body = &doneBody{ReadCloser: resp.Body, done: func() { t.pool.Done(target, failed) }}
+	return resp, nil
+}
+
+// doneBody reports the end of a response once it is closed.
+type doneBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *doneBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// bufferBody reads the request body so that it can be sent again. It
+// reports false if the body is too large to keep.
+func bufferBody(req *http.Request) ([]byte, bool) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, true
+	}
+	if req.ContentLength > maxRetryBody {
+		return nil, false
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxRetryBody+1))
+	if err != nil || len(body) > maxRetryBody {
+		// Send what was read followed by the rest, once
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+		return nil, false
+	}
+	req.Body.Close()
+	return body, true
+}
+
+// retryable reports whether an attempt failed in a way another attempt
+// may fix.
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, upstream.ErrCircuitOpen) &&
+			!errors.Is(err, context.Canceled) &&
+			!errors.Is(err, context.DeadlineExceeded)
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func describe(resp *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return resp.Status
+}
+
+// sleep waits for d plus up to half as much jitter, so that retries from
+// many clients do not arrive together.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d > 0 {
+		d += time.Duration(rand.Int63n(int64(d)/2 + 1))
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
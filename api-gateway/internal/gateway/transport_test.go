@@ -0,0 +1,161 @@
+package gateway
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gauss2302/microtest/api-gateway/internal/cache"
+	"github.com/gauss2302/microtest/api-gateway/internal/config"
+	"github.com/gauss2302/microtest/api-gateway/internal/ratelimit"
+	"github.com/gauss2302/microtest/api-gateway/internal/upstream"
+)
+
+// flakyUpstream answers 503 to the first failures requests and echoes the
+// request body afterwards.
+func flakyUpstream(t *testing.T, failures int32) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.Copy(w, r.Body)
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func sampleCount(t *testing.T, histogram prometheus.Histogram) int {
+	t.Helper()
+
+	var metric dto.Metric
+	require.NoError(t, histogram.Write(&metric))
+	return int(metric.GetHistogram().GetSampleCount())
+}
+
+func newTestGateway(t *testing.T, route config.Route) *Gateway {
+	t.Helper()
+
+	limiter := ratelimit.NewLimiter(time.Hour)
+	t.Cleanup(func() { limiter.Close() })
+
+	route.Name, route.Path, route.Auth = "flaky", "/flaky", "public"
+	g, err := New(&config.Routes{Routes: []config.Route{route}}, denyAll{}, limiter, nil, nil, net.DefaultResolver)
+	require.NoError(t, err)
+	t.Cleanup(func() { g.Close() })
+	return g
+}
+
+func TestTransport_Retries(t *testing.T) {
+	t.Run("idempotent requests are retried with their body", func(t *testing.T) {
+		server, calls := flakyUpstream(t, 2)
+		g := newTestGateway(t, config.Route{
+			Upstreams: []string{server.URL},
+			Retries:   &config.Retries{Attempts: 2, Backoff: time.Millisecond},
+		})
+
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/flaky", strings.NewReader("payload")))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "payload", rec.Body.String())
+		assert.Equal(t, int32(3), calls.Load())
+
+		// Every attempt is timed
+		host := strings.TrimPrefix(server.URL, "http://")
+		failed := upstreamDuration.WithLabelValues("flaky", host, "503").(prometheus.Histogram)
+		succeeded := upstreamDuration.WithLabelValues("flaky", host, "200").(prometheus.Histogram)
+		assert.Equal(t, 2, sampleCount(t, failed))
+		assert.Equal(t, 1, sampleCount(t, succeeded))
+	})
+
+	t.Run("other requests are not", func(t *testing.T) {
+		server, calls := flakyUpstream(t, 1)
+		g := newTestGateway(t, config.Route{
+			Upstreams: []string{server.URL},
+			Retries:   &config.Retries{Attempts: 2, Backoff: time.Millisecond},
+		})
+
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/flaky", strings.NewReader("payload")))
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("attempts run out", func(t *testing.T) {
+		server, calls := flakyUpstream(t, 10)
+		g := newTestGateway(t, config.Route{
+			Upstreams: []string{server.URL},
+			Retries:   &config.Retries{Attempts: 1, Backoff: time.Millisecond},
+		})
+
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/flaky", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Equal(t, int32(2), calls.Load())
+	})
+}
+
+func TestTransport_CircuitBreaker(t *testing.T) {
+	server, calls := flakyUpstream(t, 100)
+	host := strings.TrimPrefix(server.URL, "http://")
+	route := config.Route{
+		Upstreams:      []string{server.URL},
+		Retries:        &config.Retries{Attempts: 0},
+		CircuitBreaker: config.CircuitBreaker{FailureThreshold: 2, OpenTimeout: time.Minute},
+	}
+	g := newTestGateway(t, route)
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/flaky", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	}
+	assert.Equal(t, upstream.Open, g.Breakers()[host].State)
+
+	// Open breakers fail fast without calling the upstream
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/flaky", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "60", rec.Header().Get("Retry-After"))
+	var body map[string]string
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	assert.Equal(t, "circuit_open", body["error"])
+	assert.Equal(t, int32(2), calls.Load())
+
+	// The admin API reports the state
+	handler := &Handler{}
+	handler.Store(g)
+	rec = httptest.NewRecorder()
+	NewAdmin(handler, cache.New(1<<20, 1<<10), "").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/breakers", nil))
+	var breakers map[string]upstream.BreakerStatus
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&breakers))
+	assert.Equal(t, upstream.Open, breakers[host].State)
+
+	// A reloaded route table keeps the breakers of its upstreams
+	limiter := ratelimit.NewLimiter(time.Hour)
+	defer limiter.Close()
+	route.Name, route.Path, route.Auth = "renamed", "/renamed", "public"
+	reloaded, err := New(&config.Routes{Routes: []config.Route{route}}, denyAll{}, limiter, nil, g.breakers, net.DefaultResolver)
+	require.NoError(t, err)
+	defer reloaded.Close()
+
+	rec = httptest.NewRecorder()
+	reloaded.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/renamed", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, int32(2), calls.Load())
+}
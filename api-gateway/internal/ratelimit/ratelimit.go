@@ -0,0 +1,145 @@
+// Package ratelimit limits requests per key in memory with the generic cell
+// rate algorithm (GCRA), which keeps a single timestamp per key: the
+// theoretical arrival time (TAT) of the next request.
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit allows Count requests per Period, all of which may arrive at once.
+type Limit struct {
+	Count  int
+	Period time.Duration
+}
+
+// ParseLimit parses "<count>/<period>", where period is s, m, h or a Go
+// duration such as 10s.
+func ParseLimit(s string) (Limit, error) {
+	count, period, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid limit %q: want <count>/<period>", s)
+	}
+
+	n, err := strconv.Atoi(count)
+	if err != nil || n <= 0 {
+		return Limit{}, fmt.Errorf("invalid limit %q: count must be a positive integer", s)
+	}
+
+	var d time.Duration
+	switch period {
+	case "s":
+		d = time.Second
+	case "m":
+		d = time.Minute
+	case "h":
+		d = time.Hour
+	default:
+		d, err = time.ParseDuration(period)
+		if err != nil || d <= 0 {
+			return Limit{}, fmt.Errorf("invalid limit %q: bad period", s)
+		}
+	}
+
+	return Limit{Count: n, Period: d}, nil
+}
+
+func (l Limit) String() string {
+	return fmt.Sprintf("%d/%s", l.Count, l.Period)
+}
+
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long a rejected caller should wait
+	RetryAfter time.Duration
+}
+
+// Limiter keeps state in the process, so each gateway replica enforces
+// limits on its own.
+type Limiter struct {
+	mutex sync.Mutex
+	tats  map[string]time.Time
+	now   func() time.Time
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewLimiter drops idle keys every cleanupInterval until it is closed.
+func NewLimiter(cleanupInterval time.Duration) *Limiter {
+	limiter := &Limiter{
+		tats: make(map[string]time.Time),
+		now:  time.Now,
+		done: make(chan struct{}),
+	}
+
+	go limiter.cleanup(cleanupInterval)
+
+	return limiter
+}
+
+// Allow decides whether a request for key is within limit and records it
+// if so.
+func (l *Limiter) Allow(key string, limit Limit) Result {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	tat := l.tats[key]
+	if tat.Before(now) {
+		tat = now
+	}
+
+	interval := limit.Period / time.Duration(limit.Count)
+	newTAT := tat.Add(interval)
+	allowAt := newTAT.Add(-limit.Period)
+	if now.Before(allowAt) {
+		return Result{
+			Allowed:    false,
+			Limit:      limit.Count,
+			RetryAfter: allowAt.Sub(now),
+		}
+	}
+
+	l.tats[key] = newTAT
+	return Result{
+		Allowed:   true,
+		Limit:     limit.Count,
+		Remaining: int(now.Sub(allowAt) / interval),
+	}
+}
+
+// Close stops the cleanup.
+func (l *Limiter) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return nil
+}
+
+// cleanup removes keys whose tat has passed; they are the same as absent.
+func (l *Limiter) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+		}
+
+		l.mutex.Lock()
+		now := l.now()
+		for key, tat := range l.tats {
+			if tat.Before(now) {
+				delete(l.tats, key)
+			}
+		}
+		l.mutex.Unlock()
+	}
+}
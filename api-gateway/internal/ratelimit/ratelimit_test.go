@@ -0,0 +1,49 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLimit(t *testing.T) {
+	limit, err := ParseLimit("100/10s")
+	assert.NoError(t, err)
+	assert.Equal(t, Limit{Count: 100, Period: 10 * time.Second}, limit)
+
+	limit, err = ParseLimit("5/m")
+	assert.NoError(t, err)
+	assert.Equal(t, Limit{Count: 5, Period: time.Minute}, limit)
+
+	for _, invalid := range []string{"", "5", "0/m", "x/m", "5/-1s", "5/fortnight"} {
+		_, err := ParseLimit(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestLimiter_Allow(t *testing.T) {
+	now := time.Now()
+	limiter := NewLimiter(time.Hour)
+	defer limiter.Close()
+	limiter.now = func() time.Time { return now }
+
+	limit := Limit{Count: 2, Period: time.Minute}
+
+	result := limiter.Allow("client", limit)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 1, result.Remaining)
+	assert.True(t, limiter.Allow("client", limit).Allowed)
+
+	result = limiter.Allow("client", limit)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 30*time.Second, result.RetryAfter)
+
+	// Other keys are counted separately
+	assert.True(t, limiter.Allow("other", limit).Allowed)
+
+	now = now.Add(30 * time.Second)
+	result = limiter.Allow("client", limit)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+}
@@ -0,0 +1,149 @@
+package upstream
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+)
+
+// Strategy names a way of spreading requests over a pool's targets.
+type Strategy string
+
+const (
+	RoundRobin       Strategy = "round_robin"
+	LeastConnections Strategy = "least_connections"
+	// ConsistentHash sends requests with the same key to the same target
+	// while the set of targets is stable
+	ConsistentHash Strategy = "consistent_hash"
+)
+
+// ParseStrategy accepts the strategies above; empty means round robin.
+func ParseStrategy(s string) (Strategy, error) {
+	switch Strategy(s) {
+	case "", RoundRobin:
+		return RoundRobin, nil
+	case LeastConnections, ConsistentHash:
+		return Strategy(s), nil
+	default:
+		return "", fmt.Errorf("unknown load balancing strategy %q", s)
+	}
+}
+
+// balancer picks one of targets for r, skipping unavailable ones. It
+// returns nil when none is available.
+type balancer interface {
+	pick(targets []*Target, r *http.Request) *Target
+}
+
+type roundRobin struct {
+	next atomic.Uint64
+}
+
+func (b *roundRobin) pick(targets []*Target, r *http.Request) *Target {
+	start := b.next.Add(1) - 1
+	for i := range targets {
+		target := targets[(start+uint64(i))%uint64(len(targets))]
+		if target.Available() {
+			return target
+		}
+	}
+	return nil
+}
+
+type leastConnections struct {
+	// next breaks ties, so idle targets share the load
+	next atomic.Uint64
+}
+
+func (b *leastConnections) pick(targets []*Target, r *http.Request) *Target {
+	start := b.next.Add(1) - 1
+	var best *Target
+	for i := range targets {
+		target := targets[(start+uint64(i))%uint64(len(targets))]
+		if target.Available() && (best == nil || target.Active() < best.Active()) {
+			best = target
+		}
+	}
+	return best
+}
+
+// ringReplicas is the number of points each target has on the hash ring.
+// More points spread keys more evenly.
+const ringReplicas = 100
+
+type ringPoint struct {
+	hash   uint64
+	target *Target
+}
+
+// consistentHash hashes a header, or the client address when it is
+// absent, onto a ring of targets. Unavailable targets are passed over, so
+// only their keys move.
+type consistentHash struct {
+	header string
+	// ring is rebuilt when the targets change
+	ring atomic.Pointer[[]ringPoint]
+}
+
+func (b *consistentHash) rebuild(targets []*Target) {
+	ring := make([]ringPoint, 0, len(targets)*ringReplicas)
+	for _, target := range targets {
+		for i := 0; i < ringReplicas; i++ {
+			ring = append(ring, ringPoint{hash: hash(target.URL.String() + "#" + strconv.Itoa(i)), target: target})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+	b.ring.Store(&ring)
+}
+
+func (b *consistentHash) pick(targets []*Target, r *http.Request) *Target {
+	ring := b.ring.Load()
+	if ring == nil || len(*ring) == 0 {
+		return nil
+	}
+	points := *ring
+
+	key := ""
+	if b.header != "" {
+		key = r.Header.Get(b.header)
+	}
+	if key == "" {
+		key = clientAddress(r)
+	}
+
+	h := hash(key)
+	start := sort.Search(len(points), func(i int) bool { return points[i].hash >= h })
+	for i := range points {
+		point := points[(start+i)%len(points)]
+		if point.target.Available() {
+			return point.target
+		}
+	}
+	return nil
+}
+
+// hash is FNV-1a with the MurmurHash3 finalizer, which spreads similar
+// keys such as consecutive user IDs over the whole ring.
+func hash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,208 @@
+package upstream
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned while a breaker refuses requests.
+var ErrCircuitOpen = errors.New("circuit open")
+
+// BreakerState is the state of a circuit breaker.
+type BreakerState string
+
+const (
+	// Closed lets requests through and counts failures
+	Closed BreakerState = "closed"
+	// Open refuses requests until its timeout has passed
+	Open BreakerState = "open"
+	// HalfOpen lets a few probe requests through to decide whether to
+	// close again
+	HalfOpen BreakerState = "half_open"
+)
+
+// BreakerOptions configure a Breaker. Zero values select the defaults.
+type BreakerOptions struct {
+	// FailureThreshold consecutive failures open the breaker; 5 by default
+	FailureThreshold int
+	// OpenTimeout is how long the breaker stays open; 30s by default
+	OpenTimeout time.Duration
+	// HalfOpenRequests probes must all succeed to close it; 1 by default
+	HalfOpenRequests int
+}
+
+// Breaker stops calls to an upstream that keeps failing, so that callers
+// fail fast instead of waiting for it, and lets it recover.
+type Breaker struct {
+	options BreakerOptions
+
+	mutex     sync.Mutex
+	state     BreakerState
+	failures  int
+	openedAt  time.Time
+	probes    int // requests let through while half-open
+	successes int // of those, how many succeeded
+	now       func() time.Time
+}
+
+func NewBreaker(options BreakerOptions) *Breaker {
+	return &Breaker{options: options.withDefaults(), state: Closed, now: time.Now}
+}
+
+func (o BreakerOptions) withDefaults() BreakerOptions {
+	if o.FailureThreshold <= 0 {
+		o.FailureThreshold = 5
+	}
+	if o.OpenTimeout <= 0 {
+		o.OpenTimeout = 30 * time.Second
+	}
+	if o.HalfOpenRequests <= 0 {
+		o.HalfOpenRequests = 1
+	}
+	return o
+}
+
+// Allow reports whether a request may go through. Every allowed request
+// must be followed by a call to Record.
+func (b *Breaker) Allow() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state == Open {
+		if b.now().Sub(b.openedAt) < b.options.OpenTimeout {
+			return ErrCircuitOpen
+		}
+		b.state, b.probes, b.successes = HalfOpen, 0, 0
+	}
+
+	if b.state == HalfOpen {
+		if b.probes >= b.options.HalfOpenRequests {
+			return ErrCircuitOpen
+		}
+		b.probes++
+	}
+	return nil
+}
+
+// Record reports the outcome of an allowed request.
+func (b *Breaker) Record(success bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case Closed:
+		if success {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.options.FailureThreshold {
+			b.open()
+		}
+	case HalfOpen:
+		if !success {
+			b.open()
+			return
+		}
+		b.successes++
+		if b.successes >= b.options.HalfOpenRequests {
+			b.state, b.failures = Closed, 0
+		}
+	}
+}
+
+// Skip gives back an allowed request's place without an outcome, for
+// requests that ended before reaching the upstream or for reasons that
+// say nothing about it.
+func (b *Breaker) Skip() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state == HalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+func (b *Breaker) open() {
+	b.state = Open
+	b.openedAt = b.now()
+	b.failures = 0
+}
+
+// RetryAfter is how long an open breaker will keep refusing requests.
+func (b *Breaker) RetryAfter() time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state != Open {
+		return 0
+	}
+	return b.options.OpenTimeout - b.now().Sub(b.openedAt)
+}
+
+// BreakerStatus is a snapshot of a breaker for the admin API.
+type BreakerStatus struct {
+	State    BreakerState `json:"state"`
+	Failures int          `json:"failures"`
+	OpenedAt *time.Time   `json:"opened_at,omitempty"`
+}
+
+func (b *Breaker) Status() BreakerStatus {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	status := BreakerStatus{State: b.state, Failures: b.failures}
+	if b.state != Closed {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+	return status
+}
+
+// Breakers holds a circuit breaker for each upstream address. It outlives
+// the route tables that use it, so reloading the routes does not close
+// open breakers, and routes to the same upstream share its breaker.
+type Breakers struct {
+	mutex    sync.Mutex
+	breakers map[string]*Breaker
+}
+
+func NewBreakers() *Breakers {
+	return &Breakers{breakers: make(map[string]*Breaker)}
+}
+
+// For returns the breaker of target's address, creating it if needed. The
+// breaker keeps its state but takes on options, so that changed settings
+// apply after a reload.
+func (b *Breakers) For(target *Target, options BreakerOptions) *Breaker {
+	address := target.hostPort()
+	options = options.withDefaults()
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	breaker, ok := b.breakers[address]
+	if !ok {
+		breaker = NewBreaker(options)
+		b.breakers[address] = breaker
+		return breaker
+	}
+
+	breaker.mutex.Lock()
+	breaker.options = options
+	breaker.mutex.Unlock()
+	return breaker
+}
+
+// Status returns the state of each breaker by upstream address.
+func (b *Breakers) Status() map[string]BreakerStatus {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	statuses := make(map[string]BreakerStatus, len(b.breakers))
+	for address, breaker := range b.breakers {
+		statuses[address] = breaker.Status()
+	}
+	return statuses
+}
@@ -0,0 +1,73 @@
+package upstream
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Now()
+	breaker := NewBreaker(BreakerOptions{FailureThreshold: 2, OpenTimeout: time.Minute, HalfOpenRequests: 1})
+	breaker.now = func() time.Time { return now }
+
+	call := func(success bool) error {
+		if err := breaker.Allow(); err != nil {
+			return err
+		}
+		breaker.Record(success)
+		return nil
+	}
+
+	// Successes reset the count
+	assert.NoError(t, call(false))
+	assert.NoError(t, call(true))
+	assert.NoError(t, call(false))
+	assert.Equal(t, Closed, breaker.Status().State)
+
+	assert.NoError(t, call(false))
+	assert.Equal(t, Open, breaker.Status().State)
+	assert.ErrorIs(t, call(true), ErrCircuitOpen)
+	assert.Equal(t, time.Minute, breaker.RetryAfter())
+
+	// A failed probe opens it again
+	now = now.Add(time.Minute)
+	assert.NoError(t, call(false))
+	assert.Equal(t, Open, breaker.Status().State)
+
+	// Only one probe at a time
+	now = now.Add(time.Minute)
+	assert.NoError(t, breaker.Allow())
+	assert.Equal(t, HalfOpen, breaker.Status().State)
+	assert.ErrorIs(t, breaker.Allow(), ErrCircuitOpen)
+
+	breaker.Record(true)
+	assert.Equal(t, Closed, breaker.Status().State)
+	assert.NoError(t, call(true))
+}
+
+func TestBreakers(t *testing.T) {
+	breakers := NewBreakers()
+	a, err := url.Parse("http://a:8080")
+	require.NoError(t, err)
+	aAgain, err := url.Parse("http://a:8080/other")
+	require.NoError(t, err)
+
+	breaker := breakers.For(newTarget(a), BreakerOptions{FailureThreshold: 1})
+	assert.NoError(t, breaker.Allow())
+	breaker.Record(false)
+	assert.Equal(t, Open, breaker.Status().State)
+
+	// Targets at the same address share the breaker, which takes on new
+	// options but keeps its state
+	shared := breakers.For(newTarget(aAgain), BreakerOptions{FailureThreshold: 3})
+	assert.Same(t, breaker, shared)
+	assert.Equal(t, Open, shared.Status().State)
+	assert.Equal(t, 3, shared.options.FailureThreshold)
+
+	assert.Len(t, breakers.Status(), 1)
+	assert.Equal(t, Open, breakers.Status()["a:8080"].State)
+}
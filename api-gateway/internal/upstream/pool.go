@@ -0,0 +1,406 @@
+// Package upstream balances requests over the instances behind a route,
+// keeping track of which of them are healthy.
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNoTarget is returned when every target is down or ejected.
+var ErrNoTarget = errors.New("no healthy upstream")
+
+// Target is one instance of an upstream service.
+type Target struct {
+	URL *url.URL
+
+	active       atomic.Int64
+	healthy      atomic.Bool
+	failures     atomic.Int32
+	ejectedUntil atomic.Int64 // Unix nanoseconds
+}
+
+func newTarget(u *url.URL) *Target {
+	target := &Target{URL: u}
+	// Assume the best until a health check says otherwise
+	target.healthy.Store(true)
+	return target
+}
+
+// Available reports whether the target may receive requests: it passed
+// its last health check and is not ejected.
+func (t *Target) Available() bool {
+	return t.healthy.Load() && time.Now().UnixNano() >= t.ejectedUntil.Load()
+}
+
+// hostPort is the address of the target, with the port of its scheme if
+// the URL has none.
+func (t *Target) hostPort() string {
+	if t.URL.Port() != "" {
+		return t.URL.Host
+	}
+	port := "80"
+	if t.URL.Scheme == "https" {
+		port = "443"
+	}
+	return net.JoinHostPort(t.URL.Hostname(), port)
+}
+
+// Active is the number of requests the target is serving.
+func (t *Target) Active() int64 {
+	return t.active.Load()
+}
+
+// HealthCheck configures active checks: every Interval each target's Path
+// is requested, and the target is taken out of rotation while that fails.
+// The defaults are /readyz every 10s with a 2s timeout.
+type HealthCheck struct {
+	Path     string
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// Options configure a Pool. Zero values select the defaults.
+type Options struct {
+	Strategy Strategy
+	// HashHeader is the request header consistent hashing keys on; the
+	// client address is used without it
+	HashHeader string
+	// HealthCheck enables active health checks when set
+	HealthCheck *HealthCheck
+	// A target that fails MaxFailures requests in a row (transport errors
+	// or 5xx responses) is ejected for EjectionDuration; 5 and 30s by
+	// default
+	MaxFailures      int
+	EjectionDuration time.Duration
+	// DNSRefresh is how often dns+http(s) upstreams are resolved again,
+	// 30s by default
+	DNSRefresh time.Duration
+}
+
+const (
+	defaultMaxFailures      = 5
+	defaultEjectionDuration = 30 * time.Second
+	defaultDNSRefresh       = 30 * time.Second
+	defaultHealthPath       = "/readyz"
+	defaultHealthInterval   = 10 * time.Second
+	defaultHealthTimeout    = 2 * time.Second
+	dnsScheme               = "dns+"
+)
+
+// Resolver looks up the addresses behind a name. net.DefaultResolver
+// satisfies it.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// Pool is the set of targets of one route. An upstream written as
+// dns+http://name:port stands for every address name resolves to, which
+// is how the instances behind a headless Kubernetes service are found.
+type Pool struct {
+	name     string
+	options  Options
+	balancer balancer
+	resolver Resolver
+	client   *http.Client
+
+	static []*url.URL
+	dns    []*url.URL
+	// resolved holds the targets last found for each of dns
+	resolved [][]*Target
+
+	mutex   sync.Mutex // serializes updates of targets
+	targets atomic.Pointer[[]*Target]
+
+	cancel context.CancelFunc
+	done   sync.WaitGroup
+}
+
+// NewPool creates a pool and starts its health checks and DNS refreshes,
+// which run until it is closed.
+func NewPool(name string, upstreams []string, options Options, resolver Resolver) (*Pool, error) {
+	if options.MaxFailures <= 0 {
+		options.MaxFailures = defaultMaxFailures
+	}
+	if options.EjectionDuration <= 0 {
+		options.EjectionDuration = defaultEjectionDuration
+	}
+	if options.DNSRefresh <= 0 {
+		options.DNSRefresh = defaultDNSRefresh
+	}
+	if options.HealthCheck != nil {
+		check := *options.HealthCheck
+		if check.Path == "" {
+			check.Path = defaultHealthPath
+		}
+		if check.Interval <= 0 {
+			check.Interval = defaultHealthInterval
+		}
+		if check.Timeout <= 0 {
+			check.Timeout = defaultHealthTimeout
+		}
+		options.HealthCheck = &check
+	}
+
+	pool := &Pool{
+		name:     name,
+		options:  options,
+		resolver: resolver,
+		client:   &http.Client{},
+	}
+
+	switch options.Strategy {
+	case "", RoundRobin:
+		pool.balancer = &roundRobin{}
+	case LeastConnections:
+		pool.balancer = &leastConnections{}
+	case ConsistentHash:
+		pool.balancer = &consistentHash{header: options.HashHeader}
+	default:
+		return nil, fmt.Errorf("unknown load balancing strategy %q", options.Strategy)
+	}
+
+	for _, upstream := range upstreams {
+		u, err := url.Parse(upstream)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(u.Scheme, dnsScheme) {
+			u.Scheme = strings.TrimPrefix(u.Scheme, dnsScheme)
+			pool.dns = append(pool.dns, u)
+		} else {
+			pool.static = append(pool.static, u)
+		}
+	}
+
+	pool.resolved = make([][]*Target, len(pool.dns))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pool.cancel = cancel
+
+	targets := make([]*Target, len(pool.static))
+	for i, u := range pool.static {
+		targets[i] = newTarget(u)
+	}
+	pool.setTargets(targets)
+	if len(pool.dns) > 0 {
+		pool.resolve(ctx)
+		pool.loop(ctx, options.DNSRefresh, pool.resolve)
+	}
+	if options.HealthCheck != nil {
+		pool.checkHealth(ctx)
+		pool.loop(ctx, options.HealthCheck.Interval, pool.checkHealth)
+	}
+
+	return pool, nil
+}
+
+// Targets returns the pool's current targets.
+func (p *Pool) Targets() []*Target {
+	return *p.targets.Load()
+}
+
+// Pick chooses the target for r. The caller must pass it to Done once the
+// request has completed.
+func (p *Pool) Pick(r *http.Request) (*Target, error) {
+	target := p.balancer.pick(p.Targets(), r)
+	if target == nil {
+		return nil, ErrNoTarget
+	}
+	target.active.Add(1)
+	return target, nil
+}
+
+// Done records the outcome of a request to target. Repeated failures
+// eject it, unless it is the last available target.
+func (p *Pool) Done(target *Target, failed bool) {
+	target.active.Add(-1)
+
+	if !failed {
+		target.failures.Store(0)
+		return
+	}
+	if target.failures.Add(1) < int32(p.options.MaxFailures) {
+		return
+	}
+
+	for _, other := range p.Targets() {
+		if other != target && other.Available() {
+			target.failures.Store(0)
+			target.ejectedUntil.Store(time.Now().Add(p.options.EjectionDuration).UnixNano())
+			slog.Warn("Ejected upstream target",
+				"route", p.name, "target", target.URL.Host,
+				"duration", p.options.EjectionDuration, "failures", p.options.MaxFailures)
+			return
+		}
+	}
+}
+
+// Release gives back a target picked for a request that never reached it,
+// or that ended for reasons that say nothing about it, without recording
+// an outcome.
+func (p *Pool) Release(target *Target) {
+	target.active.Add(-1)
+}
+
+// Check reports whether the pool can serve requests. With active health
+// checks that is whether a target passed its last one; otherwise whether
+// any target accepts connections.
+func (p *Pool) Check(ctx context.Context) error {
+	targets := p.Targets()
+	if p.options.HealthCheck != nil {
+		for _, target := range targets {
+			if target.Available() {
+				return nil
+			}
+		}
+		return ErrNoTarget
+	}
+
+	errs := make(chan error, len(targets))
+	for _, target := range targets {
+		go func() {
+			var dialer net.Dialer
+			conn, err := dialer.DialContext(ctx, "tcp", target.hostPort())
+			if err == nil {
+				conn.Close()
+			}
+			errs <- err
+		}()
+	}
+	err := ErrNoTarget
+	for range targets {
+		if err = <-errs; err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// Close stops the health checks and DNS refreshes.
+func (p *Pool) Close() error {
+	p.cancel()
+	p.done.Wait()
+	return nil
+}
+
+func (p *Pool) setTargets(targets []*Target) {
+	p.targets.Store(&targets)
+	if ring, ok := p.balancer.(*consistentHash); ok {
+		ring.rebuild(targets)
+	}
+}
+
+func (p *Pool) loop(ctx context.Context, interval time.Duration, fn func(ctx context.Context)) {
+	p.done.Add(1)
+	go func() {
+		defer p.done.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				fn(ctx)
+			}
+		}
+	}()
+}
+
+// resolve replaces the targets of dns upstreams with the addresses their
+// names currently resolve to. Targets that remain keep their state; a
+// failed lookup keeps the previous addresses.
+func (p *Pool) resolve(ctx context.Context) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	current := make(map[string]*Target)
+	for _, target := range p.Targets() {
+		current[target.URL.String()] = target
+	}
+
+	targets := make([]*Target, len(p.static))
+	for i, u := range p.static {
+		targets[i] = current[u.String()]
+	}
+
+	for i, u := range p.dns {
+		addresses, err := p.resolver.LookupHost(ctx, u.Hostname())
+		if err != nil {
+			slog.Error("Failed to resolve upstream", "route", p.name, "host", u.Hostname(), "error", err)
+			targets = append(targets, p.resolved[i]...)
+			continue
+		}
+
+		resolved := make([]*Target, 0, len(addresses))
+		for _, address := range addresses {
+			addressURL := *u
+			addressURL.Host = net.JoinHostPort(address, u.Port())
+			if target, ok := current[addressURL.String()]; ok {
+				resolved = append(resolved, target)
+			} else {
+				resolved = append(resolved, newTarget(&addressURL))
+			}
+		}
+		p.resolved[i] = resolved
+		targets = append(targets, resolved...)
+	}
+
+	p.setTargets(targets)
+}
+
+// checkHealth requests every target's health path at once.
+func (p *Pool) checkHealth(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, target := range p.Targets() {
+		wg.Add(1)
+		go func(target *Target) {
+			defer wg.Done()
+
+			healthy := p.probe(ctx, target)
+			if ctx.Err() != nil {
+				// Closed while probing
+				return
+			}
+			if target.healthy.Swap(healthy) != healthy {
+				state := "unhealthy"
+				if healthy {
+					state = "healthy"
+				}
+				slog.Info("Upstream target changed state", "route", p.name, "target", target.URL.Host, "state", state)
+			}
+		}(target)
+	}
+	wg.Wait()
+}
+
+func (p *Pool) probe(ctx context.Context, target *Target) bool {
+	check := p.options.HealthCheck
+	ctx, cancel := context.WithTimeout(ctx, check.Timeout)
+	defer cancel()
+
+	healthURL := *target.URL
+	healthURL.Path = check.Path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, healthURL.String(), nil)
+	if err != nil {
+		return false
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 400
+}
@@ -0,0 +1,226 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPool(t *testing.T, upstreams []string, options Options) *Pool {
+	t.Helper()
+
+	pool, err := NewPool("test", upstreams, options, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { pool.Close() })
+	return pool
+}
+
+func pick(t *testing.T, pool *Pool, r *http.Request) string {
+	t.Helper()
+
+	target, err := pool.Pick(r)
+	require.NoError(t, err)
+	pool.Done(target, false)
+	return target.URL.Host
+}
+
+func TestPool_RoundRobin(t *testing.T) {
+	pool := newTestPool(t, []string{"http://a:80", "http://b:80", "http://c:80"}, Options{})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	assert.Equal(t, []string{"a:80", "b:80", "c:80", "a:80"}, []string{
+		pick(t, pool, r), pick(t, pool, r), pick(t, pool, r), pick(t, pool, r),
+	})
+}
+
+func TestPool_LeastConnections(t *testing.T) {
+	pool := newTestPool(t, []string{"http://a:80", "http://b:80"}, Options{Strategy: LeastConnections})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	busy, err := pool.Pick(r)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		assert.NotEqual(t, busy.URL.Host, pick(t, pool, r))
+	}
+	pool.Done(busy, false)
+}
+
+func TestPool_ConsistentHash(t *testing.T) {
+	pool := newTestPool(t, []string{"http://a:80", "http://b:80", "http://c:80"}, Options{
+		Strategy:   ConsistentHash,
+		HashHeader: "X-User-ID",
+	})
+
+	hosts := make(map[string]bool)
+	for _, user := range []string{"1", "2", "3", "4", "5", "6", "7", "8"} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-User-ID", user)
+
+		host := pick(t, pool, r)
+		hosts[host] = true
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, host, pick(t, pool, r), "user %s moved", user)
+		}
+	}
+	assert.Greater(t, len(hosts), 1, "keys spread over targets")
+
+	// Without the header the client address is the key
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	assert.Equal(t, pick(t, pool, r), pick(t, pool, r))
+}
+
+func TestPool_PassiveEjection(t *testing.T) {
+	pool := newTestPool(t, []string{"http://a:80", "http://b:80"}, Options{
+		MaxFailures:      2,
+		EjectionDuration: time.Minute,
+	})
+	a, b := pool.Targets()[0], pool.Targets()[1]
+
+	fail := func(target *Target) {
+		target.active.Add(1)
+		pool.Done(target, true)
+	}
+
+	fail(a)
+	assert.True(t, a.Available())
+	// Requests that never reached the target leave its count alone
+	a.active.Add(1)
+	pool.Release(a)
+	assert.Zero(t, a.Active())
+	fail(a)
+	assert.False(t, a.Available())
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	assert.Equal(t, "b:80", pick(t, pool, r))
+	assert.Equal(t, "b:80", pick(t, pool, r))
+
+	// The last available target is never ejected
+	fail(b)
+	fail(b)
+	assert.True(t, b.Available())
+}
+
+func TestPool_HealthCheck(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/readyz" || !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	pool := newTestPool(t, []string{server.URL}, Options{
+		HealthCheck: &HealthCheck{Interval: 10 * time.Millisecond},
+	})
+	target := pool.Targets()[0]
+	assert.True(t, target.Available())
+
+	healthy.Store(false)
+	assert.Eventually(t, func() bool { return !target.Available() }, time.Second, 10*time.Millisecond)
+
+	_, err := pool.Pick(httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.ErrorIs(t, err, ErrNoTarget)
+
+	healthy.Store(true)
+	assert.Eventually(t, target.Available, time.Second, 10*time.Millisecond)
+}
+
+func TestPool_Check(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	t.Run("dials targets without health checks", func(t *testing.T) {
+		assert.NoError(t, newTestPool(t, []string{server.URL}, Options{}).Check(context.Background()))
+
+		closed := httptest.NewServer(http.NotFoundHandler())
+		closed.Close()
+		assert.Error(t, newTestPool(t, []string{closed.URL}, Options{}).Check(context.Background()))
+		assert.NoError(t, newTestPool(t, []string{closed.URL, server.URL}, Options{}).Check(context.Background()))
+	})
+
+	t.Run("uses the last health check", func(t *testing.T) {
+		pool := newTestPool(t, []string{server.URL}, Options{
+			HealthCheck: &HealthCheck{Interval: time.Hour},
+		})
+		// The upstream answers 404 to /readyz
+		assert.ErrorIs(t, pool.Check(context.Background()), ErrNoTarget)
+	})
+
+	t.Run("fails without targets", func(t *testing.T) {
+		assert.ErrorIs(t, newTestPool(t, nil, Options{}).Check(context.Background()), ErrNoTarget)
+	})
+}
+
+// fakeResolver answers from a map that tests can change.
+type fakeResolver struct {
+	mutex sync.Mutex
+	hosts map[string][]string
+}
+
+func (r *fakeResolver) set(host string, addresses ...string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.hosts[host] = addresses
+}
+
+func (r *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	addresses, ok := r.hosts[host]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return addresses, nil
+}
+
+func TestPool_DNS(t *testing.T) {
+	resolver := &fakeResolver{hosts: make(map[string][]string)}
+	resolver.set("users", "10.0.0.1", "10.0.0.2")
+
+	pool, err := NewPool("test", []string{"http://static:80", "dns+http://users:8080"}, Options{
+		DNSRefresh: 10 * time.Millisecond,
+	}, resolver)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	hosts := func() []string {
+		var hosts []string
+		for _, target := range pool.Targets() {
+			hosts = append(hosts, target.URL.String())
+		}
+		return hosts
+	}
+	assert.Equal(t, []string{"http://static:80", "http://10.0.0.1:8080", "http://10.0.0.2:8080"}, hosts())
+	kept := pool.Targets()[2]
+
+	resolver.set("users", "10.0.0.2", "10.0.0.3")
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{"http://static:80", "http://10.0.0.2:8080", "http://10.0.0.3:8080"}, hosts())
+	}, time.Second, 10*time.Millisecond)
+	assert.Same(t, kept, pool.Targets()[1], "surviving targets keep their state")
+
+	// Failed lookups keep the last known addresses
+	resolver.mutex.Lock()
+	delete(resolver.hosts, "users")
+	resolver.mutex.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, pool.Targets(), 3)
+}
+
+func TestParseStrategy(t *testing.T) {
+	strategy, err := ParseStrategy("")
+	assert.NoError(t, err)
+	assert.Equal(t, RoundRobin, strategy)
+
+	_, err = ParseStrategy("random")
+	assert.Error(t, err)
+}
@@ -1,128 +1,149 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
-	"net/http/httputil"
-	"net/url"
-	"os"
+	"sync"
 	"time"
+
+	"github.com/gauss2302/microtest/api-gateway/internal/auth"
+	"github.com/gauss2302/microtest/api-gateway/internal/cache"
+	"github.com/gauss2302/microtest/api-gateway/internal/config"
+	"github.com/gauss2302/microtest/api-gateway/internal/gateway"
+	"github.com/gauss2302/microtest/api-gateway/internal/ratelimit"
+	"github.com/gauss2302/microtest/api-gateway/internal/upstream"
+	sharedconfig "github.com/gauss2302/microtest/pkg/config"
+	"github.com/gauss2302/microtest/pkg/lifecycle"
+	"github.com/gauss2302/microtest/pkg/logging"
+	"github.com/gauss2302/microtest/pkg/metrics"
+	"github.com/gauss2302/microtest/pkg/server"
+	"github.com/gauss2302/microtest/pkg/telemetry"
 )
 
-type ServiceConfig struct {
-	Name string
-	URL  string
-	Path string
+// settings are the gateway's settings, loaded as described in pkg/config.
+type settings struct {
+	Port      string `env:"PORT" default:"8080"`
+	AdminPort string `env:"ADMIN_PORT" default:"9090"`
+	// The admin API is only reachable locally unless ADMIN_HOST says
+	// otherwise; ADMIN_TOKEN then keeps it from being open to anyone
+	AdminHost  string `env:"ADMIN_HOST" default:"127.0.0.1"`
+	AdminToken string `env:"ADMIN_TOKEN" secret:"true"`
+	// Prometheus metrics are served on their own port, on all interfaces
+	MetricsPort string `env:"METRICS_PORT" default:"9091"`
+	// How long in-flight requests get to finish on shutdown
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT" default:"15s"`
+	// Minimum level logged (debug, info, warn or error) and the format
+	// of log lines (json or text)
+	LogLevel  string `env:"LOG_LEVEL" default:"info" oneof:"debug info warn error"`
+	LogFormat string `env:"LOG_FORMAT" default:"json" oneof:"json text"`
+
+	// Routes, reloaded when the file changes
+	RoutesFile           string        `env:"ROUTES_FILE" default:"config/routes.yaml"`
+	RoutesReloadInterval time.Duration `env:"ROUTES_RELOAD_INTERVAL" default:"5s"`
+
+	AuthServiceURL string        `env:"AUTH_SERVICE_URL" default:"http://auth-service:8080"`
+	AuthCacheTTL   time.Duration `env:"AUTH_CACHE_TTL" default:"30s"`
+	// OAuth client the gateway introspects tokens as
+	AuthClientID     string `env:"AUTH_CLIENT_ID" default:"api-gateway"`
+	AuthClientSecret string `env:"AUTH_CLIENT_SECRET" required:"true" secret:"true"`
+
+	// Size of the response cache and of the largest response it keeps
+	CacheMaxBytes      int64 `env:"CACHE_MAX_BYTES" default:"67108864"`
+	CacheMaxEntryBytes int64 `env:"CACHE_MAX_ENTRY_BYTES" default:"1048576"`
 }
 
-func createProxy(serviceConfig ServiceConfig) http.HandlerFunc {
-	serviceURL, err := url.Parse(serviceConfig.URL)
-	if err != nil {
-		log.Fatalf("Invalid URL for %s service: %v", serviceConfig.Name, err)
+func main() {
+	cfg := &settings{}
+	sharedconfig.MustLoad(cfg)
+	if err := logging.Setup("api-gateway", cfg.LogLevel, cfg.LogFormat); err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
 	}
 
-	proxy := httputil.NewSingleHostReverseProxy(serviceURL)
-
-	proxy.Director = func(req *http.Request) {
-		log.Printf("Before proxy - Method: %s, URL: %s", req.Method, req.URL.String())
-
-		// Preserve the original path
-		originalPath := req.URL.Path
-
-		req.URL.Scheme = serviceURL.Scheme
-		req.URL.Host = serviceURL.Host
-
-		// Keep the same path
-		req.URL.Path = originalPath
-
-		log.Printf("After proxy - Forwarding to: %s%s", req.URL.Host, req.URL.Path)
+	routes, err := config.Load(cfg.RoutesFile)
+	if err != nil {
+		logging.Fatal("Failed to load routes", "error", err)
 	}
 
-	return func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("Gateway received: %s %s", r.Method, r.URL.Path)
-
-		// Add CORS headers
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-		// Handle preflight request
-		if r.Method == http.MethodOptions {
-			return
-		}
+	app := lifecycle.New(cfg.ShutdownTimeout)
 
-		proxy.ServeHTTP(w, r)
+	shutdownTracing, err := telemetry.Setup(context.Background(), "api-gateway")
+	if err != nil {
+		logging.Fatal("Failed to set up tracing", "error", err)
 	}
-}
+	app.OnShutdown("tracing", shutdownTracing)
+
+	// Authenticate requests at the edge
+	validator := auth.NewIntrospector(cfg.AuthServiceURL, cfg.AuthClientID, cfg.AuthClientSecret, cfg.AuthCacheTTL)
+	limiter := ratelimit.NewLimiter(time.Hour)
+	app.Close("rate limiter", limiter)
+	responses := cache.New(cfg.CacheMaxBytes, cfg.CacheMaxEntryBytes)
+	breakers := upstream.NewBreakers()
+
+	// Build the routes, and rebuild them whenever the file changes. The
+	// replaced version's health checks stop; requests it is still serving
+	// are unaffected. The mutex orders reloads against shutdown, which may
+	// run while a reload that missed the shutdown timeout is still going.
+	handler := &gateway.Handler{}
+	var (
+		mutex   sync.Mutex
+		current *gateway.Gateway
+		stopped bool
+	)
+	apply := func(routes *config.Routes) error {
+		next, err := gateway.New(routes, validator, limiter, responses, breakers, net.DefaultResolver)
+		if err != nil {
+			return err
+		}
 
-func main() {
-	services := []ServiceConfig{
-		{
-			Name: "Products",
-			URL:  getEnv("PRODUCT_SERVICE_URL", "http://product-service:8080"),
-			Path: "/products",
-		},
-		{
-			Name: "Payments",
-			URL:  getEnv("PAYMENT_SERVICE_URL", "http://payment-service:8080"),
-			Path: "/payment",
-		},
-		{
-			Name: "Users",
-			URL:  getEnv("USER_SERVICE_URL", "http://user-service:8080"),
-			Path: "/users",
-		},
-		{
-			Name: "Auth",
-			URL:  getEnv("AUTH_SERVICE_URL", "http://auth-service:8080"),
-			Path: "/auth",
-		},
+		mutex.Lock()
+		defer mutex.Unlock()
+		if stopped {
+			return next.Close()
+		}
+		handler.Store(next)
+		if current != nil {
+			current.Close()
+		}
+		current = next
+		return nil
 	}
-
-	// Set up routes for each service
-	for _, service := range services {
-		path := service.Path
-		handler := createProxy(service)
-
-		// Register routes
-		http.Handle(path, handler)
-		http.Handle(path+"/", handler)
+	if err := apply(routes); err != nil {
+		logging.Fatal("Failed to set up routes", "error", err)
 	}
-
-	// Health check endpoint
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
+	app.OnShutdown("routes", func(context.Context) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		stopped = true
+		return current.Close()
 	})
-
-	// Root handler
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/" {
-			w.WriteHeader(http.StatusOK)
-			fmt.Fprintf(w, "Welcome to the API Gateway!")
-			return
-		}
-		http.NotFound(w, r)
+	app.Go("route reload", func(ctx context.Context) error {
+		return config.Watch(ctx, cfg.RoutesFile, cfg.RoutesReloadInterval, apply)
 	})
 
-	port := getEnv("PORT", "8080")
-	server := &http.Server{
-		Addr:         ":" + port,
+	app.Serve(server.New(":"+cfg.Port, handler))
+
+	// The admin API listens on its own port, which is not exposed
+	app.Serve(&http.Server{
+		Addr:         net.JoinHostPort(cfg.AdminHost, cfg.AdminPort),
+		Handler:      gateway.NewAdmin(handler, responses, cfg.AdminToken),
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
-	}
+	})
 
-	log.Printf("API Gateway starting on port %s", port)
-	if err := server.ListenAndServe(); err != nil {
-		log.Fatalf("Could not start server: %v", err)
-	}
-}
+	// Scrapers need to reach the metrics, so they get a port of their own
+	// rather than sharing the admin API's
+	app.Serve(&http.Server{
+		Addr:         ":" + cfg.MetricsPort,
+		Handler:      metrics.Handler(),
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 15 * time.Second,
+	})
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+	slog.Info("API Gateway starting", "port", cfg.Port, "routes", len(routes.Routes), "routes_file", cfg.RoutesFile)
+	if err := app.Run(context.Background()); err != nil {
+		logging.Fatal("API Gateway failed", "error", err)
 	}
-	return defaultValue
 }
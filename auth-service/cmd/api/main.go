@@ -1,75 +1,148 @@
 package main
 
 import (
-	memcachediml "github.com/gauss2302/microtest/auth-service/pkg/memcached"
+	"context"
 	"log"
-	"net/http"
+	"log/slog"
+	"strings"
 	"time"
 
 	httpauth "github.com/gauss2302/microtest/auth-service/internal/auth/delivery/http"
+	"github.com/gauss2302/microtest/auth-service/internal/auth/repository/file"
 	"github.com/gauss2302/microtest/auth-service/internal/auth/repository/memcached"
 	"github.com/gauss2302/microtest/auth-service/internal/auth/usecase"
 	"github.com/gauss2302/microtest/auth-service/internal/middleware"
+	"github.com/gauss2302/microtest/auth-service/internal/ratelimit"
+	"github.com/gauss2302/microtest/auth-service/pkg/clientip"
 	"github.com/gauss2302/microtest/auth-service/pkg/config"
+	"github.com/gauss2302/microtest/auth-service/pkg/mailer"
+	memcachediml "github.com/gauss2302/microtest/auth-service/pkg/memcached"
+	"github.com/gauss2302/microtest/pkg/health"
+	"github.com/gauss2302/microtest/pkg/lifecycle"
+	"github.com/gauss2302/microtest/pkg/logging"
+	"github.com/gauss2302/microtest/pkg/server"
+	"github.com/gauss2302/microtest/pkg/telemetry"
 )
 
 func main() {
 	// Load configuration
 	cfg := config.LoadConfig()
+	if err := logging.Setup("auth-service", cfg.LogLevel, cfg.LogFormat); err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	app := lifecycle.New(cfg.ShutdownTimeout)
+
+	// Export traces; the exporter is flushed last
+	shutdownTracing, err := telemetry.Setup(context.Background(), "auth-service")
+	if err != nil {
+		logging.Fatal("Failed to set up tracing", "error", err)
+	}
+	app.OnShutdown("tracing", shutdownTracing)
 
 	// Initialize memcached client
 	memcachedWrapper := memcachediml.NewClient(cfg.MemcachedHost, cfg.MemcachedPort)
+	app.Close("memcached", memcachedWrapper.Client)
 
-	// Initialize rate limiters
-	globalLimiter := middleware.NewRateLimiter(100, 10) // 100 requests max, refill 10 per second
-	clientLimiter := middleware.NewClientRateLimiter(middleware.ClientRateLimiterConfig{
-		Capacity:        10,
-		RefillRate:      1,
-		CleanupInterval: time.Hour,
+	// The service is ready while memcached, which holds its tokens, answers
+	checks := health.NewRegistry(5 * time.Second)
+	checks.Register("memcached", time.Second, func(ctx context.Context) error {
+		return memcachedWrapper.Client.Ping()
 	})
 
+	// Initialize client address resolution and rate limiting
+	resolver, err := clientip.NewResolver(strings.Split(cfg.TrustedProxies, ","))
+	if err != nil {
+		logging.Fatal("Invalid trusted proxies", "error", err)
+	}
+	if !resolver.TrustsProxies() {
+		slog.Warn("No trusted proxies, failed logins are only counted per account")
+	}
+	rateLimitConfig, err := loadRateLimitConfig(cfg)
+	if err != nil {
+		logging.Fatal("Invalid rate limit configuration", "error", err)
+	}
+	var rateLimitStore ratelimit.Store
+	switch cfg.RateLimitStore {
+	case "memory":
+		memoryStore := ratelimit.NewMemoryStore(time.Hour)
+		app.Close("rate limit store", memoryStore)
+		rateLimitStore = memoryStore
+	case "memcached":
+		rateLimitStore = ratelimit.NewMemcachedStore(memcachedWrapper.Client)
+	default:
+		logging.Fatal("Unknown rate limit store", "store", cfg.RateLimitStore)
+	}
+
 	// Initialize application layers
-	authRepo := memcached.NewAuthRepository(memcachedWrapper.Client) // Pass the raw *memcache.Client
+	authRepo := memcached.NewAuthRepository(
+		memcachedWrapper.Client, // Pass the raw *memcache.Client
+		cfg.TokenHashSecret,
+		cfg.LegacyTokenWindow,
+	)
+	clientRepo, err := file.NewClientRepository(cfg.OAuthClientsFile)
+	if err != nil {
+		logging.Fatal("Failed to load OAuth clients", "error", err)
+	}
+	mail, err := mailer.New(cfg.Mailer, mailer.SMTPConfig{
+		Host:     cfg.SMTPHost,
+		Port:     cfg.SMTPPort,
+		Username: cfg.SMTPUsername,
+		Password: cfg.SMTPPassword,
+		From:     cfg.MailFrom,
+	}, cfg.MailerFilePath)
+	if err != nil {
+		logging.Fatal("Failed to create mailer", "error", err)
+	}
 	authUsecase := usecase.NewAuthUsecase(
 		authRepo,
+		clientRepo,
+		mail,
 		cfg.TokenExpiration,
 		cfg.UserServiceURL,
+		cfg.PublicURL,
 	)
-	authHandler := httpauth.NewAuthHandler(authUsecase)
+	oidcProviders, err := config.LoadOIDCProviders(cfg.OIDCProvidersFile)
+	if err != nil {
+		logging.Fatal("Failed to load OIDC providers", "error", err)
+	}
+	oidcUsecase := usecase.NewOIDCUsecase(authRepo, authUsecase, oidcProviders)
+	authHandler := httpauth.NewAuthHandler(authUsecase, oidcUsecase)
 
 	// Set up HTTP server with middleware
-	mux := http.NewServeMux()
+	mux := server.NewMux(checks)
+	mux.Handle("/auth/", server.Chain(authHandler,
+		middleware.ClientIP(resolver),
+		middleware.RateLimit(rateLimitStore, rateLimitConfig),
+	))
 
-	// Apply middleware stack
-	handler := middleware.Logging(
-		middleware.CORS(
-			middleware.RateLimit(globalLimiter)(
-				middleware.PerClientRateLimit(clientLimiter)(
-					authHandler,
-				),
-			),
-		),
-	)
-
-	// Health check endpoint
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("ok"))
-	})
+	app.Serve(server.New(":"+cfg.ServerPort, mux))
 
-	// Auth handler
-	mux.Handle("/auth/", handler)
-
-	server := &http.Server{
-		Addr:         ":" + cfg.ServerPort,
-		Handler:      mux,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+	slog.Info("Auth Service running", "port", cfg.ServerPort)
+	if err := app.Run(context.Background()); err != nil {
+		logging.Fatal("Auth Service failed", "error", err)
 	}
+}
 
-	log.Printf("Auth Service running on port %s", cfg.ServerPort)
-	if err := server.ListenAndServe(); err != nil {
-		log.Fatal(err)
+func loadRateLimitConfig(cfg *config.Config) (middleware.RateLimitConfig, error) {
+	global, err := ratelimit.ParseLimit(cfg.RateLimitGlobal)
+	if err != nil {
+		return middleware.RateLimitConfig{}, err
+	}
+	perClient, err := ratelimit.ParseLimit(cfg.RateLimitPerClient)
+	if err != nil {
+		return middleware.RateLimitConfig{}, err
 	}
+	routes, err := ratelimit.ParseRoutes(cfg.RateLimitRoutes)
+	if err != nil {
+		return middleware.RateLimitConfig{}, err
+	}
+
+	return middleware.RateLimitConfig{
+		Global:    global,
+		PerClient: perClient,
+		Routes:    routes,
+		// Called by the gateway for every token it has not cached
+		Exempt: []string{"/auth/introspect"},
+	}, nil
 }
@@ -0,0 +1,7 @@
+package auth
+
+import "github.com/gauss2302/microtest/auth-service/internal/entity"
+
+type ClientRepository interface {
+	GetClient(clientID string) (*entity.OAuthClient, error)
+}
@@ -2,27 +2,33 @@ package http
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/gauss2302/microtest/auth-service/internal/auth"
 	"github.com/gauss2302/microtest/auth-service/internal/entity"
+	"github.com/gauss2302/microtest/auth-service/pkg/clientip"
+	"github.com/gauss2302/microtest/pkg/metrics"
+	"github.com/gauss2302/microtest/pkg/respond"
 )
 
 type AuthHandler struct {
 	usecase auth.Usecase
+	oidc    auth.OIDCUsecase
 }
 
-func NewAuthHandler(usecase auth.Usecase) *AuthHandler {
+func NewAuthHandler(usecase auth.Usecase, oidc auth.OIDCUsecase) *AuthHandler {
 	return &AuthHandler{
 		usecase: usecase,
+		oidc:    oidc,
 	}
 }
 
 func (h *AuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Received request: %s %s", r.Method, r.URL.Path)
-
 	path := r.URL.Path
 	if strings.HasSuffix(path, "/") {
 		path = path[:len(path)-1]
@@ -30,26 +36,72 @@ func (h *AuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	switch {
 	case r.Method == http.MethodPost && path == "/auth/login":
+		metrics.SetRoute(r, "/auth/login")
 		h.Login(w, r)
+	case r.Method == http.MethodPost && path == "/auth/login/mfa":
+		metrics.SetRoute(r, "/auth/login/mfa")
+		h.LoginMFA(w, r)
+	case r.Method == http.MethodPost && path == "/auth/mfa/totp/enroll":
+		metrics.SetRoute(r, "/auth/mfa/totp/enroll")
+		h.EnrollTOTP(w, r)
+	case r.Method == http.MethodPost && path == "/auth/mfa/totp/verify":
+		metrics.SetRoute(r, "/auth/mfa/totp/verify")
+		h.VerifyTOTP(w, r)
 	case r.Method == http.MethodPost && path == "/auth/register":
+		metrics.SetRoute(r, "/auth/register")
 		h.Register(w, r)
+	case r.Method == http.MethodPost && path == "/auth/password/forgot":
+		metrics.SetRoute(r, "/auth/password/forgot")
+		h.ForgotPassword(w, r)
+	case r.Method == http.MethodPost && path == "/auth/password/reset":
+		metrics.SetRoute(r, "/auth/password/reset")
+		h.ResetPassword(w, r)
+	case r.Method == http.MethodPost && path == "/auth/email/verify":
+		metrics.SetRoute(r, "/auth/email/verify")
+		h.VerifyEmail(w, r)
+	case r.Method == http.MethodPost && path == "/auth/email/verify/resend":
+		metrics.SetRoute(r, "/auth/email/verify/resend")
+		h.ResendVerificationEmail(w, r)
+	case r.Method == http.MethodPost && path == "/auth/email/verify/send":
+		metrics.SetRoute(r, "/auth/email/verify/send")
+		h.SendVerificationEmail(w, r)
 	case r.Method == http.MethodPost && path == "/auth/logout":
+		metrics.SetRoute(r, "/auth/logout")
 		h.Logout(w, r)
+	case r.Method == http.MethodPost && path == "/auth/token":
+		metrics.SetRoute(r, "/auth/token")
+		h.Token(w, r)
+	case r.Method == http.MethodPost && path == "/auth/introspect":
+		metrics.SetRoute(r, "/auth/introspect")
+		h.Introspect(w, r)
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/auth/oidc/") && strings.HasSuffix(path, "/start"):
+		metrics.SetRoute(r, "/auth/oidc/{provider}/start")
+		h.StartOIDCLogin(w, r, oidcProviderName(path, "/start"))
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/auth/oidc/") && strings.HasSuffix(path, "/callback"):
+		metrics.SetRoute(r, "/auth/oidc/{provider}/callback")
+		h.OIDCCallback(w, r, oidcProviderName(path, "/callback"))
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		respond.Error(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 	}
 }
 
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	var req entity.LoginRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		respond.Error(w, r, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
-	token, err := h.usecase.Login(&req)
+	req.ClientIP = clientIP(r)
+
+	token, err := h.usecase.Login(r.Context(), &req)
+	var lockout *auth.LockoutError
+	if errors.As(err, &lockout) {
+		writeLockoutError(w, r, lockout)
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		respond.Error(w, r, http.StatusUnauthorized, err.Error())
 		return
 	}
 
@@ -57,16 +109,88 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(token)
 }
 
+func (h *AuthHandler) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	var req entity.MFALoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respond.Error(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	req.ClientIP = clientIP(r)
+
+	token, err := h.usecase.LoginMFA(r.Context(), &req)
+	var lockout *auth.LockoutError
+	if errors.As(err, &lockout) {
+		writeLockoutError(w, r, lockout)
+		return
+	}
+	if err != nil {
+		respond.Error(w, r, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(token)
+}
+
+func (h *AuthHandler) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	token := bearerToken(r)
+	if token == "" {
+		respond.Error(w, r, http.StatusUnauthorized, "Authorization header is required")
+		return
+	}
+
+	enrollment, err := h.usecase.EnrollTOTP(r.Context(), token)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error enrolling TOTP", "error", err)
+		respond.Error(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+func (h *AuthHandler) VerifyTOTP(w http.ResponseWriter, r *http.Request) {
+	token := bearerToken(r)
+	if token == "" {
+		respond.Error(w, r, http.StatusUnauthorized, "Authorization header is required")
+		return
+	}
+
+	var req entity.TOTPVerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respond.Error(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	codes, err := h.usecase.VerifyTOTP(r.Context(), token, req.Code)
+	if err != nil {
+		respond.Error(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(codes)
+}
+
 func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	var req entity.RegisterRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		respond.Error(w, r, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
-	token, err := h.usecase.Register(&req)
+	token, err := h.usecase.Register(r.Context(), &req)
+	var rejected *auth.PasswordRejectedError
+	if errors.As(err, &rejected) {
+		respond.Error(w, r, http.StatusUnprocessableEntity, rejected.Error())
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		respond.Error(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -75,20 +199,290 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(token)
 }
 
-func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
-	token := r.Header.Get("Authorization")
+// ForgotPassword always answers 202 so that callers cannot tell whether
+// the address belongs to an account.
+func (h *AuthHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req entity.ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		respond.Error(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := h.usecase.ForgotPassword(r.Context(), req.Email); err != nil {
+		slog.ErrorContext(r.Context(), "Error starting password reset", "error", err)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *AuthHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req entity.ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respond.Error(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	err := h.usecase.ResetPassword(r.Context(), &req)
+	var rejected *auth.PasswordRejectedError
+	if errors.As(err, &rejected) {
+		respond.Error(w, r, http.StatusUnprocessableEntity, rejected.Error())
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error resetting password", "error", err)
+		respond.Error(w, r, http.StatusBadRequest, "Invalid or expired token")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AuthHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req entity.VerifyEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respond.Error(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := h.usecase.VerifyEmail(r.Context(), req.Token); err != nil {
+		slog.ErrorContext(r.Context(), "Error verifying email", "error", err)
+		respond.Error(w, r, http.StatusBadRequest, "Invalid or expired token")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AuthHandler) ResendVerificationEmail(w http.ResponseWriter, r *http.Request) {
+	token := bearerToken(r)
 	if token == "" {
-		http.Error(w, "Authorization header is required", http.StatusBadRequest)
+		respond.Error(w, r, http.StatusUnauthorized, "Authorization header is required")
 		return
 	}
 
-	// Remove "Bearer " prefix if present
-	token = strings.TrimPrefix(token, "Bearer ")
+	if err := h.usecase.ResendVerificationEmail(r.Context(), token); err != nil {
+		slog.ErrorContext(r.Context(), "Error resending verification email", "error", err)
+		respond.Error(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
 
-	if err := h.usecase.Logout(token); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// SendVerificationEmail is called by user-service when a user changes
+// their email address. The gateway does not expose it.
+func (h *AuthHandler) SendVerificationEmail(w http.ResponseWriter, r *http.Request) {
+	var req entity.SendVerificationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == 0 {
+		respond.Error(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := h.usecase.SendVerificationEmail(r.Context(), req.UserID); err != nil {
+		slog.ErrorContext(r.Context(), "Error sending verification email", "error", err)
+		respond.Error(w, r, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	token := bearerToken(r)
+	if token == "" {
+		respond.Error(w, r, http.StatusBadRequest, "Authorization header is required")
+		return
+	}
+
+	if err := h.usecase.Logout(r.Context(), token); err != nil {
+		respond.Error(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// Token is the OAuth2 token endpoint. Clients authenticate with HTTP Basic
+// or with client_id and client_secret form fields.
+func (h *AuthHandler) Token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, auth.NewOAuthError(auth.ErrCodeInvalidRequest, "malformed form body"))
+		return
+	}
+
+	req := entity.TokenRequest{
+		GrantType:    r.PostForm.Get("grant_type"),
+		ClientID:     r.PostForm.Get("client_id"),
+		ClientSecret: r.PostForm.Get("client_secret"),
+		Scope:        r.PostForm.Get("scope"),
+		Username:     r.PostForm.Get("username"),
+		Password:     r.PostForm.Get("password"),
+		ClientIP:     clientIP(r),
+	}
+	if clientID, clientSecret, ok := r.BasicAuth(); ok {
+		req.ClientID, req.ClientSecret = clientID, clientSecret
+	}
+
+	token, err := h.usecase.Token(r.Context(), &req)
+	var lockout *auth.LockoutError
+	if errors.As(err, &lockout) {
+		writeLockoutError(w, r, lockout)
+		return
+	}
+	if err != nil {
+		var oauthErr *auth.OAuthError
+		if !errors.As(err, &oauthErr) {
+			slog.ErrorContext(r.Context(), "Error issuing token", "error", err)
+			respond.Error(w, r, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if oauthErr.Code == auth.ErrCodeInvalidClient && r.Header.Get("Authorization") != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="auth-service"`)
+		}
+		writeOAuthError(w, oauthErr)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(token)
+}
+
+// Introspect reports whether a token is active and, if so, who it belongs
+// to. As in RFC 7662 the token is read from the "token" form field, and the
+// caller authenticates as a registered client like at the token endpoint.
+func (h *AuthHandler) Introspect(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, auth.NewOAuthError(auth.ErrCodeInvalidRequest, "malformed form body"))
+		return
+	}
+
+	req := entity.IntrospectionRequest{
+		ClientID:     r.PostForm.Get("client_id"),
+		ClientSecret: r.PostForm.Get("client_secret"),
+		Token:        r.PostForm.Get("token"),
+	}
+	if clientID, clientSecret, ok := r.BasicAuth(); ok {
+		req.ClientID, req.ClientSecret = clientID, clientSecret
+	}
+	if req.Token == "" {
+		writeOAuthError(w, auth.NewOAuthError(auth.ErrCodeInvalidRequest, "token is required"))
+		return
+	}
+
+	response, err := h.usecase.Introspect(r.Context(), &req)
+	if err != nil {
+		var oauthErr *auth.OAuthError
+		if !errors.As(err, &oauthErr) {
+			slog.ErrorContext(r.Context(), "Error introspecting token", "error", err)
+			respond.Error(w, r, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="auth-service"`)
+		writeOAuthError(w, oauthErr)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(response)
+}
+
+// oidcBindingCookie holds the binding of the OIDC login the browser
+// started, so that only that browser can complete it.
+const oidcBindingCookie = "oidc_binding"
+
+// StartOIDCLogin redirects the user agent to the identity provider.
+func (h *AuthHandler) StartOIDCLogin(w http.ResponseWriter, r *http.Request, provider string) {
+	login, err := h.oidc.StartLogin(r.Context(), provider)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error starting OIDC login", "error", err)
+		respond.Error(w, r, http.StatusBadRequest, "Failed to start login")
+		return
+	}
+
+	// Lax, because the callback is a cross-site redirect from the provider
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcBindingCookie,
+		Value:    login.Binding,
+		Path:     "/auth/oidc/",
+		Expires:  login.ExpiresAt,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, login.URL, http.StatusFound)
+}
+
+// OIDCCallback completes the login the provider redirected back from.
+func (h *AuthHandler) OIDCCallback(w http.ResponseWriter, r *http.Request, provider string) {
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		respond.Error(w, r, http.StatusUnauthorized, "Login failed at provider: "+errCode)
+		return
+	}
+
+	var binding string
+	if cookie, err := r.Cookie(oidcBindingCookie); err == nil {
+		binding = cookie.Value
+	}
+	// The binding is single-use, like the state
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcBindingCookie,
+		Path:     "/auth/oidc/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	token, err := h.oidc.CompleteLogin(r.Context(), provider, query.Get("state"), binding, query.Get("code"))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error completing OIDC login", "error", err)
+		respond.Error(w, r, http.StatusUnauthorized, "Login failed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(token)
+}
+
+// oidcProviderName extracts {provider} from /auth/oidc/{provider}/{action}.
+func oidcProviderName(path, action string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(path, "/auth/oidc/"), action)
+}
+
+func bearerToken(r *http.Request) string {
+	// Remove "Bearer " prefix if present
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// clientIP returns the client address resolved by the ClientIP
+// middleware, which only follows forwarding headers from trusted proxies.
+// It is empty when no proxy is trusted: failed logins are counted per
+// address, and clients behind the gateway would all share its address.
+func clientIP(r *http.Request) string {
+	if clientip.Identified(r) {
+		return clientip.FromRequest(r).String()
+	}
+	return ""
+}
+
+func writeLockoutError(w http.ResponseWriter, r *http.Request, err *auth.LockoutError) {
+	seconds := int(math.Ceil(err.RetryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	respond.Error(w, r, http.StatusTooManyRequests, err.Error())
+}
+
+func writeOAuthError(w http.ResponseWriter, err *auth.OAuthError) {
+	status := http.StatusBadRequest
+	if err.Code == auth.ErrCodeInvalidClient {
+		status = http.StatusUnauthorized
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(err)
+}
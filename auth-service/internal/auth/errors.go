@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+)
+
+// Error codes from RFC 6749 section 5.2.
+const (
+	ErrCodeInvalidRequest       = "invalid_request"
+	ErrCodeInvalidClient        = "invalid_client"
+	ErrCodeInvalidGrant         = "invalid_grant"
+	ErrCodeUnauthorizedClient   = "unauthorized_client"
+	ErrCodeUnsupportedGrantType = "unsupported_grant_type"
+	ErrCodeInvalidScope         = "invalid_scope"
+)
+
+// OAuthError is returned by the token endpoint and rendered to the client
+// as is, so Description must not leak internal details.
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func NewOAuthError(code, description string) *OAuthError {
+	return &OAuthError{Code: code, Description: description}
+}
+
+func (e *OAuthError) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+// LockoutError means logins are refused until RetryAfter has passed, after
+// too many failed attempts for the account or the client address.
+type LockoutError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockoutError) Error() string {
+	return fmt.Sprintf("too many failed login attempts, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+// PasswordRejectedError carries user-service's reason for refusing a new
+// password, which is safe to show to the user.
+type PasswordRejectedError struct {
+	Reason string
+}
+
+func (e *PasswordRejectedError) Error() string {
+	return e.Reason
+}
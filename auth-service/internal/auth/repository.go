@@ -1,12 +1,45 @@
 package auth
 
 import (
-	"github.com/gauss2302/microtest/auth-service/internal/entity"
+	"context"
 	"time"
+
+	"github.com/gauss2302/microtest/auth-service/internal/entity"
 )
 
 type Repository interface {
-	StoreToken(userID int, token string, expiration time.Duration) error
-	GetToken(token string) (*entity.TokenDetails, error)
-	DeleteToken(token string) error
+	StoreToken(ctx context.Context, token string, details *entity.TokenDetails) error
+	GetToken(ctx context.Context, token string) (*entity.TokenDetails, error)
+	DeleteToken(ctx context.Context, token string) error
+	// RevokeUserTokens invalidates every token issued to the user so far.
+	// lifetime is how long tokens live, after which none of them is left.
+	RevokeUserTokens(ctx context.Context, userID int, lifetime time.Duration) error
+
+	StoreMFAChallenge(ctx context.Context, token string, challenge *entity.MFAChallenge) error
+	GetMFAChallenge(ctx context.Context, token string) (*entity.MFAChallenge, error)
+	DeleteMFAChallenge(ctx context.Context, token string) error
+	// RecordMFAFailure counts a wrong code for the challenge and returns
+	// the count so far.
+	RecordMFAFailure(ctx context.Context, token string, expiresAt time.Time) (int, error)
+	// UseTOTPStep fails if the user already redeemed a code for step.
+	UseTOTPStep(ctx context.Context, userID int, step int64) error
+
+	StoreOneTimeToken(ctx context.Context, token string, details *entity.OneTimeToken) error
+	// ConsumeOneTimeToken succeeds at most once per token.
+	ConsumeOneTimeToken(ctx context.Context, token string) (*entity.OneTimeToken, error)
+
+	// RecordLoginFailure counts a failed login for subject, an account or
+	// a client address, and returns the failures within window so far.
+	RecordLoginFailure(ctx context.Context, subject string, window time.Duration) (int, error)
+	ResetLoginFailures(ctx context.Context, subject string) error
+	LockLogin(ctx context.Context, subject string, until time.Time) error
+	// LoginLockedUntil returns the zero time when subject is not locked.
+	LoginLockedUntil(ctx context.Context, subject string) (time.Time, error)
+}
+
+// StateRepository keeps short-lived OIDC login state. ConsumeOIDCState
+// succeeds at most once per state.
+type StateRepository interface {
+	StoreOIDCState(ctx context.Context, state string, details *entity.OIDCState) error
+	ConsumeOIDCState(ctx context.Context, state string) (*entity.OIDCState, error)
 }
@@ -0,0 +1,73 @@
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/gauss2302/microtest/auth-service/internal/entity"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ClientRepository serves OAuth clients registered in a JSON file. The file
+// is read once at startup; secrets are kept as bcrypt hashes. A client
+// gives either a secret_hash or a secret of the form "${VAR}", which is
+// taken from the environment so that the file itself can be committed.
+// Clients whose variable is not set are left out.
+type ClientRepository struct {
+	clients map[string]*entity.OAuthClient
+}
+
+// NewClientRepository loads clients from path. An empty path yields a
+// repository without clients.
+func NewClientRepository(path string) (*ClientRepository, error) {
+	repo := &ClientRepository{clients: make(map[string]*entity.OAuthClient)}
+	if path == "" {
+		return repo, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read clients file: %w", err)
+	}
+
+	var clients []*entity.OAuthClient
+	if err := json.Unmarshal(data, &clients); err != nil {
+		return nil, fmt.Errorf("failed to parse clients file: %w", err)
+	}
+
+	for _, client := range clients {
+		if client.ID == "" || (client.SecretHash == "") == (client.Secret == "") {
+			return nil, fmt.Errorf("client_id and either secret_hash or secret are required for every client")
+		}
+		if _, exists := repo.clients[client.ID]; exists {
+			return nil, fmt.Errorf("duplicate client %q", client.ID)
+		}
+
+		if client.Secret != "" {
+			secret := os.ExpandEnv(client.Secret)
+			client.Secret = ""
+			if secret == "" {
+				slog.Warn("OAuth client has no secret set and is disabled", "client_id", client.ID)
+				continue
+			}
+			hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+			if err != nil {
+				return nil, fmt.Errorf("failed to hash secret of client %q: %w", client.ID, err)
+			}
+			client.SecretHash = string(hash)
+		}
+		repo.clients[client.ID] = client
+	}
+
+	return repo, nil
+}
+
+func (r *ClientRepository) GetClient(clientID string) (*entity.OAuthClient, error) {
+	client, ok := r.clients[clientID]
+	if !ok {
+		return nil, fmt.Errorf("client not found")
+	}
+	return client, nil
+}
@@ -0,0 +1,57 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func writeClients(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "oauth_clients.json")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestNewClientRepository(t *testing.T) {
+	t.Setenv("GATEWAY_SECRET", "s3cret")
+
+	repo, err := NewClientRepository(writeClients(t, `[
+		{"client_id": "api-gateway", "secret": "${GATEWAY_SECRET}"},
+		{"client_id": "unset", "secret": "${UNSET_CLIENT_SECRET}"},
+		{"client_id": "hashed", "secret_hash": "$2a$10$jkOo7/fzdAsv8GjA3WAjLe6NTSIeOANgL95S6I9VkRiClygRktVOu"}
+	]`))
+	require.NoError(t, err)
+
+	client, err := repo.GetClient("api-gateway")
+	require.NoError(t, err)
+	assert.Empty(t, client.Secret)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(client.SecretHash), []byte("s3cret")))
+
+	// Clients without their secret are disabled
+	_, err = repo.GetClient("unset")
+	assert.Error(t, err)
+
+	_, err = repo.GetClient("hashed")
+	assert.NoError(t, err)
+}
+
+func TestNewClientRepository_Invalid(t *testing.T) {
+	for name, content := range map[string]string{
+		"no secret":    `[{"client_id": "web"}]`,
+		"both":         `[{"client_id": "web", "secret": "${S}", "secret_hash": "x"}]`,
+		"no id":        `[{"secret_hash": "x"}]`,
+		"duplicate":    `[{"client_id": "web", "secret_hash": "x"}, {"client_id": "web", "secret_hash": "y"}]`,
+		"not an array": `{}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewClientRepository(writeClients(t, content))
+			assert.Error(t, err)
+		})
+	}
+}
@@ -1,61 +1,489 @@
 package memcached
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/bradfitz/gomemcache/memcache"
 	"github.com/gauss2302/microtest/auth-service/internal/entity"
+	"github.com/gauss2302/microtest/auth-service/pkg/totp"
 	"time"
 )
 
+const (
+	tokenKeyPrefix        = "token:"
+	revokedKeyPrefix      = "revoked_before:"
+	stateKeyPrefix        = "oidc_state:"
+	mfaChallengeKeyPrefix = "mfa_challenge:"
+	mfaFailuresKeyPrefix  = "mfa_failures:"
+	totpStepKeyPrefix     = "totp_step:"
+	oneTimeKeyPrefix      = "one_time:"
+	loginFailuresPrefix   = "login_failures:"
+	loginLockPrefix       = "login_lock:"
+)
+
+// Client is the subset of *memcache.Client used by the repository.
+type Client interface {
+	Get(key string) (*memcache.Item, error)
+	Set(item *memcache.Item) error
+	Add(item *memcache.Item) error
+	Delete(key string) error
+	Increment(key string, delta uint64) (uint64, error)
+}
+
 type AuthRepository struct {
-	client *memcache.Client
+	client Client
+	secret []byte
+
+	// Tokens issued before hashing was introduced are stored under the raw
+	// token. They are still accepted until legacyUntil, after which every
+	// such entry has expired on its own.
+	legacyUntil time.Time
 }
 
-func NewAuthRepository(client *memcache.Client) *AuthRepository {
+func NewAuthRepository(client Client, secret string, legacyWindow time.Duration) *AuthRepository {
 	return &AuthRepository{
-		client: client,
+		client:      client,
+		secret:      []byte(secret),
+		legacyUntil: time.Now().Add(legacyWindow),
 	}
 }
 
-func (r *AuthRepository) StoreToken(userID int, token string, expiration time.Duration) error {
-	tokenDetails := &entity.TokenDetails{
-		UserID:    userID,
-		ExpiresAt: time.Now().Add(expiration),
+func (r *AuthRepository) StoreToken(ctx context.Context, token string, details *entity.TokenDetails) error {
+	return r.storeTokenDetails(ctx, r.tokenKey(token), details)
+}
+
+// GetToken fails for tokens issued before the user's tokens were revoked.
+func (r *AuthRepository) GetToken(ctx context.Context, token string) (*entity.TokenDetails, error) {
+	tokenDetails, err := r.getToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if tokenDetails.UserID == 0 {
+		return tokenDetails, nil
 	}
 
-	// Serialization of tokenDetails
-	value, err := json.Marshal(tokenDetails)
+	item, err := r.with(ctx).Get(fmt.Sprintf("%s%d", revokedKeyPrefix, tokenDetails.UserID))
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return tokenDetails, nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to marshal token details: %w", err)
+		return nil, fmt.Errorf("failed to get token revocation from memcached: %w", err)
 	}
 
-	// Store token in memcached
-	err = r.client.Set(&memcache.Item{
-		Key:        token,
+	var revokedAt time.Time
+	if err := revokedAt.UnmarshalText(item.Value); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal token revocation: %w", err)
+	}
+	// Tokens from before IssuedAt was recorded have the zero time
+	if !tokenDetails.IssuedAt.After(revokedAt) {
+		return nil, fmt.Errorf("token is revoked")
+	}
+
+	return tokenDetails, nil
+}
+
+func (r *AuthRepository) getToken(ctx context.Context, token string) (*entity.TokenDetails, error) {
+	// Get token from memcached
+	item, err := r.with(ctx).Get(r.tokenKey(token))
+	if errors.Is(err, memcache.ErrCacheMiss) && r.acceptLegacy() {
+		return r.getLegacyToken(ctx, token)
+	}
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return nil, fmt.Errorf("token not found")
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token from memcached: %w", err)
+	}
+
+	return decodeTokenDetails(item)
+}
+
+func (r *AuthRepository) DeleteToken(ctx context.Context, token string) error {
+	err := r.with(ctx).Delete(r.tokenKey(token))
+	if err != nil && !errors.Is(err, memcache.ErrCacheMiss) {
+		return fmt.Errorf("failed to delete token from memcached: %w", err)
+	}
+
+	if r.acceptLegacy() {
+		err := r.with(ctx).Delete(token)
+		if err != nil && !isMissingLegacyKey(err) {
+			return fmt.Errorf("failed to delete legacy token from memcached: %w", err)
+		}
+	}
+	return nil
+}
+
+func (r *AuthRepository) RevokeUserTokens(ctx context.Context, userID int, lifetime time.Duration) error {
+	value, err := time.Now().MarshalText()
+	if err != nil {
+		return fmt.Errorf("failed to marshal token revocation: %w", err)
+	}
+
+	err = r.with(ctx).Set(&memcache.Item{
+		Key:        fmt.Sprintf("%s%d", revokedKeyPrefix, userID),
 		Value:      value,
-		Expiration: int32(expiration.Seconds()),
+		Expiration: int32(lifetime.Seconds()) + 1,
 	})
+	if err != nil {
+		return fmt.Errorf("failed to store token revocation in memcached: %w", err)
+	}
 
+	return nil
+}
+
+func (r *AuthRepository) StoreOIDCState(ctx context.Context, state string, details *entity.OIDCState) error {
+	value, err := json.Marshal(details)
 	if err != nil {
-		return fmt.Errorf("failed to store token in memcached: %w", err)
+		return fmt.Errorf("failed to marshal oidc state: %w", err)
+	}
+
+	err = r.with(ctx).Set(&memcache.Item{
+		Key:        r.stateKey(state),
+		Value:      value,
+		Expiration: int32(time.Until(details.ExpiresAt).Seconds()),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store oidc state in memcached: %w", err)
 	}
 
 	return nil
 }
 
-func (r *AuthRepository) GetToken(token string) (*entity.TokenDetails, error) {
-	// Get token from memcached
-	item, err := r.client.Get(token)
+// ConsumeOIDCState returns the state and removes it, so a callback cannot
+// be replayed.
+func (r *AuthRepository) ConsumeOIDCState(ctx context.Context, state string) (*entity.OIDCState, error) {
+	item, err := r.consume(ctx, r.stateKey(state))
 	if errors.Is(err, memcache.ErrCacheMiss) {
-		return nil, fmt.Errorf("token not found")
+		return nil, fmt.Errorf("oidc state not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume oidc state: %w", err)
+	}
+
+	var details entity.OIDCState
+	if err := json.Unmarshal(item.Value, &details); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal oidc state: %w", err)
+	}
+
+	if time.Now().After(details.ExpiresAt) {
+		return nil, fmt.Errorf("oidc state is expired")
+	}
+
+	return &details, nil
+}
+
+func (r *AuthRepository) StoreOneTimeToken(ctx context.Context, token string, details *entity.OneTimeToken) error {
+	value, err := json.Marshal(details)
+	if err != nil {
+		return fmt.Errorf("failed to marshal one-time token: %w", err)
+	}
+
+	err = r.with(ctx).Set(&memcache.Item{
+		Key:        r.oneTimeKey(token),
+		Value:      value,
+		Expiration: int32(time.Until(details.ExpiresAt).Seconds()),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store one-time token in memcached: %w", err)
+	}
+
+	return nil
+}
+
+func (r *AuthRepository) ConsumeOneTimeToken(ctx context.Context, token string) (*entity.OneTimeToken, error) {
+	item, err := r.consume(ctx, r.oneTimeKey(token))
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return nil, fmt.Errorf("one-time token not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume one-time token: %w", err)
+	}
+
+	var details entity.OneTimeToken
+	if err := json.Unmarshal(item.Value, &details); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal one-time token: %w", err)
+	}
+
+	if time.Now().After(details.ExpiresAt) {
+		return nil, fmt.Errorf("one-time token is expired")
+	}
+
+	return &details, nil
+}
+
+func (r *AuthRepository) StoreMFAChallenge(ctx context.Context, token string, challenge *entity.MFAChallenge) error {
+	value, err := json.Marshal(challenge)
+	if err != nil {
+		return fmt.Errorf("failed to marshal mfa challenge: %w", err)
+	}
+
+	err = r.with(ctx).Set(&memcache.Item{
+		Key:        r.mfaChallengeKey(token),
+		Value:      value,
+		Expiration: int32(time.Until(challenge.ExpiresAt).Seconds()),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store mfa challenge in memcached: %w", err)
+	}
+
+	return nil
+}
+
+func (r *AuthRepository) GetMFAChallenge(ctx context.Context, token string) (*entity.MFAChallenge, error) {
+	item, err := r.with(ctx).Get(r.mfaChallengeKey(token))
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return nil, fmt.Errorf("mfa challenge not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get mfa challenge from memcached: %w", err)
+	}
+
+	var challenge entity.MFAChallenge
+	if err := json.Unmarshal(item.Value, &challenge); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal mfa challenge: %w", err)
+	}
+
+	if time.Now().After(challenge.ExpiresAt) {
+		return nil, fmt.Errorf("mfa challenge is expired")
+	}
+
+	return &challenge, nil
+}
+
+func (r *AuthRepository) DeleteMFAChallenge(ctx context.Context, token string) error {
+	err := r.with(ctx).Delete(r.mfaChallengeKey(token))
+	if err != nil && !errors.Is(err, memcache.ErrCacheMiss) {
+		return fmt.Errorf("failed to delete mfa challenge from memcached: %w", err)
+	}
+	return nil
+}
+
+// RecordMFAFailure keeps an atomic counter per challenge, so concurrent
+// guesses cannot overwrite each other's count.
+func (r *AuthRepository) RecordMFAFailure(ctx context.Context, token string, expiresAt time.Time) (int, error) {
+	count, err := r.increment(ctx, r.mfaFailuresKey(token), time.Until(expiresAt))
+	if err != nil {
+		return 0, fmt.Errorf("failed to record mfa failure in memcached: %w", err)
 	}
+	return count, nil
+}
 
+// UseTOTPStep relies on memcached's add, which only succeeds for a key that
+// does not exist yet. The key outlives the window in which a code for the
+// step is accepted.
+func (r *AuthRepository) UseTOTPStep(ctx context.Context, userID int, step int64) error {
+	err := r.with(ctx).Add(&memcache.Item{
+		Key:        fmt.Sprintf("%s%d:%d", totpStepKeyPrefix, userID, step),
+		Value:      []byte{1},
+		Expiration: int32((3 * totp.Period).Seconds()),
+	})
+	if errors.Is(err, memcache.ErrNotStored) {
+		return fmt.Errorf("code was already used")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record totp step in memcached: %w", err)
+	}
+	return nil
+}
+
+// RecordLoginFailure keeps an atomic counter per subject. The counter
+// expires window after the first failure, not the latest one.
+func (r *AuthRepository) RecordLoginFailure(ctx context.Context, subject string, window time.Duration) (int, error) {
+	count, err := r.increment(ctx, r.loginKey(loginFailuresPrefix, subject), window)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record login failure in memcached: %w", err)
+	}
+	return count, nil
+}
+
+func (r *AuthRepository) ResetLoginFailures(ctx context.Context, subject string) error {
+	err := r.with(ctx).Delete(r.loginKey(loginFailuresPrefix, subject))
+	if err != nil && !errors.Is(err, memcache.ErrCacheMiss) {
+		return fmt.Errorf("failed to reset login failures in memcached: %w", err)
+	}
+	return nil
+}
+
+func (r *AuthRepository) LockLogin(ctx context.Context, subject string, until time.Time) error {
+	value, err := until.MarshalText()
+	if err != nil {
+		return fmt.Errorf("failed to marshal lockout: %w", err)
+	}
+
+	err = r.with(ctx).Set(&memcache.Item{
+		Key:        r.loginKey(loginLockPrefix, subject),
+		Value:      value,
+		Expiration: int32(time.Until(until).Seconds()) + 1,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store lockout in memcached: %w", err)
+	}
+
+	return nil
+}
+
+func (r *AuthRepository) LoginLockedUntil(ctx context.Context, subject string) (time.Time, error) {
+	item, err := r.with(ctx).Get(r.loginKey(loginLockPrefix, subject))
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get lockout from memcached: %w", err)
+	}
+
+	var until time.Time
+	if err := until.UnmarshalText(item.Value); err != nil {
+		return time.Time{}, fmt.Errorf("failed to unmarshal lockout: %w", err)
+	}
+
+	return until, nil
+}
+
+// increment adds one to the counter at key, creating it to expire after
+// expiration if it does not exist.
+func (r *AuthRepository) increment(ctx context.Context, key string, expiration time.Duration) (int, error) {
+	for {
+		count, err := r.with(ctx).Increment(key, 1)
+		if err == nil {
+			return int(count), nil
+		}
+		if !errors.Is(err, memcache.ErrCacheMiss) {
+			return 0, err
+		}
+
+		// Counters are stored as decimal text so that incr works on them
+		err = r.with(ctx).Add(&memcache.Item{
+			Key:        key,
+			Value:      []byte("1"),
+			Expiration: int32(expiration.Seconds()),
+		})
+		if err == nil {
+			return 1, nil
+		}
+		if !errors.Is(err, memcache.ErrNotStored) {
+			return 0, err
+		}
+		// Another request created the counter first, so increment it
+	}
+}
+
+// consume gets and deletes key. Only the caller whose delete succeeds gets
+// the item, so concurrent callers cannot both use it.
+func (r *AuthRepository) consume(ctx context.Context, key string) (*memcache.Item, error) {
+	item, err := r.with(ctx).Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.with(ctx).Delete(key); err != nil {
+		return nil, err
+	}
+
+	return item, nil
+}
+
+// tokenKey derives the memcached key for a bearer token so that the token
+// itself is never persisted. With a secret configured the digest is an
+// HMAC, otherwise a plain SHA-256.
+func (r *AuthRepository) tokenKey(token string) string {
+	var digest []byte
+	if len(r.secret) > 0 {
+		mac := hmac.New(sha256.New, r.secret)
+		mac.Write([]byte(token))
+		digest = mac.Sum(nil)
+	} else {
+		sum := sha256.Sum256([]byte(token))
+		digest = sum[:]
+	}
+	return tokenKeyPrefix + hex.EncodeToString(digest)
+}
+
+func (r *AuthRepository) mfaChallengeKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return mfaChallengeKeyPrefix + hex.EncodeToString(sum[:])
+}
+
+func (r *AuthRepository) mfaFailuresKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return mfaFailuresKeyPrefix + hex.EncodeToString(sum[:])
+}
+
+func (r *AuthRepository) oneTimeKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return oneTimeKeyPrefix + hex.EncodeToString(sum[:])
+}
+
+// loginKey hashes subject, which holds an email address or IP that may not
+// be a valid memcached key.
+func (r *AuthRepository) loginKey(prefix, subject string) string {
+	sum := sha256.Sum256([]byte(subject))
+	return prefix + hex.EncodeToString(sum[:])
+}
+
+func (r *AuthRepository) stateKey(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return stateKeyPrefix + hex.EncodeToString(sum[:])
+}
+
+func (r *AuthRepository) acceptLegacy() bool {
+	return time.Now().Before(r.legacyUntil)
+}
+
+// getLegacyToken looks the token up under its raw key and, when found,
+// moves it to the hashed key for the rest of its lifetime.
+func (r *AuthRepository) getLegacyToken(ctx context.Context, token string) (*entity.TokenDetails, error) {
+	item, err := r.with(ctx).Get(token)
+	if err != nil && isMissingLegacyKey(err) {
+		return nil, fmt.Errorf("token not found")
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get token from memcached: %w", err)
 	}
 
+	tokenDetails, err := decodeTokenDetails(item)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.storeTokenDetails(ctx, r.tokenKey(token), tokenDetails); err != nil {
+		return nil, err
+	}
+	if err := r.with(ctx).Delete(token); err != nil && !isMissingLegacyKey(err) {
+		return nil, fmt.Errorf("failed to delete legacy token from memcached: %w", err)
+	}
+
+	return tokenDetails, nil
+}
+
+// storeTokenDetails stores the record until the token expires.
+func (r *AuthRepository) storeTokenDetails(ctx context.Context, key string, tokenDetails *entity.TokenDetails) error {
+	// Serialization of tokenDetails
+	value, err := json.Marshal(tokenDetails)
+	if err != nil {
+		return fmt.Errorf("failed to marshal token details: %w", err)
+	}
+
+	// Store token in memcached
+	err = r.with(ctx).Set(&memcache.Item{
+		Key:        key,
+		Value:      value,
+		Expiration: int32(time.Until(tokenDetails.ExpiresAt).Seconds()),
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to store token in memcached: %w", err)
+	}
+
+	return nil
+}
+
+func decodeTokenDetails(item *memcache.Item) (*entity.TokenDetails, error) {
 	// Deserialization of tokenDetails
 	var tokenDetails entity.TokenDetails
 	if err := json.Unmarshal(item.Value, &tokenDetails); err != nil {
@@ -70,10 +498,9 @@ func (r *AuthRepository) GetToken(token string) (*entity.TokenDetails, error) {
 	return &tokenDetails, nil
 }
 
-func (r *AuthRepository) DeleteToken(token string) error {
-	err := r.client.Delete(token)
-	if err != nil && !errors.Is(err, memcache.ErrCacheMiss) {
-		return fmt.Errorf("failed to delete token from memcached: %w", err)
-	}
-	return nil
+// isMissingLegacyKey reports whether a lookup by raw token failed because
+// nothing is stored there. Client-supplied tokens may not be valid
+// memcached keys, which is the same as not being found.
+func isMissingLegacyKey(err error) bool {
+	return errors.Is(err, memcache.ErrCacheMiss) || errors.Is(err, memcache.ErrMalformedKey)
 }
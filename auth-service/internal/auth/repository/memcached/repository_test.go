@@ -0,0 +1,275 @@
+package memcached
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/gauss2302/microtest/auth-service/internal/entity"
+	"github.com/stretchr/testify/assert"
+)
+
+// Fake memcached client
+type fakeClient struct {
+	items map[string]*memcache.Item
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{items: make(map[string]*memcache.Item)}
+}
+
+func (c *fakeClient) Get(key string) (*memcache.Item, error) {
+	if strings.ContainsAny(key, " \n") {
+		return nil, memcache.ErrMalformedKey
+	}
+	item, ok := c.items[key]
+	if !ok {
+		return nil, memcache.ErrCacheMiss
+	}
+	return item, nil
+}
+
+func (c *fakeClient) Set(item *memcache.Item) error {
+	c.items[item.Key] = item
+	return nil
+}
+
+func (c *fakeClient) Add(item *memcache.Item) error {
+	if _, ok := c.items[item.Key]; ok {
+		return memcache.ErrNotStored
+	}
+	c.items[item.Key] = item
+	return nil
+}
+
+func (c *fakeClient) Delete(key string) error {
+	if _, ok := c.items[key]; !ok {
+		return memcache.ErrCacheMiss
+	}
+	delete(c.items, key)
+	return nil
+}
+
+func (c *fakeClient) Increment(key string, delta uint64) (uint64, error) {
+	item, ok := c.items[key]
+	if !ok {
+		return 0, memcache.ErrCacheMiss
+	}
+	value, err := strconv.ParseUint(string(item.Value), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	value += delta
+	item.Value = []byte(strconv.FormatUint(value, 10))
+	return value, nil
+}
+
+func TestAuthRepository_StoreToken(t *testing.T) {
+	client := newFakeClient()
+	repo := NewAuthRepository(client, "", 0)
+
+	err := repo.StoreToken(context.Background(), "raw-token", &entity.TokenDetails{
+		UserID:    1,
+		Username:  "alice",
+		Roles:     []string{"user"},
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	assert.NoError(t, err)
+
+	_, stored := client.items["raw-token"]
+	assert.False(t, stored, "raw token must not be used as a key")
+	assert.Len(t, client.items, 1)
+
+	details, err := repo.GetToken(context.Background(), "raw-token")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, details.UserID)
+	assert.Equal(t, "alice", details.Username)
+	assert.Equal(t, []string{"user"}, details.Roles)
+}
+
+func TestAuthRepository_TokenKey(t *testing.T) {
+	plain := NewAuthRepository(newFakeClient(), "", 0)
+	keyed := NewAuthRepository(newFakeClient(), "server-secret", 0)
+
+	assert.True(t, strings.HasPrefix(plain.tokenKey("token"), tokenKeyPrefix))
+	assert.Equal(t, plain.tokenKey("token"), plain.tokenKey("token"))
+	assert.NotEqual(t, plain.tokenKey("token"), keyed.tokenKey("token"))
+	assert.NotContains(t, keyed.tokenKey("raw-token"), "raw-token")
+}
+
+func TestAuthRepository_GetToken(t *testing.T) {
+	t.Run("not found", func(t *testing.T) {
+		repo := NewAuthRepository(newFakeClient(), "", time.Hour)
+
+		details, err := repo.GetToken(context.Background(), "missing")
+		assert.Error(t, err)
+		assert.Nil(t, details)
+	})
+
+	t.Run("legacy token is migrated", func(t *testing.T) {
+		client := newFakeClient()
+		storeLegacy(t, client, "legacy-token", 7)
+		repo := NewAuthRepository(client, "secret", time.Hour)
+
+		details, err := repo.GetToken(context.Background(), "legacy-token")
+		assert.NoError(t, err)
+		assert.Equal(t, 7, details.UserID)
+
+		_, stillRaw := client.items["legacy-token"]
+		assert.False(t, stillRaw)
+		_, hashed := client.items[repo.tokenKey("legacy-token")]
+		assert.True(t, hashed)
+	})
+
+	t.Run("legacy token after window", func(t *testing.T) {
+		client := newFakeClient()
+		storeLegacy(t, client, "legacy-token", 7)
+		repo := NewAuthRepository(client, "", 0)
+
+		details, err := repo.GetToken(context.Background(), "legacy-token")
+		assert.Error(t, err)
+		assert.Nil(t, details)
+	})
+
+	t.Run("malformed legacy key", func(t *testing.T) {
+		repo := NewAuthRepository(newFakeClient(), "", time.Hour)
+
+		details, err := repo.GetToken(context.Background(), "has space")
+		assert.EqualError(t, err, "token not found")
+		assert.Nil(t, details)
+	})
+}
+
+func TestAuthRepository_RevokeUserTokens(t *testing.T) {
+	repo := NewAuthRepository(newFakeClient(), "secret", 0)
+	ctx := context.Background()
+
+	store := func(token string, userID int) {
+		now := time.Now()
+		assert.NoError(t, repo.StoreToken(ctx, token, &entity.TokenDetails{UserID: userID, IssuedAt: now, ExpiresAt: now.Add(time.Hour)}))
+	}
+	store("before", 7)
+	store("other-user", 8)
+	store("client", 0)
+
+	assert.NoError(t, repo.RevokeUserTokens(ctx, 7, time.Hour))
+	store("after", 7)
+
+	_, err := repo.GetToken(ctx, "before")
+	assert.EqualError(t, err, "token is revoked")
+	for _, token := range []string{"other-user", "client", "after"} {
+		_, err := repo.GetToken(ctx, token)
+		assert.NoError(t, err, token)
+	}
+}
+
+func TestAuthRepository_DeleteToken(t *testing.T) {
+	client := newFakeClient()
+	storeLegacy(t, client, "legacy-token", 7)
+	repo := NewAuthRepository(client, "", time.Hour)
+	assert.NoError(t, repo.StoreToken(context.Background(), "new-token", &entity.TokenDetails{
+		UserID:    1,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}))
+
+	assert.NoError(t, repo.DeleteToken(context.Background(), "new-token"))
+	assert.NoError(t, repo.DeleteToken(context.Background(), "legacy-token"))
+	assert.Empty(t, client.items)
+
+	_, err := repo.GetToken(context.Background(), "legacy-token")
+	assert.Error(t, err)
+}
+
+func storeLegacy(t *testing.T, client *fakeClient, token string, userID int) {
+	t.Helper()
+
+	value, err := json.Marshal(&entity.TokenDetails{
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	assert.NoError(t, err)
+	client.items[token] = &memcache.Item{Key: token, Value: value}
+}
+
+func TestAuthRepository_UseTOTPStep(t *testing.T) {
+	repo := NewAuthRepository(newFakeClient(), "", 0)
+
+	assert.NoError(t, repo.UseTOTPStep(context.Background(), 1, 100))
+	assert.Error(t, repo.UseTOTPStep(context.Background(), 1, 100))
+	assert.NoError(t, repo.UseTOTPStep(context.Background(), 1, 101))
+	assert.NoError(t, repo.UseTOTPStep(context.Background(), 2, 100))
+}
+
+func TestAuthRepository_ConsumeOneTimeToken(t *testing.T) {
+	client := newFakeClient()
+	repo := NewAuthRepository(client, "", 0)
+
+	assert.NoError(t, repo.StoreOneTimeToken(context.Background(), "reset-token", &entity.OneTimeToken{
+		UserID:    1,
+		Purpose:   "password_reset",
+		ExpiresAt: time.Now().Add(time.Hour),
+	}))
+	_, stored := client.items["reset-token"]
+	assert.False(t, stored, "raw token must not be used as a key")
+
+	details, err := repo.ConsumeOneTimeToken(context.Background(), "reset-token")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, details.UserID)
+	assert.Equal(t, "password_reset", details.Purpose)
+
+	_, err = repo.ConsumeOneTimeToken(context.Background(), "reset-token")
+	assert.Error(t, err)
+}
+
+func TestAuthRepository_LoginFailures(t *testing.T) {
+	repo := NewAuthRepository(newFakeClient(), "", 0)
+
+	for want := 1; want <= 3; want++ {
+		count, err := repo.RecordLoginFailure(context.Background(), "email:alice@example.com", time.Hour)
+		assert.NoError(t, err)
+		assert.Equal(t, want, count)
+	}
+
+	count, err := repo.RecordLoginFailure(context.Background(), "ip:10.0.0.1", time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	assert.NoError(t, repo.ResetLoginFailures(context.Background(), "email:alice@example.com"))
+	count, err = repo.RecordLoginFailure(context.Background(), "email:alice@example.com", time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestAuthRepository_RecordMFAFailure(t *testing.T) {
+	repo := NewAuthRepository(newFakeClient(), "", 0)
+	expiresAt := time.Now().Add(time.Minute)
+
+	for want := 1; want <= 3; want++ {
+		count, err := repo.RecordMFAFailure(context.Background(), "challenge", expiresAt)
+		assert.NoError(t, err)
+		assert.Equal(t, want, count)
+	}
+
+	count, err := repo.RecordMFAFailure(context.Background(), "other", expiresAt)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestAuthRepository_LockLogin(t *testing.T) {
+	repo := NewAuthRepository(newFakeClient(), "", 0)
+
+	until, err := repo.LoginLockedUntil(context.Background(), "email:alice@example.com")
+	assert.NoError(t, err)
+	assert.True(t, until.IsZero())
+
+	lockedUntil := time.Now().Add(time.Minute)
+	assert.NoError(t, repo.LockLogin(context.Background(), "email:alice@example.com", lockedUntil))
+
+	until, err = repo.LoginLockedUntil(context.Background(), "email:alice@example.com")
+	assert.NoError(t, err)
+	assert.True(t, lockedUntil.Equal(until))
+}
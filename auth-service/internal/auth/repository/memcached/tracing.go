@@ -0,0 +1,65 @@
+package memcached
+
+import (
+	"context"
+	"errors"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/gauss2302/microtest/pkg/metrics"
+	"github.com/gauss2302/microtest/pkg/telemetry"
+)
+
+// tracedClient records each call as a span of the request in ctx and
+// times it.
+type tracedClient struct {
+	ctx    context.Context
+	client Client
+}
+
+func (r *AuthRepository) with(ctx context.Context) Client {
+	return &tracedClient{ctx: ctx, client: r.client}
+}
+
+// trace starts a span and a timer for operation. Misses and lost races
+// are expected outcomes, not errors.
+func (c *tracedClient) trace(operation string) func(error) {
+	endSpan := telemetry.StartClientSpan(c.ctx, "memcached", operation)
+	endCall := metrics.StartClientCall("memcached", operation)
+	return func(err error) {
+		if errors.Is(err, memcache.ErrCacheMiss) || errors.Is(err, memcache.ErrNotStored) {
+			err = nil
+		}
+		endSpan(err)
+		endCall(err)
+	}
+}
+
+func (c *tracedClient) Get(key string) (item *memcache.Item, err error) {
+	end := c.trace("get")
+	defer func() { end(err) }()
+	return c.client.Get(key)
+}
+
+func (c *tracedClient) Set(item *memcache.Item) (err error) {
+	end := c.trace("set")
+	defer func() { end(err) }()
+	return c.client.Set(item)
+}
+
+func (c *tracedClient) Add(item *memcache.Item) (err error) {
+	end := c.trace("add")
+	defer func() { end(err) }()
+	return c.client.Add(item)
+}
+
+func (c *tracedClient) Delete(key string) (err error) {
+	end := c.trace("delete")
+	defer func() { end(err) }()
+	return c.client.Delete(key)
+}
+
+func (c *tracedClient) Increment(key string, delta uint64) (value uint64, err error) {
+	end := c.trace("incr")
+	defer func() { end(err) }()
+	return c.client.Increment(key, delta)
+}
@@ -1,10 +1,29 @@
 package auth
 
-import "github.com/gauss2302/microtest/auth-service/internal/entity"
+import (
+	"context"
+
+	"github.com/gauss2302/microtest/auth-service/internal/entity"
+)
 
 type Usecase interface {
-	Login(request *entity.LoginRequest) (*entity.TokenResponse, error)
-	Register(request *entity.RegisterRequest) (*entity.TokenResponse, error)
-	ValidateToken(token string) (*entity.TokenDetails, error)
-	Logout(token string) error
+	Login(ctx context.Context, request *entity.LoginRequest) (*entity.LoginResponse, error)
+	LoginMFA(ctx context.Context, request *entity.MFALoginRequest) (*entity.TokenResponse, error)
+	Register(ctx context.Context, request *entity.RegisterRequest) (*entity.TokenResponse, error)
+	Token(ctx context.Context, request *entity.TokenRequest) (*entity.TokenResponse, error)
+	ValidateToken(ctx context.Context, token string) (*entity.TokenDetails, error)
+	Introspect(ctx context.Context, request *entity.IntrospectionRequest) (*entity.IntrospectionResponse, error)
+	Logout(ctx context.Context, token string) error
+	EnrollTOTP(ctx context.Context, token string) (*entity.TOTPEnrollment, error)
+	VerifyTOTP(ctx context.Context, token, code string) (*entity.RecoveryCodesResponse, error)
+	ForgotPassword(ctx context.Context, email string) error
+	ResetPassword(ctx context.Context, request *entity.ResetPasswordRequest) error
+	VerifyEmail(ctx context.Context, token string) error
+	ResendVerificationEmail(ctx context.Context, accessToken string) error
+	SendVerificationEmail(ctx context.Context, userID int) error
+}
+
+type OIDCUsecase interface {
+	StartLogin(ctx context.Context, provider string) (*entity.OIDCLogin, error)
+	CompleteLogin(ctx context.Context, provider, state, binding, code string) (*entity.LoginResponse, error)
 }
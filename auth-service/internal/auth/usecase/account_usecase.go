@@ -0,0 +1,283 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gauss2302/microtest/auth-service/internal/auth"
+	"github.com/gauss2302/microtest/auth-service/internal/entity"
+	"github.com/gauss2302/microtest/auth-service/pkg/mailer"
+	"github.com/gauss2302/microtest/pkg/respond"
+)
+
+// One-time token purposes
+const (
+	PurposePasswordReset     = "password_reset"
+	PurposeEmailVerification = "email_verification"
+)
+
+const (
+	passwordResetExpiration     = time.Hour
+	emailVerificationExpiration = 48 * time.Hour
+)
+
+// ForgotPassword mails a reset link if an account exists for email. It
+// reports success either way so the endpoint cannot be used to probe for
+// registered addresses.
+func (u *AuthUsecase) ForgotPassword(ctx context.Context, email string) error {
+	user, err := u.findUserByEmail(ctx, email)
+	if err != nil {
+		return fmt.Errorf("failed to look up user: %w", err)
+	}
+	if user == nil {
+		return nil
+	}
+
+	token, err := u.issueOneTimeToken(ctx, user, PurposePasswordReset, passwordResetExpiration)
+	if err != nil {
+		return err
+	}
+
+	return u.mailer.Send(&mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Use the link below to choose a new password. It expires in %s.\n\n%s",
+			passwordResetExpiration, u.link("/reset-password", token),
+		),
+	})
+}
+
+func (u *AuthUsecase) ResetPassword(ctx context.Context, request *entity.ResetPasswordRequest) error {
+	if request.Password == "" {
+		return fmt.Errorf("password is required")
+	}
+
+	details, err := u.consumeOneTimeToken(ctx, request.Token, PurposePasswordReset)
+	if err != nil {
+		return err
+	}
+
+	err = u.updatePassword(ctx, details.UserID, request.Password)
+	var rejected *auth.PasswordRejectedError
+	if errors.As(err, &rejected) {
+		// Give the token back so the user can try another password
+		if err := u.repo.StoreOneTimeToken(ctx, request.Token, details); err != nil {
+			slog.ErrorContext(ctx, "Failed to restore password reset token", "error", err)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// Whoever knew the old password may still hold a session
+	if err := u.repo.RevokeUserTokens(ctx, details.UserID, u.tokenExpiration); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return nil
+}
+
+// VerifyEmail marks the address the link was sent to as verified. Links
+// to an address the user has since changed are refused.
+func (u *AuthUsecase) VerifyEmail(ctx context.Context, token string) error {
+	details, err := u.consumeOneTimeToken(ctx, token, PurposeEmailVerification)
+	if err != nil {
+		return err
+	}
+
+	user, err := u.getUser(ctx, details.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to look up user: %w", err)
+	}
+	if !strings.EqualFold(user.Email, details.Email) {
+		return fmt.Errorf("invalid token: email address has changed")
+	}
+
+	return u.markEmailVerified(ctx, details.UserID)
+}
+
+// ResendVerificationEmail sends a new verification link to the owner of
+// accessToken unless their address is already verified.
+func (u *AuthUsecase) ResendVerificationEmail(ctx context.Context, accessToken string) error {
+	details, err := u.repo.GetToken(ctx, accessToken)
+	if err != nil {
+		return fmt.Errorf("invalid token: %w", err)
+	}
+	if details.UserID == 0 {
+		return fmt.Errorf("token does not belong to a user")
+	}
+
+	return u.SendVerificationEmail(ctx, details.UserID)
+}
+
+// SendVerificationEmail sends a verification link to the user's current
+// address unless it is already verified. user-service asks for one when
+// the address changes.
+func (u *AuthUsecase) SendVerificationEmail(ctx context.Context, userID int) error {
+	user, err := u.getUser(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to look up user: %w", err)
+	}
+	if user.EmailVerifiedAt != nil {
+		return nil
+	}
+
+	return u.sendVerificationEmail(ctx, user)
+}
+
+func (u *AuthUsecase) sendVerificationEmail(ctx context.Context, user *entity.User) error {
+	token, err := u.issueOneTimeToken(ctx, user, PurposeEmailVerification, emailVerificationExpiration)
+	if err != nil {
+		return err
+	}
+
+	return u.mailer.Send(&mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf(
+			"Use the link below to confirm your email address. It expires in %s.\n\n%s",
+			emailVerificationExpiration, u.link("/verify-email", token),
+		),
+	})
+}
+
+func (u *AuthUsecase) issueOneTimeToken(ctx context.Context, user *entity.User, purpose string, expiration time.Duration) (string, error) {
+	token, err := u.generateToken(32)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	err = u.repo.StoreOneTimeToken(ctx, token, &entity.OneTimeToken{
+		UserID:    user.ID,
+		Email:     user.Email,
+		Purpose:   purpose,
+		ExpiresAt: time.Now().Add(expiration),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to store token: %w", err)
+	}
+
+	return token, nil
+}
+
+// consumeOneTimeToken redeems token for purpose. A token is spent even if
+// it was presented for the wrong purpose.
+func (u *AuthUsecase) consumeOneTimeToken(ctx context.Context, token, purpose string) (*entity.OneTimeToken, error) {
+	if token == "" {
+		return nil, fmt.Errorf("token is required")
+	}
+
+	details, err := u.repo.ConsumeOneTimeToken(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+	if details.Purpose != purpose {
+		return nil, fmt.Errorf("invalid token: wrong purpose")
+	}
+
+	return details, nil
+}
+
+func (u *AuthUsecase) link(path, token string) string {
+	return u.publicURL + path + "?token=" + url.QueryEscape(token)
+}
+
+// logMailError keeps a failed delivery from failing the request that
+// triggered it. The user can ask for the message again.
+func logMailError(ctx context.Context, err error) {
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to send mail", "error", err)
+	}
+}
+
+func (u *AuthUsecase) findUserByEmail(ctx context.Context, email string) (*entity.User, error) {
+	resp, err := u.callUserService(ctx, http.MethodGet, "/users?email="+url.QueryEscape(email), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to find user")
+	}
+
+	var users []entity.User
+	if err := json.NewDecoder(resp.Body).Decode(&users); err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, nil
+	}
+
+	return &users[0], nil
+}
+
+func (u *AuthUsecase) getUser(ctx context.Context, userID int) (*entity.User, error) {
+	resp, err := u.callUserService(ctx, http.MethodGet, fmt.Sprintf("/users/%d", userID), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get user")
+	}
+
+	var user entity.User
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (u *AuthUsecase) updatePassword(ctx context.Context, userID int, password string) error {
+	resp, err := u.callUserService(ctx, http.MethodPut, fmt.Sprintf("/users/%d/password", userID), map[string]string{"password": password})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnprocessableEntity {
+		return passwordRejected(resp)
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("failed to update password")
+	}
+
+	return nil
+}
+
+// passwordRejected reads the policy violation user-service reported.
+func passwordRejected(resp *http.Response) error {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return &auth.PasswordRejectedError{Reason: "password does not meet the policy"}
+	}
+	reason := respond.ReadError(body)
+	if reason == "" {
+		return &auth.PasswordRejectedError{Reason: "password does not meet the policy"}
+	}
+	return &auth.PasswordRejectedError{Reason: reason}
+}
+
+func (u *AuthUsecase) markEmailVerified(ctx context.Context, userID int) error {
+	resp, err := u.callUserService(ctx, http.MethodPost, fmt.Sprintf("/users/%d/verify-email", userID), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to mark email verified")
+	}
+
+	return nil
+}
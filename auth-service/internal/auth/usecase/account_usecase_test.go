@@ -0,0 +1,199 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gauss2302/microtest/auth-service/internal/auth"
+	"github.com/gauss2302/microtest/auth-service/internal/entity"
+	"github.com/gauss2302/microtest/auth-service/pkg/mailer"
+	"github.com/gauss2302/microtest/pkg/respond"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// accountUserService fakes the user-service endpoints used by the account
+// flows for a single user.
+type accountUserService struct {
+	*httptest.Server
+	password string
+}
+
+func newAccountUserService(t *testing.T, user *entity.User) *accountUserService {
+	t.Helper()
+
+	service := &accountUserService{}
+	service.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userPath := "/users/6"
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == userPath:
+			json.NewEncoder(w).Encode(user)
+		case r.Method == http.MethodGet && r.URL.Path == "/users":
+			users := []*entity.User{}
+			if r.URL.Query().Get("email") == user.Email {
+				users = append(users, user)
+			}
+			json.NewEncoder(w).Encode(users)
+		case r.Method == http.MethodPut && r.URL.Path == userPath+"/password":
+			var body map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			if body["password"] == "weak" {
+				respond.Error(w, r, http.StatusUnprocessableEntity, "password must be at least 8 characters long")
+				return
+			}
+			service.password = body["password"]
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodPost && r.URL.Path == userPath+"/verify-email":
+			now := time.Now()
+			user.EmailVerifiedAt = &now
+			json.NewEncoder(w).Encode(user)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(service.Close)
+	return service
+}
+
+// tokenFromMail extracts the token from the link in msg.
+func tokenFromMail(t *testing.T, msg *mailer.Message) string {
+	t.Helper()
+
+	link := msg.Body[strings.LastIndex(msg.Body, "\n")+1:]
+	parsed, err := url.Parse(link)
+	assert.NoError(t, err)
+	return parsed.Query().Get("token")
+}
+
+func TestAuthUsecase_PasswordReset(t *testing.T) {
+	user := &entity.User{ID: 6, Username: "frank", Email: "frank@example.com"}
+
+	t.Run("reset with mailed token", func(t *testing.T) {
+		service := newAccountUserService(t, user)
+		mockRepo := new(MockAuthRepository)
+		mockMailer := new(MockMailer)
+		usecase := NewAuthUsecase(mockRepo, nil, mockMailer, time.Hour, service.URL, "http://localhost:8080/")
+
+		var stored *entity.OneTimeToken
+		mockRepo.On("StoreOneTimeToken", mock.AnythingOfType("string"), mock.Anything).
+			Run(func(args mock.Arguments) { stored = args.Get(1).(*entity.OneTimeToken) }).
+			Return(nil).Once()
+
+		var sent *mailer.Message
+		mockMailer.On("Send", mock.Anything).
+			Run(func(args mock.Arguments) { sent = args.Get(0).(*mailer.Message) }).
+			Return(nil).Once()
+
+		assert.NoError(t, usecase.ForgotPassword(context.Background(), user.Email))
+		assert.Equal(t, PurposePasswordReset, stored.Purpose)
+		assert.WithinDuration(t, time.Now().Add(passwordResetExpiration), stored.ExpiresAt, time.Minute)
+		assert.Equal(t, user.Email, sent.To)
+		assert.Contains(t, sent.Body, "http://localhost:8080/reset-password?token=")
+
+		token := tokenFromMail(t, sent)
+		mockRepo.On("ConsumeOneTimeToken", token).Return(stored, nil).Once()
+		mockRepo.On("ConsumeOneTimeToken", token).Return(nil, errors.New("one-time token not found"))
+		mockRepo.On("RevokeUserTokens", 6, time.Hour).Return(nil).Once()
+
+		assert.NoError(t, usecase.ResetPassword(context.Background(), &entity.ResetPasswordRequest{Token: token, Password: "new-password"}))
+		assert.Equal(t, "new-password", service.password)
+		mockRepo.AssertCalled(t, "RevokeUserTokens", 6, time.Hour)
+
+		// Tokens are single-use
+		assert.Error(t, usecase.ResetPassword(context.Background(), &entity.ResetPasswordRequest{Token: token, Password: "other"}))
+		assert.Equal(t, "new-password", service.password)
+	})
+
+	t.Run("unknown email sends nothing", func(t *testing.T) {
+		service := newAccountUserService(t, user)
+		mockRepo := new(MockAuthRepository)
+		mockMailer := new(MockMailer)
+		usecase := NewAuthUsecase(mockRepo, nil, mockMailer, time.Hour, service.URL, "")
+
+		assert.NoError(t, usecase.ForgotPassword(context.Background(), "nobody@example.com"))
+		mockRepo.AssertNotCalled(t, "StoreOneTimeToken", mock.Anything, mock.Anything)
+		mockMailer.AssertNotCalled(t, "Send", mock.Anything)
+	})
+
+	t.Run("rejected password keeps the token", func(t *testing.T) {
+		service := newAccountUserService(t, user)
+		mockRepo := new(MockAuthRepository)
+		usecase := NewAuthUsecase(mockRepo, nil, new(MockMailer), time.Hour, service.URL, "")
+
+		details := &entity.OneTimeToken{UserID: 6, Purpose: PurposePasswordReset, ExpiresAt: time.Now().Add(time.Hour)}
+		mockRepo.On("ConsumeOneTimeToken", "reset").Return(details, nil).Once()
+		mockRepo.On("StoreOneTimeToken", "reset", details).Return(nil).Once()
+
+		err := usecase.ResetPassword(context.Background(), &entity.ResetPasswordRequest{Token: "reset", Password: "weak"})
+		var rejected *auth.PasswordRejectedError
+		if assert.ErrorAs(t, err, &rejected) {
+			assert.Equal(t, "password must be at least 8 characters long", rejected.Reason)
+		}
+		mockRepo.AssertExpectations(t)
+		mockRepo.AssertNotCalled(t, "RevokeUserTokens", mock.Anything, mock.Anything)
+	})
+
+	t.Run("verification token cannot reset password", func(t *testing.T) {
+		service := newAccountUserService(t, user)
+		mockRepo := new(MockAuthRepository)
+		usecase := NewAuthUsecase(mockRepo, nil, new(MockMailer), time.Hour, service.URL, "")
+
+		mockRepo.On("ConsumeOneTimeToken", "verify").Return(&entity.OneTimeToken{
+			UserID:    6,
+			Purpose:   PurposeEmailVerification,
+			ExpiresAt: time.Now().Add(time.Hour),
+		}, nil)
+
+		assert.Error(t, usecase.ResetPassword(context.Background(), &entity.ResetPasswordRequest{Token: "verify", Password: "new-password"}))
+		assert.Empty(t, service.password)
+	})
+}
+
+func TestAuthUsecase_VerifyEmail(t *testing.T) {
+	user := &entity.User{ID: 6, Username: "frank", Email: "frank@example.com"}
+	service := newAccountUserService(t, user)
+	mockRepo := new(MockAuthRepository)
+	mockMailer := new(MockMailer)
+	usecase := NewAuthUsecase(mockRepo, nil, mockMailer, time.Hour, service.URL, "")
+
+	mockRepo.On("GetToken", "access").Return(&entity.TokenDetails{UserID: 6, Email: user.Email}, nil)
+	mockRepo.On("StoreOneTimeToken", mock.AnythingOfType("string"), mock.MatchedBy(func(d *entity.OneTimeToken) bool {
+		return d.UserID == 6 && d.Purpose == PurposeEmailVerification
+	})).Return(nil).Once()
+	mockMailer.On("Send", mock.Anything).Return(nil).Once()
+
+	assert.NoError(t, usecase.ResendVerificationEmail(context.Background(), "access"))
+
+	// Links to an address the user has since changed are refused
+	mockRepo.On("ConsumeOneTimeToken", "old-address").Return(&entity.OneTimeToken{
+		UserID:    6,
+		Email:     "old@example.com",
+		Purpose:   PurposeEmailVerification,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}, nil).Once()
+
+	assert.Error(t, usecase.VerifyEmail(context.Background(), "old-address"))
+	assert.Nil(t, user.EmailVerifiedAt)
+
+	mockRepo.On("ConsumeOneTimeToken", "verify").Return(&entity.OneTimeToken{
+		UserID:    6,
+		Email:     user.Email,
+		Purpose:   PurposeEmailVerification,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}, nil).Once()
+
+	assert.NoError(t, usecase.VerifyEmail(context.Background(), "verify"))
+	assert.NotNil(t, user.EmailVerifiedAt)
+
+	// Verified addresses get no further mail
+	assert.NoError(t, usecase.ResendVerificationEmail(context.Background(), "access"))
+	mockRepo.AssertExpectations(t)
+	mockMailer.AssertExpectations(t)
+}
@@ -2,76 +2,209 @@ package usecase
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/gauss2302/microtest/auth-service/internal/auth"
 	"github.com/gauss2302/microtest/auth-service/internal/entity"
+	"github.com/gauss2302/microtest/auth-service/pkg/mailer"
+	"github.com/gauss2302/microtest/pkg/correlation"
+	"github.com/gauss2302/microtest/pkg/telemetry"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Grant types accepted by the token endpoint
+const (
+	GrantTypeClientCredentials = "client_credentials"
+	GrantTypePassword          = "password"
 )
 
 type AuthUsecase struct {
 	repo            auth.Repository
+	clients         auth.ClientRepository
+	mailer          mailer.Mailer
 	tokenExpiration time.Duration
 	userServiceURL  string
-	httpClient      *http.Client
+	// Base URL of the frontend that links in emails point to
+	publicURL  string
+	httpClient *http.Client
 }
 
-func NewAuthUsecase(repo auth.Repository, tokenExpiration time.Duration, userServiceURL string) *AuthUsecase {
+func NewAuthUsecase(repo auth.Repository, clients auth.ClientRepository, mailer mailer.Mailer, tokenExpiration time.Duration, userServiceURL, publicURL string) *AuthUsecase {
 	return &AuthUsecase{
 		repo:            repo,
+		clients:         clients,
+		mailer:          mailer,
 		tokenExpiration: tokenExpiration,
 		userServiceURL:  userServiceURL,
-		httpClient:      &http.Client{Timeout: 10 * time.Second},
+		publicURL:       strings.TrimSuffix(publicURL, "/"),
+		httpClient:      &http.Client{Timeout: 10 * time.Second, Transport: &correlation.Transport{Base: telemetry.Transport(nil)}},
 	}
 }
 
-func (u *AuthUsecase) Login(request *entity.LoginRequest) (*entity.TokenResponse, error) {
+// Login verifies the password. Users with MFA enabled get a challenge that
+// must be completed through LoginMFA before a token is issued.
+func (u *AuthUsecase) Login(ctx context.Context, request *entity.LoginRequest) (*entity.LoginResponse, error) {
 	// Verify credentials with user service
-	userID, err := u.verifyCredentials(request.Email, request.Password)
+	user, err := u.authenticate(ctx, request.Email, request.Password, request.ClientIP)
+	var lockout *auth.LockoutError
+	if errors.As(err, &lockout) {
+		return nil, err
+	}
 	if err != nil {
 		return nil, fmt.Errorf("invalid credentials: %w", err)
 	}
 
-	// Generate token
-	token, err := u.generateToken(32)
+	return u.completeLogin(ctx, user)
+}
+
+// completeLogin issues a token for an authenticated user, or starts an MFA
+// challenge when the user has a second factor enabled.
+func (u *AuthUsecase) completeLogin(ctx context.Context, user *entity.User) (*entity.LoginResponse, error) {
+	settings, err := u.getMFASettings(ctx, user.ID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate token: %w", err)
+		return nil, fmt.Errorf("failed to load mfa settings: %w", err)
+	}
+	if settings.TOTPEnabled {
+		return u.startMFAChallenge(ctx, userTokenDetails(user))
 	}
 
-	// Store token in memcached
-	err = u.repo.StoreToken(userID, token, u.tokenExpiration)
+	token, err := u.issueToken(ctx, userTokenDetails(user))
 	if err != nil {
-		return nil, fmt.Errorf("failed to store token: %w", err)
+		return nil, err
 	}
-
-	return &entity.TokenResponse{
-		AccessToken: token,
-		TokenType:   "Bearer",
-		ExpiresIn:   int(u.tokenExpiration.Seconds()),
-		CreatedAt:   time.Now(),
-	}, nil
+	return &entity.LoginResponse{TokenResponse: token}, nil
 }
 
-func (u *AuthUsecase) Register(request *entity.RegisterRequest) (*entity.TokenResponse, error) {
+func (u *AuthUsecase) Register(ctx context.Context, request *entity.RegisterRequest) (*entity.TokenResponse, error) {
 	// Call user service to create user
-	userID, err := u.createUser(request)
+	user, err := u.createUser(ctx, request)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create user: %w", err)
 	}
 
+	logMailError(ctx, u.sendVerificationEmail(ctx, user))
+
+	return u.issueToken(ctx, userTokenDetails(user))
+}
+
+// Token implements the OAuth2 token endpoint for registered clients. The
+// issued token carries the granted scopes; for the password grant it also
+// carries the resource owner's profile.
+func (u *AuthUsecase) Token(ctx context.Context, request *entity.TokenRequest) (*entity.TokenResponse, error) {
+	client, err := u.authenticateClient(request.ClientID, request.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	switch request.GrantType {
+	case GrantTypeClientCredentials, GrantTypePassword:
+	case "":
+		return nil, auth.NewOAuthError(auth.ErrCodeInvalidRequest, "grant_type is required")
+	default:
+		return nil, auth.NewOAuthError(auth.ErrCodeUnsupportedGrantType, "")
+	}
+
+	if len(client.GrantTypes) > 0 && !slices.Contains(client.GrantTypes, request.GrantType) {
+		return nil, auth.NewOAuthError(auth.ErrCodeUnauthorizedClient, "grant type is not allowed for this client")
+	}
+
+	scopes, err := grantedScopes(client, request.Scope)
+	if err != nil {
+		return nil, err
+	}
+
+	details := &entity.TokenDetails{}
+	if request.GrantType == GrantTypePassword {
+		if request.Username == "" || request.Password == "" {
+			return nil, auth.NewOAuthError(auth.ErrCodeInvalidRequest, "username and password are required")
+		}
+
+		user, err := u.authenticate(ctx, request.Username, request.Password, request.ClientIP)
+		var lockout *auth.LockoutError
+		if errors.As(err, &lockout) {
+			return nil, err
+		}
+		if err != nil {
+			return nil, auth.NewOAuthError(auth.ErrCodeInvalidGrant, "invalid resource owner credentials")
+		}
+
+		// The grant has no step for a second factor, so users who enabled
+		// one must log in through Login and LoginMFA
+		settings, err := u.getMFASettings(ctx, user.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load mfa settings: %w", err)
+		}
+		if settings.TOTPEnabled {
+			return nil, auth.NewOAuthError(auth.ErrCodeInvalidGrant, "multi-factor authentication is required, use /auth/login")
+		}
+		details = userTokenDetails(user)
+	}
+
+	details.ClientID = client.ID
+	details.Scopes = scopes
+
+	return u.issueToken(ctx, details)
+}
+
+func (u *AuthUsecase) Logout(ctx context.Context, token string) error {
+	return u.repo.DeleteToken(ctx, token)
+}
+
+func (u *AuthUsecase) ValidateToken(ctx context.Context, token string) (*entity.TokenDetails, error) {
+	return u.repo.GetToken(ctx, token)
+}
+
+// Introspect reports on a token to an authenticated client. Tokens that
+// cannot be looked up are reported inactive rather than as an error.
+func (u *AuthUsecase) Introspect(ctx context.Context, request *entity.IntrospectionRequest) (*entity.IntrospectionResponse, error) {
+	if _, err := u.authenticateClient(request.ClientID, request.ClientSecret); err != nil {
+		return nil, err
+	}
+
+	details, err := u.repo.GetToken(ctx, request.Token)
+	if err != nil {
+		return &entity.IntrospectionResponse{}, nil
+	}
+	return &entity.IntrospectionResponse{
+		Active:    true,
+		TokenType: "Bearer",
+		UserID:    details.UserID,
+		Username:  details.Username,
+		Email:     details.Email,
+		Roles:     details.Roles,
+		ClientID:  details.ClientID,
+		Scope:     strings.Join(details.Scopes, " "),
+		ExpiresAt: details.ExpiresAt.Unix(),
+	}, nil
+}
+
+// Helper methods
+
+// issueToken generates a token and stores it together with details, so
+// that introspection needs no call to user-service.
+func (u *AuthUsecase) issueToken(ctx context.Context, details *entity.TokenDetails) (*entity.TokenResponse, error) {
 	// Generate token
 	token, err := u.generateToken(32)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate token: %w", err)
 	}
 
-	// Store token
-	err = u.repo.StoreToken(userID, token, u.tokenExpiration)
-	if err != nil {
+	// Store token in memcached
+	now := time.Now()
+	details.IssuedAt = now
+	details.ExpiresAt = now.Add(u.tokenExpiration)
+	if err := u.repo.StoreToken(ctx, token, details); err != nil {
 		return nil, fmt.Errorf("failed to store token: %w", err)
 	}
 
@@ -79,81 +212,193 @@ func (u *AuthUsecase) Register(request *entity.RegisterRequest) (*entity.TokenRe
 		AccessToken: token,
 		TokenType:   "Bearer",
 		ExpiresIn:   int(u.tokenExpiration.Seconds()),
-		CreatedAt:   time.Now(),
+		Scope:       strings.Join(details.Scopes, " "),
+		CreatedAt:   now,
 	}, nil
 }
 
-func (u *AuthUsecase) Logout(token string) error {
-	return u.repo.DeleteToken(token)
+func userTokenDetails(user *entity.User) *entity.TokenDetails {
+	return &entity.TokenDetails{
+		UserID:   user.ID,
+		Username: user.Username,
+		Email:    user.Email,
+		Roles:    user.Roles,
+	}
 }
 
-func (u *AuthUsecase) ValidateToken(token string) (*entity.TokenDetails, error) {
-	return u.repo.GetToken(token)
+func (u *AuthUsecase) authenticateClient(clientID, clientSecret string) (*entity.OAuthClient, error) {
+	if clientID == "" || clientSecret == "" {
+		return nil, auth.NewOAuthError(auth.ErrCodeInvalidClient, "client authentication is required")
+	}
+
+	client, err := u.clients.GetClient(clientID)
+	if err != nil {
+		return nil, auth.NewOAuthError(auth.ErrCodeInvalidClient, "client authentication failed")
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(client.SecretHash), []byte(clientSecret)); err != nil {
+		return nil, auth.NewOAuthError(auth.ErrCodeInvalidClient, "client authentication failed")
+	}
+
+	return client, nil
 }
 
-// Helper methods
+// grantedScopes returns the requested scopes, or every scope of the client
+// when none were requested. Asking for a scope the client is not allowed
+// is an error rather than a silent downgrade.
+func grantedScopes(client *entity.OAuthClient, requested string) ([]string, error) {
+	scopes := strings.Fields(requested)
+	if len(scopes) == 0 {
+		return client.Scopes, nil
+	}
+
+	for _, scope := range scopes {
+		if !slices.Contains(client.Scopes, scope) {
+			return nil, auth.NewOAuthError(auth.ErrCodeInvalidScope, fmt.Sprintf("scope %q is not allowed for this client", scope))
+		}
+	}
+	return scopes, nil
+}
 
-func (u *AuthUsecase) verifyCredentials(email, password string) (int, error) {
-	reqBody, err := json.Marshal(map[string]string{
+func (u *AuthUsecase) verifyCredentials(ctx context.Context, email, password string) (*entity.User, error) {
+	resp, err := u.callUserService(ctx, http.MethodPost, "/users/verify", map[string]string{
 		"email":    email,
 		"password": password,
 	})
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, errInvalidCredentials
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to verify credentials")
 	}
 
-	resp, err := u.httpClient.Post(
-		u.userServiceURL+"/users/verify",
-		"application/json",
-		bytes.NewBuffer(reqBody),
-	)
+	var user entity.User
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (u *AuthUsecase) createUser(ctx context.Context, req *entity.RegisterRequest) (*entity.User, error) {
+	resp, err := u.callUserService(ctx, http.MethodPost, "/users", req)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return 0, fmt.Errorf("invalid credentials")
+	if resp.StatusCode == http.StatusUnprocessableEntity {
+		return nil, passwordRejected(resp)
 	}
-
-	var user struct {
-		ID int `json:"id"`
+	if resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("failed to create user")
 	}
+
+	var user entity.User
 	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	return user.ID, nil
+	return &user, nil
 }
 
-func (u *AuthUsecase) createUser(req *entity.RegisterRequest) (int, error) {
-	reqBody, err := json.Marshal(req)
+func (u *AuthUsecase) getMFASettings(ctx context.Context, userID int) (*entity.MFASettings, error) {
+	resp, err := u.callUserService(ctx, http.MethodGet, fmt.Sprintf("/users/%d/mfa", userID), nil)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
+	defer resp.Body.Close()
 
-	resp, err := u.httpClient.Post(
-		u.userServiceURL+"/users",
-		"application/json",
-		bytes.NewBuffer(reqBody),
-	)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get mfa settings")
+	}
+
+	var settings entity.MFASettings
+	if err := json.NewDecoder(resp.Body).Decode(&settings); err != nil {
+		return nil, err
+	}
+
+	return &settings, nil
+}
+
+func (u *AuthUsecase) saveMFASettings(ctx context.Context, userID int, settings *entity.MFASettings) error {
+	resp, err := u.callUserService(ctx, http.MethodPut, fmt.Sprintf("/users/%d/mfa", userID), settings)
 	if err != nil {
-		return 0, err
+		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusCreated {
-		return 0, fmt.Errorf("failed to create user")
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("failed to save mfa settings")
+	}
+
+	return nil
+}
+
+// consumeRecoveryCode removes a recovery code hash from the user's codes,
+// reporting false if the user no longer has it.
+func (u *AuthUsecase) consumeRecoveryCode(ctx context.Context, userID int, hash string) (bool, error) {
+	resp, err := u.callUserService(ctx, http.MethodDelete, fmt.Sprintf("/users/%d/mfa/recovery-codes/%s", userID, url.PathEscape(hash)), nil)
+	if err != nil {
+		return false, err
 	}
+	defer resp.Body.Close()
 
-	var user struct {
-		ID int `json:"id"`
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("failed to consume recovery code")
+	}
+}
+
+func (u *AuthUsecase) linkIdentity(ctx context.Context, identity *entity.ExternalIdentity) (*entity.User, error) {
+	resp, err := u.callUserService(ctx, http.MethodPost, "/users/identities", identity)
+	if err != nil {
+		return nil, err
 	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to link identity")
+	}
+
+	var user entity.User
 	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
-		return 0, err
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// callUserService sends body, if any, as JSON to user-service. The request
+// carries the IDs of the request in ctx, so both services log them.
+func (u *AuthUsecase) callUserService(ctx context.Context, method, path string, body any) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reqBody, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(reqBody)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.userServiceURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
 	}
 
-	return user.ID, nil
+	return u.httpClient.Do(req)
 }
 
 func (u *AuthUsecase) generateToken(length int) (string, error) {
@@ -0,0 +1,427 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gauss2302/microtest/auth-service/internal/auth"
+	"github.com/gauss2302/microtest/auth-service/internal/entity"
+	"github.com/gauss2302/microtest/auth-service/pkg/mailer"
+	"github.com/gauss2302/microtest/pkg/correlation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Mock repository
+type MockAuthRepository struct {
+	mock.Mock
+}
+
+func (m *MockAuthRepository) StoreToken(ctx context.Context, token string, details *entity.TokenDetails) error {
+	args := m.Called(token, details)
+	return args.Error(0)
+}
+
+func (m *MockAuthRepository) GetToken(ctx context.Context, token string) (*entity.TokenDetails, error) {
+	args := m.Called(token)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*entity.TokenDetails), args.Error(1)
+}
+
+func (m *MockAuthRepository) DeleteToken(ctx context.Context, token string) error {
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+func (m *MockAuthRepository) RevokeUserTokens(ctx context.Context, userID int, lifetime time.Duration) error {
+	args := m.Called(userID, lifetime)
+	return args.Error(0)
+}
+
+func (m *MockAuthRepository) StoreMFAChallenge(ctx context.Context, token string, challenge *entity.MFAChallenge) error {
+	args := m.Called(token, challenge)
+	return args.Error(0)
+}
+
+func (m *MockAuthRepository) GetMFAChallenge(ctx context.Context, token string) (*entity.MFAChallenge, error) {
+	args := m.Called(token)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*entity.MFAChallenge), args.Error(1)
+}
+
+func (m *MockAuthRepository) DeleteMFAChallenge(ctx context.Context, token string) error {
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+func (m *MockAuthRepository) RecordMFAFailure(ctx context.Context, token string, expiresAt time.Time) (int, error) {
+	args := m.Called(token, expiresAt)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockAuthRepository) UseTOTPStep(ctx context.Context, userID int, step int64) error {
+	args := m.Called(userID, step)
+	return args.Error(0)
+}
+
+func (m *MockAuthRepository) StoreOneTimeToken(ctx context.Context, token string, details *entity.OneTimeToken) error {
+	args := m.Called(token, details)
+	return args.Error(0)
+}
+
+func (m *MockAuthRepository) ConsumeOneTimeToken(ctx context.Context, token string) (*entity.OneTimeToken, error) {
+	args := m.Called(token)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*entity.OneTimeToken), args.Error(1)
+}
+
+func (m *MockAuthRepository) RecordLoginFailure(ctx context.Context, subject string, window time.Duration) (int, error) {
+	args := m.Called(subject, window)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockAuthRepository) ResetLoginFailures(ctx context.Context, subject string) error {
+	args := m.Called(subject)
+	return args.Error(0)
+}
+
+func (m *MockAuthRepository) LockLogin(ctx context.Context, subject string, until time.Time) error {
+	args := m.Called(subject, until)
+	return args.Error(0)
+}
+
+func (m *MockAuthRepository) LoginLockedUntil(ctx context.Context, subject string) (time.Time, error) {
+	args := m.Called(subject)
+	return args.Get(0).(time.Time), args.Error(1)
+}
+
+// allowLogins sets up the lockout bookkeeping for tests that are not about
+// lockout: nobody is locked and failures stay below the thresholds.
+func allowLogins(m *MockAuthRepository) {
+	m.On("LoginLockedUntil", mock.Anything).Return(time.Time{}, nil).Maybe()
+	m.On("RecordLoginFailure", mock.Anything, mock.Anything).Return(1, nil).Maybe()
+	m.On("ResetLoginFailures", mock.Anything).Return(nil).Maybe()
+}
+
+// Mock client repository
+type MockClientRepository struct {
+	mock.Mock
+}
+
+func (m *MockClientRepository) GetClient(clientID string) (*entity.OAuthClient, error) {
+	args := m.Called(clientID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*entity.OAuthClient), args.Error(1)
+}
+
+// Mock mailer
+type MockMailer struct {
+	mock.Mock
+}
+
+func (m *MockMailer) Send(msg *mailer.Message) error {
+	args := m.Called(msg)
+	return args.Error(0)
+}
+
+func newUserService(t *testing.T, status int, user *entity.User) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/mfa") {
+			json.NewEncoder(w).Encode(&entity.MFASettings{})
+			return
+		}
+		w.WriteHeader(status)
+		if user != nil {
+			json.NewEncoder(w).Encode(user)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestAuthUsecase_Login(t *testing.T) {
+	user := &entity.User{ID: 1, Username: "alice", Email: "alice@example.com", Roles: []string{"user", "admin"}}
+
+	t.Run("stores the user profile with the token", func(t *testing.T) {
+		mockRepo := new(MockAuthRepository)
+		allowLogins(mockRepo)
+		server := newUserService(t, http.StatusOK, user)
+		usecase := NewAuthUsecase(mockRepo, nil, new(MockMailer), time.Hour, server.URL, "")
+
+		mockRepo.On("StoreToken", mock.AnythingOfType("string"), mock.MatchedBy(func(d *entity.TokenDetails) bool {
+			return d.UserID == 1 &&
+				d.Username == "alice" &&
+				d.Email == "alice@example.com" &&
+				assert.ObjectsAreEqual([]string{"user", "admin"}, d.Roles) &&
+				d.ExpiresAt.After(time.Now())
+		})).Return(nil).Once()
+
+		token, err := usecase.Login(context.Background(), &entity.LoginRequest{Email: user.Email, Password: "secret"})
+		assert.NoError(t, err)
+		assert.False(t, token.MFARequired)
+		assert.NotEmpty(t, token.AccessToken)
+		assert.Equal(t, 3600, token.ExpiresIn)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("invalid credentials", func(t *testing.T) {
+		mockRepo := new(MockAuthRepository)
+		allowLogins(mockRepo)
+		server := newUserService(t, http.StatusUnauthorized, nil)
+		usecase := NewAuthUsecase(mockRepo, nil, new(MockMailer), time.Hour, server.URL, "")
+
+		token, err := usecase.Login(context.Background(), &entity.LoginRequest{Email: user.Email, Password: "wrong"})
+		assert.Error(t, err)
+		assert.Nil(t, token)
+		mockRepo.AssertNotCalled(t, "StoreToken", mock.Anything, mock.Anything)
+	})
+
+	t.Run("user-service calls carry the request IDs", func(t *testing.T) {
+		mockRepo := new(MockAuthRepository)
+		allowLogins(mockRepo)
+		mockRepo.On("StoreToken", mock.Anything, mock.Anything).Return(nil)
+
+		var requestIDs []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestIDs = append(requestIDs, r.Header.Get(correlation.HeaderRequestID))
+			assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", r.Header.Get(correlation.HeaderTraceparent))
+			if strings.HasSuffix(r.URL.Path, "/mfa") {
+				json.NewEncoder(w).Encode(&entity.MFASettings{})
+				return
+			}
+			json.NewEncoder(w).Encode(user)
+		}))
+		t.Cleanup(server.Close)
+		usecase := NewAuthUsecase(mockRepo, nil, new(MockMailer), time.Hour, server.URL, "")
+
+		ctx := correlation.NewContext(context.Background(), correlation.IDs{
+			RequestID: "req-1",
+			TraceID:   "4bf92f3577b34da6a3ce929d0e0e4736",
+			SpanID:    "00f067aa0ba902b7",
+			Flags:     "01",
+		})
+		_, err := usecase.Login(ctx, &entity.LoginRequest{Email: user.Email, Password: "secret"})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"req-1", "req-1"}, requestIDs)
+	})
+}
+
+func TestAuthUsecase_Register(t *testing.T) {
+	user := &entity.User{ID: 2, Username: "bob", Email: "bob@example.com", Roles: []string{"user"}}
+	mockRepo := new(MockAuthRepository)
+	mockMailer := new(MockMailer)
+	server := newUserService(t, http.StatusCreated, user)
+	usecase := NewAuthUsecase(mockRepo, nil, mockMailer, time.Hour, server.URL, "http://localhost:8080")
+
+	mockRepo.On("StoreToken", mock.AnythingOfType("string"), mock.MatchedBy(func(d *entity.TokenDetails) bool {
+		return d.UserID == 2 && d.Username == "bob"
+	})).Return(nil).Once()
+	mockRepo.On("StoreOneTimeToken", mock.AnythingOfType("string"), mock.MatchedBy(func(d *entity.OneTimeToken) bool {
+		return d.UserID == 2 && d.Purpose == PurposeEmailVerification
+	})).Return(nil).Once()
+	// A failed delivery does not fail the registration
+	mockMailer.On("Send", mock.MatchedBy(func(msg *mailer.Message) bool {
+		return msg.To == "bob@example.com" && strings.Contains(msg.Body, "http://localhost:8080/verify-email?token=")
+	})).Return(errors.New("smtp down")).Once()
+
+	token, err := usecase.Register(context.Background(), &entity.RegisterRequest{Username: "bob", Email: user.Email, Password: "secret"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer", token.TokenType)
+	mockRepo.AssertExpectations(t)
+	mockMailer.AssertExpectations(t)
+}
+
+func TestAuthUsecase_Token(t *testing.T) {
+	secretHash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	assert.NoError(t, err)
+
+	client := &entity.OAuthClient{
+		ID:         "payment-service",
+		SecretHash: string(secretHash),
+		Scopes:     []string{"products:read", "users:read"},
+		GrantTypes: []string{GrantTypeClientCredentials},
+	}
+	user := &entity.User{ID: 3, Username: "carol", Email: "carol@example.com", Roles: []string{"user"}}
+
+	mockClients := new(MockClientRepository)
+	mockClients.On("GetClient", "payment-service").Return(client, nil)
+	mockClients.On("GetClient", "web").Return(&entity.OAuthClient{
+		ID:         "web",
+		SecretHash: string(secretHash),
+		Scopes:     []string{"profile"},
+	}, nil)
+	mockClients.On("GetClient", "unknown").Return(nil, errors.New("client not found"))
+
+	server := newUserService(t, http.StatusOK, user)
+
+	assertOAuthError := func(t *testing.T, err error, code string) {
+		t.Helper()
+		var oauthErr *auth.OAuthError
+		if assert.ErrorAs(t, err, &oauthErr) {
+			assert.Equal(t, code, oauthErr.Code)
+		}
+	}
+
+	t.Run("client credentials with all client scopes", func(t *testing.T) {
+		mockRepo := new(MockAuthRepository)
+		usecase := NewAuthUsecase(mockRepo, mockClients, new(MockMailer), time.Hour, server.URL, "")
+
+		mockRepo.On("StoreToken", mock.AnythingOfType("string"), mock.MatchedBy(func(d *entity.TokenDetails) bool {
+			return d.UserID == 0 && d.ClientID == "payment-service" && len(d.Scopes) == 2
+		})).Return(nil).Once()
+
+		token, err := usecase.Token(context.Background(), &entity.TokenRequest{
+			GrantType:    GrantTypeClientCredentials,
+			ClientID:     "payment-service",
+			ClientSecret: "s3cret",
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "products:read users:read", token.Scope)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("client credentials with narrowed scope", func(t *testing.T) {
+		mockRepo := new(MockAuthRepository)
+		usecase := NewAuthUsecase(mockRepo, mockClients, new(MockMailer), time.Hour, server.URL, "")
+
+		mockRepo.On("StoreToken", mock.AnythingOfType("string"), mock.Anything).Return(nil).Once()
+
+		token, err := usecase.Token(context.Background(), &entity.TokenRequest{
+			GrantType:    GrantTypeClientCredentials,
+			ClientID:     "payment-service",
+			ClientSecret: "s3cret",
+			Scope:        "products:read",
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "products:read", token.Scope)
+	})
+
+	t.Run("password grant carries the user", func(t *testing.T) {
+		mockRepo := new(MockAuthRepository)
+		allowLogins(mockRepo)
+		usecase := NewAuthUsecase(mockRepo, mockClients, new(MockMailer), time.Hour, server.URL, "")
+
+		mockRepo.On("StoreToken", mock.AnythingOfType("string"), mock.MatchedBy(func(d *entity.TokenDetails) bool {
+			return d.UserID == 3 && d.ClientID == "web" && d.Username == "carol"
+		})).Return(nil).Once()
+
+		_, err := usecase.Token(context.Background(), &entity.TokenRequest{
+			GrantType:    GrantTypePassword,
+			ClientID:     "web",
+			ClientSecret: "s3cret",
+			Username:     "carol@example.com",
+			Password:     "password",
+		})
+		assert.NoError(t, err)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("password grant is refused to users with mfa", func(t *testing.T) {
+		mfaUser := &entity.User{ID: 5, Username: "erin", Email: "erin@example.com"}
+		mfaServer := newMFAUserService(t, mfaUser, &entity.MFASettings{TOTPSecret: "secret", TOTPEnabled: true})
+
+		mockRepo := new(MockAuthRepository)
+		allowLogins(mockRepo)
+		usecase := NewAuthUsecase(mockRepo, mockClients, new(MockMailer), time.Hour, mfaServer.URL, "")
+
+		token, err := usecase.Token(context.Background(), &entity.TokenRequest{
+			GrantType:    GrantTypePassword,
+			ClientID:     "web",
+			ClientSecret: "s3cret",
+			Username:     "erin@example.com",
+			Password:     "password",
+		})
+		assert.Nil(t, token)
+		assertOAuthError(t, err, auth.ErrCodeInvalidGrant)
+		mockRepo.AssertNotCalled(t, "StoreToken", mock.Anything, mock.Anything)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		usecase := NewAuthUsecase(new(MockAuthRepository), mockClients, new(MockMailer), time.Hour, server.URL, "")
+
+		tests := []struct {
+			name    string
+			request entity.TokenRequest
+			code    string
+		}{
+			{"unknown client", entity.TokenRequest{GrantType: GrantTypeClientCredentials, ClientID: "unknown", ClientSecret: "s3cret"}, auth.ErrCodeInvalidClient},
+			{"wrong secret", entity.TokenRequest{GrantType: GrantTypeClientCredentials, ClientID: "payment-service", ClientSecret: "nope"}, auth.ErrCodeInvalidClient},
+			{"missing grant type", entity.TokenRequest{ClientID: "payment-service", ClientSecret: "s3cret"}, auth.ErrCodeInvalidRequest},
+			{"unsupported grant type", entity.TokenRequest{GrantType: "implicit", ClientID: "payment-service", ClientSecret: "s3cret"}, auth.ErrCodeUnsupportedGrantType},
+			{"grant not allowed for client", entity.TokenRequest{GrantType: GrantTypePassword, ClientID: "payment-service", ClientSecret: "s3cret", Username: "u", Password: "p"}, auth.ErrCodeUnauthorizedClient},
+			{"scope not allowed", entity.TokenRequest{GrantType: GrantTypeClientCredentials, ClientID: "payment-service", ClientSecret: "s3cret", Scope: "payments:write"}, auth.ErrCodeInvalidScope},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				token, err := usecase.Token(context.Background(), &tt.request)
+				assert.Nil(t, token)
+				assertOAuthError(t, err, tt.code)
+			})
+		}
+	})
+}
+
+func TestAuthUsecase_Introspect(t *testing.T) {
+	secretHash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	assert.NoError(t, err)
+
+	mockClients := new(MockClientRepository)
+	mockClients.On("GetClient", "api-gateway").Return(&entity.OAuthClient{ID: "api-gateway", SecretHash: string(secretHash)}, nil)
+	mockClients.On("GetClient", "unknown").Return(nil, errors.New("client not found"))
+
+	mockRepo := new(MockAuthRepository)
+	expiresAt := time.Now().Add(time.Hour)
+	mockRepo.On("GetToken", "valid").Return(&entity.TokenDetails{UserID: 3, Roles: []string{"user"}, Scopes: []string{"profile"}, ExpiresAt: expiresAt}, nil)
+	mockRepo.On("GetToken", "expired").Return(nil, errors.New("token not found"))
+
+	usecase := NewAuthUsecase(mockRepo, mockClients, new(MockMailer), time.Hour, "", "")
+
+	t.Run("active token", func(t *testing.T) {
+		response, err := usecase.Introspect(context.Background(), &entity.IntrospectionRequest{ClientID: "api-gateway", ClientSecret: "s3cret", Token: "valid"})
+		assert.NoError(t, err)
+		assert.True(t, response.Active)
+		assert.Equal(t, 3, response.UserID)
+		assert.Equal(t, "profile", response.Scope)
+		assert.Equal(t, expiresAt.Unix(), response.ExpiresAt)
+	})
+
+	t.Run("unknown token is inactive", func(t *testing.T) {
+		response, err := usecase.Introspect(context.Background(), &entity.IntrospectionRequest{ClientID: "api-gateway", ClientSecret: "s3cret", Token: "expired"})
+		assert.NoError(t, err)
+		assert.Equal(t, &entity.IntrospectionResponse{}, response)
+	})
+
+	t.Run("requires client authentication", func(t *testing.T) {
+		for _, request := range []entity.IntrospectionRequest{
+			{Token: "valid"},
+			{ClientID: "api-gateway", ClientSecret: "nope", Token: "valid"},
+			{ClientID: "unknown", ClientSecret: "s3cret", Token: "valid"},
+		} {
+			response, err := usecase.Introspect(context.Background(), &request)
+			assert.Nil(t, response)
+			var oauthErr *auth.OAuthError
+			if assert.ErrorAs(t, err, &oauthErr) {
+				assert.Equal(t, auth.ErrCodeInvalidClient, oauthErr.Code)
+			}
+		}
+	})
+}
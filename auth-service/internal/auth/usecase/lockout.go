@@ -0,0 +1,138 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gauss2302/microtest/auth-service/internal/auth"
+	"github.com/gauss2302/microtest/auth-service/internal/entity"
+	"github.com/gauss2302/microtest/auth-service/pkg/audit"
+	"github.com/gauss2302/microtest/auth-service/pkg/clientip"
+)
+
+// Brute-force protection. Failed logins are counted per account and per
+// client address within loginFailureWindow. Once a counter reaches its
+// threshold every further failure locks the subject out, starting at
+// lockoutBase and doubling up to lockoutMax.
+const (
+	loginFailureWindow    = time.Hour
+	maxAccountFailures    = 5
+	maxClientFailures     = 20
+	lockoutBase           = time.Minute
+	lockoutMax            = time.Hour
+	accountSubjectPrefix  = "email:"
+	clientIPSubjectPrefix = "ip:"
+)
+
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// authenticate verifies a password login on behalf of clientIP, enforcing
+// the lockout policy. Only rejected credentials count as failures; an
+// unreachable user-service does not.
+func (u *AuthUsecase) authenticate(ctx context.Context, email, password, clientIP string) (*entity.User, error) {
+	subjects := loginSubjects(email, clientIP)
+
+	if err := u.checkLockout(ctx, subjects); err != nil {
+		audit.Record(audit.Event{Type: audit.LoginRejected, Email: email, ClientIP: clientIP, Detail: err.Error()})
+		return nil, err
+	}
+
+	user, err := u.verifyCredentials(ctx, email, password)
+	if errors.Is(err, errInvalidCredentials) {
+		audit.Record(audit.Event{Type: audit.LoginFailed, Email: email, ClientIP: clientIP})
+		u.recordLoginFailure(ctx, subjects, email, clientIP)
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the account counter is reset. A client that owns one account
+	// must not be able to clear its failures against others.
+	if err := u.repo.ResetLoginFailures(ctx, subjects[0].key); err != nil {
+		slog.ErrorContext(ctx, "Failed to reset login failures", "error", err)
+	}
+	audit.Record(audit.Event{Type: audit.LoginSucceeded, UserID: user.ID, Email: email, ClientIP: clientIP})
+
+	return user, nil
+}
+
+type loginSubject struct {
+	key       string
+	threshold int
+}
+
+func loginSubjects(email, clientIP string) []loginSubject {
+	subjects := []loginSubject{{
+		key:       accountSubjectPrefix + strings.ToLower(strings.TrimSpace(email)),
+		threshold: maxAccountFailures,
+	}}
+	if ip := net.ParseIP(clientIP); ip != nil {
+		subjects = append(subjects, loginSubject{
+			key:       clientIPSubjectPrefix + clientip.Key(ip),
+			threshold: maxClientFailures,
+		})
+	}
+	return subjects
+}
+
+// checkLockout returns a *auth.LockoutError for the longest lock among
+// subjects. Lookup errors fail open so a cache outage does not block every
+// login.
+func (u *AuthUsecase) checkLockout(ctx context.Context, subjects []loginSubject) error {
+	var retryAfter time.Duration
+	for _, subject := range subjects {
+		until, err := u.repo.LoginLockedUntil(ctx, subject.key)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to check login lockout", "error", err)
+			continue
+		}
+		if wait := time.Until(until); wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+
+	if retryAfter > 0 {
+		return &auth.LockoutError{RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+func (u *AuthUsecase) recordLoginFailure(ctx context.Context, subjects []loginSubject, email, clientIP string) {
+	for _, subject := range subjects {
+		failures, err := u.repo.RecordLoginFailure(ctx, subject.key, loginFailureWindow)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to record login failure", "error", err)
+			continue
+		}
+		if failures < subject.threshold {
+			continue
+		}
+
+		duration := lockoutDuration(failures - subject.threshold)
+		if err := u.repo.LockLogin(ctx, subject.key, time.Now().Add(duration)); err != nil {
+			slog.ErrorContext(ctx, "Failed to lock login", "error", err)
+			continue
+		}
+		audit.Record(audit.Event{
+			Type:     audit.LoginLocked,
+			Email:    email,
+			ClientIP: clientIP,
+			Detail:   fmt.Sprintf("%s locked for %s after %d failures", strings.SplitN(subject.key, ":", 2)[0], duration, failures),
+		})
+	}
+}
+
+// lockoutDuration returns the lock for the nth failure past the threshold.
+func lockoutDuration(n int) time.Duration {
+	duration := lockoutBase
+	for i := 0; i < n && duration < lockoutMax; i++ {
+		duration *= 2
+	}
+	return min(duration, lockoutMax)
+}
@@ -0,0 +1,122 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gauss2302/microtest/auth-service/internal/auth"
+	"github.com/gauss2302/microtest/auth-service/internal/entity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// lockoutRepository keeps the lockout bookkeeping in memory and leaves
+// everything else to the mock.
+type lockoutRepository struct {
+	*MockAuthRepository
+	failures map[string]int
+	locks    map[string]time.Time
+}
+
+func newLockoutRepository() *lockoutRepository {
+	return &lockoutRepository{
+		MockAuthRepository: new(MockAuthRepository),
+		failures:           make(map[string]int),
+		locks:              make(map[string]time.Time),
+	}
+}
+
+func (r *lockoutRepository) RecordLoginFailure(ctx context.Context, subject string, window time.Duration) (int, error) {
+	r.failures[subject]++
+	return r.failures[subject], nil
+}
+
+func (r *lockoutRepository) ResetLoginFailures(ctx context.Context, subject string) error {
+	delete(r.failures, subject)
+	return nil
+}
+
+func (r *lockoutRepository) LockLogin(ctx context.Context, subject string, until time.Time) error {
+	r.locks[subject] = until
+	return nil
+}
+
+func (r *lockoutRepository) LoginLockedUntil(ctx context.Context, subject string) (time.Time, error) {
+	return r.locks[subject], nil
+}
+
+func TestAuthUsecase_LoginLockout(t *testing.T) {
+	t.Run("account is locked after repeated failures", func(t *testing.T) {
+		repo := newLockoutRepository()
+		server := newUserService(t, http.StatusUnauthorized, nil)
+		usecase := NewAuthUsecase(repo, nil, new(MockMailer), time.Hour, server.URL, "")
+
+		request := &entity.LoginRequest{Email: "Alice@example.com", Password: "wrong", ClientIP: "10.0.0.1"}
+		for i := 0; i < maxAccountFailures-1; i++ {
+			_, err := usecase.Login(context.Background(), request)
+			var lockout *auth.LockoutError
+			assert.False(t, errors.As(err, &lockout))
+		}
+
+		// The threshold failure itself is reported as bad credentials
+		_, err := usecase.Login(context.Background(), request)
+		var lockout *auth.LockoutError
+		assert.False(t, errors.As(err, &lockout))
+		assert.Contains(t, repo.locks, "email:alice@example.com")
+
+		_, err = usecase.Login(context.Background(), &entity.LoginRequest{Email: "alice@example.com", Password: "right", ClientIP: "10.0.0.2"})
+		if assert.ErrorAs(t, err, &lockout) {
+			assert.InDelta(t, lockoutBase.Seconds(), lockout.RetryAfter.Seconds(), 1)
+		}
+	})
+
+	t.Run("client address is locked across accounts", func(t *testing.T) {
+		repo := newLockoutRepository()
+		server := newUserService(t, http.StatusUnauthorized, nil)
+		usecase := NewAuthUsecase(repo, nil, new(MockMailer), time.Hour, server.URL, "")
+
+		for i := 0; i < maxClientFailures; i++ {
+			usecase.Login(context.Background(), &entity.LoginRequest{Email: fmt.Sprintf("user%d@example.com", i), Password: "wrong", ClientIP: "10.0.0.1"})
+		}
+		assert.Contains(t, repo.locks, "ip:10.0.0.1")
+
+		_, err := usecase.Login(context.Background(), &entity.LoginRequest{Email: "new@example.com", Password: "wrong", ClientIP: "10.0.0.1"})
+		var lockout *auth.LockoutError
+		assert.ErrorAs(t, err, &lockout)
+	})
+
+	t.Run("success resets the account counter only", func(t *testing.T) {
+		repo := newLockoutRepository()
+		repo.failures["email:alice@example.com"] = 3
+		repo.failures["ip:10.0.0.1"] = 3
+		repo.On("StoreToken", mock.Anything, mock.Anything).Return(nil)
+		server := newUserService(t, http.StatusOK, &entity.User{ID: 1, Email: "alice@example.com"})
+		usecase := NewAuthUsecase(repo, nil, new(MockMailer), time.Hour, server.URL, "")
+
+		_, err := usecase.Login(context.Background(), &entity.LoginRequest{Email: "alice@example.com", Password: "right", ClientIP: "10.0.0.1"})
+		assert.NoError(t, err)
+		assert.NotContains(t, repo.failures, "email:alice@example.com")
+		assert.Equal(t, 3, repo.failures["ip:10.0.0.1"])
+	})
+
+	t.Run("user-service errors do not count", func(t *testing.T) {
+		repo := newLockoutRepository()
+		server := newUserService(t, http.StatusInternalServerError, nil)
+		usecase := NewAuthUsecase(repo, nil, new(MockMailer), time.Hour, server.URL, "")
+
+		_, err := usecase.Login(context.Background(), &entity.LoginRequest{Email: "alice@example.com", Password: "right"})
+		assert.Error(t, err)
+		assert.Empty(t, repo.failures)
+	})
+}
+
+func TestLockoutDuration(t *testing.T) {
+	assert.Equal(t, lockoutBase, lockoutDuration(0))
+	assert.Equal(t, 2*lockoutBase, lockoutDuration(1))
+	assert.Equal(t, 8*lockoutBase, lockoutDuration(3))
+	assert.Equal(t, lockoutMax, lockoutDuration(100))
+}
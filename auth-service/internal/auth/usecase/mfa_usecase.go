@@ -0,0 +1,273 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gauss2302/microtest/auth-service/internal/entity"
+	"github.com/gauss2302/microtest/auth-service/pkg/audit"
+	"github.com/gauss2302/microtest/auth-service/pkg/totp"
+)
+
+const (
+	// Shown as the account issuer in authenticator apps
+	totpIssuer = "Buymania"
+
+	mfaChallengeExpiration = 5 * time.Minute
+	maxMFAAttempts         = 5
+	recoveryCodeCount      = 10
+)
+
+// Recovery codes avoid characters that are easy to confuse when typed. The
+// alphabet has 32 characters so that random bytes map onto it uniformly.
+const recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz023456789"
+
+// LoginMFA completes a login started by Login. A challenge is discarded
+// after maxMFAAttempts wrong codes, and wrong codes count toward the same
+// lockout as wrong passwords, so that a known password does not buy
+// unlimited guesses at the second factor.
+func (u *AuthUsecase) LoginMFA(ctx context.Context, request *entity.MFALoginRequest) (*entity.TokenResponse, error) {
+	challenge, err := u.repo.GetMFAChallenge(ctx, request.MFAToken)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mfa token")
+	}
+	userID := challenge.Details.UserID
+	email := challenge.Details.Email
+
+	subjects := loginSubjects(email, request.ClientIP)
+	if err := u.checkLockout(ctx, subjects); err != nil {
+		audit.Record(audit.Event{Type: audit.LoginRejected, UserID: userID, Email: email, ClientIP: request.ClientIP, Detail: err.Error()})
+		return nil, err
+	}
+
+	settings, err := u.getMFASettings(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load mfa settings: %w", err)
+	}
+
+	var valid bool
+	switch {
+	case request.Code != "":
+		step, ok := totp.Validate(settings.TOTPSecret, request.Code, time.Now())
+		valid = ok && settings.TOTPEnabled && u.repo.UseTOTPStep(ctx, userID, step) == nil
+	case request.RecoveryCode != "":
+		valid, err = u.redeemRecoveryCode(ctx, userID, settings, request.RecoveryCode)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("code or recovery_code is required")
+	}
+
+	if !valid {
+		audit.Record(audit.Event{Type: audit.LoginFailed, UserID: userID, Email: email, ClientIP: request.ClientIP, Detail: "invalid mfa code"})
+		u.recordLoginFailure(ctx, subjects, email, request.ClientIP)
+
+		// A challenge whose failures cannot be counted is not kept either
+		attempts, err := u.repo.RecordMFAFailure(ctx, request.MFAToken, challenge.ExpiresAt)
+		if err != nil || attempts >= maxMFAAttempts {
+			if err := u.repo.DeleteMFAChallenge(ctx, request.MFAToken); err != nil {
+				return nil, fmt.Errorf("failed to delete mfa challenge: %w", err)
+			}
+		}
+		return nil, fmt.Errorf("invalid code")
+	}
+
+	if err := u.repo.DeleteMFAChallenge(ctx, request.MFAToken); err != nil {
+		return nil, fmt.Errorf("failed to delete mfa challenge: %w", err)
+	}
+
+	return u.issueToken(ctx, &challenge.Details)
+}
+
+// EnrollTOTP creates a new TOTP secret for the token's user. The secret is
+// inactive until confirmed with VerifyTOTP.
+func (u *AuthUsecase) EnrollTOTP(ctx context.Context, token string) (*entity.TOTPEnrollment, error) {
+	details, err := u.userFromToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := u.getMFASettings(ctx, details.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load mfa settings: %w", err)
+	}
+	if settings.TOTPEnabled {
+		return nil, fmt.Errorf("totp is already enabled")
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate secret: %w", err)
+	}
+
+	settings.TOTPSecret = secret
+	if err := u.saveMFASettings(ctx, details.UserID, settings); err != nil {
+		return nil, fmt.Errorf("failed to save mfa settings: %w", err)
+	}
+
+	account := details.Email
+	if account == "" {
+		account = details.Username
+	}
+
+	return &entity.TOTPEnrollment{
+		Secret: secret,
+		URI:    totp.URI(totpIssuer, account, secret),
+	}, nil
+}
+
+// VerifyTOTP activates a pending enrollment once the user proves they can
+// generate codes, and returns freshly generated recovery codes. The codes
+// are only ever shown here; user-service keeps their hashes.
+func (u *AuthUsecase) VerifyTOTP(ctx context.Context, token, code string) (*entity.RecoveryCodesResponse, error) {
+	details, err := u.userFromToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := u.getMFASettings(ctx, details.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load mfa settings: %w", err)
+	}
+	if settings.TOTPEnabled {
+		return nil, fmt.Errorf("totp is already enabled")
+	}
+	if settings.TOTPSecret == "" {
+		return nil, fmt.Errorf("totp enrollment has not been started")
+	}
+
+	step, ok := totp.Validate(settings.TOTPSecret, code, time.Now())
+	if !ok || u.repo.UseTOTPStep(ctx, details.UserID, step) != nil {
+		return nil, fmt.Errorf("invalid code")
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate recovery codes: %w", err)
+	}
+
+	settings.TOTPEnabled = true
+	settings.RecoveryCodes = hashes
+	if err := u.saveMFASettings(ctx, details.UserID, settings); err != nil {
+		return nil, fmt.Errorf("failed to save mfa settings: %w", err)
+	}
+
+	return &entity.RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+func (u *AuthUsecase) startMFAChallenge(ctx context.Context, details *entity.TokenDetails) (*entity.LoginResponse, error) {
+	token, err := u.generateToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate mfa token: %w", err)
+	}
+
+	err = u.repo.StoreMFAChallenge(ctx, token, &entity.MFAChallenge{
+		Details:   *details,
+		ExpiresAt: time.Now().Add(mfaChallengeExpiration),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store mfa challenge: %w", err)
+	}
+
+	return &entity.LoginResponse{MFARequired: true, MFAToken: token}, nil
+}
+
+// redeemRecoveryCode consumes the code so that it cannot be used twice.
+// user-service removes it with a conditional update, so of concurrent
+// requests redeeming the same code only one succeeds.
+func (u *AuthUsecase) redeemRecoveryCode(ctx context.Context, userID int, settings *entity.MFASettings, code string) (bool, error) {
+	index := slices.IndexFunc(settings.RecoveryCodes, func(hash string) bool {
+		return matchRecoveryCode(hash, code)
+	})
+	if !settings.TOTPEnabled || index < 0 {
+		return false, nil
+	}
+
+	consumed, err := u.consumeRecoveryCode(ctx, userID, settings.RecoveryCodes[index])
+	if err != nil {
+		return false, fmt.Errorf("failed to consume recovery code: %w", err)
+	}
+	return consumed, nil
+}
+
+func (u *AuthUsecase) userFromToken(ctx context.Context, token string) (*entity.TokenDetails, error) {
+	details, err := u.repo.GetToken(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+	if details.UserID == 0 {
+		return nil, fmt.Errorf("token does not belong to a user")
+	}
+	return details, nil
+}
+
+// generateRecoveryCodes returns codes formatted as "xxxxx-xxxxx" along
+// with their hashes.
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		random := make([]byte, 10)
+		if _, err := rand.Read(random); err != nil {
+			return nil, nil, err
+		}
+
+		var code strings.Builder
+		for j, b := range random {
+			if j == 5 {
+				code.WriteByte('-')
+			}
+			code.WriteByte(recoveryCodeAlphabet[int(b)%len(recoveryCodeAlphabet)])
+		}
+
+		codes[i] = code.String()
+		hash, err := hashRecoveryCode(codes[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		hashes[i] = hash
+	}
+
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode returns "<salt>$<digest>" for code, both hex encoded.
+// The random salt keeps a leaked list of hashes from being matched
+// against precomputed digests of all codes.
+func hashRecoveryCode(code string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(salt) + "$" + recoveryCodeDigest(salt, code), nil
+}
+
+// matchRecoveryCode reports whether code is the one hashed in stored.
+func matchRecoveryCode(stored, code string) bool {
+	encodedSalt, digest, ok := strings.Cut(stored, "$")
+	if !ok {
+		return false
+	}
+	salt, err := hex.DecodeString(encodedSalt)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(digest), []byte(recoveryCodeDigest(salt, code))) == 1
+}
+
+func recoveryCodeDigest(salt []byte, code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	hash := sha256.New()
+	hash.Write(salt)
+	hash.Write([]byte(normalized))
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,271 @@
+package usecase
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gauss2302/microtest/auth-service/internal/auth"
+	"github.com/gauss2302/microtest/auth-service/internal/entity"
+	"github.com/gauss2302/microtest/auth-service/pkg/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// newMFAUserService fakes user-service for a single user whose MFA
+// settings are kept in settings.
+func newMFAUserService(t *testing.T, user *entity.User, settings *entity.MFASettings) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/users/verify":
+			json.NewEncoder(w).Encode(user)
+		case r.URL.Path == "/users/5/mfa" && r.Method == http.MethodGet:
+			json.NewEncoder(w).Encode(settings)
+		case r.URL.Path == "/users/5/mfa" && r.Method == http.MethodPut:
+			*settings = entity.MFASettings{}
+			json.NewDecoder(r.Body).Decode(settings)
+			w.WriteHeader(http.StatusNoContent)
+		case strings.HasPrefix(r.URL.Path, "/users/5/mfa/recovery-codes/") && r.Method == http.MethodDelete:
+			hash := strings.TrimPrefix(r.URL.Path, "/users/5/mfa/recovery-codes/")
+			index := slices.Index(settings.RecoveryCodes, hash)
+			if index < 0 {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			settings.RecoveryCodes = slices.Delete(settings.RecoveryCodes, index, index+1)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func currentCode(t *testing.T, secret string) string {
+	t.Helper()
+	code, err := totp.Code(secret, totp.Step(time.Now()))
+	assert.NoError(t, err)
+	return code
+}
+
+func TestAuthUsecase_TOTPEnrollment(t *testing.T) {
+	user := &entity.User{ID: 5, Username: "erin", Email: "erin@example.com"}
+	details := &entity.TokenDetails{UserID: 5, Email: "erin@example.com", ExpiresAt: time.Now().Add(time.Hour)}
+	settings := &entity.MFASettings{}
+	server := newMFAUserService(t, user, settings)
+
+	mockRepo := new(MockAuthRepository)
+	mockRepo.On("GetToken", "access").Return(details, nil)
+	mockRepo.On("UseTOTPStep", 5, mock.Anything).Return(nil)
+	usecase := NewAuthUsecase(mockRepo, nil, new(MockMailer), time.Hour, server.URL, "")
+
+	enrollment, err := usecase.EnrollTOTP(context.Background(), "access")
+	assert.NoError(t, err)
+	assert.Contains(t, enrollment.URI, "otpauth://totp/Buymania:erin@example.com")
+	assert.Equal(t, enrollment.Secret, settings.TOTPSecret)
+	assert.False(t, settings.TOTPEnabled)
+
+	_, err = usecase.VerifyTOTP(context.Background(), "access", "000000")
+	assert.Error(t, err)
+	assert.False(t, settings.TOTPEnabled)
+
+	codes, err := usecase.VerifyTOTP(context.Background(), "access", currentCode(t, settings.TOTPSecret))
+	assert.NoError(t, err)
+	assert.Len(t, codes.RecoveryCodes, recoveryCodeCount)
+	assert.True(t, settings.TOTPEnabled)
+	assert.Len(t, settings.RecoveryCodes, recoveryCodeCount)
+	assert.NotContains(t, settings.RecoveryCodes, codes.RecoveryCodes[0], "recovery codes must be stored hashed")
+
+	_, err = usecase.EnrollTOTP(context.Background(), "access")
+	assert.Error(t, err, "enrolling again requires disabling first")
+}
+
+func TestAuthUsecase_LoginWithMFA(t *testing.T) {
+	secret, err := totp.GenerateSecret()
+	assert.NoError(t, err)
+	_, hashes, err := generateRecoveryCodes()
+	assert.NoError(t, err)
+
+	user := &entity.User{ID: 5, Username: "erin", Email: "erin@example.com", Roles: []string{"admin"}}
+	newUsecase := func(t *testing.T, mockRepo *MockAuthRepository) (*AuthUsecase, *entity.MFASettings) {
+		settings := &entity.MFASettings{TOTPSecret: secret, TOTPEnabled: true, RecoveryCodes: hashes}
+		server := newMFAUserService(t, user, settings)
+		return NewAuthUsecase(mockRepo, nil, new(MockMailer), time.Hour, server.URL, ""), settings
+	}
+
+	t.Run("password step returns a challenge", func(t *testing.T) {
+		mockRepo := new(MockAuthRepository)
+		allowLogins(mockRepo)
+		usecase, _ := newUsecase(t, mockRepo)
+
+		mockRepo.On("StoreMFAChallenge", mock.AnythingOfType("string"), mock.MatchedBy(func(c *entity.MFAChallenge) bool {
+			return c.Details.UserID == 5 && c.Details.Username == "erin"
+		})).Return(nil).Once()
+
+		response, err := usecase.Login(context.Background(), &entity.LoginRequest{Email: user.Email, Password: "secret"})
+		assert.NoError(t, err)
+		assert.True(t, response.MFARequired)
+		assert.NotEmpty(t, response.MFAToken)
+		assert.Nil(t, response.TokenResponse)
+		mockRepo.AssertNotCalled(t, "StoreToken", mock.Anything, mock.Anything)
+	})
+
+	t.Run("totp code issues the token", func(t *testing.T) {
+		mockRepo := new(MockAuthRepository)
+		allowLogins(mockRepo)
+		usecase, _ := newUsecase(t, mockRepo)
+		challenge := &entity.MFAChallenge{Details: entity.TokenDetails{UserID: 5, Username: "erin"}}
+
+		mockRepo.On("GetMFAChallenge", "challenge").Return(challenge, nil).Once()
+		mockRepo.On("UseTOTPStep", 5, mock.AnythingOfType("int64")).Return(nil).Once()
+		mockRepo.On("DeleteMFAChallenge", "challenge").Return(nil).Once()
+		mockRepo.On("StoreToken", mock.AnythingOfType("string"), mock.MatchedBy(func(d *entity.TokenDetails) bool {
+			return d.UserID == 5
+		})).Return(nil).Once()
+
+		token, err := usecase.LoginMFA(context.Background(), &entity.MFALoginRequest{MFAToken: "challenge", Code: currentCode(t, secret)})
+		assert.NoError(t, err)
+		assert.NotEmpty(t, token.AccessToken)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("replayed totp code", func(t *testing.T) {
+		mockRepo := new(MockAuthRepository)
+		allowLogins(mockRepo)
+		usecase, _ := newUsecase(t, mockRepo)
+		challenge := &entity.MFAChallenge{Details: entity.TokenDetails{UserID: 5}}
+
+		mockRepo.On("GetMFAChallenge", "challenge").Return(challenge, nil).Once()
+		mockRepo.On("UseTOTPStep", 5, mock.Anything).Return(errors.New("code was already used")).Once()
+		mockRepo.On("RecordMFAFailure", "challenge", mock.Anything).Return(1, nil).Once()
+
+		token, err := usecase.LoginMFA(context.Background(), &entity.MFALoginRequest{MFAToken: "challenge", Code: currentCode(t, secret)})
+		assert.Error(t, err)
+		assert.Nil(t, token)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("recovery code is single use", func(t *testing.T) {
+		codes, codeHashes, err := generateRecoveryCodes()
+		assert.NoError(t, err)
+
+		mockRepo := new(MockAuthRepository)
+		allowLogins(mockRepo)
+		usecase, settings := newUsecase(t, mockRepo)
+		settings.RecoveryCodes = codeHashes
+
+		mockRepo.On("GetMFAChallenge", "challenge").Return(&entity.MFAChallenge{Details: entity.TokenDetails{UserID: 5}}, nil).Twice()
+		mockRepo.On("DeleteMFAChallenge", "challenge").Return(nil).Once()
+		mockRepo.On("StoreToken", mock.AnythingOfType("string"), mock.Anything).Return(nil).Once()
+		mockRepo.On("RecordMFAFailure", "challenge", mock.Anything).Return(1, nil).Once()
+
+		_, err = usecase.LoginMFA(context.Background(), &entity.MFALoginRequest{MFAToken: "challenge", RecoveryCode: codes[3]})
+		assert.NoError(t, err)
+		assert.Len(t, settings.RecoveryCodes, recoveryCodeCount-1)
+
+		_, err = usecase.LoginMFA(context.Background(), &entity.MFALoginRequest{MFAToken: "challenge", RecoveryCode: codes[3]})
+		assert.Error(t, err)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("recovery code consumed by a concurrent request", func(t *testing.T) {
+		codes, codeHashes, err := generateRecoveryCodes()
+		assert.NoError(t, err)
+
+		// The settings were read just before another request consumed
+		// the code
+		var consumeCalls int
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.URL.Path == "/users/5/mfa" && r.Method == http.MethodGet:
+				json.NewEncoder(w).Encode(&entity.MFASettings{TOTPSecret: secret, TOTPEnabled: true, RecoveryCodes: codeHashes})
+			case strings.HasPrefix(r.URL.Path, "/users/5/mfa/recovery-codes/") && r.Method == http.MethodDelete:
+				consumeCalls++
+				w.WriteHeader(http.StatusNotFound)
+			default:
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}))
+		t.Cleanup(server.Close)
+
+		mockRepo := new(MockAuthRepository)
+		allowLogins(mockRepo)
+		usecase := NewAuthUsecase(mockRepo, nil, new(MockMailer), time.Hour, server.URL, "")
+		mockRepo.On("GetMFAChallenge", "challenge").Return(&entity.MFAChallenge{Details: entity.TokenDetails{UserID: 5}}, nil).Once()
+		mockRepo.On("RecordMFAFailure", "challenge", mock.Anything).Return(1, nil).Once()
+
+		_, err = usecase.LoginMFA(context.Background(), &entity.MFALoginRequest{MFAToken: "challenge", RecoveryCode: codes[0]})
+		assert.Error(t, err)
+		assert.Equal(t, 1, consumeCalls)
+		mockRepo.AssertNotCalled(t, "StoreToken", mock.Anything, mock.Anything)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("challenge is dropped after too many attempts", func(t *testing.T) {
+		mockRepo := new(MockAuthRepository)
+		allowLogins(mockRepo)
+		usecase, _ := newUsecase(t, mockRepo)
+		challenge := &entity.MFAChallenge{Details: entity.TokenDetails{UserID: 5}}
+
+		mockRepo.On("GetMFAChallenge", "challenge").Return(challenge, nil).Once()
+		mockRepo.On("RecordMFAFailure", "challenge", mock.Anything).Return(maxMFAAttempts, nil).Once()
+		mockRepo.On("DeleteMFAChallenge", "challenge").Return(nil).Once()
+
+		_, err := usecase.LoginMFA(context.Background(), &entity.MFALoginRequest{MFAToken: "challenge", Code: "000000"})
+		assert.Error(t, err)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("wrong codes count toward the lockout", func(t *testing.T) {
+		mockRepo := new(MockAuthRepository)
+		usecase, _ := newUsecase(t, mockRepo)
+		challenge := &entity.MFAChallenge{Details: entity.TokenDetails{UserID: 5, Email: "erin@example.com"}}
+
+		mockRepo.On("GetMFAChallenge", "challenge").Return(challenge, nil)
+		mockRepo.On("LoginLockedUntil", mock.Anything).Return(time.Time{}, nil).Once()
+		mockRepo.On("LoginLockedUntil", mock.Anything).Return(time.Time{}, nil).Once()
+		mockRepo.On("RecordLoginFailure", "email:erin@example.com", loginFailureWindow).Return(1, nil).Once()
+		mockRepo.On("RecordLoginFailure", "ip:10.0.0.1", loginFailureWindow).Return(1, nil).Once()
+		mockRepo.On("RecordMFAFailure", "challenge", mock.Anything).Return(1, nil).Once()
+
+		request := &entity.MFALoginRequest{MFAToken: "challenge", Code: "000000", ClientIP: "10.0.0.1"}
+		_, err := usecase.LoginMFA(context.Background(), request)
+		assert.Error(t, err)
+
+		// Once locked, not even the right code is checked
+		mockRepo.On("LoginLockedUntil", "email:erin@example.com").Return(time.Now().Add(time.Minute), nil)
+		mockRepo.On("LoginLockedUntil", mock.Anything).Return(time.Time{}, nil)
+		request.Code = currentCode(t, secret)
+		_, err = usecase.LoginMFA(context.Background(), request)
+		var lockout *auth.LockoutError
+		assert.ErrorAs(t, err, &lockout)
+		mockRepo.AssertNotCalled(t, "StoreToken", mock.Anything, mock.Anything)
+		mockRepo.AssertNotCalled(t, "UseTOTPStep", mock.Anything, mock.Anything)
+	})
+}
+
+func TestMatchRecoveryCode(t *testing.T) {
+	hash, err := hashRecoveryCode("abcde-fghjk")
+	assert.NoError(t, err)
+	assert.True(t, matchRecoveryCode(hash, " ABCDEFGHJK "))
+	assert.False(t, matchRecoveryCode(hash, "abcde-fghjm"))
+
+	again, err := hashRecoveryCode("abcde-fghjk")
+	assert.NoError(t, err)
+	assert.NotEqual(t, hash, again, "hashes must be salted")
+
+	// Unsalted digests are not accepted
+	unsalted := sha256.Sum256([]byte("abcdefghjk"))
+	assert.False(t, matchRecoveryCode(hex.EncodeToString(unsalted[:]), "abcde-fghjk"))
+}
@@ -0,0 +1,207 @@
+package usecase
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gauss2302/microtest/auth-service/internal/auth"
+	"github.com/gauss2302/microtest/auth-service/internal/entity"
+	"golang.org/x/oauth2"
+)
+
+// How long a user has to complete the login at the provider
+const oidcStateExpiration = 10 * time.Minute
+
+// OIDCUsecase signs users in through external OpenID Connect providers
+// using the authorization code flow with PKCE, state and nonce.
+type OIDCUsecase struct {
+	repo      auth.StateRepository
+	tokens    *AuthUsecase
+	providers map[string]*oidcProvider
+}
+
+// oidcProvider runs discovery on first use so that an unreachable provider
+// does not keep the service from starting.
+type oidcProvider struct {
+	config entity.OIDCProvider
+
+	mutex    sync.Mutex
+	oauth2   *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+func NewOIDCUsecase(repo auth.StateRepository, tokens *AuthUsecase, providers []entity.OIDCProvider) *OIDCUsecase {
+	u := &OIDCUsecase{
+		repo:      repo,
+		tokens:    tokens,
+		providers: make(map[string]*oidcProvider, len(providers)),
+	}
+	for _, provider := range providers {
+		u.providers[provider.Name] = &oidcProvider{config: provider}
+	}
+	return u
+}
+
+// StartLogin returns the provider URL the user agent is redirected to,
+// along with the binding it must present on the callback. The binding
+// keeps an attacker from completing a login they started in a victim's
+// browser.
+func (u *OIDCUsecase) StartLogin(ctx context.Context, providerName string) (*entity.OIDCLogin, error) {
+	provider, ok := u.providers[providerName]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q", providerName)
+	}
+
+	config, _, err := provider.discover(u.context(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := u.tokens.generateToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate state: %w", err)
+	}
+	nonce, err := u.tokens.generateToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	binding, err := u.tokens.generateToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate binding: %w", err)
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	expiresAt := time.Now().Add(oidcStateExpiration)
+	err = u.repo.StoreOIDCState(ctx, state, &entity.OIDCState{
+		Provider:     providerName,
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		BindingHash:  hashBinding(binding),
+		ExpiresAt:    expiresAt,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store state: %w", err)
+	}
+
+	return &entity.OIDCLogin{
+		URL:       config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)),
+		Binding:   binding,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// CompleteLogin handles the provider callback: it exchanges the code,
+// verifies the ID token and logs in the linked user, which requires the
+// second factor just like a password login. binding is the value the user
+// agent kept from StartLogin.
+func (u *OIDCUsecase) CompleteLogin(ctx context.Context, providerName, state, binding, code string) (*entity.LoginResponse, error) {
+	provider, ok := u.providers[providerName]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q", providerName)
+	}
+
+	saved, err := u.repo.ConsumeOIDCState(ctx, state)
+	if err != nil {
+		return nil, fmt.Errorf("invalid state: %w", err)
+	}
+	if saved.Provider != providerName {
+		return nil, fmt.Errorf("invalid state: issued for another provider")
+	}
+	if subtle.ConstantTimeCompare([]byte(hashBinding(binding)), []byte(saved.BindingHash)) != 1 {
+		return nil, fmt.Errorf("invalid state: issued to another browser")
+	}
+
+	providerCtx := u.context(ctx)
+	config, verifier, err := provider.discover(providerCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := config.Exchange(providerCtx, code, oauth2.VerifierOption(saved.CodeVerifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange code: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, fmt.Errorf("provider returned no id_token")
+	}
+
+	idToken, err := verifier.Verify(providerCtx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify id_token: %w", err)
+	}
+	if idToken.Nonce != saved.Nonce {
+		return nil, fmt.Errorf("id_token nonce does not match")
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("failed to parse id_token claims: %w", err)
+	}
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, fmt.Errorf("provider did not assert a verified email")
+	}
+
+	user, err := u.tokens.linkIdentity(ctx, &entity.ExternalIdentity{
+		Provider:      providerName,
+		Subject:       idToken.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to link identity: %w", err)
+	}
+
+	return u.tokens.completeLogin(ctx, user)
+}
+
+// context makes the oauth2 and oidc packages use the usecase's HTTP client
+// for requests made on behalf of ctx.
+func (u *OIDCUsecase) context(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, u.tokens.httpClient)
+}
+
+func hashBinding(binding string) string {
+	sum := sha256.Sum256([]byte(binding))
+	return hex.EncodeToString(sum[:])
+}
+
+func (p *oidcProvider) discover(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.oauth2 != nil {
+		return p.oauth2, p.verifier, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, p.config.Issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to discover provider %q: %w", p.config.Name, err)
+	}
+
+	scopes := p.config.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+	}
+
+	p.oauth2 = &oauth2.Config{
+		ClientID:     p.config.ClientID,
+		ClientSecret: p.config.ClientSecret,
+		RedirectURL:  p.config.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       scopes,
+	}
+	p.verifier = provider.Verifier(&oidc.Config{ClientID: p.config.ClientID})
+
+	return p.oauth2, p.verifier, nil
+}
@@ -0,0 +1,296 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gauss2302/microtest/auth-service/internal/entity"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// Fake state repository
+type fakeStateRepository struct {
+	states map[string]*entity.OIDCState
+}
+
+func (r *fakeStateRepository) StoreOIDCState(ctx context.Context, state string, details *entity.OIDCState) error {
+	r.states[state] = details
+	return nil
+}
+
+func (r *fakeStateRepository) ConsumeOIDCState(ctx context.Context, state string) (*entity.OIDCState, error) {
+	details, ok := r.states[state]
+	if !ok {
+		return nil, errors.New("oidc state not found")
+	}
+	delete(r.states, state)
+	return details, nil
+}
+
+// mockIssuer is a minimal OpenID Connect provider. It issues an ID token
+// for the code "auth-code" when the PKCE verifier matches the challenge
+// sent to the authorization endpoint.
+type mockIssuer struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	challenge string
+	nonce     string
+	claims    map[string]any
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	issuer := &mockIssuer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                issuer.URL,
+			"authorization_endpoint":                issuer.URL + "/authorize",
+			"token_endpoint":                        issuer.URL + "/token",
+			"jwks_uri":                              issuer.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if r.PostForm.Get("code") != "auth-code" || base64.RawURLEncoding.EncodeToString(sum[:]) != issuer.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "provider-access-token",
+			"token_type":   "Bearer",
+			"id_token":     issuer.idToken(t),
+		})
+	})
+	issuer.Server = httptest.NewServer(mux)
+	t.Cleanup(issuer.Close)
+
+	return issuer
+}
+
+func (i *mockIssuer) idToken(t *testing.T) string {
+	claims := map[string]any{
+		"iss":            i.URL,
+		"sub":            "external-42",
+		"aud":            "auth-service",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          i.nonce,
+		"email":          "dave@example.com",
+		"email_verified": true,
+	}
+	for k, v := range i.claims {
+		claims[k] = v
+	}
+
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.RS256, Key: i.key},
+		(&jose.SignerOptions{}).WithHeader("kid", "test"),
+	)
+	assert.NoError(t, err)
+
+	payload, _ := json.Marshal(claims)
+	signed, err := signer.Sign(payload)
+	assert.NoError(t, err)
+
+	token, err := signed.CompactSerialize()
+	assert.NoError(t, err)
+	return token
+}
+
+// authorize records what the authorization endpoint would have received.
+func (i *mockIssuer) authorize(t *testing.T, authURL string) (state string) {
+	t.Helper()
+
+	parsed, err := url.Parse(authURL)
+	assert.NoError(t, err)
+	query := parsed.Query()
+
+	assert.Equal(t, i.URL+"/authorize", parsed.Scheme+"://"+parsed.Host+parsed.Path)
+	assert.Equal(t, "S256", query.Get("code_challenge_method"))
+	assert.NotEmpty(t, query.Get("nonce"))
+
+	i.challenge = query.Get("code_challenge")
+	i.nonce = query.Get("nonce")
+	return query.Get("state")
+}
+
+func newOIDCUsecase(t *testing.T, issuer *mockIssuer, repo *MockAuthRepository) (*OIDCUsecase, *fakeStateRepository) {
+	t.Helper()
+	return newOIDCUsecaseWithMFA(t, issuer, repo, &entity.MFASettings{})
+}
+
+func newOIDCUsecaseWithMFA(t *testing.T, issuer *mockIssuer, repo *MockAuthRepository, settings *entity.MFASettings) (*OIDCUsecase, *fakeStateRepository) {
+	t.Helper()
+
+	user := &entity.User{ID: 4, Username: "dave@example.com", Email: "dave@example.com", Roles: []string{"user"}}
+	userService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/users/4/mfa" && r.Method == http.MethodGet {
+			json.NewEncoder(w).Encode(settings)
+			return
+		}
+
+		var identity entity.ExternalIdentity
+		json.NewDecoder(r.Body).Decode(&identity)
+		if r.URL.Path != "/users/identities" || identity.Subject != "external-42" || !identity.EmailVerified {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		json.NewEncoder(w).Encode(user)
+	}))
+	t.Cleanup(userService.Close)
+
+	states := &fakeStateRepository{states: make(map[string]*entity.OIDCState)}
+	tokens := NewAuthUsecase(repo, nil, new(MockMailer), time.Hour, userService.URL, "")
+	usecase := NewOIDCUsecase(states, tokens, []entity.OIDCProvider{{
+		Name:         "mock",
+		Issuer:       issuer.URL,
+		ClientID:     "auth-service",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:8084/auth/oidc/mock/callback",
+	}})
+
+	return usecase, states
+}
+
+func TestOIDCUsecase_Login(t *testing.T) {
+	t.Run("successful login", func(t *testing.T) {
+		issuer := newMockIssuer(t)
+		mockRepo := new(MockAuthRepository)
+		usecase, _ := newOIDCUsecase(t, issuer, mockRepo)
+
+		mockRepo.On("StoreToken", mock.AnythingOfType("string"), mock.MatchedBy(func(d *entity.TokenDetails) bool {
+			return d.UserID == 4 && d.Email == "dave@example.com"
+		})).Return(nil).Once()
+
+		login, err := usecase.StartLogin(context.Background(), "mock")
+		assert.NoError(t, err)
+		state := issuer.authorize(t, login.URL)
+
+		token, err := usecase.CompleteLogin(context.Background(), "mock", state, login.Binding, "auth-code")
+		assert.NoError(t, err)
+		assert.False(t, token.MFARequired)
+		assert.NotEmpty(t, token.AccessToken)
+		mockRepo.AssertExpectations(t)
+
+		// The state is single-use
+		_, err = usecase.CompleteLogin(context.Background(), "mock", state, login.Binding, "auth-code")
+		assert.Error(t, err)
+	})
+
+	t.Run("second factor required", func(t *testing.T) {
+		issuer := newMockIssuer(t)
+		mockRepo := new(MockAuthRepository)
+		usecase, _ := newOIDCUsecaseWithMFA(t, issuer, mockRepo, &entity.MFASettings{TOTPEnabled: true})
+
+		mockRepo.On("StoreMFAChallenge", mock.AnythingOfType("string"), mock.MatchedBy(func(c *entity.MFAChallenge) bool {
+			return c.Details.UserID == 4
+		})).Return(nil).Once()
+
+		login, err := usecase.StartLogin(context.Background(), "mock")
+		assert.NoError(t, err)
+		state := issuer.authorize(t, login.URL)
+
+		response, err := usecase.CompleteLogin(context.Background(), "mock", state, login.Binding, "auth-code")
+		assert.NoError(t, err)
+		assert.True(t, response.MFARequired)
+		assert.NotEmpty(t, response.MFAToken)
+		assert.Nil(t, response.TokenResponse)
+		mockRepo.AssertExpectations(t)
+		mockRepo.AssertNotCalled(t, "StoreToken", mock.Anything, mock.Anything)
+	})
+
+	t.Run("unknown provider", func(t *testing.T) {
+		usecase, _ := newOIDCUsecase(t, newMockIssuer(t), new(MockAuthRepository))
+
+		_, err := usecase.StartLogin(context.Background(), "other")
+		assert.Error(t, err)
+	})
+
+	failures := []struct {
+		name   string
+		claims map[string]any
+		tamper func(issuer *mockIssuer)
+	}{
+		{name: "nonce mismatch", tamper: func(issuer *mockIssuer) { issuer.nonce = "other" }},
+		{name: "unverified email", claims: map[string]any{"email_verified": false}},
+		{name: "wrong audience", claims: map[string]any{"aud": "someone-else"}},
+		{name: "wrong code verifier", tamper: func(issuer *mockIssuer) { issuer.challenge = "other" }},
+	}
+
+	for _, tt := range failures {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newMockIssuer(t)
+			issuer.claims = tt.claims
+			mockRepo := new(MockAuthRepository)
+			usecase, _ := newOIDCUsecase(t, issuer, mockRepo)
+
+			login, err := usecase.StartLogin(context.Background(), "mock")
+			assert.NoError(t, err)
+			state := issuer.authorize(t, login.URL)
+			if tt.tamper != nil {
+				tt.tamper(issuer)
+			}
+
+			token, err := usecase.CompleteLogin(context.Background(), "mock", state, login.Binding, "auth-code")
+			assert.Error(t, err)
+			assert.Nil(t, token)
+			mockRepo.AssertNotCalled(t, "StoreToken", mock.Anything, mock.Anything)
+		})
+	}
+
+	t.Run("state from another browser", func(t *testing.T) {
+		issuer := newMockIssuer(t)
+		mockRepo := new(MockAuthRepository)
+		usecase, _ := newOIDCUsecase(t, issuer, mockRepo)
+
+		// An attacker starts a login and gets the victim's browser to
+		// follow the callback, which carries no binding or another one
+		login, err := usecase.StartLogin(context.Background(), "mock")
+		assert.NoError(t, err)
+		state := issuer.authorize(t, login.URL)
+
+		_, err = usecase.CompleteLogin(context.Background(), "mock", state, "", "auth-code")
+		assert.Error(t, err)
+		mockRepo.AssertNotCalled(t, "StoreToken", mock.Anything, mock.Anything)
+
+		// The attempt used up the state
+		_, err = usecase.CompleteLogin(context.Background(), "mock", state, login.Binding, "auth-code")
+		assert.Error(t, err)
+	})
+
+	t.Run("state from another provider", func(t *testing.T) {
+		issuer := newMockIssuer(t)
+		usecase, states := newOIDCUsecase(t, issuer, new(MockAuthRepository))
+		states.states["forged"] = &entity.OIDCState{Provider: "other", BindingHash: hashBinding("binding"), ExpiresAt: time.Now().Add(time.Minute)}
+
+		_, err := usecase.CompleteLogin(context.Background(), "mock", "forged", "binding", "auth-code")
+		assert.Error(t, err)
+	})
+}
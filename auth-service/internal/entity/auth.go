@@ -5,6 +5,8 @@ import "time"
 type LoginRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	// Set by the handler from the connection, never from the body
+	ClientIP string `json:"-"`
 }
 
 type RegisterRequest struct {
@@ -13,15 +15,186 @@ type RegisterRequest struct {
 	Password string `json:"password"`
 }
 
+// TokenRequest is the body of POST /auth/token (RFC 6749 section 4).
+type TokenRequest struct {
+	GrantType    string
+	ClientID     string
+	ClientSecret string
+	Scope        string
+	Username     string
+	Password     string
+	ClientIP     string
+}
+
+// LoginResponse is returned by Login. For users with MFA enabled it holds
+// only a challenge token to be redeemed at /auth/login/mfa.
+type LoginResponse struct {
+	*TokenResponse
+	MFARequired bool   `json:"mfa_required,omitempty"`
+	MFAToken    string `json:"mfa_token,omitempty"`
+}
+
+// MFALoginRequest completes a login with either a TOTP code or a recovery
+// code.
+type MFALoginRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+	// Set by the handler from the connection, never from the body
+	ClientIP string `json:"-"`
+}
+
 type TokenResponse struct {
 	AccessToken string    `json:"access_token"`
 	TokenType   string    `json:"token_type"`
 	ExpiresIn   int       `json:"expires_in"`
+	Scope       string    `json:"scope,omitempty"`
 	CreatedAt   time.Time `json:"created_at"`
 }
 
+// OAuthClient is a registered machine identity. An empty GrantTypes allows
+// every supported grant.
+type OAuthClient struct {
+	ID         string `json:"client_id"`
+	SecretHash string `json:"secret_hash"`
+	// Secret is hashed when the client is loaded. It is meant to name an
+	// environment variable as "${VAR}" rather than hold the secret.
+	Secret     string   `json:"secret,omitempty"`
+	Scopes     []string `json:"scopes"`
+	GrantTypes []string `json:"grant_types"`
+}
+
+// User is the profile returned by user-service.
+type User struct {
+	ID              int        `json:"id"`
+	Username        string     `json:"username"`
+	Email           string     `json:"email"`
+	Roles           []string   `json:"roles"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+}
+
+// TokenDetails is the record kept for an access token. Tokens issued to a
+// client through the client_credentials grant have no user.
 type TokenDetails struct {
 	UserID    int
 	Username  string
+	Email     string
+	Roles     []string
+	ClientID  string
+	Scopes    []string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// IntrospectionRequest is the body of POST /auth/introspect (RFC 7662
+// section 2.1). The caller authenticates as a registered client.
+type IntrospectionRequest struct {
+	ClientID     string
+	ClientSecret string
+	Token        string
+}
+
+// IntrospectionResponse follows RFC 7662. Only Active is set for tokens
+// that are unknown or expired.
+type IntrospectionResponse struct {
+	Active    bool     `json:"active"`
+	TokenType string   `json:"token_type,omitempty"`
+	UserID    int      `json:"user_id,omitempty"`
+	Username  string   `json:"username,omitempty"`
+	Email     string   `json:"email,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	Scope     string   `json:"scope,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+}
+
+// OIDCProvider configures an external OpenID Connect identity provider.
+type OIDCProvider struct {
+	Name         string   `json:"name"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	RedirectURL  string   `json:"redirect_url"`
+	Scopes       []string `json:"scopes"`
+}
+
+// OIDCState is kept between the start of an OIDC login and its callback.
+type OIDCState struct {
+	Provider     string
+	CodeVerifier string
+	Nonce        string
+	// BindingHash is the SHA-256 of the OIDCLogin binding, which ties the
+	// state to the browser that started the login
+	BindingHash string
+	ExpiresAt   time.Time
+}
+
+// OIDCLogin is a login started at an external provider. The user agent
+// keeps Binding, in a cookie, until the callback.
+type OIDCLogin struct {
+	URL       string
+	Binding   string
 	ExpiresAt time.Time
 }
+
+// ExternalIdentity is an identity asserted by an OIDC provider.
+type ExternalIdentity struct {
+	Provider      string `json:"provider"`
+	Subject       string `json:"subject"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+// MFASettings mirrors the user-service record. RecoveryCodes are salted
+// SHA-256 hashes of the codes handed to the user.
+type MFASettings struct {
+	TOTPSecret    string   `json:"totp_secret"`
+	TOTPEnabled   bool     `json:"totp_enabled"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// MFAChallenge is kept between the password step and the MFA step of a
+// login.
+type MFAChallenge struct {
+	Details   TokenDetails
+	ExpiresAt time.Time
+}
+
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+type TOTPVerifyRequest struct {
+	Code string `json:"code"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// OneTimeToken backs password reset and email verification links.
+type OneTimeToken struct {
+	UserID    int
+	Email     string
+	Purpose   string
+	ExpiresAt time.Time
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// SendVerificationRequest is sent by user-service after an email change.
+type SendVerificationRequest struct {
+	UserID int `json:"user_id"`
+}
@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gauss2302/microtest/auth-service/pkg/clientip"
+)
+
+// ClientIP resolves the client address once per request, so that rate
+// limiting and handlers agree on it. The address only identifies clients
+// when the resolver trusts a proxy.
+func ClientIP(resolver *clientip.Resolver) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := clientip.NewContext(r.Context(), resolver.Resolve(r), resolver.TrustsProxies())
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -1,57 +1,93 @@
 package middleware
 
 import (
+	"context"
+	"log/slog"
+	"math"
 	"net/http"
-	"sync"
-	"time"
-)
+	"slices"
+	"strconv"
+	"strings"
 
-type RateLimiter struct {
-	tokens        float64
-	capacity      float64
-	refillRate    float64
-	lastRefill    time.Time
-	mutex         sync.Mutex
-	perClientRate map[string]float64 // Track rates per IP
-}
+	"github.com/gauss2302/microtest/auth-service/internal/ratelimit"
+	"github.com/gauss2302/microtest/auth-service/pkg/clientip"
+	"github.com/gauss2302/microtest/pkg/metrics"
+	"github.com/gauss2302/microtest/pkg/respond"
+)
 
-func NewRateLimiter(capacity float64, refillRate float64) *RateLimiter {
-	return &RateLimiter{
-		tokens:        capacity,
-		capacity:      capacity,
-		refillRate:    refillRate,
-		lastRefill:    time.Now(),
-		perClientRate: make(map[string]float64),
-	}
+type RateLimitConfig struct {
+	// Global caps all requests together
+	Global ratelimit.Limit
+	// PerClient caps each client on routes without a limit of their own
+	PerClient ratelimit.Limit
+	// Routes holds per-client limits by path, e.g. a stricter one for
+	// /auth/login. Each route is counted separately.
+	Routes map[string]ratelimit.Limit
+	// Exempt paths have no per-client limit. They are called by other
+	// services, such as the gateway introspecting tokens, whose calls all
+	// come from one address on behalf of many users.
+	Exempt []string
 }
 
-func (rl *RateLimiter) refill() {
-	now := time.Now()
-	duration := now.Sub(rl.lastRefill).Seconds()
-	rl.tokens = min(rl.capacity, rl.tokens+(duration*rl.refillRate))
-	rl.lastRefill = now
-}
+// RateLimit enforces config with state kept in store. Clients are told
+// their per-client quota through the RateLimit-Limit and
+// RateLimit-Remaining headers, and when to come back through Retry-After.
+// Requests are let through when the store fails, so a cache outage does
+// not take the service down.
+//
+// Clients are identified by the address stored by ClientIP.
+func RateLimit(store ratelimit.Store, config RateLimitConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if result, ok := allow(r.Context(), store, "global", config.Global); !ok {
+				metrics.RateLimited("global")
+				rejectRateLimited(w, r, result)
+				return
+			}
 
-func (rl *RateLimiter) allow() bool {
-	rl.mutex.Lock()
-	defer rl.mutex.Unlock()
+			path := strings.TrimSuffix(r.URL.Path, "/")
+			if slices.Contains(config.Exempt, path) {
+				next.ServeHTTP(w, r)
+				return
+			}
 
-	rl.refill()
-	if rl.tokens >= 1 {
-		rl.tokens--
-		return true
-	}
-	return false
-}
+			client := clientip.Key(clientip.FromRequest(r))
+			scope, key, limit := "client", "client:"+client, config.PerClient
+			if routeLimit, ok := config.Routes[path]; ok {
+				scope, key, limit = "route", "route:"+path+":"+client, routeLimit
+			}
 
-func RateLimit(limiter *RateLimiter) func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if !limiter.allow() {
-				http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			result, ok := allow(r.Context(), store, key, limit)
+			if result != nil {
+				w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+				w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			}
+			if !ok {
+				metrics.RateLimited(scope)
+				rejectRateLimited(w, r, result)
 				return
 			}
 			next.ServeHTTP(w, r)
 		})
 	}
 }
+
+// allow returns a nil result when the limit is disabled or the store
+// failed; the request is allowed in both cases.
+func allow(ctx context.Context, store ratelimit.Store, key string, limit ratelimit.Limit) (*ratelimit.Result, bool) {
+	if limit.Count == 0 {
+		return nil, true
+	}
+
+	result, err := store.Allow(ctx, key, limit)
+	if err != nil {
+		slog.ErrorContext(ctx, "Rate limiter unavailable", "error", err)
+		return nil, true
+	}
+	return &result, result.Allowed
+}
+
+func rejectRateLimited(w http.ResponseWriter, r *http.Request, result *ratelimit.Result) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+	respond.Error(w, r, http.StatusTooManyRequests, "Rate limit exceeded")
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gauss2302/microtest/auth-service/internal/ratelimit"
+	"github.com/gauss2302/microtest/auth-service/pkg/clientip"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimit_TrustedProxy(t *testing.T) {
+	resolver, err := clientip.NewResolver([]string{"172.16.0.0/12"})
+	assert.NoError(t, err)
+
+	handler := ClientIP(resolver)(RateLimit(ratelimit.NewMemoryStore(time.Hour), RateLimitConfig{
+		PerClient: ratelimit.Limit{Count: 1, Period: time.Minute},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	request := func(remoteAddr, forwardedFor string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/auth/validate", nil)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := request("172.18.0.5:1234", "203.0.113.7")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", rec.Header().Get("RateLimit-Remaining"))
+
+	// Another client behind the same proxy has its own quota
+	assert.Equal(t, http.StatusOK, request("172.18.0.5:1234", "203.0.113.8").Code)
+
+	rec = request("172.18.0.5:1234", "203.0.113.7")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+
+	// Untrusted peers cannot choose their key by spoofing the header
+	assert.Equal(t, http.StatusOK, request("198.51.100.1:1234", "203.0.113.9").Code)
+	assert.Equal(t, http.StatusTooManyRequests, request("198.51.100.1:1234", "203.0.113.10").Code)
+}
+
+func TestRateLimit_Exempt(t *testing.T) {
+	handler := RateLimit(ratelimit.NewMemoryStore(time.Hour), RateLimitConfig{
+		PerClient: ratelimit.Limit{Count: 1, Period: time.Minute},
+		Exempt:    []string{"/auth/introspect"},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func(path string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		return rec.Code
+	}
+
+	// The gateway introspects tokens for all its users from one address
+	for range 5 {
+		assert.Equal(t, http.StatusOK, request("/auth/introspect"))
+	}
+
+	assert.Equal(t, http.StatusOK, request("/auth/login"))
+	assert.Equal(t, http.StatusTooManyRequests, request("/auth/login"))
+	assert.Equal(t, http.StatusOK, request("/auth/introspect/"))
+}
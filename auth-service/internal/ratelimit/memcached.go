@@ -0,0 +1,124 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/gauss2302/microtest/pkg/metrics"
+	"github.com/gauss2302/microtest/pkg/telemetry"
+)
+
+const (
+	keyPrefix  = "ratelimit:"
+	maxRetries = 10
+)
+
+// Client is the subset of *memcache.Client used by MemcachedStore.
+type Client interface {
+	Get(key string) (*memcache.Item, error)
+	Add(item *memcache.Item) error
+	CompareAndSwap(item *memcache.Item) error
+}
+
+// MemcachedStore shares state between replicas. Updates use
+// compare-and-swap, so concurrent requests on different replicas cannot
+// both take the last slot.
+type MemcachedStore struct {
+	client Client
+	now    func() time.Time
+}
+
+func NewMemcachedStore(client Client) *MemcachedStore {
+	return &MemcachedStore{client: client, now: time.Now}
+}
+
+func (s *MemcachedStore) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	key = storeKey(key)
+
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		end := observe(ctx, "get")
+		item, err := s.client.Get(key)
+		end(expected(err, memcache.ErrCacheMiss))
+		if err != nil && !errors.Is(err, memcache.ErrCacheMiss) {
+			return Result{}, fmt.Errorf("failed to get rate limit state: %w", err)
+		}
+
+		var tat time.Time
+		if item != nil {
+			nanos, err := strconv.ParseInt(string(item.Value), 10, 64)
+			if err != nil {
+				return Result{}, fmt.Errorf("invalid rate limit state: %w", err)
+			}
+			tat = time.Unix(0, nanos)
+		}
+
+		now := s.now()
+		newTAT, result := gcra(now, tat, limit)
+		if !result.Allowed {
+			return result, nil
+		}
+
+		value := []byte(strconv.FormatInt(newTAT.UnixNano(), 10))
+		// Keep the state until the tat has passed, after which it is the
+		// same as no state
+		expiration := int32(newTAT.Sub(now)/time.Second) + 1
+
+		if item == nil {
+			end := observe(ctx, "add")
+			err = s.client.Add(&memcache.Item{Key: key, Value: value, Expiration: expiration})
+			end(expected(err, memcache.ErrNotStored))
+			if errors.Is(err, memcache.ErrNotStored) {
+				continue
+			}
+		} else {
+			item.Value = value
+			item.Expiration = expiration
+			end := observe(ctx, "cas")
+			err = s.client.CompareAndSwap(item)
+			end(expected(err, memcache.ErrCASConflict, memcache.ErrNotStored))
+			if errors.Is(err, memcache.ErrCASConflict) || errors.Is(err, memcache.ErrNotStored) {
+				continue
+			}
+		}
+		if err != nil {
+			return Result{}, fmt.Errorf("failed to store rate limit state: %w", err)
+		}
+		return result, nil
+	}
+
+	return Result{}, fmt.Errorf("rate limit state for %s is too contended", key)
+}
+
+// observe traces and times a memcached call.
+func observe(ctx context.Context, operation string) func(error) {
+	endSpan := telemetry.StartClientSpan(ctx, "memcached", operation)
+	endCall := metrics.StartClientCall("memcached", operation)
+	return func(err error) {
+		endSpan(err)
+		endCall(err)
+	}
+}
+
+// expected hides the errors that are normal outcomes from tracing and
+// metrics.
+func expected(err error, outcomes ...error) error {
+	for _, outcome := range outcomes {
+		if errors.Is(err, outcome) {
+			return nil
+		}
+	}
+	return err
+}
+
+// storeKey hashes key, which holds client addresses and paths that are not
+// always valid memcached keys.
+func storeKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return keyPrefix + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,70 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps state in the process. It is the default and suits a
+// single replica; with several, each enforces the limit on its own.
+type MemoryStore struct {
+	mutex sync.Mutex
+	tats  map[string]time.Time
+	now   func() time.Time
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewMemoryStore drops idle keys every cleanupInterval until it is closed.
+func NewMemoryStore(cleanupInterval time.Duration) *MemoryStore {
+	store := &MemoryStore{
+		tats: make(map[string]time.Time),
+		now:  time.Now,
+		done: make(chan struct{}),
+	}
+
+	go store.cleanup(cleanupInterval)
+
+	return store
+}
+
+func (s *MemoryStore) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	tat, result := gcra(s.now(), s.tats[key], limit)
+	s.tats[key] = tat
+	return result, nil
+}
+
+// Close stops the cleanup. The store keeps working, it just no longer
+// forgets idle keys.
+func (s *MemoryStore) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	return nil
+}
+
+// cleanup removes keys whose tat has passed; they are the same as absent.
+func (s *MemoryStore) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		s.mutex.Lock()
+		now := s.now()
+		for key, tat := range s.tats {
+			if tat.Before(now) {
+				delete(s.tats, key)
+			}
+		}
+		s.mutex.Unlock()
+	}
+}
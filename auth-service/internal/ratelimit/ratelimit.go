@@ -0,0 +1,121 @@
+// Package ratelimit implements the generic cell rate algorithm (GCRA) over
+// pluggable stores. GCRA keeps a single timestamp per key, the theoretical
+// arrival time (TAT) of the next request, which makes it cheap to share
+// between replicas through memcached.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit allows Count requests per Period, all of which may arrive at once.
+type Limit struct {
+	Count  int
+	Period time.Duration
+}
+
+// ParseLimit parses "<count>/<period>", where period is s, m, h or a Go
+// duration such as 10s.
+func ParseLimit(s string) (Limit, error) {
+	count, period, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid limit %q: want <count>/<period>", s)
+	}
+
+	n, err := strconv.Atoi(count)
+	if err != nil || n <= 0 {
+		return Limit{}, fmt.Errorf("invalid limit %q: count must be a positive integer", s)
+	}
+
+	var d time.Duration
+	switch period {
+	case "s":
+		d = time.Second
+	case "m":
+		d = time.Minute
+	case "h":
+		d = time.Hour
+	default:
+		d, err = time.ParseDuration(period)
+		if err != nil || d <= 0 {
+			return Limit{}, fmt.Errorf("invalid limit %q: bad period", s)
+		}
+	}
+
+	return Limit{Count: n, Period: d}, nil
+}
+
+func (l Limit) String() string {
+	return fmt.Sprintf("%d/%s", l.Count, l.Period)
+}
+
+// interval is the time one request uses up.
+func (l Limit) interval() time.Duration {
+	return l.Period / time.Duration(l.Count)
+}
+
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long a rejected caller should wait
+	RetryAfter time.Duration
+}
+
+// Store decides whether a request for key is within limit and records it
+// if so.
+type Store interface {
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// gcra applies one request arriving at now to the stored tat. It returns
+// the tat to store, which equals tat when the request is rejected.
+func gcra(now, tat time.Time, limit Limit) (time.Time, Result) {
+	interval := limit.interval()
+	if tat.Before(now) {
+		tat = now
+	}
+
+	newTAT := tat.Add(interval)
+	allowAt := newTAT.Add(-limit.Period)
+	if now.Before(allowAt) {
+		return tat, Result{
+			Allowed:    false,
+			Limit:      limit.Count,
+			Remaining:  0,
+			RetryAfter: allowAt.Sub(now),
+		}
+	}
+
+	return newTAT, Result{
+		Allowed:   true,
+		Limit:     limit.Count,
+		Remaining: int(now.Sub(allowAt) / interval),
+	}
+}
+
+// ParseRoutes parses comma-separated "<path>=<limit>" pairs such as
+// "/auth/login=5/m,/auth/register=20/m".
+func ParseRoutes(s string) (map[string]Limit, error) {
+	routes := make(map[string]Limit)
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		path, spec, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid route limit %q: want <path>=<limit>", pair)
+		}
+		limit, err := ParseLimit(spec)
+		if err != nil {
+			return nil, err
+		}
+		routes[strings.TrimSuffix(strings.TrimSpace(path), "/")] = limit
+	}
+	return routes, nil
+}
@@ -0,0 +1,159 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		spec  string
+		limit Limit
+		err   bool
+	}{
+		{"5/m", Limit{Count: 5, Period: time.Minute}, false},
+		{"100/10s", Limit{Count: 100, Period: 10 * time.Second}, false},
+		{" 1/h ", Limit{Count: 1, Period: time.Hour}, false},
+		{"5", Limit{}, true},
+		{"0/m", Limit{}, true},
+		{"5/fortnight", Limit{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			limit, err := ParseLimit(tt.spec)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.limit, limit)
+		})
+	}
+}
+
+func TestParseRoutes(t *testing.T) {
+	routes, err := ParseRoutes("/auth/login=5/m, /auth/register/=20/h,")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]Limit{
+		"/auth/login":    {Count: 5, Period: time.Minute},
+		"/auth/register": {Count: 20, Period: time.Hour},
+	}, routes)
+
+	_, err = ParseRoutes("/auth/login")
+	assert.Error(t, err)
+}
+
+// testStore runs the same scenario against every store: a burst up to the
+// limit, a rejection, and a slot freed after one interval.
+func testStore(t *testing.T, store Store, advance func(time.Duration)) {
+	t.Helper()
+	limit := Limit{Count: 3, Period: 3 * time.Second}
+
+	for want := 2; want >= 0; want-- {
+		result, err := store.Allow(context.Background(), "client", limit)
+		assert.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, want, result.Remaining)
+	}
+
+	result, err := store.Allow(context.Background(), "client", limit)
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, time.Second, result.RetryAfter)
+
+	// Other keys are counted separately
+	result, err = store.Allow(context.Background(), "other", limit)
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+
+	advance(time.Second)
+	result, err = store.Allow(context.Background(), "client", limit)
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+}
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryStore(time.Hour)
+	defer store.Close()
+	store.now = func() time.Time { return now }
+
+	testStore(t, store, func(d time.Duration) { now = now.Add(d) })
+}
+
+// Fake memcached client
+type fakeClient struct {
+	items map[string]*memcache.Item
+	// conflicts makes the next CompareAndSwap calls fail as if another
+	// replica had written first
+	conflicts int
+}
+
+func (c *fakeClient) Get(key string) (*memcache.Item, error) {
+	item, ok := c.items[key]
+	if !ok {
+		return nil, memcache.ErrCacheMiss
+	}
+	copied := *item
+	return &copied, nil
+}
+
+func (c *fakeClient) Add(item *memcache.Item) error {
+	if _, ok := c.items[item.Key]; ok {
+		return memcache.ErrNotStored
+	}
+	c.store(item)
+	return nil
+}
+
+func (c *fakeClient) CompareAndSwap(item *memcache.Item) error {
+	if c.conflicts > 0 {
+		c.conflicts--
+		return memcache.ErrCASConflict
+	}
+	c.store(item)
+	return nil
+}
+
+func (c *fakeClient) store(item *memcache.Item) {
+	copied := *item
+	c.items[item.Key] = &copied
+}
+
+func TestMemcachedStore(t *testing.T) {
+	now := time.Now()
+	client := &fakeClient{items: make(map[string]*memcache.Item)}
+	store := NewMemcachedStore(client)
+	store.now = func() time.Time { return now }
+
+	testStore(t, store, func(d time.Duration) { now = now.Add(d) })
+
+	t.Run("retries on conflict", func(t *testing.T) {
+		client.conflicts = 2
+		result, err := store.Allow(context.Background(), "other", Limit{Count: 10, Period: time.Second})
+		assert.NoError(t, err)
+		assert.True(t, result.Allowed)
+	})
+
+	t.Run("gives up when contended", func(t *testing.T) {
+		client.conflicts = maxRetries
+		_, err := store.Allow(context.Background(), "other", Limit{Count: 10, Period: time.Second})
+		assert.Error(t, err)
+		client.conflicts = 0
+	})
+
+	t.Run("state is a timestamp", func(t *testing.T) {
+		item, err := client.Get(storeKey("client"))
+		assert.NoError(t, err)
+		_, err = strconv.ParseInt(string(item.Value), 10, 64)
+		assert.NoError(t, err)
+		assert.Positive(t, item.Expiration)
+	})
+}
@@ -0,0 +1,61 @@
+// Package audit records security-relevant events as JSON lines, separate
+// from the request log, so they can be shipped and retained on their own.
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	LoginSucceeded = "login_succeeded"
+	LoginFailed    = "login_failed"
+	LoginLocked    = "login_locked"
+	LoginRejected  = "login_rejected"
+)
+
+type Event struct {
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	UserID   int       `json:"user_id,omitempty"`
+	Email    string    `json:"email,omitempty"`
+	ClientIP string    `json:"client_ip,omitempty"`
+	Detail   string    `json:"detail,omitempty"`
+}
+
+var (
+	mutex  sync.Mutex
+	output io.Writer = os.Stderr
+)
+
+// SetOutput redirects events, e.g. to a file or a buffer in tests.
+func SetOutput(w io.Writer) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	output = w
+}
+
+// Record writes event. Failures are logged rather than returned, since an
+// audit sink outage must not change the outcome of the audited action.
+func Record(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("Failed to marshal audit event", "error", err)
+		return
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if _, err := output.Write(append(line, '\n')); err != nil {
+		slog.Error("Failed to write audit event", "error", err)
+	}
+}
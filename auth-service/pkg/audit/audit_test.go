@@ -0,0 +1,28 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecord(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	t.Cleanup(func() { SetOutput(os.Stderr) })
+
+	Record(Event{Type: LoginFailed, Email: "alice@example.com", ClientIP: "10.0.0.1"})
+	Record(Event{Type: LoginSucceeded, UserID: 1})
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	assert.Len(t, lines, 2)
+
+	var event Event
+	assert.NoError(t, json.Unmarshal(lines[0], &event))
+	assert.Equal(t, LoginFailed, event.Type)
+	assert.Equal(t, "10.0.0.1", event.ClientIP)
+	assert.False(t, event.Time.IsZero())
+}
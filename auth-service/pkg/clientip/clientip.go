@@ -0,0 +1,167 @@
+// Package clientip determines the address of the client behind a request.
+// Forwarding headers are only believed when they were added by a trusted
+// proxy, and are read right to left: each trusted hop vouches for the one
+// before it, up to the first address that is not a trusted proxy.
+package clientip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Resolver resolves client addresses given the trusted proxy networks.
+// The zero value trusts no proxy and always returns the peer address.
+type Resolver struct {
+	trusted []*net.IPNet
+}
+
+// NewResolver accepts CIDRs and bare addresses.
+func NewResolver(trustedProxies []string) (*Resolver, error) {
+	resolver := &Resolver{}
+	for _, proxy := range trustedProxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			resolver.trusted = append(resolver.trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		resolver.trusted = append(resolver.trusted, network)
+	}
+	return resolver, nil
+}
+
+// Resolve returns the client address for r. The Forwarded header (RFC
+// 7239) takes precedence over X-Forwarded-For.
+func (res *Resolver) Resolve(r *http.Request) net.IP {
+	peer := parseHost(r.RemoteAddr)
+	if peer == nil || !res.isTrusted(peer) {
+		return peer
+	}
+
+	var hops []string
+	if forwarded := r.Header.Values("Forwarded"); len(forwarded) > 0 {
+		hops = forwardedFor(forwarded)
+	} else {
+		for _, value := range r.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(value, ",")...)
+		}
+	}
+
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := parseHost(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			// An unknown or obfuscated hop ends the chain we can follow
+			break
+		}
+		client = ip
+		if !res.isTrusted(ip) {
+			break
+		}
+	}
+	return client
+}
+
+// TrustsProxies reports whether any proxy is trusted. Without one, every
+// client behind a proxy or gateway resolves to the proxy's address.
+func (res *Resolver) TrustsProxies() bool {
+	return len(res.trusted) > 0
+}
+
+func (res *Resolver) isTrusted(ip net.IP) bool {
+	for _, network := range res.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Key returns the rate limiting key for ip. IPv6 clients usually control
+// a whole /64, so addresses are grouped by that prefix.
+func Key(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String()
+}
+
+// parseHost parses an address with or without a port. IPv6 addresses may
+// be bracketed.
+func parseHost(s string) net.IP {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	return net.ParseIP(strings.Trim(s, "[]"))
+}
+
+// forwardedFor returns the for= parameters of Forwarded header values in
+// order. Elements without one yield an empty hop.
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			hop := ""
+			for _, pair := range strings.Split(element, ";") {
+				key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					hop = strings.Trim(val, `"`)
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+type contextKey struct{}
+
+type resolved struct {
+	ip         net.IP
+	identified bool
+}
+
+// NewContext stores the resolved client address. identified reports
+// whether the address tells clients apart, see Identified.
+func NewContext(ctx context.Context, ip net.IP, identified bool) context.Context {
+	return context.WithValue(ctx, contextKey{}, resolved{ip: ip, identified: identified})
+}
+
+// FromRequest returns the address stored by NewContext, falling back to
+// the peer address.
+func FromRequest(r *http.Request) net.IP {
+	if value, ok := r.Context().Value(contextKey{}).(resolved); ok {
+		return value.ip
+	}
+	return parseHost(r.RemoteAddr)
+}
+
+// Identified reports whether the address stored by NewContext identifies
+// the client. It does not when no proxy is trusted, as every request
+// arriving through a gateway then carries the gateway's address.
+func Identified(r *http.Request) bool {
+	value, ok := r.Context().Value(contextKey{}).(resolved)
+	return ok && value.identified && value.ip != nil
+}
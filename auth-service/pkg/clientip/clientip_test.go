@@ -0,0 +1,83 @@
+package clientip
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolver_Resolve(t *testing.T) {
+	resolver, err := NewResolver([]string{"10.0.0.0/8", "192.0.2.1", "2001:db8:ffff::/48"})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string][]string
+		want       string
+	}{
+		{"direct client", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"untrusted peer cannot spoof", "203.0.113.7:5000", map[string][]string{"X-Forwarded-For": {"1.2.3.4"}}, "203.0.113.7"},
+		{"trusted proxy", "10.0.0.2:5000", map[string][]string{"X-Forwarded-For": {"203.0.113.7"}}, "203.0.113.7"},
+		{"spoofed left entries are ignored", "10.0.0.2:5000", map[string][]string{"X-Forwarded-For": {"1.2.3.4, 203.0.113.7"}}, "203.0.113.7"},
+		{"chain of trusted proxies", "10.0.0.2:5000", map[string][]string{"X-Forwarded-For": {"1.2.3.4, 203.0.113.7, 192.0.2.1", "10.0.0.3"}}, "203.0.113.7"},
+		{"only proxies", "10.0.0.2:5000", map[string][]string{"X-Forwarded-For": {"10.0.0.4, 10.0.0.3"}}, "10.0.0.4"},
+		{"no header from proxy", "10.0.0.2:5000", nil, "10.0.0.2"},
+		{"garbage hop", "10.0.0.2:5000", map[string][]string{"X-Forwarded-For": {"203.0.113.7, nonsense"}}, "10.0.0.2"},
+		{"forwarded header", "10.0.0.2:5000", map[string][]string{
+			"Forwarded":       {`for=1.2.3.4, for="[2001:db8:cafe::17]:4711";proto=https`},
+			"X-Forwarded-For": {"5.6.7.8"},
+		}, "2001:db8:cafe::17"},
+		{"obfuscated forwarded hop", "10.0.0.2:5000", map[string][]string{"Forwarded": {"for=_hidden"}}, "10.0.0.2"},
+		{"ipv6 proxy", "[2001:db8:ffff::1]:5000", map[string][]string{"X-Forwarded-For": {"203.0.113.7"}}, "203.0.113.7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for key, values := range tt.headers {
+				for _, value := range values {
+					r.Header.Add(key, value)
+				}
+			}
+
+			assert.Equal(t, tt.want, resolver.Resolve(r).String())
+		})
+	}
+}
+
+func TestNewResolver(t *testing.T) {
+	_, err := NewResolver([]string{"not-a-network"})
+	assert.Error(t, err)
+
+	_, err = NewResolver([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+
+	resolver, err := NewResolver([]string{"", " "})
+	assert.NoError(t, err)
+	assert.Empty(t, resolver.trusted)
+}
+
+func TestKey(t *testing.T) {
+	assert.Equal(t, "203.0.113.7", Key(net.ParseIP("203.0.113.7")))
+	assert.Equal(t, "203.0.113.7", Key(net.ParseIP("::ffff:203.0.113.7")))
+	assert.Equal(t, "2001:db8:1:2::/64", Key(net.ParseIP("2001:db8:1:2:aaaa::1")))
+	assert.Equal(t, Key(net.ParseIP("2001:db8:1:2::1")), Key(net.ParseIP("2001:db8:1:2:ffff::1")))
+	assert.Empty(t, Key(nil))
+}
+
+func TestIdentified(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/auth/login", nil)
+	assert.False(t, Identified(req))
+
+	gateway := req.WithContext(NewContext(req.Context(), net.ParseIP("172.18.0.5"), false))
+	assert.Equal(t, "172.18.0.5", FromRequest(gateway).String())
+	assert.False(t, Identified(gateway))
+
+	client := req.WithContext(NewContext(req.Context(), net.ParseIP("203.0.113.7"), true))
+	assert.True(t, Identified(client))
+}
@@ -1,47 +1,70 @@
 package config
 
 import (
-	"os"
 	"time"
+
+	sharedconfig "github.com/gauss2302/microtest/pkg/config"
 )
 
 type Config struct {
 	// Server settings
-	ServerPort string
+	ServerPort string `env:"SERVER_PORT" default:"8080"`
+	// How long in-flight requests get to finish on shutdown
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT" default:"15s"`
+	// Minimum level logged (debug, info, warn or error) and the format
+	// of log lines (json or text)
+	LogLevel  string `env:"LOG_LEVEL" default:"info" oneof:"debug info warn error"`
+	LogFormat string `env:"LOG_FORMAT" default:"json" oneof:"json text"`
 
 	// Memcached settings
-	MemcachedHost string
-	MemcachedPort string
+	MemcachedHost string `env:"MEMCACHED_HOST" default:"localhost"`
+	MemcachedPort string `env:"MEMCACHED_PORT" default:"11211"`
 
 	// Auth settings
-	TokenExpiration time.Duration
+	TokenExpiration time.Duration `env:"TOKEN_EXPIRATION" default:"24h"`
+	TokenHashSecret string        `env:"TOKEN_HASH_SECRET" secret:"true"`
+	// How long tokens stored under their raw value are still honoured;
+	// TokenExpiration when unset
+	LegacyTokenWindow time.Duration `env:"LEGACY_TOKEN_WINDOW"`
+	// JSON file with registered OAuth clients
+	OAuthClientsFile string `env:"OAUTH_CLIENTS_FILE"`
+	// JSON file with external OpenID Connect providers
+	OIDCProvidersFile string `env:"OIDC_PROVIDERS_FILE"`
+
+	// Comma-separated CIDRs of proxies whose forwarding headers are
+	// believed when determining the client address
+	TrustedProxies string `env:"TRUSTED_PROXIES"`
+
+	// Rate limits as "<count>/<period>". The store is "memory" or
+	// "memcached"; only the latter is shared between replicas.
+	RateLimitStore     string `env:"RATE_LIMIT_STORE" default:"memory" oneof:"memory memcached"`
+	RateLimitGlobal    string `env:"RATE_LIMIT_GLOBAL" default:"100/10s"`
+	RateLimitPerClient string `env:"RATE_LIMIT_PER_CLIENT" default:"10/10s"`
+	// Per-client limits for single routes, "<path>=<limit>,..."
+	RateLimitRoutes string `env:"RATE_LIMIT_ROUTES" default:"/auth/login=5/m,/auth/login/mfa=5/m,/auth/token=30/m,/auth/password/forgot=5/h,/auth/register=10/h"`
+
+	// Mail settings. The log and file mailers are for local development.
+	Mailer         string `env:"MAILER" default:"smtp" oneof:"smtp log file"`
+	MailerFilePath string `env:"MAILER_FILE_PATH" default:"mail.log"`
+	SMTPHost       string `env:"SMTP_HOST"`
+	SMTPPort       string `env:"SMTP_PORT" default:"587"`
+	SMTPUsername   string `env:"SMTP_USERNAME"`
+	SMTPPassword   string `env:"SMTP_PASSWORD" secret:"true"`
+	MailFrom       string `env:"MAIL_FROM"`
+	// Base URL of the frontend, used for links in emails
+	PublicURL string `env:"PUBLIC_URL" default:"http://localhost:8080"`
 
 	// External services
-	UserServiceURL string
+	UserServiceURL string `env:"USER_SERVICE_URL" default:"http://user-service:8080"`
 }
 
+// LoadConfig loads the configuration, exiting if it is invalid.
 func LoadConfig() *Config {
-	return &Config{
-		ServerPort:      getEnv("SERVER_PORT", "8080"),
-		MemcachedHost:   getEnv("MEMCACHED_HOST", "localhost"),
-		MemcachedPort:   getEnv("MEMCACHED_PORT", "11211"),
-		TokenExpiration: getDurationEnv("TOKEN_EXPIRATION", 24*time.Hour),
-		UserServiceURL:  getEnv("USER_SERVICE_URL", "http://user-service:8080"),
-	}
-}
-
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
-}
+	cfg := &Config{}
+	sharedconfig.MustLoad(cfg)
 
-func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
-	if value := os.Getenv(key); value != "" {
-		if duration, err := time.ParseDuration(value); err == nil {
-			return duration
-		}
+	if cfg.LegacyTokenWindow == 0 {
+		cfg.LegacyTokenWindow = cfg.TokenExpiration
 	}
-	return defaultValue
+	return cfg
 }
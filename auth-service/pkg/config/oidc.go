@@ -0,0 +1,48 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gauss2302/microtest/auth-service/internal/entity"
+)
+
+// LoadOIDCProviders reads the provider list from a JSON file. A client
+// secret of the form "${VAR}" is taken from the environment so that the
+// file itself can be committed. An empty path yields no providers.
+func LoadOIDCProviders(path string) ([]entity.OIDCProvider, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read oidc providers file: %w", err)
+	}
+
+	var providers []entity.OIDCProvider
+	if err := json.Unmarshal(data, &providers); err != nil {
+		return nil, fmt.Errorf("failed to parse oidc providers file: %w", err)
+	}
+
+	seen := make(map[string]bool, len(providers))
+	for i := range providers {
+		p := &providers[i]
+		if p.Name == "" || p.Issuer == "" || p.ClientID == "" || p.RedirectURL == "" {
+			return nil, fmt.Errorf("name, issuer, client_id and redirect_url are required for every provider")
+		}
+		if strings.Contains(p.Name, "/") {
+			return nil, fmt.Errorf("provider name %q must not contain '/'", p.Name)
+		}
+		if seen[p.Name] {
+			return nil, fmt.Errorf("duplicate provider %q", p.Name)
+		}
+		seen[p.Name] = true
+
+		p.ClientSecret = os.ExpandEnv(p.ClientSecret)
+	}
+
+	return providers, nil
+}
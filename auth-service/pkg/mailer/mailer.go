@@ -0,0 +1,78 @@
+// Package mailer delivers transactional email. Deployments send it over
+// SMTP; the log and file mailers are meant for local runs.
+package mailer
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(msg *Message) error
+}
+
+// New returns the mailer selected by kind: "smtp", or "log" and "file" for
+// local development.
+func New(kind string, smtp SMTPConfig, path string) (Mailer, error) {
+	switch kind {
+	case "smtp":
+		return NewSMTPMailer(smtp)
+	case "log":
+		return NewLogMailer(), nil
+	case "file":
+		return NewFileMailer(path), nil
+	default:
+		return nil, fmt.Errorf("unknown mailer %q", kind)
+	}
+}
+
+// LogMailer notes messages in the service log without sending them.
+// Messages carry one-time tokens, so their bodies are left out.
+type LogMailer struct{}
+
+func NewLogMailer() *LogMailer {
+	return &LogMailer{}
+}
+
+func (m *LogMailer) Send(msg *Message) error {
+	slog.Info("Mail", "to", msg.To, "subject", msg.Subject)
+	return nil
+}
+
+// FileMailer appends messages to a file, one after another.
+type FileMailer struct {
+	path  string
+	mutex sync.Mutex
+}
+
+func NewFileMailer(path string) *FileMailer {
+	return &FileMailer{path: path}
+}
+
+func (m *FileMailer) Send(msg *Message) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	f, err := os.OpenFile(m.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open mail file: %w", err)
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n",
+		time.Now().Format(time.RFC1123Z), msg.To, msg.Subject, msg.Body)
+	if err != nil {
+		return fmt.Errorf("failed to write mail file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package mailer
+
+import (
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSMTPServer accepts one message and returns the commands and data it
+// received.
+func fakeSMTPServer(t *testing.T) (host, port string, received <-chan []string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	lines := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		text := textproto.NewConn(conn)
+		var seen []string
+		text.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				break
+			}
+			seen = append(seen, line)
+			switch verb := strings.ToUpper(strings.Fields(line)[0]); verb {
+			case "DATA":
+				text.PrintfLine("354 go ahead")
+				data, _ := text.ReadDotLines()
+				seen = append(seen, data...)
+				text.PrintfLine("250 queued")
+			case "QUIT":
+				text.PrintfLine("221 bye")
+				lines <- seen
+				return
+			default:
+				text.PrintfLine("250 ok")
+			}
+		}
+		lines <- seen
+	}()
+
+	host, port, _ = net.SplitHostPort(listener.Addr().String())
+	return host, port, lines
+}
+
+func TestFileMailer_Send(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.log")
+	m := NewFileMailer(path)
+
+	assert.NoError(t, m.Send(&Message{To: "a@example.com", Subject: "First", Body: "one"}))
+	assert.NoError(t, m.Send(&Message{To: "b@example.com", Subject: "Second", Body: "two"}))
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "To: a@example.com\nSubject: First\n\none")
+	assert.Contains(t, string(data), "To: b@example.com\nSubject: Second\n\ntwo")
+}
+
+func TestSMTPMailer_Send(t *testing.T) {
+	host, port, received := fakeSMTPServer(t)
+	m, err := NewSMTPMailer(SMTPConfig{Host: host, Port: port, From: "noreply@example.com"})
+	require.NoError(t, err)
+
+	err = m.Send(&Message{To: "a@example.com", Subject: "Reset your password", Body: "line one\nline two"})
+	assert.NoError(t, err)
+
+	lines := <-received
+	assert.Contains(t, lines, "MAIL FROM:<noreply@example.com>")
+	assert.Contains(t, lines, "RCPT TO:<a@example.com>")
+	assert.Contains(t, lines, "To: a@example.com")
+	assert.Contains(t, lines, "Subject: Reset your password")
+	assert.Contains(t, lines, "line two")
+
+	// Headers cannot be smuggled in through the recipient
+	err = m.Send(&Message{To: "a@example.com\r\nBcc: b@example.com", Subject: "Hi"})
+	assert.Error(t, err)
+}
+
+func TestNew(t *testing.T) {
+	m, err := New("log", SMTPConfig{}, "")
+	assert.NoError(t, err)
+	assert.IsType(t, &LogMailer{}, m)
+
+	m, err = New("file", SMTPConfig{}, "mail.log")
+	assert.NoError(t, err)
+	assert.IsType(t, &FileMailer{}, m)
+
+	m, err = New("smtp", SMTPConfig{Host: "mail", Port: "587", From: "noreply@example.com"}, "")
+	assert.NoError(t, err)
+	assert.IsType(t, &SMTPMailer{}, m)
+
+	// SMTP needs a server, and the log mailer has to be asked for
+	_, err = New("smtp", SMTPConfig{}, "")
+	assert.Error(t, err)
+	_, err = New("", SMTPConfig{}, "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,109 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// smtpTimeout bounds the whole conversation with the mail server
+const smtpTimeout = 30 * time.Second
+
+// SMTPConfig says how to reach the mail server.
+type SMTPConfig struct {
+	Host string
+	Port string
+	// Credentials, if the server wants them. They are only sent over TLS.
+	Username string
+	Password string
+	// From is the sender address
+	From string
+}
+
+// SMTPMailer submits messages to a mail server, upgrading the connection
+// with STARTTLS when the server offers it.
+type SMTPMailer struct {
+	config SMTPConfig
+}
+
+func NewSMTPMailer(config SMTPConfig) (*SMTPMailer, error) {
+	if config.Host == "" || config.Port == "" {
+		return nil, errors.New("smtp mailer needs a host and port")
+	}
+	if config.From == "" {
+		return nil, errors.New("smtp mailer needs a sender address")
+	}
+	return &SMTPMailer{config: config}, nil
+}
+
+func (m *SMTPMailer) Send(msg *Message) error {
+	if strings.ContainsAny(msg.To+msg.Subject, "\r\n") {
+		return errors.New("invalid message header")
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(m.config.Host, m.config.Port), smtpTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to mail server: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	client, err := smtp.NewClient(conn, m.config.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to greet mail server: %w", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: m.config.Host}); err != nil {
+			return fmt.Errorf("failed to start tls: %w", err)
+		}
+	}
+	if m.config.Username != "" {
+		// PlainAuth refuses to send the password over plain text
+		auth := smtp.PlainAuth("", m.config.Username, m.config.Password, m.config.Host)
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("failed to authenticate with mail server: %w", err)
+		}
+	}
+
+	if err := client.Mail(m.config.From); err != nil {
+		return fmt.Errorf("mail server refused sender: %w", err)
+	}
+	if err := client.Rcpt(msg.To); err != nil {
+		return fmt.Errorf("mail server refused recipient: %w", err)
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	if _, err := w.Write(m.format(msg)); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+
+	return client.Quit()
+}
+
+// format renders msg as a plain text message with CRLF line endings.
+func (m *SMTPMailer) format(msg *Message) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", m.config.From)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
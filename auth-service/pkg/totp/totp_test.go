@@ -0,0 +1,79 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Secret and SHA1 vectors from RFC 6238 appendix B, truncated to 6 digits
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		code, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		assert.NoError(t, err)
+		assert.Equal(t, tt.code, code, "time %d", tt.unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+
+	t.Run("current step", func(t *testing.T) {
+		step, ok := Validate(rfcSecret, "081804", now)
+		assert.True(t, ok)
+		assert.Equal(t, Step(now), step)
+	})
+
+	t.Run("previous step is accepted", func(t *testing.T) {
+		step, ok := Validate(rfcSecret, "081804", now.Add(Period))
+		assert.True(t, ok)
+		assert.Equal(t, Step(now), step)
+	})
+
+	t.Run("older steps are rejected", func(t *testing.T) {
+		_, ok := Validate(rfcSecret, "081804", now.Add(2*Period))
+		assert.False(t, ok)
+	})
+
+	t.Run("malformed code", func(t *testing.T) {
+		_, ok := Validate(rfcSecret, "12345", now)
+		assert.False(t, ok)
+	})
+
+	t.Run("invalid secret", func(t *testing.T) {
+		_, ok := Validate("not base32!", "081804", now)
+		assert.False(t, ok)
+	})
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	assert.NoError(t, err)
+	assert.Len(t, secret, 32)
+
+	_, err = Code(secret, 1)
+	assert.NoError(t, err)
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Buymania", "alice@example.com", "JBSWY3DPEHPK3PXP")
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Buymania:alice@example.com?"))
+	assert.Contains(t, uri, "secret=JBSWY3DPEHPK3PXP")
+	assert.Contains(t, uri, "issuer=Buymania")
+}
@@ -1,16 +1,96 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"log"
+	"log/slog"
 	"net/http"
+	"time"
+
+	"github.com/gauss2302/microtest/pkg/config"
+	"github.com/gauss2302/microtest/pkg/database"
+	"github.com/gauss2302/microtest/pkg/health"
+	"github.com/gauss2302/microtest/pkg/lifecycle"
+	"github.com/gauss2302/microtest/pkg/logging"
+	"github.com/gauss2302/microtest/pkg/respond"
+	"github.com/gauss2302/microtest/pkg/server"
+	"github.com/gauss2302/microtest/pkg/telemetry"
 )
 
+// settings are the service's settings, loaded as described in pkg/config.
+type settings struct {
+	ServerPort string `env:"SERVER_PORT" default:"8080"`
+	// How long in-flight requests get to finish on shutdown
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT" default:"15s"`
+	// Minimum level logged (debug, info, warn or error) and the format
+	// of log lines (json or text)
+	LogLevel  string `env:"LOG_LEVEL" default:"info" oneof:"debug info warn error"`
+	LogFormat string `env:"LOG_FORMAT" default:"json" oneof:"json text"`
+
+	// Database settings. Without DB_HOST the service runs without a
+	// database and is ready as soon as it serves requests. With it,
+	// DB_PASSWORD is required.
+	DBHost     string `env:"DB_HOST"`
+	DBPort     string `env:"DB_PORT" default:"5432"`
+	DBUser     string `env:"DB_USER" default:"postgres"`
+	DBPassword string `env:"DB_PASSWORD" secret:"true"`
+	DBName     string `env:"DB_NAME" default:"paymentdb"`
+}
+
+// Validate requires the database password whenever a database is set.
+func (s *settings) Validate() error {
+	if s.DBHost != "" && s.DBPassword == "" {
+		return errors.New("DB_PASSWORD is required when DB_HOST is set")
+	}
+	return nil
+}
+
+// database returns the settings of the service's database.
+func (s *settings) database() database.Config {
+	return database.Config{
+		Host:     s.DBHost,
+		Port:     s.DBPort,
+		User:     s.DBUser,
+		Password: s.DBPassword,
+		Name:     s.DBName,
+	}
+}
+
 func main() {
-	http.HandleFunc("/payment", paymentHandler)
+	cfg := &settings{}
+	config.MustLoad(cfg)
+	if err := logging.Setup("payment-service", cfg.LogLevel, cfg.LogFormat); err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	app := lifecycle.New(cfg.ShutdownTimeout)
 
-	fmt.Println("Payment service is running on port 8081")
-	log.Fatal(http.ListenAndServe(":8081", nil))
+	shutdownTracing, err := telemetry.Setup(context.Background(), "payment-service")
+	if err != nil {
+		logging.Fatal("Failed to set up tracing", "error", err)
+	}
+	app.OnShutdown("tracing", shutdownTracing)
+
+	checks := health.NewRegistry(5 * time.Second)
+	if cfg.DBHost != "" {
+		dbConn, err := database.Open(cfg.database())
+		if err != nil {
+			logging.Fatal("Failed to connect to the database", "error", err)
+		}
+		app.Close("database", dbConn)
+		checks.Register("postgres", 2*time.Second, dbConn.PingContext)
+	}
+
+	mux := server.NewMux(checks)
+	mux.HandleFunc("/payment", paymentHandler)
+
+	app.Serve(server.New(":"+cfg.ServerPort, mux))
+
+	slog.Info("Payment service running", "port", cfg.ServerPort)
+	if err := app.Run(context.Background()); err != nil {
+		logging.Fatal("Payment service failed", "error", err)
+	}
 }
 
 func paymentHandler(w http.ResponseWriter, r *http.Request) {
@@ -19,7 +99,7 @@ func paymentHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("Payment processed successfully"))
 	} else {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		w.Write([]byte("Method not allowed"))
+		w.Header().Set("Allow", http.MethodPost)
+		respond.Error(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 	}
 }
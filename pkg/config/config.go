@@ -0,0 +1,307 @@
+// Package config loads service settings into structs whose fields are
+// described by tags:
+//
+//	type Config struct {
+//		DBHost     string        `env:"DB_HOST" default:"localhost"`
+//		DBPassword string        `env:"DB_PASSWORD" required:"true" secret:"true"`
+//		Timeout    time.Duration `env:"SHUTDOWN_TIMEOUT" default:"15s"`
+//		LogFormat  string        `env:"LOG_FORMAT" default:"json" oneof:"json text"`
+//	}
+//
+// Each setting is taken from, in increasing order of precedence, its
+// default, a YAML file, its environment variable and a command-line flag.
+// The file is named by the --config flag or the CONFIG_FILE variable, and
+// spells settings in lower case (db_host: localhost); flags spell them in
+// lower case with dashes (--db-host=localhost). A setting that is present
+// but empty overrides the ones below it, so DB_HOST= clears a default;
+// required settings must not be empty.
+//
+// Fields of type string, bool, int, int64, float64 and time.Duration are
+// supported; fields without an env tag are left alone.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/gauss2302/microtest/pkg/logging"
+)
+
+// EnvFile names the YAML file to load settings from when --config is not
+// given.
+const EnvFile = "CONFIG_FILE"
+
+// Validator is implemented by configs with checks of their own, which
+// run once every setting loaded.
+type Validator interface {
+	Validate() error
+}
+
+// setting is a tagged field of a config.
+type setting struct {
+	field    reflect.Value
+	env      string
+	value    string
+	hasValue bool
+	required bool
+	secret   bool
+	oneOf    []string
+}
+
+// key is the setting's name in config files.
+func (s *setting) key() string {
+	return strings.ToLower(s.env)
+}
+
+// flag is the setting's command-line flag.
+func (s *setting) flag() string {
+	return strings.ReplaceAll(s.key(), "_", "-")
+}
+
+// MustLoad loads cfg from the process's environment and arguments for a
+// service's main function. Invalid settings are reported all together
+// before exiting. With --print-config the loaded settings are printed,
+// with secrets masked, and the process exits.
+func MustLoad(cfg any) {
+	printConfig, err := load(cfg, os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if printConfig {
+		Print(os.Stdout, cfg)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		os.Exit(2)
+	}
+	if printConfig {
+		os.Exit(0)
+	}
+}
+
+// Load fills cfg, a pointer to a struct with tagged fields, from the
+// defaults, the config file, the environment and the flags in args. The
+// errors of all invalid settings are returned joined.
+func Load(cfg any, args []string) error {
+	_, err := load(cfg, args)
+	return err
+}
+
+func load(cfg any, args []string) (bool, error) {
+	settings, err := settingsOf(cfg)
+	if err != nil {
+		return false, err
+	}
+
+	// Flags are parsed first, since they may name the file
+	flags := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	file := flags.String("config", os.Getenv(EnvFile), "YAML file to load settings from")
+	printConfig := flags.Bool("print-config", false, "print the settings, with secrets masked, and exit")
+	flagValues := make(map[*setting]string)
+	for _, s := range settings {
+		usage := "overrides $" + s.env
+		set := func(value string) error {
+			flagValues[s] = value
+			return nil
+		}
+		if s.field.Kind() == reflect.Bool {
+			flags.BoolFunc(s.flag(), usage, set)
+		} else {
+			flags.Func(s.flag(), usage, set)
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		return false, err
+	}
+
+	var errs []error
+	var fileValues map[string]string
+	if *file != "" {
+		fileValues, err = readFile(*file)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(fileValues)) {
+		if !slices.ContainsFunc(settings, func(s *setting) bool { return s.key() == key }) {
+			errs = append(errs, fmt.Errorf("%s: unknown setting %q", *file, key))
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := fileValues[s.key()]; ok {
+			s.value, s.hasValue = value, true
+		}
+		if value, ok := os.LookupEnv(s.env); ok {
+			s.value, s.hasValue = value, true
+		}
+		if value, ok := flagValues[s]; ok {
+			s.value, s.hasValue = value, true
+		}
+
+		if s.required && s.value == "" {
+			errs = append(errs, fmt.Errorf("%s is required", s.env))
+			continue
+		}
+		if !s.hasValue {
+			continue
+		}
+		if err := s.set(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Checks of the config itself may rely on every setting being valid
+	if validator, ok := cfg.(Validator); ok && len(errs) == 0 {
+		if err := validator.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return *printConfig, errors.Join(errs...)
+}
+
+// settingsOf returns the tagged fields of cfg, holding their defaults.
+func settingsOf(cfg any) ([]*setting, error) {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("config must be a pointer to a struct, not %T", cfg)
+	}
+	v = v.Elem()
+
+	var settings []*setting
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		env, ok := field.Tag.Lookup("env")
+		if !ok {
+			continue
+		}
+		s := &setting{
+			field:    v.Field(i),
+			env:      env,
+			required: field.Tag.Get("required") == "true",
+			secret:   field.Tag.Get("secret") == "true",
+			oneOf:    strings.Fields(field.Tag.Get("oneof")),
+		}
+		s.value, s.hasValue = field.Tag.Lookup("default")
+		settings = append(settings, s)
+	}
+	return settings, nil
+}
+
+// set parses the setting's value into its field.
+func (s *setting) set() error {
+	if len(s.oneOf) > 0 && !slices.Contains(s.oneOf, s.value) {
+		return fmt.Errorf("%s must be one of %s, not %q", s.env, strings.Join(s.oneOf, ", "), s.value)
+	}
+
+	invalid := func(err error) error {
+		if s.secret {
+			return fmt.Errorf("%s is invalid", s.env)
+		}
+		return fmt.Errorf("%s: invalid value %q: %w", s.env, s.value, err)
+	}
+
+	if s.field.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(s.value)
+		if err != nil {
+			return invalid(err)
+		}
+		s.field.SetInt(int64(d))
+		return nil
+	}
+
+	switch s.field.Kind() {
+	case reflect.String:
+		s.field.SetString(s.value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s.value)
+		if err != nil {
+			return invalid(err)
+		}
+		s.field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s.value, 10, s.field.Type().Bits())
+		if err != nil {
+			return invalid(err)
+		}
+		s.field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s.value, 64)
+		if err != nil {
+			return invalid(err)
+		}
+		s.field.SetFloat(f)
+	default:
+		return fmt.Errorf("%s: unsupported type %s", s.env, s.field.Type())
+	}
+	return nil
+}
+
+// readFile returns the settings in the YAML file at path as strings, to
+// be parsed like any other value.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var raw map[string]yaml.Node
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	for key, node := range raw {
+		if node.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("%s: %s must be a single value", path, key)
+		}
+		values[strings.ToLower(key)] = node.Value
+	}
+	return values, nil
+}
+
+// Print writes the settings of cfg to w in the format of config files,
+// with the values of secrets masked.
+func Print(w io.Writer, cfg any) error {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config must be a pointer to a struct, not %T", cfg)
+	}
+	v = v.Elem()
+
+	document := &yaml.Node{Kind: yaml.MappingNode}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		env, ok := field.Tag.Lookup("env")
+		if !ok {
+			continue
+		}
+
+		value := fmt.Sprint(v.Field(i).Interface())
+		if field.Tag.Get("secret") == "true" && value != "" {
+			value = logging.Redacted
+		}
+		document.Content = append(document.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: strings.ToLower(env)},
+			&yaml.Node{Kind: yaml.ScalarNode, Value: value},
+		)
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(document); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
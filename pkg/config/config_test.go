@@ -0,0 +1,156 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testConfig struct {
+	Host     string        `env:"TEST_HOST" default:"localhost"`
+	Port     int           `env:"TEST_PORT" default:"5432"`
+	Password string        `env:"TEST_PASSWORD" required:"true" secret:"true"`
+	Timeout  time.Duration `env:"TEST_TIMEOUT" default:"15s"`
+	Debug    bool          `env:"TEST_DEBUG" default:"false"`
+	Format   string        `env:"TEST_FORMAT" default:"json" oneof:"json text"`
+	Limit    int64         `env:"TEST_LIMIT"`
+	Internal string
+}
+
+// validatedConfig fails its own checks when Port is 0.
+type validatedConfig struct {
+	Port int `env:"TEST_PORT" default:"1"`
+}
+
+func (c *validatedConfig) Validate() error {
+	if c.Port == 0 {
+		return errors.New("TEST_PORT must not be 0")
+	}
+	return nil
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("applies defaults", func(t *testing.T) {
+		t.Setenv("TEST_PASSWORD", "hunter22")
+
+		var cfg testConfig
+		require.NoError(t, Load(&cfg, nil))
+		assert.Equal(t, testConfig{
+			Host:     "localhost",
+			Port:     5432,
+			Password: "hunter22",
+			Timeout:  15 * time.Second,
+			Format:   "json",
+		}, cfg)
+	})
+
+	t.Run("flags override the environment, which overrides the file", func(t *testing.T) {
+		path := writeFile(t, "test_host: file\ntest_port: 1\ntest_password: from-file\ntest_limit: 100\n")
+		t.Setenv("TEST_HOST", "env")
+		t.Setenv("TEST_PORT", "2")
+
+		var cfg testConfig
+		require.NoError(t, Load(&cfg, []string{"--config", path, "--test-port=3", "--test-debug"}))
+		assert.Equal(t, "env", cfg.Host)
+		assert.Equal(t, 3, cfg.Port)
+		assert.Equal(t, "from-file", cfg.Password)
+		assert.Equal(t, int64(100), cfg.Limit)
+		assert.True(t, cfg.Debug)
+	})
+
+	t.Run("empty variables override the default", func(t *testing.T) {
+		path := writeFile(t, "test_password: from-file\n")
+		t.Setenv("TEST_HOST", "")
+		t.Setenv("TEST_PASSWORD", "")
+
+		var cfg testConfig
+		err := Load(&cfg, []string{"--config", path})
+		assert.EqualError(t, err, "TEST_PASSWORD is required")
+		assert.Empty(t, cfg.Host)
+	})
+
+	t.Run("names the file in the environment", func(t *testing.T) {
+		t.Setenv(EnvFile, writeFile(t, "test_password: from-file\n"))
+
+		var cfg testConfig
+		require.NoError(t, Load(&cfg, nil))
+		assert.Equal(t, "from-file", cfg.Password)
+	})
+
+	t.Run("reports every invalid setting", func(t *testing.T) {
+		t.Setenv("TEST_PORT", "many")
+		t.Setenv("TEST_TIMEOUT", "soon")
+		t.Setenv("TEST_FORMAT", "xml")
+
+		var cfg testConfig
+		err := Load(&cfg, nil)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "TEST_PASSWORD is required")
+		assert.Contains(t, err.Error(), `TEST_PORT: invalid value "many"`)
+		assert.Contains(t, err.Error(), `TEST_TIMEOUT: invalid value "soon"`)
+		assert.Contains(t, err.Error(), "TEST_FORMAT must be one of json, text")
+	})
+
+	t.Run("hides invalid secrets", func(t *testing.T) {
+		type secretConfig struct {
+			Key int `env:"TEST_KEY" secret:"true"`
+		}
+		t.Setenv("TEST_KEY", "hunter22")
+
+		err := Load(&secretConfig{}, nil)
+		require.Error(t, err)
+		assert.NotContains(t, err.Error(), "hunter22")
+	})
+
+	t.Run("rejects unknown settings in the file", func(t *testing.T) {
+		path := writeFile(t, "test_password: x\ntest_hots: typo\n")
+
+		err := Load(&testConfig{}, []string{"--config", path})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `unknown setting "test_hots"`)
+	})
+
+	t.Run("runs the config's own checks", func(t *testing.T) {
+		assert.NoError(t, Load(&validatedConfig{}, nil))
+		assert.EqualError(t, Load(&validatedConfig{}, []string{"--test-port=0"}), "TEST_PORT must not be 0")
+	})
+
+	t.Run("rejects unknown flags", func(t *testing.T) {
+		assert.Error(t, Load(&validatedConfig{}, []string{"--test-prot=1"}))
+	})
+}
+
+func TestPrint(t *testing.T) {
+	cfg := testConfig{Host: "db", Port: 5432, Password: "hunter22", Timeout: time.Minute, Format: "text"}
+
+	var buf bytes.Buffer
+	require.NoError(t, Print(&buf, &cfg))
+	assert.Equal(t, `test_host: db
+test_port: 5432
+test_password: '[REDACTED]'
+test_timeout: 1m0s
+test_debug: false
+test_format: text
+test_limit: 0
+`, buf.String())
+
+	// The output loads back
+	path := writeFile(t, buf.String())
+	var loaded testConfig
+	require.NoError(t, Load(&loaded, []string{"--config", path}))
+	assert.Equal(t, time.Minute, loaded.Timeout)
+}
@@ -0,0 +1,172 @@
+// Package correlation carries a request ID and W3C trace context through
+// the services, so that the log lines and responses a request causes
+// anywhere can be tied together.
+//
+// The gateway accepts X-Request-ID and traceparent from clients or makes
+// them up; every hop after that keeps the request ID and trace ID, and
+// passes its own span ID on as the parent of the next.
+package correlation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Propagated headers
+const (
+	HeaderRequestID   = "X-Request-ID"
+	HeaderTraceparent = "Traceparent"
+)
+
+// maxRequestIDLength bounds the request IDs accepted from callers
+const maxRequestIDLength = 128
+
+// IDs identify a request and the span of it this service is handling.
+type IDs struct {
+	RequestID string
+	// TraceID is 32 and SpanID 16 lowercase hex digits
+	TraceID string
+	SpanID  string
+	// Flags are the trace flags, e.g. 01 for sampled
+	Flags string
+}
+
+// Traceparent formats the IDs as a traceparent header, naming this span as
+// the parent of the next.
+func (ids IDs) Traceparent() string {
+	return "00-" + ids.TraceID + "-" + ids.SpanID + "-" + ids.Flags
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying ids.
+func NewContext(ctx context.Context, ids IDs) context.Context {
+	return context.WithValue(ctx, contextKey{}, ids)
+}
+
+// FromContext returns the IDs in ctx, if any.
+func FromContext(ctx context.Context) (IDs, bool) {
+	ids, ok := ctx.Value(contextKey{}).(IDs)
+	return ids, ok
+}
+
+// FromRequest continues the trace and request r belongs to, or starts new
+// ones when r carries none or carries invalid headers. When r is already
+// traced by OpenTelemetry, its span is used.
+func FromRequest(r *http.Request) IDs {
+	ids := IDs{RequestID: r.Header.Get(HeaderRequestID), SpanID: randomHex(8)}
+	if !validRequestID(ids.RequestID) {
+		ids.RequestID = randomHex(16)
+	}
+
+	if span := trace.SpanContextFromContext(r.Context()); span.IsValid() && !span.IsRemote() {
+		ids.TraceID, ids.SpanID, ids.Flags = span.TraceID().String(), span.SpanID().String(), span.TraceFlags().String()
+		return ids
+	}
+	if traceID, flags, ok := parseTraceparent(r.Header.Get(HeaderTraceparent)); ok {
+		ids.TraceID, ids.Flags = traceID, flags
+	} else {
+		ids.TraceID, ids.Flags = randomHex(16), "01"
+	}
+	return ids
+}
+
+// Middleware gives every request its IDs and returns the request ID to the
+// client, including on errors.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ids := FromRequest(r)
+		w.Header().Set(HeaderRequestID, ids.RequestID)
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), ids)))
+	})
+}
+
+// Inject sets the headers that continue the request in ctx on an outgoing
+// request.
+func Inject(ctx context.Context, header http.Header) {
+	ids, ok := FromContext(ctx)
+	if !ok {
+		return
+	}
+	header.Set(HeaderRequestID, ids.RequestID)
+	header.Set(HeaderTraceparent, ids.Traceparent())
+}
+
+// Transport injects the IDs of each request's context before handing it to
+// Base, or http.DefaultTransport if Base is nil.
+type Transport struct {
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if _, ok := FromContext(req.Context()); ok {
+		// A RoundTripper must not modify the request it was given
+		req = req.Clone(req.Context())
+		Inject(req.Context(), req.Header)
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
+
+// parseTraceparent returns the trace ID and flags of a version 00
+// traceparent header. Later versions are read as far as 00 goes.
+func parseTraceparent(value string) (traceID, flags string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || parts[0] == "ff" || !isHex(parts[0], 2) {
+		return "", "", false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return "", "", false
+	}
+	traceID, parentID, flags := parts[1], parts[2], parts[3]
+	if !isHex(traceID, 32) || !isHex(parentID, 16) || !isHex(flags, 2) {
+		return "", "", false
+	}
+	if strings.Trim(traceID, "0") == "" || strings.Trim(parentID, "0") == "" {
+		return "", "", false
+	}
+	return traceID, flags, true
+}
+
+func isHex(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// validRequestID accepts IDs that are safe to echo into headers and logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("-_.:", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
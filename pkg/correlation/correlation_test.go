@@ -0,0 +1,101 @@
+package correlation
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestFromRequest(t *testing.T) {
+	t.Run("continues the caller's trace", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(HeaderRequestID, "req-1")
+		r.Header.Set(HeaderTraceparent, traceparent)
+
+		ids := FromRequest(r)
+		assert.Equal(t, "req-1", ids.RequestID)
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", ids.TraceID)
+		assert.Equal(t, "01", ids.Flags)
+		assert.Len(t, ids.SpanID, 16)
+		assert.NotEqual(t, "00f067aa0ba902b7", ids.SpanID)
+	})
+
+	t.Run("starts over on invalid headers", func(t *testing.T) {
+		for _, header := range []string{
+			"",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+			"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+			"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+			"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		} {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set(HeaderTraceparent, header)
+			r.Header.Set(HeaderRequestID, "bad id\n")
+
+			ids := FromRequest(r)
+			assert.Len(t, ids.TraceID, 32, header)
+			assert.NotEqual(t, "4bf92f3577b34da6a3ce929d0e0e4736", ids.TraceID, header)
+			assert.Len(t, ids.RequestID, 32, header)
+		}
+
+		// Future versions may add fields
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(HeaderTraceparent, "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra")
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", FromRequest(r).TraceID)
+	})
+}
+
+func TestFromRequest_Span(t *testing.T) {
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	span := trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID, TraceFlags: trace.FlagsSampled})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r = r.WithContext(trace.ContextWithSpanContext(r.Context(), span))
+	ids := FromRequest(r)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", ids.Traceparent())
+
+	// A remote parent is the caller's span, not ours
+	r = r.WithContext(trace.ContextWithRemoteSpanContext(context.Background(), span))
+	r.Header.Set(HeaderTraceparent, traceparent)
+	ids = FromRequest(r)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", ids.TraceID)
+	assert.NotEqual(t, "00f067aa0ba902b7", ids.SpanID)
+}
+
+func TestPropagation(t *testing.T) {
+	var received http.Header
+	upstream := httptest.NewServer(Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		w.WriteHeader(http.StatusNoContent)
+	})))
+	defer upstream.Close()
+
+	client := &http.Client{Transport: &Transport{}}
+	var ids IDs
+	service := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ids, _ = FromContext(r.Context())
+		req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL, nil)
+		require.NoError(t, err)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(HeaderTraceparent, traceparent)
+	rec := httptest.NewRecorder()
+	service.ServeHTTP(rec, r)
+
+	assert.Equal(t, ids.RequestID, rec.Header().Get(HeaderRequestID))
+	assert.Equal(t, ids.RequestID, received.Get(HeaderRequestID))
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+ids.SpanID+"-01", received.Get(HeaderTraceparent))
+}
@@ -0,0 +1,93 @@
+// Package database connects services to their PostgreSQL database and
+// applies its migrations.
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	_ "github.com/lib/pq"
+
+	"github.com/gauss2302/microtest/pkg/metrics"
+	"github.com/gauss2302/microtest/pkg/telemetry"
+)
+
+// Config locates a database and the credentials to log in with.
+type Config struct {
+	Host     string
+	Port     string
+	User     string
+	Password string
+	Name     string
+}
+
+// DSN returns the connection string of the database for lib/pq. Values
+// are quoted, so that spaces or quotes in them cannot start another key.
+func (c Config) DSN() string {
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+		quote(c.Host), quote(c.Port), quote(c.User), quote(c.Password), quote(c.Name))
+}
+
+// quote quotes value for a key/value connection string.
+func quote(value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+	return "'" + value + "'"
+}
+
+// URL returns the connection URL of the database, as golang-migrate
+// expects it.
+func (c Config) URL() string {
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.User, c.Password),
+		Host:     net.JoinHostPort(c.Host, c.Port),
+		Path:     "/" + c.Name,
+		RawQuery: "sslmode=disable",
+	}
+	return u.String()
+}
+
+// Open connects to the database and checks that it answers. Queries are
+// traced and the connection pool's statistics are exported as metrics.
+func Open(cfg Config) (*sql.DB, error) {
+	db, err := telemetry.OpenPostgres(cfg.DSN())
+	if err != nil {
+		return nil, fmt.Errorf("error opening db: %w", err)
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error pinging db: %w", err)
+	}
+
+	db.SetMaxOpenConns(25)
+	db.SetMaxIdleConns(25)
+	metrics.RegisterDB(db, cfg.Name)
+
+	slog.Info("Connected to the database", "database", cfg.Name)
+	return db, nil
+}
+
+// Migrate applies the migrations in dir that the database lacks.
+func Migrate(cfg Config, dir string) error {
+	m, err := migrate.New("file://"+dir, cfg.URL())
+	if err != nil {
+		return fmt.Errorf("error creating migration instance: %w", err)
+	}
+	defer m.Close()
+
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return fmt.Errorf("error running migrations: %w", err)
+	}
+
+	slog.Info("Ran migrations", "database", cfg.Name)
+	return nil
+}
@@ -0,0 +1,19 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig(t *testing.T) {
+	cfg := Config{Host: "db", Port: "5432", User: "app", Password: "p@ss word", Name: "userdb"}
+
+	assert.Equal(t, "host='db' port='5432' user='app' password='p@ss word' dbname='userdb' sslmode=disable", cfg.DSN())
+	// The password is escaped so that it cannot end the user info early
+	assert.Equal(t, "postgres://app:p%40ss%20word@db:5432/userdb?sslmode=disable", cfg.URL())
+
+	// Quotes and backslashes cannot end the quoted value early
+	cfg.Password = `it's\ sslmode=require`
+	assert.Equal(t, `host='db' port='5432' user='app' password='it\'s\\ sslmode=require' dbname='userdb' sslmode=disable`, cfg.DSN())
+}
@@ -0,0 +1,214 @@
+// Package health answers the probes of load balancers and orchestrators.
+//
+// A service is live while it serves requests at all: restarting it would
+// not help otherwise. It is ready while the dependencies registered with
+// its Registry, such as its database, answer too, and should receive
+// traffic only then.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Probe paths
+const (
+	PathLive  = "/livez"
+	PathReady = "/readyz"
+	// Path is kept for existing probes and answers like PathLive
+	Path = "/health"
+)
+
+// Statuses of checks and of a whole report
+const (
+	StatusOK       = "ok"
+	StatusFail     = "fail"
+	StatusDegraded = "degraded"
+)
+
+// DefaultTimeout bounds checks registered without a timeout.
+const DefaultTimeout = 2 * time.Second
+
+// IsProbe reports whether path is one of the probe paths, whose requests
+// are not worth logging or tracing.
+func IsProbe(path string) bool {
+	return path == PathLive || path == PathReady || path == Path
+}
+
+// CheckFunc checks a dependency, returning an error if it is unusable.
+type CheckFunc func(ctx context.Context) error
+
+// Result is the outcome of one check.
+type Result struct {
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	Optional   bool      `json:"optional,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+	CheckedAt  time.Time `json:"checked_at"`
+}
+
+// Report is the outcome of all checks, by name.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// check is a registered check and its last result.
+type check struct {
+	name     string
+	timeout  time.Duration
+	optional bool
+	fn       CheckFunc
+
+	mutex  sync.Mutex // held while checking, so callers share one run
+	result Result
+}
+
+// Registry holds the checks that decide whether a service is ready.
+// Results are reused for the cache duration, so frequent probes from
+// several sources do not hammer the dependencies.
+type Registry struct {
+	cacheFor time.Duration
+	now      func() time.Time
+
+	mutex  sync.Mutex
+	checks []*check
+}
+
+// NewRegistry returns an empty registry that reuses results for cacheFor.
+func NewRegistry(cacheFor time.Duration) *Registry {
+	return &Registry{cacheFor: cacheFor, now: time.Now}
+}
+
+// Register adds a check the service cannot be ready without. A timeout
+// of 0 selects DefaultTimeout.
+func (r *Registry) Register(name string, timeout time.Duration, fn CheckFunc) {
+	r.add(&check{name: name, timeout: timeout, fn: fn})
+}
+
+// RegisterOptional adds a check whose failure is reported, as degraded,
+// but leaves the service ready.
+func (r *Registry) RegisterOptional(name string, timeout time.Duration, fn CheckFunc) {
+	r.add(&check{name: name, timeout: timeout, optional: true, fn: fn})
+}
+
+func (r *Registry) add(c *check) {
+	if c.timeout <= 0 {
+		c.timeout = DefaultTimeout
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.checks = append(r.checks, c)
+}
+
+// Check runs every check at once, or reuses its cached result.
+func (r *Registry) Check(ctx context.Context) Report {
+	r.mutex.Lock()
+	checks := append([]*check(nil), r.checks...)
+	r.mutex.Unlock()
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = r.run(ctx, c)
+		}()
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(checks))}
+	for i, c := range checks {
+		result := results[i]
+		report.Checks[c.name] = result
+		switch {
+		case result.Status == StatusOK:
+		case c.optional:
+			if report.Status == StatusOK {
+				report.Status = StatusDegraded
+			}
+		default:
+			report.Status = StatusFail
+		}
+	}
+	return report
+}
+
+// run returns the result of c, checking again once it is older than the
+// cache duration.
+func (r *Registry) run(ctx context.Context, c *check) Result {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.result.CheckedAt.IsZero() && r.now().Sub(c.result.CheckedAt) < r.cacheFor {
+		return c.result
+	}
+
+	// A prober that hangs up must not leave a failure in the cache
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.timeout)
+	defer cancel()
+
+	start := r.now()
+	// Clients that take no context still cannot hold up the probe
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				done <- fmt.Errorf("check panicked: %v", recovered)
+			}
+		}()
+		done <- c.fn(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out after %s", c.timeout)
+	}
+
+	result := Result{
+		Status:     StatusOK,
+		Optional:   c.optional,
+		DurationMS: r.now().Sub(start).Milliseconds(),
+		CheckedAt:  r.now(),
+	}
+	if err != nil {
+		result.Status = StatusFail
+		result.Error = err.Error()
+	}
+	c.result = result
+	return result
+}
+
+// Handler answers readiness probes with the report of every check: 200
+// when the service is ready, degraded or not, and 503 otherwise.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		report := r.Check(req.Context())
+
+		status := http.StatusOK
+		if report.Status == StatusFail {
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(report)
+	})
+}
+
+// LiveHandler answers liveness probes, for as long as the process serves
+// requests.
+func LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(map[string]string{"status": StatusOK})
+	})
+}
@@ -0,0 +1,140 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_Check(t *testing.T) {
+	ok := func(ctx context.Context) error { return nil }
+	failing := func(ctx context.Context) error { return errors.New("connection refused") }
+
+	t.Run("ok without checks", func(t *testing.T) {
+		report := NewRegistry(0).Check(context.Background())
+		assert.Equal(t, StatusOK, report.Status)
+		assert.Empty(t, report.Checks)
+	})
+
+	t.Run("fails when a required check fails", func(t *testing.T) {
+		checks := NewRegistry(0)
+		checks.Register("postgres", 0, failing)
+		checks.RegisterOptional("cache", 0, ok)
+
+		report := checks.Check(context.Background())
+		assert.Equal(t, StatusFail, report.Status)
+		assert.Equal(t, StatusFail, report.Checks["postgres"].Status)
+		assert.Equal(t, "connection refused", report.Checks["postgres"].Error)
+		assert.Equal(t, StatusOK, report.Checks["cache"].Status)
+	})
+
+	t.Run("degrades when an optional check fails", func(t *testing.T) {
+		checks := NewRegistry(0)
+		checks.Register("postgres", 0, ok)
+		checks.RegisterOptional("cache", 0, failing)
+
+		report := checks.Check(context.Background())
+		assert.Equal(t, StatusDegraded, report.Status)
+		assert.True(t, report.Checks["cache"].Optional)
+	})
+
+	t.Run("times out checks that ignore their context", func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+
+		checks := NewRegistry(0)
+		checks.Register("stuck", 10*time.Millisecond, func(ctx context.Context) error {
+			<-release
+			return nil
+		})
+
+		report := checks.Check(context.Background())
+		assert.Equal(t, StatusFail, report.Status)
+		assert.Equal(t, "timed out after 10ms", report.Checks["stuck"].Error)
+	})
+
+	t.Run("recovers panicking checks", func(t *testing.T) {
+		checks := NewRegistry(0)
+		checks.Register("broken", 0, func(ctx context.Context) error { panic("nil client") })
+
+		report := checks.Check(context.Background())
+		assert.Equal(t, "check panicked: nil client", report.Checks["broken"].Error)
+	})
+
+	t.Run("reuses results for the cache duration", func(t *testing.T) {
+		var calls atomic.Int32
+		now := time.Now()
+
+		checks := NewRegistry(5 * time.Second)
+		checks.now = func() time.Time { return now }
+		checks.Register("postgres", 0, func(ctx context.Context) error {
+			calls.Add(1)
+			return nil
+		})
+
+		checks.Check(context.Background())
+		now = now.Add(4 * time.Second)
+		checks.Check(context.Background())
+		assert.Equal(t, int32(1), calls.Load())
+
+		now = now.Add(time.Second)
+		checks.Check(context.Background())
+		assert.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("does not cache the prober hanging up", func(t *testing.T) {
+		checks := NewRegistry(time.Minute)
+		checks.Register("postgres", 0, func(ctx context.Context) error { return ctx.Err() })
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		assert.Equal(t, StatusOK, checks.Check(ctx).Status)
+	})
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	checks := NewRegistry(0)
+	var failing atomic.Bool
+	checks.Register("postgres", 0, func(ctx context.Context) error {
+		if failing.Load() {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+
+	serve := func() (*httptest.ResponseRecorder, Report) {
+		rec := httptest.NewRecorder()
+		checks.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, PathReady, nil))
+
+		var report Report
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+		return rec, report
+	}
+
+	rec, report := serve()
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, StatusOK, report.Checks["postgres"].Status)
+
+	failing.Store(true)
+	rec, report = serve()
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, StatusFail, report.Status)
+	assert.Equal(t, "connection refused", report.Checks["postgres"].Error)
+}
+
+func TestLiveHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	LiveHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, PathLive, nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, rec.Body.String())
+}
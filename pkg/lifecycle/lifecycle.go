@@ -0,0 +1,155 @@
+// Package lifecycle runs a service's HTTP servers and background workers
+// and shuts them down in order when the process is asked to stop.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultShutdownTimeout is used when New is given a timeout of zero.
+const DefaultShutdownTimeout = 15 * time.Second
+
+type hook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// Lifecycle collects what a service runs and what it must release. On
+// shutdown it stops accepting connections and drains in-flight requests,
+// then stops the workers, then runs the shutdown hooks in reverse order of
+// registration, so resources are closed after everything that uses them.
+// All of this has to finish within the shutdown timeout.
+type Lifecycle struct {
+	timeout time.Duration
+	servers []*http.Server
+	workers []hook
+	hooks   []hook
+}
+
+func New(shutdownTimeout time.Duration) *Lifecycle {
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = DefaultShutdownTimeout
+	}
+	return &Lifecycle{timeout: shutdownTimeout}
+}
+
+// Serve registers a server to be started by Run.
+func (l *Lifecycle) Serve(server *http.Server) {
+	l.servers = append(l.servers, server)
+}
+
+// Go registers a background worker to be started by Run. The worker must
+// return once its context is cancelled.
+func (l *Lifecycle) Go(name string, worker func(ctx context.Context) error) {
+	l.workers = append(l.workers, hook{name: name, fn: worker})
+}
+
+// OnShutdown registers fn to run after the servers and workers stopped.
+func (l *Lifecycle) OnShutdown(name string, fn func(ctx context.Context) error) {
+	l.hooks = append(l.hooks, hook{name: name, fn: fn})
+}
+
+// Close registers closer to be closed on shutdown.
+func (l *Lifecycle) Close(name string, closer io.Closer) {
+	l.OnShutdown(name, func(context.Context) error {
+		return closer.Close()
+	})
+}
+
+// Run starts the servers and workers and blocks until ctx is done, the
+// process receives SIGINT or SIGTERM, or a server or worker fails. It then
+// shuts everything down and returns the errors met on the way.
+func (l *Lifecycle) Run(ctx context.Context) error {
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	failed := make(chan error, len(l.servers)+len(l.workers))
+
+	for _, server := range l.servers {
+		go func(server *http.Server) {
+			slog.Info("Listening", "addr", server.Addr)
+			if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				failed <- fmt.Errorf("server %s: %w", server.Addr, err)
+			}
+		}(server)
+	}
+
+	workerCtx, cancelWorkers := context.WithCancel(context.Background())
+	defer cancelWorkers()
+	var workers sync.WaitGroup
+	for _, worker := range l.workers {
+		workers.Add(1)
+		go func(worker hook) {
+			defer workers.Done()
+			if err := worker.fn(workerCtx); err != nil && workerCtx.Err() == nil {
+				failed <- fmt.Errorf("worker %s: %w", worker.name, err)
+			}
+		}(worker)
+	}
+
+	var errs []error
+	select {
+	case <-ctx.Done():
+		slog.Info("Shutting down")
+	case err := <-failed:
+		slog.Error("Shutting down", "error", err)
+		errs = append(errs, err)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), l.timeout)
+	defer cancel()
+
+	errs = append(errs, l.shutdownServers(shutdownCtx)...)
+
+	cancelWorkers()
+	stopped := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-shutdownCtx.Done():
+		errs = append(errs, fmt.Errorf("workers: %w", shutdownCtx.Err()))
+	}
+
+	for i := len(l.hooks) - 1; i >= 0; i-- {
+		if err := l.hooks[i].fn(shutdownCtx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", l.hooks[i].name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// shutdownServers drains all servers at once so that one slow client does
+// not use up the others' share of the timeout.
+func (l *Lifecycle) shutdownServers(ctx context.Context) []error {
+	var (
+		mutex sync.Mutex
+		wg    sync.WaitGroup
+		errs  []error
+	)
+	for _, server := range l.servers {
+		wg.Add(1)
+		go func(server *http.Server) {
+			defer wg.Done()
+			if err := server.Shutdown(ctx); err != nil {
+				mutex.Lock()
+				errs = append(errs, fmt.Errorf("server %s: %w", server.Addr, err))
+				mutex.Unlock()
+			}
+		}(server)
+	}
+	wg.Wait()
+	return errs
+}
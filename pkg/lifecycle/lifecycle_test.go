@@ -0,0 +1,122 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
+func TestLifecycle_Run(t *testing.T) {
+	t.Run("drains requests before stopping workers and closing", func(t *testing.T) {
+		started := make(chan struct{})
+		release := make(chan struct{})
+		addr := freeAddr(t)
+
+		l := New(5 * time.Second)
+		l.Serve(&http.Server{
+			Addr: addr,
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				<-release
+				w.Write([]byte("done"))
+			}),
+		})
+
+		var order []string
+		l.Go("worker", func(ctx context.Context) error {
+			<-ctx.Done()
+			order = append(order, "worker")
+			return nil
+		})
+		l.Close("first", closerFunc(func() error {
+			order = append(order, "first")
+			return nil
+		}))
+		l.OnShutdown("second", func(context.Context) error {
+			order = append(order, "second")
+			return nil
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		result := make(chan error)
+		go func() { result <- l.Run(ctx) }()
+
+		response := make(chan string)
+		go func() {
+			var resp *http.Response
+			var err error
+			for i := 0; i < 50; i++ {
+				if resp, err = http.Get("http://" + addr); err == nil {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if !assert.NoError(t, err) {
+				close(started)
+				response <- ""
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			response <- string(body)
+		}()
+
+		<-started
+		cancel()
+		time.Sleep(50 * time.Millisecond)
+		assert.Empty(t, order, "nothing stops while a request is in flight")
+
+		close(release)
+		assert.Equal(t, "done", <-response)
+		assert.NoError(t, <-result)
+		assert.Equal(t, []string{"worker", "second", "first"}, order)
+	})
+
+	t.Run("failing worker shuts down", func(t *testing.T) {
+		l := New(time.Second)
+		l.Go("broken", func(ctx context.Context) error {
+			return errors.New("boom")
+		})
+		closed := false
+		l.Close("resource", closerFunc(func() error {
+			closed = true
+			return nil
+		}))
+
+		err := l.Run(context.Background())
+		assert.ErrorContains(t, err, "worker broken: boom")
+		assert.True(t, closed)
+	})
+
+	t.Run("shutdown timeout is enforced", func(t *testing.T) {
+		l := New(50 * time.Millisecond)
+		l.Go("stuck", func(ctx context.Context) error {
+			select {}
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := l.Run(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
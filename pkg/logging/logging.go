@@ -0,0 +1,139 @@
+// Package logging sets up structured logging with log/slog. Lines are
+// written as JSON, carry the IDs of the request they were logged for, and
+// have secrets such as passwords and tokens redacted.
+//
+// Log through the slog package-level functions, passing the request's
+// context so its fields are attached:
+//
+//	slog.ErrorContext(ctx, "Failed to create user", "error", err)
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/gauss2302/microtest/pkg/correlation"
+)
+
+// Output formats
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// Redacted replaces the values of secret attributes.
+const Redacted = "[REDACTED]"
+
+// secretKeys are the parts of attribute keys whose values are never
+// logged. They are matched case-insensitively anywhere in the key, so
+// "password" also covers "new_password" and "token" covers "access_token".
+var secretKeys = []string{
+	"password",
+	"secret",
+	"token",
+	"authorization",
+	"cookie",
+	"api_key",
+	"apikey",
+	"credential",
+	"private_key",
+	"totp_code",
+	"recovery_code",
+}
+
+// Setup makes a logger for service the default slog logger and the
+// destination of the standard log package, so lines from libraries that
+// use it are structured too. level is one of debug, info, warn or error.
+func Setup(service, level, format string) error {
+	var minimum slog.Level
+	if err := minimum.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+
+	logger, err := New(os.Stderr, minimum, format)
+	if err != nil {
+		return err
+	}
+	logger = logger.With("service", service)
+
+	slog.SetDefault(logger)
+	// SetDefault routes the log package through logger at info level;
+	// lines there are already timestamped by the handler
+	log.SetFlags(0)
+	return nil
+}
+
+// New returns a logger writing lines of at least level to w in format.
+func New(w io.Writer, level slog.Leveler, format string) (*slog.Logger, error) {
+	options := &slog.HandlerOptions{Level: level, ReplaceAttr: redact}
+
+	var handler slog.Handler
+	switch format {
+	case "", FormatJSON:
+		handler = slog.NewJSONHandler(w, options)
+	case FormatText:
+		handler = slog.NewTextHandler(w, options)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+	return slog.New(&contextHandler{Handler: handler}), nil
+}
+
+// Fatal logs msg at error level and exits, for failures during startup.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// redact hides the values of attributes whose keys look secret.
+func redact(groups []string, attr slog.Attr) slog.Attr {
+	if attr.Value.Kind() == slog.KindGroup {
+		return attr
+	}
+	if IsSecret(attr.Key) {
+		return slog.String(attr.Key, Redacted)
+	}
+	return attr
+}
+
+// IsSecret reports whether values named key must not be logged.
+func IsSecret(key string) bool {
+	key = strings.ToLower(key)
+	for _, secret := range secretKeys {
+		if strings.Contains(key, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// contextHandler adds the fields of the request in ctx to every line.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if ids, ok := correlation.FromContext(ctx); ok {
+		record.AddAttrs(
+			slog.String("request_id", ids.RequestID),
+			slog.String("trace_id", ids.TraceID),
+		)
+	}
+	if scope, ok := ctx.Value(scopeKey{}).(*scope); ok {
+		record.AddAttrs(scope.attrs()...)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,126 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gauss2302/microtest/pkg/correlation"
+)
+
+// lines decodes the JSON lines written to buf.
+func lines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var decoded []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var fields map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &fields))
+		decoded = append(decoded, fields)
+	}
+	return decoded
+}
+
+// capture makes a logger writing to the returned buffer the default for
+// the duration of the test.
+func capture(t *testing.T, level slog.Level) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	logger, err := New(&buf, level, FormatJSON)
+	require.NoError(t, err)
+
+	previous := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+func TestNew(t *testing.T) {
+	t.Run("redacts secrets", func(t *testing.T) {
+		var buf bytes.Buffer
+		logger, err := New(&buf, slog.LevelInfo, FormatJSON)
+		require.NoError(t, err)
+
+		logger.Info("Login",
+			"email", "alice@example.com",
+			"password", "hunter22",
+			slog.Group("request", "Access_Token", "abc", "client_id", "web"),
+		)
+
+		fields := lines(t, &buf)[0]
+		assert.Equal(t, "alice@example.com", fields["email"])
+		assert.Equal(t, Redacted, fields["password"])
+		assert.Equal(t, map[string]any{"Access_Token": Redacted, "client_id": "web"}, fields["request"])
+		assert.NotContains(t, buf.String(), "hunter22")
+	})
+
+	t.Run("filters by level", func(t *testing.T) {
+		var buf bytes.Buffer
+		logger, err := New(&buf, slog.LevelWarn, FormatText)
+		require.NoError(t, err)
+
+		logger.Info("quiet")
+		logger.Warn("loud")
+		assert.NotContains(t, buf.String(), "quiet")
+		assert.Contains(t, buf.String(), "msg=loud")
+	})
+
+	t.Run("rejects unknown formats", func(t *testing.T) {
+		_, err := New(&bytes.Buffer{}, slog.LevelInfo, "xml")
+		assert.Error(t, err)
+	})
+}
+
+func TestSetup(t *testing.T) {
+	previous := slog.Default()
+	defer slog.SetDefault(previous)
+
+	assert.Error(t, Setup("test", "loud", FormatJSON))
+	assert.NoError(t, Setup("test", "debug", FormatJSON))
+	assert.True(t, slog.Default().Enabled(context.Background(), slog.LevelDebug))
+}
+
+func TestMiddleware(t *testing.T) {
+	buf := capture(t, slog.LevelInfo)
+
+	handler := correlation.Middleware(Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetRoute(r.Context(), "/users/{id}")
+		slog.ErrorContext(r.Context(), "Failed to load user", "error", "boom")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	})))
+
+	r := httptest.NewRequest(http.MethodGet, "/users/7", nil)
+	r.Header.Set(correlation.HeaderRequestID, "req-1")
+	r.Header.Set(HeaderUserID, "42")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	logged := lines(t, buf)
+	require.Len(t, logged, 2)
+	for _, fields := range logged {
+		assert.Equal(t, "req-1", fields["request_id"])
+		assert.NotEmpty(t, fields["trace_id"])
+		assert.Equal(t, "42", fields["user_id"])
+		assert.Equal(t, "/users/{id}", fields["route"])
+	}
+	assert.Equal(t, "Failed to load user", logged[0]["msg"])
+	assert.Equal(t, "Served request", logged[1]["msg"])
+	assert.Equal(t, "ERROR", logged[1]["level"])
+	assert.Equal(t, float64(http.StatusInternalServerError), logged[1]["status"])
+
+	// Health checks are served silently
+	buf.Reset()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+	assert.NotContains(t, buf.String(), "Served request")
+}
@@ -0,0 +1,112 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gauss2302/microtest/pkg/health"
+)
+
+// HeaderUserID carries the authenticated user from the gateway to the
+// services behind it, which may trust it since the gateway strips it from
+// incoming requests.
+const HeaderUserID = "X-User-ID"
+
+type scopeKey struct{}
+
+// scope holds the fields of a request that become known while it is
+// served, such as the route it matched.
+type scope struct {
+	mutex  sync.Mutex
+	userID string
+	route  string
+}
+
+func (s *scope) attrs() []slog.Attr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var attrs []slog.Attr
+	if s.userID != "" {
+		attrs = append(attrs, slog.String("user_id", s.userID))
+	}
+	if s.route != "" {
+		attrs = append(attrs, slog.String("route", s.route))
+	}
+	return attrs
+}
+
+// SetUserID names the user the request in ctx is served for.
+func SetUserID(ctx context.Context, userID string) {
+	if scope, ok := ctx.Value(scopeKey{}).(*scope); ok {
+		scope.mutex.Lock()
+		scope.userID = userID
+		scope.mutex.Unlock()
+	}
+}
+
+// SetRoute names the route template the request in ctx matched, e.g.
+// "/users/{id}".
+func SetRoute(ctx context.Context, route string) {
+	if scope, ok := ctx.Value(scopeKey{}).(*scope); ok {
+		scope.mutex.Lock()
+		scope.route = route
+		scope.mutex.Unlock()
+	}
+}
+
+// Middleware gives lines logged while serving a request the request's
+// user and route, and logs a line for each request once it is served.
+// Probes and metrics scrapes are served silently.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if health.IsProbe(r.URL.Path) || r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		ctx := context.WithValue(r.Context(), scopeKey{}, &scope{userID: r.Header.Get(HeaderUserID)})
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		level := slog.LevelInfo
+		if recorder.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		slog.Log(ctx, level, "Served request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"duration_ms", time.Since(start).Milliseconds(),
+		)
+	})
+}
+
+// statusRecorder remembers the status code written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
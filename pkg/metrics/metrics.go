@@ -0,0 +1,149 @@
+// Package metrics exposes Prometheus metrics for a service: RED metrics
+// for the requests it serves, its database connection pools and the
+// latency of the backends it calls.
+//
+// Metrics are registered with the default Prometheus registry, which
+// Handler serves along with the Go runtime and process metrics.
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gauss2302/microtest/pkg/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// RouteOther labels requests that no route claimed, so unknown paths
+// cannot blow up the number of series.
+const RouteOther = "other"
+
+var (
+	requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests served, by route template, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Time taken to serve HTTP requests, by route template, method and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+
+	clientCalls = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "client_operation_duration_seconds",
+		Help:    "Time taken by calls to backends such as memcached, by system, operation and outcome.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"system", "operation", "outcome"})
+
+	rateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ratelimit_rejections_total",
+		Help: "Requests rejected by the rate limiter, by the limit that was exceeded.",
+	}, []string{"limit"})
+)
+
+// Handler serves the registered metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+type routeKey struct{}
+
+// route is filled in by whatever routes the request further down the chain.
+type route struct {
+	template string
+}
+
+// SetRoute names the route template r was matched against, e.g.
+// "/users/{id}", for handlers that route requests themselves. Requests
+// routed by an http.ServeMux are labelled with its pattern otherwise. The
+// route is added to the request's log lines as well.
+func SetRoute(r *http.Request, template string) {
+	if route, ok := r.Context().Value(routeKey{}).(*route); ok {
+		route.template = template
+	}
+	logging.SetRoute(r.Context(), template)
+}
+
+// Middleware counts and times the requests next serves. It must wrap the
+// ServeMux directly, with nothing copying the request in between, to see
+// the pattern the request matched.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		matched := &route{}
+		r = r.WithContext(context.WithValue(r.Context(), routeKey{}, matched))
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(recorder, r)
+
+		template := matched.template
+		if template == "" {
+			template = r.Pattern
+		}
+		if template == "" {
+			template = RouteOther
+		}
+		code := strconv.Itoa(recorder.status)
+		requests.WithLabelValues(template, r.Method, code).Inc()
+		requestDuration.WithLabelValues(template, r.Method, code).Observe(time.Since(start).Seconds())
+	})
+}
+
+// statusRecorder remembers the status code written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g.
+// to flush streamed responses.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// RegisterDB exports the connection pool statistics of db, labelled with
+// name.
+func RegisterDB(db *sql.DB, name string) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// StartClientCall times a call to system, such as memcached. The returned
+// function records the call's duration with its outcome; errors that are
+// an expected answer, such as a cache miss, should be passed as nil.
+func StartClientCall(system, operation string) func(error) {
+	start := time.Now()
+	return func(err error) {
+		outcome := "ok"
+		if err != nil {
+			outcome = "error"
+		}
+		clientCalls.WithLabelValues(system, operation, outcome).Observe(time.Since(start).Seconds())
+	}
+}
+
+// RateLimited counts a request rejected for exceeding limit, such as
+// "global" or "client".
+func RateLimited(limit string) {
+	rateLimited.WithLabelValues(limit).Inc()
+}
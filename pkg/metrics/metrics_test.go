@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/users/", func(w http.ResponseWriter, r *http.Request) {
+		SetRoute(r, "/users/{id}")
+		http.Error(w, "User not found", http.StatusNotFound)
+	})
+	handler := Middleware(mux)
+
+	for _, path := range []string{"/health", "/users/1", "/users/2", "/unknown"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(requests.WithLabelValues("/health", "GET", "200")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(requests.WithLabelValues("/users/{id}", "GET", "404")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(requests.WithLabelValues(RouteOther, "GET", "404")))
+	assert.Equal(t, 3, testutil.CollectAndCount(requestDuration))
+}
+
+func TestStartClientCall(t *testing.T) {
+	StartClientCall("memcached", "get")(nil)
+	StartClientCall("memcached", "get")(errors.New("connection refused"))
+
+	// One series for each outcome
+	assert.Equal(t, 2, testutil.CollectAndCount(clientCalls))
+}
+
+func TestHandler(t *testing.T) {
+	RateLimited("global")
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `ratelimit_rejections_total{limit="global"} 1`)
+	assert.Contains(t, w.Body.String(), "go_goroutines")
+}
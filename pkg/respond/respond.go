@@ -0,0 +1,60 @@
+// Package respond writes HTTP responses in the format every service
+// shares. Errors are JSON objects with a machine-readable code, a message
+// for people and the ID of the request, so that a client reporting one
+// can be matched with the logs:
+//
+//	{"error": "not_found", "message": "User not found", "request_id": "..."}
+package respond
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gauss2302/microtest/pkg/correlation"
+)
+
+// ErrorBody is the body of error responses.
+type ErrorBody struct {
+	Error     string `json:"error"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// JSON answers with status and v encoded as JSON.
+func JSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Error answers r with an error of status, coded after the status, e.g.
+// "not_found" for 404.
+func Error(w http.ResponseWriter, r *http.Request, status int, message string) {
+	ErrorCode(w, r, status, Code(status), message)
+}
+
+// ErrorCode answers r with an error of status that has its own code.
+func ErrorCode(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	ids, _ := correlation.FromContext(r.Context())
+	JSON(w, status, ErrorBody{Error: code, Message: message, RequestID: ids.RequestID})
+}
+
+// Code returns the error code of status: its text in snake case.
+func Code(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "error"
+	}
+	return strings.ReplaceAll(strings.ToLower(text), " ", "_")
+}
+
+// ReadError returns the message of the error response body, or body
+// itself if it is not one.
+func ReadError(body []byte) string {
+	var decoded ErrorBody
+	if err := json.Unmarshal(body, &decoded); err == nil && decoded.Message != "" {
+		return decoded.Message
+	}
+	return strings.TrimSpace(string(body))
+}
@@ -0,0 +1,38 @@
+package respond
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/gauss2302/microtest/pkg/correlation"
+)
+
+func TestError(t *testing.T) {
+	handler := correlation.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Error(w, r, http.StatusNotFound, "User not found")
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/users/7", nil)
+	r.Header.Set(correlation.HeaderRequestID, "req-1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"error": "not_found", "message": "User not found", "request_id": "req-1"}`, w.Body.String())
+}
+
+func TestCode(t *testing.T) {
+	assert.Equal(t, "bad_request", Code(http.StatusBadRequest))
+	assert.Equal(t, "too_many_requests", Code(http.StatusTooManyRequests))
+	assert.Equal(t, "error", Code(599))
+}
+
+func TestReadError(t *testing.T) {
+	assert.Equal(t, "password too short", ReadError([]byte(`{"error": "unprocessable_entity", "message": "password too short"}`)))
+	assert.Equal(t, "password too short", ReadError([]byte("password too short\n")))
+	assert.Empty(t, ReadError(nil))
+}
@@ -0,0 +1,66 @@
+// Package server builds the HTTP servers of the services, so that every
+// service serves requests behind the same middleware and with the same
+// timeouts.
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gauss2302/microtest/pkg/correlation"
+	"github.com/gauss2302/microtest/pkg/health"
+	"github.com/gauss2302/microtest/pkg/logging"
+	"github.com/gauss2302/microtest/pkg/metrics"
+	"github.com/gauss2302/microtest/pkg/telemetry"
+)
+
+// Middleware wraps a handler with behaviour of its own.
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps h with middleware, the first outermost.
+func Chain(h http.Handler, middleware ...Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// Standard wraps h with the middleware every service runs: tracing,
+// request IDs, request logging and metrics. Metrics wrap h directly to
+// see the pattern an http.ServeMux matched.
+func Standard(h http.Handler) http.Handler {
+	return Chain(h,
+		telemetry.Middleware,
+		correlation.Middleware,
+		logging.Middleware,
+		metrics.Middleware,
+	)
+}
+
+// NewMux returns a ServeMux that already serves liveness and readiness
+// probes and Prometheus metrics. The service is ready while the
+// dependencies in checks answer; nil means it has none.
+func NewMux(checks *health.Registry) *http.ServeMux {
+	if checks == nil {
+		checks = health.NewRegistry(0)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(health.PathLive, health.LiveHandler())
+	mux.Handle(health.Path, health.LiveHandler())
+	mux.Handle(health.PathReady, checks.Handler())
+	mux.Handle("/metrics", metrics.Handler())
+	return mux
+}
+
+// New returns a server for addr that serves handler behind the standard
+// middleware.
+func New(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:         addr,
+		Handler:      Standard(handler),
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 15 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/gauss2302/microtest/pkg/correlation"
+)
+
+func TestChain(t *testing.T) {
+	var order []string
+	tag := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	handler := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+	}), tag("outer"), tag("inner"))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, []string{"outer", "inner", "handler"}, order)
+}
+
+func TestNew(t *testing.T) {
+	mux := NewMux(nil)
+	mux.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("users"))
+	})
+	server := New(":8080", mux)
+	assert.Equal(t, ":8080", server.Addr)
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	for _, path := range []string{"/livez", "/readyz", "/health"} {
+		assert.Equal(t, http.StatusOK, serve(path).Code, path)
+	}
+
+	users := serve("/users")
+	assert.Equal(t, "users", users.Body.String())
+	assert.NotEmpty(t, users.Header().Get(correlation.HeaderRequestID))
+
+	assert.Equal(t, http.StatusOK, serve("/metrics").Code)
+}
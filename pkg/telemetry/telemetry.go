@@ -0,0 +1,131 @@
+// Package telemetry sets up OpenTelemetry tracing for a service and
+// instruments the HTTP servers, HTTP clients and databases it uses.
+//
+// Spans are exported over OTLP/HTTP to OTEL_EXPORTER_OTLP_ENDPOINT (or the
+// other standard OTEL_EXPORTER_OTLP_* variables) by default. Set
+// OTEL_TRACES_EXPORTER to stdout to print them instead, or to none to
+// turn tracing off.
+package telemetry
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/XSAM/otelsql"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/gauss2302/microtest/pkg/health"
+)
+
+const tracerName = "github.com/gauss2302/microtest/pkg/telemetry"
+
+// Values of OTEL_TRACES_EXPORTER
+const (
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterNone   = "none"
+)
+
+// Setup installs the global tracer provider and trace context propagator
+// for service. The returned function flushes and stops the exporter.
+func Setup(ctx context.Context, service string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch name := os.Getenv("OTEL_TRACES_EXPORTER"); name {
+	case "", ExporterOTLP:
+		exporter, err = otlptracehttp.New(ctx)
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	default:
+		return nil, fmt.Errorf("unknown OTEL_TRACES_EXPORTER %q", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES take precedence
+	res, err := resource.Merge(
+		resource.NewSchemaless(semconv.ServiceName(service)),
+		resource.Environment(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Middleware starts a server span for every request but probes and
+// metrics scrapes, continuing the trace of the caller.
+func Middleware(next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, "http.server",
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			return !health.IsProbe(r.URL.Path) && r.URL.Path != "/metrics"
+		}),
+		otelhttp.WithSpanNameFormatter(func(operation string, r *http.Request) string {
+			return r.Method + " " + r.URL.Path
+		}),
+	)
+}
+
+// Transport starts a client span for every request sent through base, or
+// http.DefaultTransport if base is nil, and passes the trace on.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return otelhttp.NewTransport(base)
+}
+
+// OpenPostgres opens a Postgres database whose queries are traced.
+func OpenPostgres(dsn string) (*sql.DB, error) {
+	return otelsql.Open("postgres", dsn,
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{OmitConnResetSession: true, OmitRows: true}),
+	)
+}
+
+// StartClientSpan starts a span for a call of ctx to system, such as
+// memcached, that has no instrumentation of its own. The returned function
+// ends the span with the outcome of the call.
+func StartClientSpan(ctx context.Context, system, operation string) func(error) {
+	_, span := otel.Tracer(tracerName).Start(ctx, system+" "+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", system),
+			attribute.String("db.operation.name", operation),
+		),
+	)
+	return func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
@@ -0,0 +1,96 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// record installs a tracer provider that keeps finished spans in memory.
+func record(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		otel.SetTextMapPropagator(propagator)
+	})
+	return recorder
+}
+
+func TestSetup_None(t *testing.T) {
+	t.Setenv("OTEL_TRACES_EXPORTER", ExporterNone)
+	shutdown, err := Setup(context.Background(), "test")
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+
+	t.Setenv("OTEL_TRACES_EXPORTER", "zipkin")
+	_, err = Setup(context.Background(), "test")
+	assert.Error(t, err)
+}
+
+func TestMiddleware(t *testing.T) {
+	recorder := record(t)
+
+	// The client span's context reaches the upstream, whose server span
+	// continues the trace
+	var upstream trace.SpanContext
+	server := httptest.NewServer(Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/products" {
+			upstream = trace.SpanContextFromContext(r.Context())
+		}
+	})))
+	defer server.Close()
+
+	client := &http.Client{Transport: Transport(nil)}
+	for _, path := range []string{"/products", "/health"} {
+		resp, err := client.Get(server.URL + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	var names []string
+	for _, span := range recorder.Ended() {
+		names = append(names, span.Name())
+	}
+	assert.ElementsMatch(t, []string{"GET /products", "HTTP GET", "HTTP GET"}, names)
+	assert.True(t, upstream.IsValid())
+
+	for _, span := range recorder.Ended() {
+		if span.Name() == "GET /products" {
+			assert.Equal(t, trace.SpanKindServer, span.SpanKind())
+			assert.True(t, span.Parent().IsRemote())
+			assert.Equal(t, span.Parent().TraceID(), span.SpanContext().TraceID())
+		}
+	}
+}
+
+func TestStartClientSpan(t *testing.T) {
+	recorder := record(t)
+
+	StartClientSpan(context.Background(), "memcached", "get")(nil)
+	StartClientSpan(context.Background(), "memcached", "set")(errors.New("connection refused"))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "memcached get", spans[0].Name())
+	assert.Equal(t, trace.SpanKindClient, spans[0].SpanKind())
+	assert.Equal(t, codes.Unset, spans[0].Status().Code)
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+	assert.Equal(t, "connection refused", spans[1].Status().Description)
+}
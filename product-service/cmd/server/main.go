@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"time"
+
+	"github.com/gauss2302/microtest/pkg/database"
+	"github.com/gauss2302/microtest/pkg/health"
+	"github.com/gauss2302/microtest/pkg/lifecycle"
+	"github.com/gauss2302/microtest/pkg/logging"
+	"github.com/gauss2302/microtest/pkg/server"
+	"github.com/gauss2302/microtest/pkg/telemetry"
+	"github.com/gauss2302/microtest/product-service/config"
+	productHttp "github.com/gauss2302/microtest/product-service/internal/product/delivery/http"
+	"github.com/gauss2302/microtest/product-service/internal/product/repository/postgres"
+	"github.com/gauss2302/microtest/product-service/internal/product/usecase"
+)
+
+func main() {
+	// Load configuration
+	cfg := config.LoadConfig()
+	if err := logging.Setup("product-service", cfg.LogLevel, cfg.LogFormat); err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+	app := lifecycle.New(cfg.ShutdownTimeout)
+
+	shutdownTracing, err := telemetry.Setup(context.Background(), "product-service")
+	if err != nil {
+		logging.Fatal("Failed to set up tracing", "error", err)
+	}
+	app.OnShutdown("tracing", shutdownTracing)
+
+	// Run database migrations
+	if err := database.Migrate(cfg.Database(), "migrations"); err != nil {
+		logging.Fatal("Failed to run migrations", "error", err)
+	}
+
+	// Initialize database connection
+	dbConn, err := database.Open(cfg.Database())
+	if err != nil {
+		logging.Fatal("Failed to connect to the database", "error", err)
+	}
+	app.Close("database", dbConn)
+
+	// The service is ready while its database answers
+	checks := health.NewRegistry(5 * time.Second)
+	checks.Register("postgres", 2*time.Second, dbConn.PingContext)
+
+	// Initialize application layers
+	productRepo := postgres.NewProductRepository(dbConn)
+	productUsecase := usecase.NewProductUsecase(productRepo)
+	productHandler := productHttp.NewProductHandler(productUsecase)
+
+	mux := server.NewMux(checks)
+	mux.Handle("/", productHandler)
+
+	app.Serve(server.New(":"+cfg.ServerPort, mux))
+
+	slog.Info("Product Service running", "port", cfg.ServerPort)
+	if err := app.Run(context.Background()); err != nil {
+		logging.Fatal("Product Service failed", "error", err)
+	}
+}
@@ -1,31 +1,42 @@
 // pkg/config/config.go
 package config
 
-import "os"
+import (
+	"time"
+
+	sharedconfig "github.com/gauss2302/microtest/pkg/config"
+	"github.com/gauss2302/microtest/pkg/database"
+)
 
 type Config struct {
-	DBHost     string
-	DBPort     string
-	DBUser     string
-	DBPassword string
-	DBName     string
-	ServerPort string
+	DBHost     string `env:"DB_HOST" default:"localhost"`
+	DBPort     string `env:"DB_PORT" default:"5432"`
+	DBUser     string `env:"DB_USER" default:"postgres"`
+	DBPassword string `env:"DB_PASSWORD" required:"true" secret:"true"`
+	DBName     string `env:"DB_NAME" default:"productdb"`
+	ServerPort string `env:"SERVER_PORT" default:"8082"`
+	// How long in-flight requests get to finish on shutdown
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT" default:"15s"`
+	// Minimum level logged (debug, info, warn or error) and the format
+	// of log lines (json or text)
+	LogLevel  string `env:"LOG_LEVEL" default:"info" oneof:"debug info warn error"`
+	LogFormat string `env:"LOG_FORMAT" default:"json" oneof:"json text"`
 }
 
+// LoadConfig loads the configuration, exiting if it is invalid.
 func LoadConfig() *Config {
-	return &Config{
-		DBHost:     getEnv("DB_HOST", "localhost"),
-		DBPort:     getEnv("DB_PORT", "5432"),
-		DBUser:     getEnv("DB_USER", "postgres"),
-		DBPassword: getEnv("DB_PASSWORD", "postgres"),
-		DBName:     getEnv("DB_NAME", "productdb"),
-		ServerPort: getEnv("SERVER_PORT", "8082"),
-	}
+	cfg := &Config{}
+	sharedconfig.MustLoad(cfg)
+	return cfg
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// Database returns the settings of the service's database.
+func (c *Config) Database() database.Config {
+	return database.Config{
+		Host:     c.DBHost,
+		Port:     c.DBPort,
+		User:     c.DBUser,
+		Password: c.DBPassword,
+		Name:     c.DBName,
 	}
-	return defaultValue
 }
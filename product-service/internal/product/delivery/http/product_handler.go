@@ -2,11 +2,13 @@ package http
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
 
+	"github.com/gauss2302/microtest/pkg/metrics"
+	"github.com/gauss2302/microtest/pkg/respond"
 	"github.com/gauss2302/microtest/product-service/internal/entity"
 	"github.com/gauss2302/microtest/product-service/internal/product/usecase"
 )
@@ -20,23 +22,11 @@ func NewProductHandler(usecase usecase.ProductUsecase) *ProductHandler {
 }
 
 func (h *ProductHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// Add CORS headers
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-	// Handle preflight request
-	if r.Method == http.MethodOptions {
-		return
-	}
-
-	log.Printf("Product service received: %s %s", r.Method, r.URL.Path)
-
 	// Check the method
 	switch r.Method {
 	case http.MethodPost:
 		if strings.HasSuffix(r.URL.Path, "/products/") || strings.HasSuffix(r.URL.Path, "/products") {
-			log.Printf("Handling POST request for path: %s", r.URL.Path)
+			metrics.SetRoute(r, "/products")
 			h.CreateProduct(w, r)
 			return
 		}
@@ -44,56 +34,53 @@ func (h *ProductHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case http.MethodGet:
 		// For GET requests, check the path
 		if strings.HasSuffix(r.URL.Path, "/products/") || strings.HasSuffix(r.URL.Path, "/products") {
+			metrics.SetRoute(r, "/products")
 			h.GetProducts(w, r)
 			return
 		}
 		if strings.HasPrefix(r.URL.Path, "/products/") {
+			metrics.SetRoute(r, "/products/{id}")
 			h.GetProduct(w, r)
 			return
 		}
 
 	default:
-		log.Printf("Method %s not allowed for path: %s", r.Method, r.URL.Path)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		respond.Error(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 	}
 }
 
 func (h *ProductHandler) GetProducts(w http.ResponseWriter, r *http.Request) {
-	products, err := h.usecase.ListProducts(10, 0) // Временно хардкодим limit и offset
+	products, err := h.usecase.ListProducts(r.Context(), 10, 0) // Временно хардкодим limit и offset
 	if err != nil {
-		log.Printf("Error getting products: %v", err)
-		http.Error(w, "Failed to get products", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Error getting products", "error", err)
+		respond.Error(w, r, http.StatusInternalServerError, "Failed to get products")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(products); err != nil {
-		log.Printf("Error encoding response: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Error encoding response", "error", err)
+		respond.Error(w, r, http.StatusInternalServerError, "Internal server error")
 		return
 	}
 }
 
 func (h *ProductHandler) CreateProduct(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Starting CreateProduct handler")
-
 	var req entity.CreateProductRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.Printf("Error decoding request body: %v", err)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		slog.ErrorContext(r.Context(), "Error decoding request body", "error", err)
+		respond.Error(w, r, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
-	log.Printf("Decoded request: %+v", req)
-
-	product, err := h.usecase.CreateProduct(&req)
+	product, err := h.usecase.CreateProduct(r.Context(), &req)
 	if err != nil {
-		log.Printf("Error creating product: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Error creating product", "error", err)
+		respond.Error(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	log.Printf("Product created successfully: %+v", product)
+	slog.InfoContext(r.Context(), "Product created", "product_id", product.ID)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -104,23 +91,23 @@ func (h *ProductHandler) GetProduct(w http.ResponseWriter, r *http.Request) {
 	idStr := strings.TrimPrefix(r.URL.Path, "/products/")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		log.Printf("Invalid ID: %s", idStr)
-		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		slog.DebugContext(r.Context(), "Invalid product ID", "id", idStr)
+		respond.Error(w, r, http.StatusBadRequest, "Invalid product ID")
 		return
 	}
 
-	log.Printf("Getting product with ID: %d", id)
-	product, err := h.usecase.GetProduct(id)
+	slog.DebugContext(r.Context(), "Getting product", "product_id", id)
+	product, err := h.usecase.GetProduct(r.Context(), id)
 	if err != nil {
-		log.Printf("Error getting product: %v", err)
-		http.Error(w, "Product not found", http.StatusNotFound)
+		slog.ErrorContext(r.Context(), "Error getting product", "error", err)
+		respond.Error(w, r, http.StatusNotFound, "Product not found")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(product); err != nil {
-		log.Printf("Error encoding response: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Error encoding response", "error", err)
+		respond.Error(w, r, http.StatusInternalServerError, "Internal server error")
 		return
 	}
 }
@@ -128,19 +115,19 @@ func (h *ProductHandler) GetProduct(w http.ResponseWriter, r *http.Request) {
 func (h *ProductHandler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/products/"))
 	if err != nil {
-		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		respond.Error(w, r, http.StatusBadRequest, "Invalid product ID")
 		return
 	}
 
 	var req entity.UpdateProductRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		respond.Error(w, r, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
-	product, err := h.usecase.UpdateProduct(id, &req)
+	product, err := h.usecase.UpdateProduct(r.Context(), id, &req)
 	if err != nil {
-		http.Error(w, "Failed to update product", http.StatusInternalServerError)
+		respond.Error(w, r, http.StatusInternalServerError, "Failed to update product")
 		return
 	}
 
@@ -151,12 +138,12 @@ func (h *ProductHandler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 func (h *ProductHandler) DeleteProduct(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/products/"))
 	if err != nil {
-		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		respond.Error(w, r, http.StatusBadRequest, "Invalid product ID")
 		return
 	}
 
-	if err := h.usecase.DeleteProduct(id); err != nil {
-		http.Error(w, "Failed to delete product", http.StatusInternalServerError)
+	if err := h.usecase.DeleteProduct(r.Context(), id); err != nil {
+		respond.Error(w, r, http.StatusInternalServerError, "Failed to delete product")
 		return
 	}
 
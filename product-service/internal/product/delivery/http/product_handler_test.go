@@ -2,6 +2,7 @@ package http
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
@@ -19,7 +20,7 @@ type MockProductUsecase struct {
 	mock.Mock
 }
 
-func (m *MockProductUsecase) CreateProduct(req *entity.CreateProductRequest) (*entity.Product, error) {
+func (m *MockProductUsecase) CreateProduct(ctx context.Context, req *entity.CreateProductRequest) (*entity.Product, error) {
 	args := m.Called(req)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -27,7 +28,7 @@ func (m *MockProductUsecase) CreateProduct(req *entity.CreateProductRequest) (*e
 	return args.Get(0).(*entity.Product), args.Error(1)
 }
 
-func (m *MockProductUsecase) GetProduct(id int) (*entity.Product, error) {
+func (m *MockProductUsecase) GetProduct(ctx context.Context, id int) (*entity.Product, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -35,7 +36,7 @@ func (m *MockProductUsecase) GetProduct(id int) (*entity.Product, error) {
 	return args.Get(0).(*entity.Product), args.Error(1)
 }
 
-func (m *MockProductUsecase) ListProducts(limit, offset int) ([]*entity.Product, error) {
+func (m *MockProductUsecase) ListProducts(ctx context.Context, limit, offset int) ([]*entity.Product, error) {
 	args := m.Called(limit, offset)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -43,7 +44,7 @@ func (m *MockProductUsecase) ListProducts(limit, offset int) ([]*entity.Product,
 	return args.Get(0).([]*entity.Product), args.Error(1)
 }
 
-func (m *MockProductUsecase) UpdateProduct(id int, req *entity.UpdateProductRequest) (*entity.Product, error) {
+func (m *MockProductUsecase) UpdateProduct(ctx context.Context, id int, req *entity.UpdateProductRequest) (*entity.Product, error) {
 	args := m.Called(id, req)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -51,7 +52,7 @@ func (m *MockProductUsecase) UpdateProduct(id int, req *entity.UpdateProductRequ
 	return args.Get(0).(*entity.Product), args.Error(1)
 }
 
-func (m *MockProductUsecase) DeleteProduct(id int) error {
+func (m *MockProductUsecase) DeleteProduct(ctx context.Context, id int) error {
 	args := m.Called(id)
 	return args.Error(0)
 }
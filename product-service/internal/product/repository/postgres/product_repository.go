@@ -1,6 +1,7 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -17,14 +18,14 @@ func NewProductRepository(db *sql.DB) repository.ProductRepository {
 	return &productRepository{db: db}
 }
 
-func (r *productRepository) Create(req *entity.CreateProductRequest) (*entity.Product, error) {
+func (r *productRepository) Create(ctx context.Context, req *entity.CreateProductRequest) (*entity.Product, error) {
 	query := `
 		 INSERT INTO products (name, description, price)
 		 VALUES ($1, $2, $3)
 		 RETURNING id, name, description, price, created_at, updated_at`
 
 	product := &entity.Product{}
-	err := r.db.QueryRow(
+	err := r.db.QueryRowContext(ctx,
 		query,
 		req.Name,
 		req.Description,
@@ -45,14 +46,14 @@ func (r *productRepository) Create(req *entity.CreateProductRequest) (*entity.Pr
 	return product, nil
 }
 
-func (r *productRepository) GetByID(id int) (*entity.Product, error) {
+func (r *productRepository) GetByID(ctx context.Context, id int) (*entity.Product, error) {
 	query := `
 		 SELECT id, name, description, price, created_at, updated_at
 		 FROM products
 		 WHERE id = $1`
 
 	product := &entity.Product{}
-	err := r.db.QueryRow(query, id).Scan(
+	err := r.db.QueryRowContext(ctx, query, id).Scan(
 		&product.ID,
 		&product.Name,
 		&product.Description,
@@ -71,14 +72,14 @@ func (r *productRepository) GetByID(id int) (*entity.Product, error) {
 	return product, nil
 }
 
-func (r *productRepository) GetAll(limit, offset int) ([]*entity.Product, error) {
+func (r *productRepository) GetAll(ctx context.Context, limit, offset int) ([]*entity.Product, error) {
 	query := `
 		 SELECT id, name, description, price, created_at, updated_at
 		 FROM products
 		 ORDER BY id
 		 LIMIT $1 OFFSET $2`
 
-	rows, err := r.db.Query(query, limit, offset)
+	rows, err := r.db.QueryContext(ctx, query, limit, offset)
 	if err != nil {
 		return nil, fmt.Errorf("error getting products: %w", err)
 	}
@@ -104,9 +105,9 @@ func (r *productRepository) GetAll(limit, offset int) ([]*entity.Product, error)
 	return products, nil
 }
 
-func (r *productRepository) Update(id int, req *entity.UpdateProductRequest) (*entity.Product, error) {
+func (r *productRepository) Update(ctx context.Context, id int, req *entity.UpdateProductRequest) (*entity.Product, error) {
 	// First, get the current product
-	current, err := r.GetByID(id)
+	current, err := r.GetByID(ctx, id)
 	if err != nil {
 		return nil, err
 	}
@@ -129,7 +130,7 @@ func (r *productRepository) Update(id int, req *entity.UpdateProductRequest) (*e
 		 RETURNING id, name, description, price, created_at, updated_at`
 
 	product := &entity.Product{}
-	err = r.db.QueryRow(
+	err = r.db.QueryRowContext(ctx,
 		query,
 		current.Name,
 		current.Description,
@@ -151,10 +152,10 @@ func (r *productRepository) Update(id int, req *entity.UpdateProductRequest) (*e
 	return product, nil
 }
 
-func (r *productRepository) Delete(id int) error {
+func (r *productRepository) Delete(ctx context.Context, id int) error {
 	query := `DELETE FROM products WHERE id = $1`
 
-	result, err := r.db.Exec(query, id)
+	result, err := r.db.ExecContext(ctx, query, id)
 	if err != nil {
 		return fmt.Errorf("error deleting product: %w", err)
 	}
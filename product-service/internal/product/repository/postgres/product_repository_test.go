@@ -1,6 +1,7 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
 	"testing"
 	"time"
@@ -33,7 +34,7 @@ func TestProductRepository_Create(t *testing.T) {
 			WithArgs(req.Name, req.Description, req.Price).
 			WillReturnRows(rows)
 
-		product, err := repo.Create(req)
+		product, err := repo.Create(context.Background(), req)
 		assert.NoError(t, err)
 		assert.NotNil(t, product)
 		assert.Equal(t, req.Name, product.Name)
@@ -50,7 +51,7 @@ func TestProductRepository_Create(t *testing.T) {
 			WithArgs(req.Name, req.Description, req.Price).
 			WillReturnError(sql.ErrConnDone)
 
-		product, err := repo.Create(req)
+		product, err := repo.Create(context.Background(), req)
 		assert.Error(t, err)
 		assert.Nil(t, product)
 	})
@@ -74,7 +75,7 @@ func TestProductRepository_GetByID(t *testing.T) {
 			WithArgs(productID).
 			WillReturnRows(rows)
 
-		product, err := repo.GetByID(productID)
+		product, err := repo.GetByID(context.Background(), productID)
 		assert.NoError(t, err)
 		assert.NotNil(t, product)
 		assert.Equal(t, productID, product.ID)
@@ -87,7 +88,7 @@ func TestProductRepository_GetByID(t *testing.T) {
 			WithArgs(productID).
 			WillReturnError(sql.ErrNoRows)
 
-		product, err := repo.GetByID(productID)
+		product, err := repo.GetByID(context.Background(), productID)
 		assert.Error(t, err)
 		assert.Nil(t, product)
 		assert.Equal(t, "product not found", err.Error())
@@ -113,7 +114,7 @@ func TestProductRepository_GetAll(t *testing.T) {
 			WithArgs(limit, offset).
 			WillReturnRows(rows)
 
-		products, err := repo.GetAll(limit, offset)
+		products, err := repo.GetAll(context.Background(), limit, offset)
 		assert.NoError(t, err)
 		assert.Len(t, products, 2)
 	})
@@ -125,7 +126,7 @@ func TestProductRepository_GetAll(t *testing.T) {
 			WithArgs(limit, offset).
 			WillReturnError(sql.ErrConnDone)
 
-		products, err := repo.GetAll(limit, offset)
+		products, err := repo.GetAll(context.Background(), limit, offset)
 		assert.Error(t, err)
 		assert.Nil(t, products)
 	})
@@ -162,7 +163,7 @@ func TestProductRepository_Update(t *testing.T) {
 		mock.ExpectQuery("UPDATE products").
 			WillReturnRows(updateRows)
 
-		product, err := repo.Update(productID, req)
+		product, err := repo.Update(context.Background(), productID, req)
 		assert.NoError(t, err)
 		assert.NotNil(t, product)
 		assert.Equal(t, newName, product.Name)
@@ -179,7 +180,7 @@ func TestProductRepository_Update(t *testing.T) {
 			WithArgs(productID).
 			WillReturnError(sql.ErrNoRows)
 
-		product, err := repo.Update(productID, req)
+		product, err := repo.Update(context.Background(), productID, req)
 		assert.Error(t, err)
 		assert.Nil(t, product)
 	})
@@ -201,7 +202,7 @@ func TestProductRepository_Delete(t *testing.T) {
 			WithArgs(productID).
 			WillReturnResult(sqlmock.NewResult(0, 1))
 
-		err := repo.Delete(productID)
+		err := repo.Delete(context.Background(), productID)
 		assert.NoError(t, err)
 	})
 
@@ -212,7 +213,7 @@ func TestProductRepository_Delete(t *testing.T) {
 			WithArgs(productID).
 			WillReturnResult(sqlmock.NewResult(0, 0))
 
-		err := repo.Delete(productID)
+		err := repo.Delete(context.Background(), productID)
 		assert.Error(t, err)
 		assert.Equal(t, "product not found", err.Error())
 	})
@@ -1,11 +1,15 @@
 package repository
 
-import "github.com/gauss2302/microtest/product-service/internal/entity"
+import (
+	"context"
+
+	"github.com/gauss2302/microtest/product-service/internal/entity"
+)
 
 type ProductRepository interface {
-	Create(product *entity.CreateProductRequest) (*entity.Product, error)
-	GetByID(id int) (*entity.Product, error)
-	GetAll(limit, offset int) ([]*entity.Product, error)
-	Update(id int, product *entity.UpdateProductRequest) (*entity.Product, error)
-	Delete(id int) error
+	Create(ctx context.Context, product *entity.CreateProductRequest) (*entity.Product, error)
+	GetByID(ctx context.Context, id int) (*entity.Product, error)
+	GetAll(ctx context.Context, limit, offset int) ([]*entity.Product, error)
+	Update(ctx context.Context, id int, product *entity.UpdateProductRequest) (*entity.Product, error)
+	Delete(ctx context.Context, id int) error
 }
@@ -1,6 +1,7 @@
 package usecase
 
 import (
+	"context"
 	"github.com/gauss2302/microtest/product-service/internal/entity"
 	"github.com/gauss2302/microtest/product-service/internal/product/repository"
 )
@@ -13,25 +14,25 @@ func NewProductUsecase(repo repository.ProductRepository) ProductUsecase {
 	return &productUsecase{repo: repo}
 }
 
-func (u *productUsecase) CreateProduct(req *entity.CreateProductRequest) (*entity.Product, error) {
-	return u.repo.Create(req)
+func (u *productUsecase) CreateProduct(ctx context.Context, req *entity.CreateProductRequest) (*entity.Product, error) {
+	return u.repo.Create(ctx, req)
 }
 
-func (u *productUsecase) GetProduct(id int) (*entity.Product, error) {
-	return u.repo.GetByID(id)
+func (u *productUsecase) GetProduct(ctx context.Context, id int) (*entity.Product, error) {
+	return u.repo.GetByID(ctx, id)
 }
 
-func (u *productUsecase) ListProducts(limit, offset int) ([]*entity.Product, error) {
+func (u *productUsecase) ListProducts(ctx context.Context, limit, offset int) ([]*entity.Product, error) {
 	if limit <= 0 {
 		limit = 10 // default limit
 	}
-	return u.repo.GetAll(limit, offset)
+	return u.repo.GetAll(ctx, limit, offset)
 }
 
-func (u *productUsecase) UpdateProduct(id int, req *entity.UpdateProductRequest) (*entity.Product, error) {
-	return u.repo.Update(id, req)
+func (u *productUsecase) UpdateProduct(ctx context.Context, id int, req *entity.UpdateProductRequest) (*entity.Product, error) {
+	return u.repo.Update(ctx, id, req)
 }
 
-func (u *productUsecase) DeleteProduct(id int) error {
-	return u.repo.Delete(id)
+func (u *productUsecase) DeleteProduct(ctx context.Context, id int) error {
+	return u.repo.Delete(ctx, id)
 }
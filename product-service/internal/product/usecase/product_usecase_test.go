@@ -1,6 +1,7 @@
 package usecase
 
 import (
+	"context"
 	"errors"
 	"testing"
 	"time"
@@ -15,7 +16,7 @@ type MockProductRepository struct {
 	mock.Mock
 }
 
-func (m *MockProductRepository) Create(req *entity.CreateProductRequest) (*entity.Product, error) {
+func (m *MockProductRepository) Create(ctx context.Context, req *entity.CreateProductRequest) (*entity.Product, error) {
 	args := m.Called(req)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -23,7 +24,7 @@ func (m *MockProductRepository) Create(req *entity.CreateProductRequest) (*entit
 	return args.Get(0).(*entity.Product), args.Error(1)
 }
 
-func (m *MockProductRepository) GetByID(id int) (*entity.Product, error) {
+func (m *MockProductRepository) GetByID(ctx context.Context, id int) (*entity.Product, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -31,7 +32,7 @@ func (m *MockProductRepository) GetByID(id int) (*entity.Product, error) {
 	return args.Get(0).(*entity.Product), args.Error(1)
 }
 
-func (m *MockProductRepository) GetAll(limit, offset int) ([]*entity.Product, error) {
+func (m *MockProductRepository) GetAll(ctx context.Context, limit, offset int) ([]*entity.Product, error) {
 	args := m.Called(limit, offset)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -39,7 +40,7 @@ func (m *MockProductRepository) GetAll(limit, offset int) ([]*entity.Product, er
 	return args.Get(0).([]*entity.Product), args.Error(1)
 }
 
-func (m *MockProductRepository) Update(id int, req *entity.UpdateProductRequest) (*entity.Product, error) {
+func (m *MockProductRepository) Update(ctx context.Context, id int, req *entity.UpdateProductRequest) (*entity.Product, error) {
 	args := m.Called(id, req)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -47,7 +48,7 @@ func (m *MockProductRepository) Update(id int, req *entity.UpdateProductRequest)
 	return args.Get(0).(*entity.Product), args.Error(1)
 }
 
-func (m *MockProductRepository) Delete(id int) error {
+func (m *MockProductRepository) Delete(ctx context.Context, id int) error {
 	args := m.Called(id)
 	return args.Error(0)
 }
@@ -74,7 +75,7 @@ func TestProductUsecase_CreateProduct(t *testing.T) {
 
 		mockRepo.On("Create", req).Return(expected, nil).Once()
 
-		result, err := usecase.CreateProduct(req)
+		result, err := usecase.CreateProduct(context.Background(), req)
 		assert.NoError(t, err)
 		assert.Equal(t, expected, result)
 		mockRepo.AssertExpectations(t)
@@ -89,7 +90,7 @@ func TestProductUsecase_CreateProduct(t *testing.T) {
 
 		mockRepo.On("Create", req).Return(nil, errors.New("repository error")).Once()
 
-		result, err := usecase.CreateProduct(req)
+		result, err := usecase.CreateProduct(context.Background(), req)
 		assert.Error(t, err)
 		assert.Nil(t, result)
 		mockRepo.AssertExpectations(t)
@@ -113,7 +114,7 @@ func TestProductUsecase_GetProduct(t *testing.T) {
 
 		mockRepo.On("GetByID", productID).Return(expected, nil).Once()
 
-		result, err := usecase.GetProduct(productID)
+		result, err := usecase.GetProduct(context.Background(), productID)
 		assert.NoError(t, err)
 		assert.Equal(t, expected, result)
 		mockRepo.AssertExpectations(t)
@@ -123,7 +124,7 @@ func TestProductUsecase_GetProduct(t *testing.T) {
 		productID := 999
 		mockRepo.On("GetByID", productID).Return(nil, errors.New("not found")).Once()
 
-		result, err := usecase.GetProduct(productID)
+		result, err := usecase.GetProduct(context.Background(), productID)
 		assert.Error(t, err)
 		assert.Nil(t, result)
 		mockRepo.AssertExpectations(t)
@@ -143,7 +144,7 @@ func TestProductUsecase_ListProducts(t *testing.T) {
 
 		mockRepo.On("GetAll", limit, offset).Return(expected, nil).Once()
 
-		result, err := usecase.ListProducts(limit, offset)
+		result, err := usecase.ListProducts(context.Background(), limit, offset)
 		assert.NoError(t, err)
 		assert.Equal(t, expected, result)
 		mockRepo.AssertExpectations(t)
@@ -157,7 +158,7 @@ func TestProductUsecase_ListProducts(t *testing.T) {
 
 		mockRepo.On("GetAll", 10, offset).Return(expected, nil).Once() // Default limit should be 10
 
-		result, err := usecase.ListProducts(limit, offset)
+		result, err := usecase.ListProducts(context.Background(), limit, offset)
 		assert.NoError(t, err)
 		assert.Equal(t, expected, result)
 		mockRepo.AssertExpectations(t)
@@ -185,7 +186,7 @@ func TestProductUsecase_UpdateProduct(t *testing.T) {
 
 		mockRepo.On("Update", productID, req).Return(expected, nil).Once()
 
-		result, err := usecase.UpdateProduct(productID, req)
+		result, err := usecase.UpdateProduct(context.Background(), productID, req)
 		assert.NoError(t, err)
 		assert.Equal(t, expected, result)
 		mockRepo.AssertExpectations(t)
@@ -200,7 +201,7 @@ func TestProductUsecase_UpdateProduct(t *testing.T) {
 
 		mockRepo.On("Update", productID, req).Return(nil, errors.New("not found")).Once()
 
-		result, err := usecase.UpdateProduct(productID, req)
+		result, err := usecase.UpdateProduct(context.Background(), productID, req)
 		assert.Error(t, err)
 		assert.Nil(t, result)
 		mockRepo.AssertExpectations(t)
@@ -215,7 +216,7 @@ func TestProductUsecase_DeleteProduct(t *testing.T) {
 		productID := 1
 		mockRepo.On("Delete", productID).Return(nil).Once()
 
-		err := usecase.DeleteProduct(productID)
+		err := usecase.DeleteProduct(context.Background(), productID)
 		assert.NoError(t, err)
 		mockRepo.AssertExpectations(t)
 	})
@@ -224,7 +225,7 @@ func TestProductUsecase_DeleteProduct(t *testing.T) {
 		productID := 999
 		mockRepo.On("Delete", productID).Return(errors.New("not found")).Once()
 
-		err := usecase.DeleteProduct(productID)
+		err := usecase.DeleteProduct(context.Background(), productID)
 		assert.Error(t, err)
 		mockRepo.AssertExpectations(t)
 	})
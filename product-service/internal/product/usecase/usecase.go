@@ -1,11 +1,15 @@
 package usecase
 
-import "github.com/gauss2302/microtest/product-service/internal/entity"
+import (
+	"context"
+
+	"github.com/gauss2302/microtest/product-service/internal/entity"
+)
 
 type ProductUsecase interface {
-	CreateProduct(req *entity.CreateProductRequest) (*entity.Product, error)
-	GetProduct(id int) (*entity.Product, error)
-	ListProducts(limit, offset int) ([]*entity.Product, error)
-	UpdateProduct(id int, req *entity.UpdateProductRequest) (*entity.Product, error)
-	DeleteProduct(id int) error
+	CreateProduct(ctx context.Context, req *entity.CreateProductRequest) (*entity.Product, error)
+	GetProduct(ctx context.Context, id int) (*entity.Product, error)
+	ListProducts(ctx context.Context, limit, offset int) ([]*entity.Product, error)
+	UpdateProduct(ctx context.Context, id int, req *entity.UpdateProductRequest) (*entity.Product, error)
+	DeleteProduct(ctx context.Context, id int) error
 }
@@ -1,30 +0,0 @@
-package db
-
-import (
-	"fmt"
-	"log"
-
-	"github.com/golang-migrate/migrate/v4"
-	_ "github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
-)
-
-func RunMigrations(host, port, user, password, dbname string) error {
-	dbURL := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable",
-		user, password, host, port, dbname)
-
-	m, err := migrate.New(
-		"file://./migrations",
-		dbURL)
-	if err != nil {
-		return fmt.Errorf("failed to create migrate instance: %v", err)
-	}
-	defer m.Close()
-
-	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
-		return fmt.Errorf("failed to run migrations: %v", err)
-	}
-
-	log.Println("Migrations completed successfully")
-	return nil
-}
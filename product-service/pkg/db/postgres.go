@@ -1,26 +0,0 @@
-package db
-
-import (
-	"database/sql"
-	"fmt"
-	"log"
-
-	_ "github.com/lib/pq"
-)
-
-func NewPostgresDB(host, port, user, password, dbname string) (*sql.DB, error) {
-	psqlInfo := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		host, port, user, password, dbname)
-
-	db, err := sql.Open("postgres", psqlInfo)
-	if err != nil {
-		return nil, err
-	}
-
-	if err = db.Ping(); err != nil {
-		log.Printf("Could not connect to the PostgreSQL database: %v", err)
-		return nil, err
-	}
-
-	return db, nil
-}
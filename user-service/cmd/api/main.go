@@ -1,103 +1,106 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"time"
+
+	"github.com/gauss2302/microtest/pkg/database"
+	"github.com/gauss2302/microtest/pkg/health"
+	"github.com/gauss2302/microtest/pkg/lifecycle"
+	"github.com/gauss2302/microtest/pkg/logging"
+	"github.com/gauss2302/microtest/pkg/server"
+	"github.com/gauss2302/microtest/pkg/telemetry"
 	userHttp "github.com/gauss2302/microtest/user-service/internal/user/delivery/http"
 	"github.com/gauss2302/microtest/user-service/internal/user/repository/postgres"
 	"github.com/gauss2302/microtest/user-service/internal/user/usecase"
+	"github.com/gauss2302/microtest/user-service/internal/user/verification"
 	"github.com/gauss2302/microtest/user-service/pkg/config"
-	"github.com/gauss2302/microtest/user-service/pkg/db"
-
-	"log"
-	"net/http"
-	"time"
+	"github.com/gauss2302/microtest/user-service/pkg/password"
 )
 
 func main() {
 	// Load configuration
 	cfg := config.LoadConfig()
+	if err := logging.Setup("user-service", cfg.LogLevel, cfg.LogFormat); err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+	app := lifecycle.New(cfg.ShutdownTimeout)
+
+	shutdownTracing, err := telemetry.Setup(context.Background(), "user-service")
+	if err != nil {
+		logging.Fatal("Failed to set up tracing", "error", err)
+	}
+	app.OnShutdown("tracing", shutdownTracing)
 
 	// Run database migrations
-	if err := db.RunMigrations(
-		cfg.DBHost,
-		cfg.DBPort,
-		cfg.DBUser,
-		cfg.DBPassword,
-		cfg.DBName,
-	); err != nil {
-		log.Fatalf("Failed to run migrations: %v", err)
+	if err := database.Migrate(cfg.Database(), "pkg/db/migrations"); err != nil {
+		logging.Fatal("Failed to run migrations", "error", err)
 	}
 
 	// Initialize database connection
-	dbConn, err := db.NewPostgresDB(
-		cfg.DBHost,
-		cfg.DBPort,
-		cfg.DBUser,
-		cfg.DBPassword,
-		cfg.DBName,
-	)
+	dbConn, err := database.Open(cfg.Database())
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal("Failed to connect to the database", "error", err)
 	}
-	defer dbConn.Close()
+	app.Close("database", dbConn)
+
+	// The service is ready while its database answers
+	checks := health.NewRegistry(5 * time.Second)
+	checks.Register("postgres", 2*time.Second, dbConn.PingContext)
 
 	//Initialize application layers
 	userRepo := postgres.NewUserRepository(dbConn)
-	userUsecase := usecase.NewUserUsecase(userRepo)
+	policy := &password.Policy{
+		MinLength:     cfg.PasswordMinLength,
+		RequireUpper:  cfg.PasswordRequireUpper,
+		RequireLower:  cfg.PasswordRequireLower,
+		RequireDigit:  cfg.PasswordRequireDigit,
+		RequireSymbol: cfg.PasswordRequireSymbol,
+	}
+	if cfg.BreachedPasswordsFile != "" {
+		policy.Breached, err = password.LoadBreachedList(cfg.BreachedPasswordsFile)
+		if err != nil {
+			logging.Fatal("Failed to load breached passwords", "error", err)
+		}
+	}
+	hasher, err := newPasswordHasher(cfg)
+	if err != nil {
+		logging.Fatal("Failed to configure password hashing", "error", err)
+	}
+	userUsecase := usecase.NewUserUsecase(userRepo, policy, hasher, verification.NewSender(cfg.AuthServiceURL))
 	userHandler := userHttp.NewUserHandler(userUsecase)
 
-	//userHandler := userHttp.NewUserHandler(userUsecase)
-
 	// Set up HTTP server with middleware
-	mux := http.NewServeMux()
-
-	// Health check handler with middleware
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("ok"))
-	})
-
-	// User handler
+	mux := server.NewMux(checks)
 	mux.Handle("/", userHandler)
 
-	server := &http.Server{
-		Addr:         ":" + cfg.ServerPort,
-		Handler:      mux,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
-	}
+	app.Serve(server.New(":"+cfg.ServerPort, mux))
 
-	log.Printf("User Service running on port %s", cfg.ServerPort)
-	if err := server.ListenAndServe(); err != nil {
-		log.Fatal(err)
+	slog.Info("User Service running", "port", cfg.ServerPort)
+	if err := app.Run(context.Background()); err != nil {
+		logging.Fatal("User Service failed", "error", err)
 	}
 }
 
-//package main
-//
-//import (
-//	"fmt"
-//	"log"
-//	"net/http"
-//)
-//
-//func main() {
-//	http.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
-//		switch r.Method {
-//		case http.MethodGet:
-//			// Handle GET request
-//			fmt.Fprintf(w, "Get Users")
-//		case http.MethodPost:
-//			// Handle POST request
-//			fmt.Fprintf(w, "Create User")
-//		default:
-//			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-//		}
-//	})
-//
-//	port := ":8083"
-//	fmt.Printf("User service is running on port %s\n", port)
-//	if err := http.ListenAndServe(port, nil); err != nil {
-//		log.Fatalf("Failed to start server: %v", err)
-//	}
-//}
+// newPasswordHasher hashes with the configured algorithm and keeps
+// verifying the other one, so existing users migrate as they log in.
+func newPasswordHasher(cfg *config.Config) (password.Hasher, error) {
+	bcryptHasher := password.NewBcryptHasher(cfg.BcryptCost)
+	argon2idHasher := password.NewArgon2idHasher(
+		uint32(cfg.Argon2Memory),
+		uint32(cfg.Argon2Iterations),
+		uint8(cfg.Argon2Parallelism),
+	)
+
+	switch cfg.PasswordHasher {
+	case password.AlgorithmArgon2id:
+		return password.NewMigratingHasher(argon2idHasher, bcryptHasher), nil
+	case password.AlgorithmBcrypt:
+		return password.NewMigratingHasher(bcryptHasher, argon2idHasher), nil
+	default:
+		return nil, fmt.Errorf("unknown password hasher %q", cfg.PasswordHasher)
+	}
+}
@@ -2,19 +2,25 @@ package entity
 
 import "time"
 
+// RoleUser is assigned to every newly registered account.
+const RoleUser = "user"
+
 type User struct {
-	ID           int       `json:"id"`
-	Username     string    `json:"username"`
-	Email        string    `json:"email"`
-	PasswordHash string    `json:"-"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID              int        `json:"id"`
+	Username        string     `json:"username"`
+	Email           string     `json:"email"`
+	PasswordHash    string     `json:"-"`
+	Roles           []string   `json:"roles"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
 }
 
 type CreateUserRequest struct {
 	Username string `json:"username" validate:"required"`
 	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"required,min=6"`
+	// Checked against the configured password policy
+	Password string `json:"password" validate:"required"`
 }
 
 type UpdateUserRequest struct {
@@ -22,3 +28,21 @@ type UpdateUserRequest struct {
 	Email    *string `json:"email,omitempty"`
 	Password *string `json:"password,omitempty"`
 }
+
+// LinkIdentityRequest links an account at an external identity provider to
+// a local user. Only identities with a verified email may be linked.
+type LinkIdentityRequest struct {
+	Provider      string `json:"provider" validate:"required"`
+	Subject       string `json:"subject" validate:"required"`
+	Email         string `json:"email" validate:"required,email"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+// MFASettings holds a user's second factors. Recovery codes are stored as
+// SHA-256 hashes; auth-service owns their generation and verification.
+type MFASettings struct {
+	UserID        int      `json:"user_id"`
+	TOTPSecret    string   `json:"totp_secret"`
+	TOTPEnabled   bool     `json:"totp_enabled"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
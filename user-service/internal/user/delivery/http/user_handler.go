@@ -2,14 +2,29 @@ package http
 
 import (
 	"encoding/json"
-	"github.com/gauss2302/microtest/user-service/internal/entity"
-	"github.com/gauss2302/microtest/user-service/internal/user/usecase"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
+
+	"github.com/gauss2302/microtest/pkg/metrics"
+	"github.com/gauss2302/microtest/pkg/respond"
+	"github.com/gauss2302/microtest/user-service/internal/entity"
+	"github.com/gauss2302/microtest/user-service/internal/user/usecase"
+	"github.com/gauss2302/microtest/user-service/pkg/password"
+)
+
+// Headers the gateway sets for the user a request was authenticated as
+const (
+	headerUserID    = "X-User-ID"
+	headerUserRoles = "X-User-Roles"
 )
 
+// adminRole may change and delete any account
+const adminRole = "admin"
+
 type UserHandler struct {
 	usecase usecase.UserUsecase // Now it can find the interface
 }
@@ -21,8 +36,6 @@ func NewUserHandler(usecase usecase.UserUsecase) *UserHandler {
 }
 
 func (h *UserHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Received request: %s %s", r.Method, r.URL.Path)
-
 	path := r.URL.Path
 	if strings.HasSuffix(path, "/") {
 		path = path[:len(path)-1]
@@ -30,34 +43,63 @@ func (h *UserHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	switch {
 	case r.Method == http.MethodPost && path == "/users":
+		metrics.SetRoute(r, "/users")
 		h.CreateUser(w, r)
+	case r.Method == http.MethodPost && path == "/users/identities":
+		metrics.SetRoute(r, "/users/identities")
+		h.LinkIdentity(w, r)
+	case r.Method == http.MethodPost && strings.HasPrefix(path, "/users/") && strings.HasSuffix(path, "/verify-email"):
+		metrics.SetRoute(r, "/users/{id}/verify-email")
+		h.MarkEmailVerified(w, r)
 	case r.Method == http.MethodGet && path == "/users":
+		metrics.SetRoute(r, "/users")
 		h.ListUsers(w, r)
+	case r.Method == http.MethodDelete && strings.HasPrefix(path, "/users/") && strings.Contains(path, "/mfa/recovery-codes/"):
+		metrics.SetRoute(r, "/users/{id}/mfa/recovery-codes/{hash}")
+		h.ConsumeRecoveryCode(w, r)
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/users/") && strings.HasSuffix(path, "/mfa"):
+		metrics.SetRoute(r, "/users/{id}/mfa")
+		h.GetMFASettings(w, r)
+	case r.Method == http.MethodPut && strings.HasPrefix(path, "/users/") && strings.HasSuffix(path, "/mfa"):
+		metrics.SetRoute(r, "/users/{id}/mfa")
+		h.SaveMFASettings(w, r)
+	case r.Method == http.MethodPut && strings.HasPrefix(path, "/users/") && strings.HasSuffix(path, "/password"):
+		metrics.SetRoute(r, "/users/{id}/password")
+		h.SetPassword(w, r)
 	case r.Method == http.MethodGet && strings.HasPrefix(path, "/users/"):
+		metrics.SetRoute(r, "/users/{id}")
 		h.GetUser(w, r)
 	case r.Method == http.MethodPut && strings.HasPrefix(path, "/users/"):
+		metrics.SetRoute(r, "/users/{id}")
 		h.UpdateUser(w, r)
 	case r.Method == http.MethodDelete && strings.HasPrefix(path, "/users/"):
+		metrics.SetRoute(r, "/users/{id}")
 		h.DeleteUser(w, r)
 	case r.Method == http.MethodPost && path == "/users/verify":
+		metrics.SetRoute(r, "/users/verify")
 		h.VerifyCredentials(w, r)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		respond.Error(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 	}
 }
 
 func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	var req entity.CreateUserRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.Printf("Error decoding request: %v", err)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		slog.ErrorContext(r.Context(), "Error decoding request", "error", err)
+		respond.Error(w, r, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
-	user, err := h.usecase.CreateUser(&req)
+	user, err := h.usecase.CreateUser(r.Context(), &req)
+	var policyErr *password.PolicyError
+	if errors.As(err, &policyErr) {
+		respond.Error(w, r, http.StatusUnprocessableEntity, policyErr.Error())
+		return
+	}
 	if err != nil {
-		log.Printf("Error creating user: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Error creating user", "error", err)
+		respond.Error(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -70,14 +112,14 @@ func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
 	idStr := strings.TrimPrefix(r.URL.Path, "/users/")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		respond.Error(w, r, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
 
-	user, err := h.usecase.GetUserByID(id)
+	user, err := h.usecase.GetUserByID(r.Context(), id)
 	if err != nil {
-		log.Printf("Error getting user: %v", err)
-		http.Error(w, "User not found", http.StatusNotFound)
+		slog.ErrorContext(r.Context(), "Error getting user", "error", err)
+		respond.Error(w, r, http.StatusNotFound, "User not found")
 		return
 	}
 
@@ -86,13 +128,18 @@ func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *UserHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	if email := r.URL.Query().Get("email"); email != "" {
+		h.findUserByEmail(w, r, email)
+		return
+	}
+
 	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
 	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
 
-	users, err := h.usecase.ListUsers(limit, offset)
+	users, err := h.usecase.ListUsers(r.Context(), limit, offset)
 	if err != nil {
-		log.Printf("Error listing users: %v", err)
-		http.Error(w, "Failed to get users", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Error listing users", "error", err)
+		respond.Error(w, r, http.StatusInternalServerError, "Failed to get users")
 		return
 	}
 
@@ -100,24 +147,45 @@ func (h *UserHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(users)
 }
 
+// findUserByEmail answers GET /users?email= with a list of at most one
+// user, so a miss is an empty list rather than an error.
+func (h *UserHandler) findUserByEmail(w http.ResponseWriter, r *http.Request, email string) {
+	users := []*entity.User{}
+	if user, err := h.usecase.GetUserByEmail(r.Context(), email); err == nil {
+		users = append(users, user)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
 func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	idStr := strings.TrimPrefix(r.URL.Path, "/users/")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		respond.Error(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	if !mayModify(r, id) {
+		respond.Error(w, r, http.StatusForbidden, "Not allowed to modify this user")
 		return
 	}
 
 	var req entity.UpdateUserRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		respond.Error(w, r, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
-	user, err := h.usecase.UpdateUser(id, &req)
+	user, err := h.usecase.UpdateUser(r.Context(), id, &req)
+	var policyErr *password.PolicyError
+	if errors.As(err, &policyErr) {
+		respond.Error(w, r, http.StatusUnprocessableEntity, policyErr.Error())
+		return
+	}
 	if err != nil {
-		log.Printf("Error updating user: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Error updating user", "error", err)
+		respond.Error(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -129,33 +197,199 @@ func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	idStr := strings.TrimPrefix(r.URL.Path, "/users/")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		respond.Error(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	if !mayModify(r, id) {
+		respond.Error(w, r, http.StatusForbidden, "Not allowed to delete this user")
+		return
+	}
+
+	if err := h.usecase.DeleteUser(r.Context(), id); err != nil {
+		slog.ErrorContext(r.Context(), "Error deleting user", "error", err)
+		respond.Error(w, r, http.StatusInternalServerError, "Failed to delete user")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetPassword sets a user's password for auth-service's password reset,
+// which has proven the request through the emailed token. The gateway
+// does not expose it.
+func (h *UserHandler) SetPassword(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	id, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(path, "/users/"), "/password"))
+	if err != nil {
+		respond.Error(w, r, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
 
-	if err := h.usecase.DeleteUser(id); err != nil {
-		log.Printf("Error deleting user: %v", err)
-		http.Error(w, "Failed to delete user", http.StatusInternalServerError)
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Password == "" {
+		respond.Error(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	_, err = h.usecase.UpdateUser(r.Context(), id, &entity.UpdateUserRequest{Password: &req.Password})
+	var policyErr *password.PolicyError
+	if errors.As(err, &policyErr) {
+		respond.Error(w, r, http.StatusUnprocessableEntity, policyErr.Error())
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error setting password", "error", err)
+		respond.Error(w, r, http.StatusInternalServerError, "Failed to set password")
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// mayModify reports whether the user the gateway authenticated may change
+// or delete the account id: its owner or an admin. Requests without a user,
+// such as those of OAuth clients, may not.
+func mayModify(r *http.Request, id int) bool {
+	if r.Header.Get(headerUserID) == strconv.Itoa(id) {
+		return true
+	}
+	return slices.Contains(strings.Split(r.Header.Get(headerUserRoles), ","), adminRole)
+}
+
 func (h *UserHandler) VerifyCredentials(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		respond.Error(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	user, err := h.usecase.VerifyCredentials(r.Context(), req.Email, req.Password)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error verifying credentials", "error", err)
+		respond.Error(w, r, http.StatusUnauthorized, "Invalid credentials")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+func (h *UserHandler) LinkIdentity(w http.ResponseWriter, r *http.Request) {
+	var req entity.LinkIdentityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respond.Error(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if req.Provider == "" || req.Subject == "" || req.Email == "" {
+		respond.Error(w, r, http.StatusBadRequest, "provider, subject and email are required")
+		return
+	}
+
+	user, err := h.usecase.LinkIdentity(r.Context(), &req)
+	if errors.Is(err, usecase.ErrUnverifiedAccount) {
+		respond.Error(w, r, http.StatusConflict, "An account with this email exists but has not verified it")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error linking identity", "error", err)
+		respond.Error(w, r, http.StatusUnprocessableEntity, "Failed to link identity")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+func (h *UserHandler) GetMFASettings(w http.ResponseWriter, r *http.Request) {
+	id, err := mfaUserID(r.URL.Path)
+	if err != nil {
+		respond.Error(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	settings, err := h.usecase.GetMFASettings(r.Context(), id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error getting mfa settings", "error", err)
+		respond.Error(w, r, http.StatusNotFound, "User not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+func (h *UserHandler) SaveMFASettings(w http.ResponseWriter, r *http.Request) {
+	id, err := mfaUserID(r.URL.Path)
+	if err != nil {
+		respond.Error(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	var settings entity.MFASettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		respond.Error(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := h.usecase.SaveMFASettings(r.Context(), id, &settings); err != nil {
+		slog.ErrorContext(r.Context(), "Error saving mfa settings", "error", err)
+		respond.Error(w, r, http.StatusInternalServerError, "Failed to save mfa settings")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ConsumeRecoveryCode removes a recovery code hash from the user's codes.
+// It answers 404 when the user has no such code, e.g. because it was just
+// redeemed by another request.
+func (h *UserHandler) ConsumeRecoveryCode(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(strings.TrimSuffix(r.URL.Path, "/"), "/users/")
+	userID, hash, _ := strings.Cut(rest, "/mfa/recovery-codes/")
+	id, err := strconv.Atoi(userID)
+	if err != nil || hash == "" || strings.Contains(hash, "/") {
+		respond.Error(w, r, http.StatusBadRequest, "Invalid recovery code")
+		return
+	}
+
+	consumed, err := h.usecase.ConsumeRecoveryCode(r.Context(), id, hash)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error consuming recovery code", "error", err)
+		respond.Error(w, r, http.StatusInternalServerError, "Failed to consume recovery code")
+		return
+	}
+	if !consumed {
+		respond.Error(w, r, http.StatusNotFound, "Recovery code not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// mfaUserID extracts {id} from /users/{id}/mfa.
+func mfaUserID(path string) (int, error) {
+	path = strings.TrimSuffix(path, "/")
+	return strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(path, "/users/"), "/mfa"))
+}
+
+func (h *UserHandler) MarkEmailVerified(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	id, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(path, "/users/"), "/verify-email"))
+	if err != nil {
+		respond.Error(w, r, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
 
-	user, err := h.usecase.VerifyCredentials(req.Email, req.Password)
+	user, err := h.usecase.MarkEmailVerified(r.Context(), id)
 	if err != nil {
-		log.Printf("Error verifying credentials: %v", err)
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		slog.ErrorContext(r.Context(), "Error marking email verified", "error", err)
+		respond.Error(w, r, http.StatusNotFound, "User not found")
 		return
 	}
 
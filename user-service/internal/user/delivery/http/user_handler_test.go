@@ -0,0 +1,45 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMayModify(t *testing.T) {
+	tests := []struct {
+		name    string
+		userID  string
+		roles   string
+		allowed bool
+	}{
+		{"owner", "6", "user", true},
+		{"another user", "7", "user", false},
+		{"admin", "7", "user,admin", true},
+		{"no user", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPut, "/users/6", nil)
+			r.Header.Set(headerUserID, tt.userID)
+			r.Header.Set(headerUserRoles, tt.roles)
+			assert.Equal(t, tt.allowed, mayModify(r, 6))
+		})
+	}
+}
+
+func TestUserHandler_ForbidsOtherUsers(t *testing.T) {
+	// The usecase is never reached
+	handler := NewUserHandler(nil)
+
+	for _, method := range []string{http.MethodPut, http.MethodDelete} {
+		r := httptest.NewRequest(method, "/users/6", nil)
+		r.Header.Set(headerUserID, "7")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		assert.Equal(t, http.StatusForbidden, rec.Code, method)
+	}
+}
@@ -1,10 +1,12 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
-	"errors"
 	"fmt"
 	"github.com/gauss2302/microtest/user-service/internal/entity"
+	"github.com/gauss2302/microtest/user-service/internal/user/repository"
+	"github.com/lib/pq"
 )
 
 type UserRepository struct {
@@ -15,22 +17,25 @@ func NewUserRepository(db *sql.DB) *UserRepository {
 	return &UserRepository{db: db}
 }
 
-func (r *UserRepository) CreateUser(user *entity.User) (*entity.User, error) {
+func (r *UserRepository) CreateUser(ctx context.Context, user *entity.User) (*entity.User, error) {
 
-	query := `INSERT INTO users (username, email, password_hash)
-        VALUES ($1, $2, $3)
-        RETURNING id, username, email, password_hash, created_at, updated_at`
+	query := `INSERT INTO users (username, email, password_hash, roles)
+        VALUES ($1, $2, $3, $4)
+        RETURNING id, username, email, password_hash, roles, email_verified_at, created_at, updated_at`
 
-	err := r.db.QueryRow(
+	err := r.db.QueryRowContext(ctx,
 		query,
 		user.Username,
 		user.Email,
 		user.PasswordHash,
+		pq.Array(user.Roles),
 	).Scan(
 		&user.ID,
 		&user.Username,
 		&user.Email,
 		&user.PasswordHash,
+		pq.Array(&user.Roles),
+		&user.EmailVerifiedAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -42,23 +47,25 @@ func (r *UserRepository) CreateUser(user *entity.User) (*entity.User, error) {
 	return user, nil
 }
 
-func (r *UserRepository) GetUserByID(id int) (*entity.User, error) {
+func (r *UserRepository) GetUserByID(ctx context.Context, id int) (*entity.User, error) {
 	query := `
-        SELECT id, username, email, password_hash, created_at, updated_at
+        SELECT id, username, email, password_hash, roles, email_verified_at, created_at, updated_at
         FROM users
         WHERE id = $1`
 
 	user := &entity.User{}
-	err := r.db.QueryRow(query, id).Scan(
+	err := r.db.QueryRowContext(ctx, query, id).Scan(
 		&user.ID,
 		&user.Username,
 		&user.Email,
 		&user.PasswordHash,
+		pq.Array(&user.Roles),
+		&user.EmailVerifiedAt,
 		&user.CreatedAt,
 		&user.UpdatedAt)
 
-	if err != sql.ErrNoRows {
-		return nil, fmt.Errorf("not found; error getting user: %w", err)
+	if err == sql.ErrNoRows {
+		return nil, repository.ErrNotFound
 	}
 
 	if err != nil {
@@ -67,24 +74,26 @@ func (r *UserRepository) GetUserByID(id int) (*entity.User, error) {
 	return user, nil
 }
 
-func (r *UserRepository) GetUserByEmail(email string) (*entity.User, error) {
+func (r *UserRepository) GetUserByEmail(ctx context.Context, email string) (*entity.User, error) {
 	query := `
-        SELECT id, username, email, password_hash, created_at, updated_at
+        SELECT id, username, email, password_hash, roles, email_verified_at, created_at, updated_at
         FROM users
         WHERE email = $1`
 
 	user := &entity.User{}
-	err := r.db.QueryRow(query, email).Scan(
+	err := r.db.QueryRowContext(ctx, query, email).Scan(
 		&user.ID,
 		&user.Username,
 		&user.Email,
 		&user.PasswordHash,
+		pq.Array(&user.Roles),
+		&user.EmailVerifiedAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
 
 	if err == sql.ErrNoRows {
-		return nil, errors.New("user not found")
+		return nil, repository.ErrNotFound
 	}
 	if err != nil {
 		return nil, fmt.Errorf("error getting user: %w", err)
@@ -93,30 +102,33 @@ func (r *UserRepository) GetUserByEmail(email string) (*entity.User, error) {
 	return user, nil
 }
 
-func (r *UserRepository) UpdateUser(user *entity.User) (*entity.User, error) {
+func (r *UserRepository) UpdateUser(ctx context.Context, user *entity.User) (*entity.User, error) {
 	query := `
         UPDATE users 
-        SET username = $1, email = $2, password_hash = $3
-        WHERE id = $4
-        RETURNING id, username, email, password_hash, created_at, updated_at`
+        SET username = $1, email = $2, password_hash = $3, email_verified_at = $4
+        WHERE id = $5
+        RETURNING id, username, email, password_hash, roles, email_verified_at, created_at, updated_at`
 
-	err := r.db.QueryRow(
+	err := r.db.QueryRowContext(ctx,
 		query,
 		user.Username,
 		user.Email,
 		user.PasswordHash,
+		user.EmailVerifiedAt,
 		user.ID,
 	).Scan(
 		&user.ID,
 		&user.Username,
 		&user.Email,
 		&user.PasswordHash,
+		pq.Array(&user.Roles),
+		&user.EmailVerifiedAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
 
 	if err == sql.ErrNoRows {
-		return nil, errors.New("user not found")
+		return nil, repository.ErrNotFound
 	}
 	if err != nil {
 		return nil, fmt.Errorf("error updating user: %w", err)
@@ -125,10 +137,22 @@ func (r *UserRepository) UpdateUser(user *entity.User) (*entity.User, error) {
 	return user, nil
 }
 
-func (r *UserRepository) DeleteUser(id int) error {
+// UpdatePasswordHash replaces the stored hash without touching the rest of
+// the profile, for rehashing on login.
+func (r *UserRepository) UpdatePasswordHash(ctx context.Context, id int, passwordHash string) error {
+	query := `UPDATE users SET password_hash = $1 WHERE id = $2`
+
+	if _, err := r.db.ExecContext(ctx, query, passwordHash, id); err != nil {
+		return fmt.Errorf("error updating password hash: %w", err)
+	}
+
+	return nil
+}
+
+func (r *UserRepository) DeleteUser(ctx context.Context, id int) error {
 	query := `DELETE FROM users WHERE id = $1`
 
-	result, err := r.db.Exec(query, id)
+	result, err := r.db.ExecContext(ctx, query, id)
 	if err != nil {
 		return fmt.Errorf("error deleting user: %w", err)
 	}
@@ -139,20 +163,20 @@ func (r *UserRepository) DeleteUser(id int) error {
 	}
 
 	if rowsAffected == 0 {
-		return errors.New("user not found")
+		return repository.ErrNotFound
 	}
 
 	return nil
 }
 
-func (r *UserRepository) ListUsers(limit, offset int) ([]*entity.User, error) {
+func (r *UserRepository) ListUsers(ctx context.Context, limit, offset int) ([]*entity.User, error) {
 	query := `
-        SELECT id, username, email, password_hash, created_at, updated_at
+        SELECT id, username, email, password_hash, roles, email_verified_at, created_at, updated_at
         FROM users
         ORDER BY id
         LIMIT $1 OFFSET $2`
 
-	rows, err := r.db.Query(query, limit, offset)
+	rows, err := r.db.QueryContext(ctx, query, limit, offset)
 	if err != nil {
 		return nil, fmt.Errorf("error listing users: %w", err)
 	}
@@ -166,6 +190,8 @@ func (r *UserRepository) ListUsers(limit, offset int) ([]*entity.User, error) {
 			&user.Username,
 			&user.Email,
 			&user.PasswordHash,
+			pq.Array(&user.Roles),
+			&user.EmailVerifiedAt,
 			&user.CreatedAt,
 			&user.UpdatedAt,
 		)
@@ -181,3 +207,129 @@ func (r *UserRepository) ListUsers(limit, offset int) ([]*entity.User, error) {
 
 	return users, nil
 }
+
+func (r *UserRepository) GetUserByIdentity(ctx context.Context, provider, subject string) (*entity.User, error) {
+	query := `
+        SELECT u.id, u.username, u.email, u.password_hash, u.roles, u.email_verified_at, u.created_at, u.updated_at
+        FROM users u
+        JOIN user_identities i ON i.user_id = u.id
+        WHERE i.provider = $1 AND i.subject = $2`
+
+	user := &entity.User{}
+	err := r.db.QueryRowContext(ctx, query, provider, subject).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.PasswordHash,
+		pq.Array(&user.Roles),
+		&user.EmailVerifiedAt,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, repository.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting user by identity: %w", err)
+	}
+
+	return user, nil
+}
+
+func (r *UserRepository) CreateIdentity(ctx context.Context, userID int, provider, subject string) error {
+	query := `INSERT INTO user_identities (user_id, provider, subject)
+        VALUES ($1, $2, $3)`
+
+	if _, err := r.db.ExecContext(ctx, query, userID, provider, subject); err != nil {
+		return fmt.Errorf("error creating identity: %w", err)
+	}
+
+	return nil
+}
+
+// GetMFASettings returns empty settings for users who never enrolled.
+func (r *UserRepository) GetMFASettings(ctx context.Context, userID int) (*entity.MFASettings, error) {
+	query := `
+        SELECT user_id, totp_secret, totp_enabled, recovery_codes
+        FROM user_mfa
+        WHERE user_id = $1`
+
+	settings := &entity.MFASettings{}
+	err := r.db.QueryRowContext(ctx, query, userID).Scan(
+		&settings.UserID,
+		&settings.TOTPSecret,
+		&settings.TOTPEnabled,
+		pq.Array(&settings.RecoveryCodes),
+	)
+
+	if err == sql.ErrNoRows {
+		return &entity.MFASettings{UserID: userID, RecoveryCodes: []string{}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting mfa settings: %w", err)
+	}
+
+	return settings, nil
+}
+
+// ConsumeRecoveryCode removes the code in a single conditional update, so
+// that of two requests redeeming the same code only one succeeds.
+func (r *UserRepository) ConsumeRecoveryCode(ctx context.Context, userID int, hash string) (bool, error) {
+	query := `
+        UPDATE user_mfa
+        SET recovery_codes = array_remove(recovery_codes, $2)
+        WHERE user_id = $1 AND totp_enabled AND $2 = ANY(recovery_codes)`
+
+	result, err := r.db.ExecContext(ctx, query, userID, hash)
+	if err != nil {
+		return false, fmt.Errorf("error consuming recovery code: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error consuming recovery code: %w", err)
+	}
+	return rows == 1, nil
+}
+
+func (r *UserRepository) SaveMFASettings(ctx context.Context, settings *entity.MFASettings) error {
+	query := `
+        INSERT INTO user_mfa (user_id, totp_secret, totp_enabled, recovery_codes)
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (user_id) DO UPDATE
+        SET totp_secret = EXCLUDED.totp_secret,
+            totp_enabled = EXCLUDED.totp_enabled,
+            recovery_codes = EXCLUDED.recovery_codes`
+
+	recoveryCodes := settings.RecoveryCodes
+	if recoveryCodes == nil {
+		recoveryCodes = []string{}
+	}
+
+	_, err := r.db.ExecContext(ctx,
+		query,
+		settings.UserID,
+		settings.TOTPSecret,
+		settings.TOTPEnabled,
+		pq.Array(recoveryCodes),
+	)
+	if err != nil {
+		return fmt.Errorf("error saving mfa settings: %w", err)
+	}
+
+	return nil
+}
+
+func (r *UserRepository) MarkEmailVerified(ctx context.Context, id int) error {
+	query := `
+        UPDATE users
+        SET email_verified_at = CURRENT_TIMESTAMP
+        WHERE id = $1 AND email_verified_at IS NULL`
+
+	if _, err := r.db.ExecContext(ctx, query, id); err != nil {
+		return fmt.Errorf("error marking email verified: %w", err)
+	}
+
+	return nil
+}
@@ -1,12 +1,29 @@
 package repository
 
-import "github.com/gauss2302/microtest/user-service/internal/entity"
+import (
+	"context"
+	"errors"
+
+	"github.com/gauss2302/microtest/user-service/internal/entity"
+)
+
+// ErrNotFound is returned when no user matches a lookup.
+var ErrNotFound = errors.New("user not found")
 
 type UserRepository interface {
-	CreateUser(user *entity.User) (*entity.User, error)
-	GetUserByID(id int) (*entity.User, error)
-	GetUserByEmail(email string) (*entity.User, error)
-	UpdateUser(user *entity.User) (*entity.User, error)
-	DeleteUser(id int) error
-	ListUsers(limit, offset int) ([]*entity.User, error)
+	CreateUser(ctx context.Context, user *entity.User) (*entity.User, error)
+	GetUserByID(ctx context.Context, id int) (*entity.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*entity.User, error)
+	UpdateUser(ctx context.Context, user *entity.User) (*entity.User, error)
+	UpdatePasswordHash(ctx context.Context, id int, passwordHash string) error
+	MarkEmailVerified(ctx context.Context, id int) error
+	DeleteUser(ctx context.Context, id int) error
+	ListUsers(ctx context.Context, limit, offset int) ([]*entity.User, error)
+	GetUserByIdentity(ctx context.Context, provider, subject string) (*entity.User, error)
+	CreateIdentity(ctx context.Context, userID int, provider, subject string) error
+	GetMFASettings(ctx context.Context, userID int) (*entity.MFASettings, error)
+	SaveMFASettings(ctx context.Context, settings *entity.MFASettings) error
+	// ConsumeRecoveryCode removes the recovery code hash from the user's
+	// codes and reports false if another request removed it first
+	ConsumeRecoveryCode(ctx context.Context, userID int, hash string) (bool, error)
 }
@@ -1,14 +1,23 @@
 // internal/user/usecase/interface.go
 package usecase
 
-import "github.com/gauss2302/microtest/user-service/internal/entity"
+import (
+	"context"
+
+	"github.com/gauss2302/microtest/user-service/internal/entity"
+)
 
 type UserUsecase interface {
-	CreateUser(req *entity.CreateUserRequest) (*entity.User, error)
-	GetUserByID(id int) (*entity.User, error)
-	GetUserByEmail(email string) (*entity.User, error)
-	UpdateUser(id int, req *entity.UpdateUserRequest) (*entity.User, error)
-	DeleteUser(id int) error
-	ListUsers(limit, offset int) ([]*entity.User, error)
-	VerifyCredentials(email, password string) (*entity.User, error)
+	CreateUser(ctx context.Context, req *entity.CreateUserRequest) (*entity.User, error)
+	GetUserByID(ctx context.Context, id int) (*entity.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*entity.User, error)
+	UpdateUser(ctx context.Context, id int, req *entity.UpdateUserRequest) (*entity.User, error)
+	MarkEmailVerified(ctx context.Context, id int) (*entity.User, error)
+	DeleteUser(ctx context.Context, id int) error
+	ListUsers(ctx context.Context, limit, offset int) ([]*entity.User, error)
+	VerifyCredentials(ctx context.Context, email, password string) (*entity.User, error)
+	LinkIdentity(ctx context.Context, req *entity.LinkIdentityRequest) (*entity.User, error)
+	GetMFASettings(ctx context.Context, userID int) (*entity.MFASettings, error)
+	SaveMFASettings(ctx context.Context, userID int, settings *entity.MFASettings) error
+	ConsumeRecoveryCode(ctx context.Context, userID int, hash string) (bool, error)
 }
@@ -1,30 +1,57 @@
 package usecase
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"log/slog"
+
 	"github.com/gauss2302/microtest/user-service/internal/entity"
 	"github.com/gauss2302/microtest/user-service/internal/user/repository"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/gauss2302/microtest/user-service/pkg/password"
 )
 
+// ErrUnverifiedAccount is returned by LinkIdentity when the account with
+// the identity's email has not verified it.
+var ErrUnverifiedAccount = errors.New("account with this email has not verified it")
+
+// VerificationSender asks for a verification link to be mailed to the
+// user's current address.
+type VerificationSender interface {
+	SendVerification(ctx context.Context, userID int) error
+}
+
 type userUsecase struct {
-	repo repository.UserRepository
+	repo   repository.UserRepository
+	policy *password.Policy
+	hasher password.Hasher
+	// Optional; without it nobody is told to verify a changed address
+	verifications VerificationSender
 }
 
-func NewUserUsecase(repo repository.UserRepository) UserUsecase {
+func NewUserUsecase(repo repository.UserRepository, policy *password.Policy, hasher password.Hasher, verifications VerificationSender) UserUsecase {
 	return &userUsecase{
-		repo: repo,
+		repo:          repo,
+		policy:        policy,
+		hasher:        hasher,
+		verifications: verifications,
 	}
 }
 
-func (u *userUsecase) CreateUser(req *entity.CreateUserRequest) (*entity.User, error) {
+func (u *userUsecase) CreateUser(ctx context.Context, req *entity.CreateUserRequest) (*entity.User, error) {
 	// Check if user with this email exists
-	if _, err := u.repo.GetUserByEmail(req.Email); err == nil {
+	if _, err := u.repo.GetUserByEmail(ctx, req.Email); err == nil {
 		return nil, fmt.Errorf("user with email %s already exists", req.Email)
 	}
 
+	if err := u.policy.Check(req.Password, req.Email, req.Username); err != nil {
+		return nil, err
+	}
+
 	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	hashedPassword, err := u.hasher.Hash(req.Password)
 	if err != nil {
 		return nil, fmt.Errorf("error hashing password: %w", err)
 	}
@@ -32,22 +59,23 @@ func (u *userUsecase) CreateUser(req *entity.CreateUserRequest) (*entity.User, e
 	user := &entity.User{
 		Username:     req.Username,
 		Email:        req.Email,
-		PasswordHash: string(hashedPassword),
+		PasswordHash: hashedPassword,
+		Roles:        []string{entity.RoleUser},
 	}
 
-	return u.repo.CreateUser(user)
+	return u.repo.CreateUser(ctx, user)
 }
 
-func (u *userUsecase) GetUserByID(id int) (*entity.User, error) {
-	return u.repo.GetUserByID(id)
+func (u *userUsecase) GetUserByID(ctx context.Context, id int) (*entity.User, error) {
+	return u.repo.GetUserByID(ctx, id)
 }
 
-func (u *userUsecase) GetUserByEmail(email string) (*entity.User, error) {
-	return u.repo.GetUserByEmail(email)
+func (u *userUsecase) GetUserByEmail(ctx context.Context, email string) (*entity.User, error) {
+	return u.repo.GetUserByEmail(ctx, email)
 }
 
-func (u *userUsecase) UpdateUser(id int, req *entity.UpdateUserRequest) (*entity.User, error) {
-	user, err := u.repo.GetUserByID(id)
+func (u *userUsecase) UpdateUser(ctx context.Context, id int, req *entity.UpdateUserRequest) (*entity.User, error) {
+	user, err := u.repo.GetUserByID(ctx, id)
 	if err != nil {
 		return nil, err
 	}
@@ -56,47 +84,182 @@ func (u *userUsecase) UpdateUser(id int, req *entity.UpdateUserRequest) (*entity
 		user.Username = *req.Username
 	}
 
-	if req.Email != nil {
+	emailChanged := req.Email != nil && *req.Email != user.Email
+	if emailChanged {
 		// Check if new email is already taken
-		if *req.Email != user.Email {
-			if _, err := u.repo.GetUserByEmail(*req.Email); err == nil {
-				return nil, fmt.Errorf("email %s is already taken", *req.Email)
-			}
+		if _, err := u.repo.GetUserByEmail(ctx, *req.Email); err == nil {
+			return nil, fmt.Errorf("email %s is already taken", *req.Email)
 		}
+		// Identities are linked by verified email, so the new address
+		// has to be verified before it counts
 		user.Email = *req.Email
+		user.EmailVerifiedAt = nil
 	}
 
 	if req.Password != nil {
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(*req.Password), bcrypt.DefaultCost)
+		if err := u.policy.Check(*req.Password, user.Email, user.Username); err != nil {
+			return nil, err
+		}
+
+		hashedPassword, err := u.hasher.Hash(*req.Password)
 		if err != nil {
 			return nil, fmt.Errorf("error hashing password: %w", err)
 		}
-		user.PasswordHash = string(hashedPassword)
+		user.PasswordHash = hashedPassword
+	}
+
+	updated, err := u.repo.UpdateUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	if emailChanged && u.verifications != nil {
+		// The change stands; the user can ask for another link
+		if err := u.verifications.SendVerification(ctx, updated.ID); err != nil {
+			slog.ErrorContext(ctx, "Failed to request email verification", "user_id", updated.ID, "error", err)
+		}
 	}
+	return updated, nil
+}
 
-	return u.repo.UpdateUser(user)
+// MarkEmailVerified records that the user proved control of their email
+// address. Verifying again keeps the original timestamp.
+func (u *userUsecase) MarkEmailVerified(ctx context.Context, id int) (*entity.User, error) {
+	if err := u.repo.MarkEmailVerified(ctx, id); err != nil {
+		return nil, err
+	}
+	return u.repo.GetUserByID(ctx, id)
 }
 
-func (u *userUsecase) DeleteUser(id int) error {
-	return u.repo.DeleteUser(id)
+func (u *userUsecase) DeleteUser(ctx context.Context, id int) error {
+	return u.repo.DeleteUser(ctx, id)
 }
 
-func (u *userUsecase) ListUsers(limit, offset int) ([]*entity.User, error) {
+func (u *userUsecase) ListUsers(ctx context.Context, limit, offset int) ([]*entity.User, error) {
 	if limit <= 0 {
 		limit = 10 // default limit
 	}
-	return u.repo.ListUsers(limit, offset)
+	return u.repo.ListUsers(ctx, limit, offset)
 }
 
-func (u *userUsecase) VerifyCredentials(email, password string) (*entity.User, error) {
-	user, err := u.repo.GetUserByEmail(email)
+func (u *userUsecase) VerifyCredentials(ctx context.Context, email, password string) (*entity.User, error) {
+	user, err := u.repo.GetUserByEmail(ctx, email)
 	if err != nil {
 		return nil, fmt.Errorf("invalid email or password")
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+	ok, err := u.hasher.Verify(password, user.PasswordHash)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error verifying password", "user_id", user.ID, "error", err)
+	}
+	if !ok {
 		return nil, fmt.Errorf("invalid email or password")
 	}
 
+	u.rehashIfNeeded(ctx, user, password)
+
+	return user, nil
+}
+
+// rehashIfNeeded replaces a hash made with another algorithm or weaker
+// parameters than configured, which is only possible while the plaintext
+// is at hand. Failing to do so does not fail the login.
+func (u *userUsecase) rehashIfNeeded(ctx context.Context, user *entity.User, password string) {
+	if !u.hasher.NeedsRehash(user.PasswordHash) {
+		return
+	}
+
+	hashedPassword, err := u.hasher.Hash(password)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error rehashing password", "user_id", user.ID, "error", err)
+		return
+	}
+
+	if err := u.repo.UpdatePasswordHash(ctx, user.ID, hashedPassword); err != nil {
+		slog.ErrorContext(ctx, "Error rehashing password", "user_id", user.ID, "error", err)
+		return
+	}
+	user.PasswordHash = hashedPassword
+}
+
+// LinkIdentity returns the user an external identity belongs to. An unknown
+// identity is linked to the account with the same email if that account
+// verified it, and an account is created when there is none. Such accounts
+// get a random password, so they can only sign in through the provider
+// until reset.
+//
+// An account whose email was never verified is not linked: whoever
+// registered it may not own the address, and could have created it to
+// take over the owner's first provider login.
+func (u *userUsecase) LinkIdentity(ctx context.Context, req *entity.LinkIdentityRequest) (*entity.User, error) {
+	user, err := u.repo.GetUserByIdentity(ctx, req.Provider, req.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, repository.ErrNotFound) {
+		return nil, err
+	}
+
+	if !req.EmailVerified {
+		return nil, fmt.Errorf("email %s is not verified by %s", req.Email, req.Provider)
+	}
+
+	user, err = u.repo.GetUserByEmail(ctx, req.Email)
+	switch {
+	case err == nil && user.EmailVerifiedAt == nil:
+		return nil, ErrUnverifiedAccount
+	case err == nil:
+	case !errors.Is(err, repository.ErrNotFound):
+		return nil, err
+	default:
+		randomPassword := make([]byte, 32)
+		if _, err := rand.Read(randomPassword); err != nil {
+			return nil, fmt.Errorf("error generating password: %w", err)
+		}
+
+		hashedPassword, err := u.hasher.Hash(base64.RawStdEncoding.EncodeToString(randomPassword))
+		if err != nil {
+			return nil, fmt.Errorf("error hashing password: %w", err)
+		}
+
+		user, err = u.repo.CreateUser(ctx, &entity.User{
+			Username:     req.Email,
+			Email:        req.Email,
+			PasswordHash: hashedPassword,
+			Roles:        []string{entity.RoleUser},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// The provider vouched for the address
+		if err := u.repo.MarkEmailVerified(ctx, user.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := u.repo.CreateIdentity(ctx, user.ID, req.Provider, req.Subject); err != nil {
+		return nil, err
+	}
+
 	return user, nil
 }
+
+func (u *userUsecase) GetMFASettings(ctx context.Context, userID int) (*entity.MFASettings, error) {
+	if _, err := u.repo.GetUserByID(ctx, userID); err != nil {
+		return nil, err
+	}
+	return u.repo.GetMFASettings(ctx, userID)
+}
+
+func (u *userUsecase) SaveMFASettings(ctx context.Context, userID int, settings *entity.MFASettings) error {
+	if _, err := u.repo.GetUserByID(ctx, userID); err != nil {
+		return err
+	}
+	settings.UserID = userID
+	return u.repo.SaveMFASettings(ctx, settings)
+}
+
+func (u *userUsecase) ConsumeRecoveryCode(ctx context.Context, userID int, hash string) (bool, error) {
+	return u.repo.ConsumeRecoveryCode(ctx, userID, hash)
+}
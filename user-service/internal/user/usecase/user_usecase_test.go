@@ -0,0 +1,327 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gauss2302/microtest/user-service/internal/entity"
+	"github.com/gauss2302/microtest/user-service/internal/user/repository"
+	"github.com/gauss2302/microtest/user-service/pkg/password"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Mock repository
+type MockUserRepository struct {
+	mock.Mock
+}
+
+func (m *MockUserRepository) CreateUser(ctx context.Context, user *entity.User) (*entity.User, error) {
+	args := m.Called(user)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*entity.User), args.Error(1)
+}
+
+func (m *MockUserRepository) GetUserByID(ctx context.Context, id int) (*entity.User, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*entity.User), args.Error(1)
+}
+
+func (m *MockUserRepository) GetUserByEmail(ctx context.Context, email string) (*entity.User, error) {
+	args := m.Called(email)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*entity.User), args.Error(1)
+}
+
+func (m *MockUserRepository) UpdateUser(ctx context.Context, user *entity.User) (*entity.User, error) {
+	args := m.Called(user)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*entity.User), args.Error(1)
+}
+
+func (m *MockUserRepository) UpdatePasswordHash(ctx context.Context, id int, passwordHash string) error {
+	args := m.Called(id, passwordHash)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) MarkEmailVerified(ctx context.Context, id int) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) DeleteUser(ctx context.Context, id int) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) ListUsers(ctx context.Context, limit, offset int) ([]*entity.User, error) {
+	args := m.Called(limit, offset)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*entity.User), args.Error(1)
+}
+
+func (m *MockUserRepository) GetUserByIdentity(ctx context.Context, provider, subject string) (*entity.User, error) {
+	args := m.Called(provider, subject)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*entity.User), args.Error(1)
+}
+
+func (m *MockUserRepository) CreateIdentity(ctx context.Context, userID int, provider, subject string) error {
+	args := m.Called(userID, provider, subject)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) GetMFASettings(ctx context.Context, userID int) (*entity.MFASettings, error) {
+	args := m.Called(userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*entity.MFASettings), args.Error(1)
+}
+
+func (m *MockUserRepository) SaveMFASettings(ctx context.Context, settings *entity.MFASettings) error {
+	args := m.Called(settings)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) ConsumeRecoveryCode(ctx context.Context, userID int, hash string) (bool, error) {
+	args := m.Called(userID, hash)
+	return args.Bool(0), args.Error(1)
+}
+
+type MockVerificationSender struct {
+	mock.Mock
+}
+
+func (m *MockVerificationSender) SendVerification(ctx context.Context, userID int) error {
+	args := m.Called(userID)
+	return args.Error(0)
+}
+
+// newTestHasher prefers argon2id with low-cost parameters and still
+// accepts bcrypt.
+func newTestHasher() password.Hasher {
+	return password.NewMigratingHasher(password.NewArgon2idHasher(1024, 1, 1), password.NewBcryptHasher(bcrypt.MinCost))
+}
+
+func TestUserUsecase_CreateUser(t *testing.T) {
+	policy := &password.Policy{MinLength: 8, RequireDigit: true}
+
+	t.Run("password meeting the policy", func(t *testing.T) {
+		mockRepo := new(MockUserRepository)
+		hasher := newTestHasher()
+		usecase := NewUserUsecase(mockRepo, policy, hasher, nil)
+
+		mockRepo.On("GetUserByEmail", "alice@example.com").Return(nil, errors.New("user not found"))
+		mockRepo.On("CreateUser", mock.MatchedBy(func(u *entity.User) bool {
+			ok, err := hasher.Verify("s3cret-pass", u.PasswordHash)
+			return ok && err == nil && strings.HasPrefix(u.PasswordHash, "$argon2id$")
+		})).Return(&entity.User{ID: 1}, nil)
+
+		user, err := usecase.CreateUser(context.Background(), &entity.CreateUserRequest{Username: "alice", Email: "alice@example.com", Password: "s3cret-pass"})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, user.ID)
+	})
+
+	t.Run("password violating the policy", func(t *testing.T) {
+		mockRepo := new(MockUserRepository)
+		usecase := NewUserUsecase(mockRepo, policy, newTestHasher(), nil)
+
+		mockRepo.On("GetUserByEmail", "alice@example.com").Return(nil, errors.New("user not found"))
+
+		_, err := usecase.CreateUser(context.Background(), &entity.CreateUserRequest{Username: "alice", Email: "alice@example.com", Password: "short"})
+		var policyErr *password.PolicyError
+		assert.ErrorAs(t, err, &policyErr)
+		mockRepo.AssertNotCalled(t, "CreateUser", mock.Anything)
+	})
+}
+
+func TestUserUsecase_VerifyCredentials(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("s3cret-pass"), bcrypt.MinCost)
+	assert.NoError(t, err)
+
+	t.Run("bcrypt hash is upgraded to argon2id", func(t *testing.T) {
+		mockRepo := new(MockUserRepository)
+		hasher := newTestHasher()
+		usecase := NewUserUsecase(mockRepo, &password.Policy{}, hasher, nil)
+
+		mockRepo.On("GetUserByEmail", "alice@example.com").Return(&entity.User{ID: 1, PasswordHash: string(bcryptHash)}, nil)
+		mockRepo.On("UpdatePasswordHash", 1, mock.MatchedBy(func(hash string) bool {
+			ok, err := hasher.Verify("s3cret-pass", hash)
+			return ok && err == nil && strings.HasPrefix(hash, "$argon2id$")
+		})).Return(nil).Once()
+
+		_, err := usecase.VerifyCredentials(context.Background(), "alice@example.com", "s3cret-pass")
+		assert.NoError(t, err)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("current hash is kept", func(t *testing.T) {
+		mockRepo := new(MockUserRepository)
+		hasher := newTestHasher()
+		usecase := NewUserUsecase(mockRepo, &password.Policy{}, hasher, nil)
+
+		current, err := hasher.Hash("s3cret-pass")
+		assert.NoError(t, err)
+		mockRepo.On("GetUserByEmail", "alice@example.com").Return(&entity.User{ID: 1, PasswordHash: current}, nil)
+
+		_, err = usecase.VerifyCredentials(context.Background(), "alice@example.com", "s3cret-pass")
+		assert.NoError(t, err)
+		mockRepo.AssertNotCalled(t, "UpdatePasswordHash", mock.Anything, mock.Anything)
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		mockRepo := new(MockUserRepository)
+		usecase := NewUserUsecase(mockRepo, &password.Policy{}, newTestHasher(), nil)
+
+		mockRepo.On("GetUserByEmail", "alice@example.com").Return(&entity.User{ID: 1, PasswordHash: string(bcryptHash)}, nil)
+
+		_, err := usecase.VerifyCredentials(context.Background(), "alice@example.com", "wrong")
+		assert.Error(t, err)
+		mockRepo.AssertNotCalled(t, "UpdatePasswordHash", mock.Anything, mock.Anything)
+	})
+}
+
+func TestUserUsecase_LinkIdentity(t *testing.T) {
+	verifiedAt := time.Now()
+	request := &entity.LinkIdentityRequest{Provider: "google", Subject: "sub-1", Email: "alice@example.com", EmailVerified: true}
+
+	t.Run("known identity", func(t *testing.T) {
+		mockRepo := new(MockUserRepository)
+		usecase := NewUserUsecase(mockRepo, &password.Policy{}, newTestHasher(), nil)
+
+		mockRepo.On("GetUserByIdentity", "google", "sub-1").Return(&entity.User{ID: 1}, nil)
+
+		user, err := usecase.LinkIdentity(context.Background(), request)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, user.ID)
+		mockRepo.AssertNotCalled(t, "CreateIdentity", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("links the verified account with the same email", func(t *testing.T) {
+		mockRepo := new(MockUserRepository)
+		usecase := NewUserUsecase(mockRepo, &password.Policy{}, newTestHasher(), nil)
+
+		mockRepo.On("GetUserByIdentity", "google", "sub-1").Return(nil, repository.ErrNotFound)
+		mockRepo.On("GetUserByEmail", "alice@example.com").Return(&entity.User{ID: 2, EmailVerifiedAt: &verifiedAt}, nil)
+		mockRepo.On("CreateIdentity", 2, "google", "sub-1").Return(nil).Once()
+
+		user, err := usecase.LinkIdentity(context.Background(), request)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, user.ID)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("refuses an account that never verified the email", func(t *testing.T) {
+		mockRepo := new(MockUserRepository)
+		usecase := NewUserUsecase(mockRepo, &password.Policy{}, newTestHasher(), nil)
+
+		mockRepo.On("GetUserByIdentity", "google", "sub-1").Return(nil, repository.ErrNotFound)
+		mockRepo.On("GetUserByEmail", "alice@example.com").Return(&entity.User{ID: 2}, nil)
+
+		_, err := usecase.LinkIdentity(context.Background(), request)
+		assert.ErrorIs(t, err, ErrUnverifiedAccount)
+		mockRepo.AssertNotCalled(t, "CreateIdentity", mock.Anything, mock.Anything, mock.Anything)
+		mockRepo.AssertNotCalled(t, "MarkEmailVerified", mock.Anything)
+	})
+
+	t.Run("creates an account for a new email", func(t *testing.T) {
+		mockRepo := new(MockUserRepository)
+		usecase := NewUserUsecase(mockRepo, &password.Policy{}, newTestHasher(), nil)
+
+		mockRepo.On("GetUserByIdentity", "google", "sub-1").Return(nil, repository.ErrNotFound)
+		mockRepo.On("GetUserByEmail", "alice@example.com").Return(nil, repository.ErrNotFound)
+		mockRepo.On("CreateUser", mock.MatchedBy(func(u *entity.User) bool {
+			return u.Email == "alice@example.com" && u.PasswordHash != ""
+		})).Return(&entity.User{ID: 3}, nil).Once()
+		mockRepo.On("MarkEmailVerified", 3).Return(nil).Once()
+		mockRepo.On("CreateIdentity", 3, "google", "sub-1").Return(nil).Once()
+
+		user, err := usecase.LinkIdentity(context.Background(), request)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, user.ID)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("lookup failures are not taken for unknown users", func(t *testing.T) {
+		dbErr := errors.New("connection refused")
+
+		mockRepo := new(MockUserRepository)
+		usecase := NewUserUsecase(mockRepo, &password.Policy{}, newTestHasher(), nil)
+		mockRepo.On("GetUserByIdentity", "google", "sub-1").Return(nil, dbErr)
+
+		_, err := usecase.LinkIdentity(context.Background(), request)
+		assert.ErrorIs(t, err, dbErr)
+
+		mockRepo = new(MockUserRepository)
+		usecase = NewUserUsecase(mockRepo, &password.Policy{}, newTestHasher(), nil)
+		mockRepo.On("GetUserByIdentity", "google", "sub-1").Return(nil, repository.ErrNotFound)
+		mockRepo.On("GetUserByEmail", "alice@example.com").Return(nil, dbErr)
+
+		_, err = usecase.LinkIdentity(context.Background(), request)
+		assert.ErrorIs(t, err, dbErr)
+		mockRepo.AssertNotCalled(t, "CreateUser", mock.Anything)
+	})
+}
+
+func TestUserUsecase_UpdateUser(t *testing.T) {
+	newUser := func() *entity.User {
+		verifiedAt := time.Now()
+		return &entity.User{ID: 9, Username: "ivan", Email: "ivan@example.com", EmailVerifiedAt: &verifiedAt}
+	}
+
+	t.Run("changing the email unverifies it", func(t *testing.T) {
+		mockRepo := new(MockUserRepository)
+		sender := new(MockVerificationSender)
+		usecase := NewUserUsecase(mockRepo, &password.Policy{}, newTestHasher(), sender)
+
+		email := "victim@example.com"
+		mockRepo.On("GetUserByID", 9).Return(newUser(), nil)
+		mockRepo.On("GetUserByEmail", email).Return(nil, repository.ErrNotFound)
+		mockRepo.On("UpdateUser", mock.MatchedBy(func(u *entity.User) bool {
+			return u.Email == email && u.EmailVerifiedAt == nil
+		})).Return(&entity.User{ID: 9, Email: email}, nil).Once()
+		sender.On("SendVerification", 9).Return(nil).Once()
+
+		user, err := usecase.UpdateUser(context.Background(), 9, &entity.UpdateUserRequest{Email: &email})
+		assert.NoError(t, err)
+		assert.Nil(t, user.EmailVerifiedAt)
+		mockRepo.AssertExpectations(t)
+		sender.AssertExpectations(t)
+	})
+
+	t.Run("other changes keep the verification", func(t *testing.T) {
+		mockRepo := new(MockUserRepository)
+		sender := new(MockVerificationSender)
+		usecase := NewUserUsecase(mockRepo, &password.Policy{}, newTestHasher(), sender)
+
+		username := "ivan2"
+		email := "ivan@example.com"
+		mockRepo.On("GetUserByID", 9).Return(newUser(), nil)
+		mockRepo.On("UpdateUser", mock.MatchedBy(func(u *entity.User) bool {
+			return u.Username == username && u.EmailVerifiedAt != nil
+		})).Return(newUser(), nil).Once()
+
+		_, err := usecase.UpdateUser(context.Background(), 9, &entity.UpdateUserRequest{Username: &username, Email: &email})
+		assert.NoError(t, err)
+		mockRepo.AssertExpectations(t)
+		sender.AssertNotCalled(t, "SendVerification", mock.Anything)
+	})
+}
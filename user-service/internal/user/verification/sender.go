@@ -0,0 +1,53 @@
+// Package verification asks auth-service to mail email verification links.
+package verification
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gauss2302/microtest/pkg/correlation"
+	"github.com/gauss2302/microtest/pkg/telemetry"
+)
+
+// Sender calls auth-service's internal endpoint for sending a verification
+// link to a user's current address.
+type Sender struct {
+	url        string
+	httpClient *http.Client
+}
+
+func NewSender(authServiceURL string) *Sender {
+	return &Sender{
+		url:        strings.TrimSuffix(authServiceURL, "/") + "/auth/email/verify/send",
+		httpClient: &http.Client{Timeout: 10 * time.Second, Transport: &correlation.Transport{Base: telemetry.Transport(nil)}},
+	}
+}
+
+func (s *Sender) SendVerification(ctx context.Context, userID int) error {
+	body, err := json.Marshal(map[string]int{"user_id": userID})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to request verification: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("failed to request verification: status %d", resp.StatusCode)
+	}
+	return nil
+}
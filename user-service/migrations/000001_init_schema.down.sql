@@ -1,5 +0,0 @@
-DROP TRIGGER IF EXISTS update_users_updated_at ON users;
-
-DROP FUNCTION IF EXISTS update_updated_at_column ();
-
-DROP TABLE IF EXISTS users;
\ No newline at end of file
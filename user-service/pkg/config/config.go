@@ -1,30 +1,83 @@
 package config
 
-import "os"
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	sharedconfig "github.com/gauss2302/microtest/pkg/config"
+	"github.com/gauss2302/microtest/pkg/database"
+	"golang.org/x/crypto/bcrypt"
+)
 
 type Config struct {
-	DBHost     string
-	DBPort     string
-	DBUser     string
-	DBPassword string
-	DBName     string
-	ServerPort string
+	DBHost     string `env:"DB_HOST" default:"localhost"`
+	DBPort     string `env:"DB_PORT" default:"5432"`
+	DBUser     string `env:"DB_USER" default:"postgres"`
+	DBPassword string `env:"DB_PASSWORD" required:"true" secret:"true"`
+	DBName     string `env:"DB_NAME" default:"userdb"`
+	ServerPort string `env:"SERVER_PORT" default:"8080"`
+	// auth-service mails the verification link after an email change
+	AuthServiceURL string `env:"AUTH_SERVICE_URL" default:"http://auth-service:8080"`
+	// How long in-flight requests get to finish on shutdown
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT" default:"15s"`
+	// Minimum level logged (debug, info, warn or error) and the format
+	// of log lines (json or text)
+	LogLevel  string `env:"LOG_LEVEL" default:"info" oneof:"debug info warn error"`
+	LogFormat string `env:"LOG_FORMAT" default:"json" oneof:"json text"`
+
+	// Password policy
+	PasswordMinLength     int  `env:"PASSWORD_MIN_LENGTH" default:"8"`
+	PasswordRequireUpper  bool `env:"PASSWORD_REQUIRE_UPPER" default:"false"`
+	PasswordRequireLower  bool `env:"PASSWORD_REQUIRE_LOWER" default:"false"`
+	PasswordRequireDigit  bool `env:"PASSWORD_REQUIRE_DIGIT" default:"false"`
+	PasswordRequireSymbol bool `env:"PASSWORD_REQUIRE_SYMBOL" default:"false"`
+	// File of SHA-1 hashes of breached passwords, one per line
+	BreachedPasswordsFile string `env:"BREACHED_PASSWORDS_FILE"`
+
+	// Password hashing. New hashes use PasswordHasher ("argon2id" or
+	// "bcrypt"); hashes of the other algorithm are still accepted and
+	// replaced on the user's next login.
+	PasswordHasher    string `env:"PASSWORD_HASHER" default:"argon2id" oneof:"argon2id bcrypt"`
+	BcryptCost        int    `env:"BCRYPT_COST" default:"10"`
+	Argon2Memory      int    `env:"ARGON2_MEMORY" default:"65536"` // KiB
+	Argon2Iterations  int    `env:"ARGON2_ITERATIONS" default:"3"`
+	Argon2Parallelism int    `env:"ARGON2_PARALLELISM" default:"2"`
 }
 
+// LoadConfig loads the configuration, exiting if it is invalid.
 func LoadConfig() *Config {
-	return &Config{
-		DBHost:     getEnv("DB_HOST", "localhost"),
-		DBPort:     getEnv("DB_PORT", "5432"),
-		DBUser:     getEnv("DB_USER", "postgres"),
-		DBPassword: getEnv("DB_PASSWORD", "postgres123"),
-		DBName:     getEnv("DB_NAME", "userdb"),
-		ServerPort: getEnv("SERVER_PORT", "8080"),
+	cfg := &Config{}
+	sharedconfig.MustLoad(cfg)
+	return cfg
+}
+
+// Validate checks the settings that are valid on their own but not for
+// the password policy and hashers.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.PasswordMinLength < 1 {
+		errs = append(errs, errors.New("PASSWORD_MIN_LENGTH must be at least 1"))
+	}
+	if c.BcryptCost < bcrypt.MinCost || c.BcryptCost > bcrypt.MaxCost {
+		errs = append(errs, fmt.Errorf("BCRYPT_COST must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost))
+	}
+	if c.Argon2Memory < 1 || c.Argon2Iterations < 1 {
+		errs = append(errs, errors.New("ARGON2_MEMORY and ARGON2_ITERATIONS must be at least 1"))
+	}
+	if c.Argon2Parallelism < 1 || c.Argon2Parallelism > 255 {
+		errs = append(errs, errors.New("ARGON2_PARALLELISM must be between 1 and 255"))
 	}
+	return errors.Join(errs...)
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// Database returns the settings of the service's database.
+func (c *Config) Database() database.Config {
+	return database.Config{
+		Host:     c.DBHost,
+		Port:     c.DBPort,
+		User:     c.DBUser,
+		Password: c.DBPassword,
+		Name:     c.DBName,
 	}
-	return defaultValue
 }
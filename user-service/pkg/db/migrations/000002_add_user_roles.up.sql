@@ -0,0 +1,2 @@
+ALTER TABLE users
+ADD COLUMN IF NOT EXISTS roles TEXT[] NOT NULL DEFAULT '{user}';
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS update_user_mfa_updated_at ON user_mfa;
+
+DROP TABLE IF EXISTS user_mfa;
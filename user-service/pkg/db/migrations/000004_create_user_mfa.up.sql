@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS user_mfa (
+    user_id INTEGER PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    totp_secret VARCHAR(255) NOT NULL DEFAULT '',
+    totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    recovery_codes TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+DROP TRIGGER IF EXISTS update_user_mfa_updated_at ON user_mfa;
+
+CREATE TRIGGER update_user_mfa_updated_at BEFORE
+UPDATE ON user_mfa FOR EACH ROW
+EXECUTE FUNCTION update_updated_at_column ();
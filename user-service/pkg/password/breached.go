@@ -0,0 +1,68 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const hashPrefixLength = 5
+
+// BreachedList holds SHA-1 hashes of breached passwords, indexed by the
+// five-character prefix used by the Pwned Passwords k-anonymity range API.
+// The file has one full 40-character "HASH" or "HASH:COUNT" line per
+// password, as written by the Pwned Passwords downloader, so the service
+// needs no outbound calls. Responses of the range API itself hold only the
+// 35-character suffixes and have to be prefixed before they can be loaded.
+type BreachedList struct {
+	ranges map[string]map[string]struct{}
+}
+
+func LoadBreachedList(path string) (*BreachedList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password list: %w", err)
+	}
+	defer f.Close()
+
+	list := &BreachedList{ranges: make(map[string]map[string]struct{})}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		hash, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if hash == "" {
+			continue
+		}
+		if len(hash) != 2*sha1.Size {
+			return nil, fmt.Errorf("breached password list line %d: invalid hash", line)
+		}
+		list.add(strings.ToUpper(hash))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read breached password list: %w", err)
+	}
+
+	return list, nil
+}
+
+func (l *BreachedList) Contains(password string) bool {
+	prefix, suffix := splitHash(password)
+	_, ok := l.ranges[prefix][suffix]
+	return ok
+}
+
+func (l *BreachedList) add(hash string) {
+	prefix, suffix := hash[:hashPrefixLength], hash[hashPrefixLength:]
+	if l.ranges[prefix] == nil {
+		l.ranges[prefix] = make(map[string]struct{})
+	}
+	l.ranges[prefix][suffix] = struct{}{}
+}
+
+func splitHash(password string) (prefix, suffix string) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	return hash[:hashPrefixLength], hash[hashPrefixLength:]
+}
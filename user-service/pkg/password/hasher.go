@@ -0,0 +1,222 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Hasher identifiers as they appear in encoded hashes
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+var ErrUnknownAlgorithm = errors.New("unknown password hash algorithm")
+
+// Hasher creates and checks encoded password hashes. Encodings are
+// self-describing, so hashes of different algorithms and parameters can
+// be stored side by side in password_hash.
+type Hasher interface {
+	Algorithm() string
+	Hash(password string) (string, error)
+	// Verify reports whether password matches encoded.
+	Verify(password, encoded string) (bool, error)
+	// NeedsRehash reports whether encoded should be replaced by a fresh
+	// hash from this hasher.
+	NeedsRehash(encoded string) bool
+}
+
+// algorithmOf returns the algorithm that produced encoded. Bcrypt keeps
+// its own modular crypt format ($2a$, $2b$, $2y$), everything else is a
+// PHC string ($id$...).
+func algorithmOf(encoded string) string {
+	id, _, _ := strings.Cut(strings.TrimPrefix(encoded, "$"), "$")
+	switch id {
+	case "2a", "2b", "2y":
+		return AlgorithmBcrypt
+	default:
+		return id
+	}
+}
+
+// MigratingHasher hashes with Preferred and still verifies hashes made by
+// Legacy hashers. Every hash that is not a current Preferred one needs a
+// rehash, which moves users over as they log in.
+type MigratingHasher struct {
+	Preferred Hasher
+	Legacy    []Hasher
+}
+
+func NewMigratingHasher(preferred Hasher, legacy ...Hasher) *MigratingHasher {
+	return &MigratingHasher{Preferred: preferred, Legacy: legacy}
+}
+
+func (h *MigratingHasher) Algorithm() string {
+	return h.Preferred.Algorithm()
+}
+
+func (h *MigratingHasher) Hash(password string) (string, error) {
+	return h.Preferred.Hash(password)
+}
+
+func (h *MigratingHasher) Verify(password, encoded string) (bool, error) {
+	algorithm := algorithmOf(encoded)
+	for _, hasher := range append([]Hasher{h.Preferred}, h.Legacy...) {
+		if hasher.Algorithm() == algorithm {
+			return hasher.Verify(password, encoded)
+		}
+	}
+	return false, ErrUnknownAlgorithm
+}
+
+func (h *MigratingHasher) NeedsRehash(encoded string) bool {
+	return algorithmOf(encoded) != h.Preferred.Algorithm() || h.Preferred.NeedsRehash(encoded)
+}
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func NewBcryptHasher(cost int) *BcryptHasher {
+	return &BcryptHasher{Cost: cost}
+}
+
+func (h *BcryptHasher) Algorithm() string {
+	return AlgorithmBcrypt
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (h *BcryptHasher) Verify(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (h *BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost < h.Cost
+}
+
+// Argon2idHasher encodes hashes as PHC strings:
+//
+//	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+//
+// with salt and key in unpadded standard base64.
+type Argon2idHasher struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// NewArgon2idHasher uses the given cost parameters with a 16-byte salt and
+// a 32-byte key.
+func NewArgon2idHasher(memory, iterations uint32, parallelism uint8) *Argon2idHasher {
+	return &Argon2idHasher{
+		Memory:      memory,
+		Iterations:  iterations,
+		Parallelism: parallelism,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+func (h *Argon2idHasher) Algorithm() string {
+	return AlgorithmArgon2id
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify uses the parameters stored in encoded, not the hasher's own, so
+// that hashes keep working after the parameters are raised.
+func (h *Argon2idHasher) Verify(password, encoded string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params.Memory < h.Memory ||
+		params.Iterations < h.Iterations ||
+		params.Parallelism < h.Parallelism ||
+		uint32(len(salt)) < h.SaltLength ||
+		uint32(len(key)) < h.KeyLength
+}
+
+func decodeArgon2id(encoded string) (*Argon2idHasher, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != AlgorithmArgon2id {
+		return nil, nil, nil, fmt.Errorf("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid argon2id version: %w", err)
+	}
+	if version != argon2.Version {
+		return nil, nil, nil, fmt.Errorf("unsupported argon2id version %d", version)
+	}
+
+	params := &Argon2idHasher{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid argon2id parameters: %w", err)
+	}
+	if params.Memory == 0 || params.Iterations == 0 || params.Parallelism == 0 {
+		return nil, nil, nil, fmt.Errorf("invalid argon2id parameters")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid argon2id key: %w", err)
+	}
+	// An empty key would match any password
+	if len(salt) == 0 || len(key) == 0 {
+		return nil, nil, nil, fmt.Errorf("invalid argon2id hash")
+	}
+
+	return params, salt, key, nil
+}
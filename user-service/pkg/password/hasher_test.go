@@ -0,0 +1,81 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Low-cost parameters keep the tests fast
+func testArgon2id() *Argon2idHasher {
+	return NewArgon2idHasher(1024, 1, 1)
+}
+
+func TestArgon2idHasher(t *testing.T) {
+	hasher := testArgon2id()
+
+	encoded, err := hasher.Hash("s3cret-pass")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$"))
+
+	ok, err := hasher.Verify("s3cret-pass", encoded)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = hasher.Verify("wrong", encoded)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	other, err := hasher.Hash("s3cret-pass")
+	assert.NoError(t, err)
+	assert.NotEqual(t, encoded, other, "salts must differ")
+
+	assert.False(t, hasher.NeedsRehash(encoded))
+	assert.True(t, NewArgon2idHasher(2048, 1, 1).NeedsRehash(encoded))
+
+	t.Run("malformed hashes", func(t *testing.T) {
+		for _, encoded := range []string{
+			"",
+			"$argon2id$v=19$m=1024,t=1,p=1$c2FsdA",
+			"$argon2id$v=18$m=1024,t=1,p=1$c2FsdHNhbHQ$a2V5",
+			"$argon2id$v=19$m=0,t=1,p=1$c2FsdHNhbHQ$a2V5",
+			"$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHQ$",
+		} {
+			ok, err := hasher.Verify("", encoded)
+			assert.Error(t, err, encoded)
+			assert.False(t, ok)
+		}
+	})
+}
+
+func TestMigratingHasher(t *testing.T) {
+	bcryptHasher := NewBcryptHasher(bcrypt.MinCost)
+	hasher := NewMigratingHasher(testArgon2id(), bcryptHasher)
+
+	legacy, err := bcryptHasher.Hash("s3cret-pass")
+	assert.NoError(t, err)
+
+	ok, err := hasher.Verify("s3cret-pass", legacy)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, hasher.NeedsRehash(legacy))
+
+	current, err := hasher.Hash("s3cret-pass")
+	assert.NoError(t, err)
+	assert.Equal(t, AlgorithmArgon2id, algorithmOf(current))
+	assert.False(t, hasher.NeedsRehash(current))
+
+	ok, err = hasher.Verify("s3cret-pass", "$scrypt$ln=15,r=8,p=1$c2FsdA$a2V5")
+	assert.ErrorIs(t, err, ErrUnknownAlgorithm)
+	assert.False(t, ok)
+}
+
+func TestBcryptHasher_NeedsRehash(t *testing.T) {
+	encoded, err := NewBcryptHasher(bcrypt.MinCost).Hash("s3cret-pass")
+	assert.NoError(t, err)
+
+	assert.False(t, NewBcryptHasher(bcrypt.MinCost).NeedsRehash(encoded))
+	assert.True(t, NewBcryptHasher(bcrypt.MinCost+1).NeedsRehash(encoded))
+}
@@ -0,0 +1,83 @@
+// Package password decides which passwords user-service accepts.
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Policy describes what a new password must look like. The zero value
+// accepts anything.
+type Policy struct {
+	MinLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// Breached rejects passwords known from public breaches when set
+	Breached *BreachedList
+}
+
+// PolicyError lists every rule a password broke, so the user can fix all
+// of them at once.
+type PolicyError struct {
+	Violations []string
+}
+
+func (e *PolicyError) Error() string {
+	return "password " + strings.Join(e.Violations, ", ")
+}
+
+// Check validates password for an account identified by identifiers such
+// as its email and username, which the password must not equal.
+func (p *Policy) Check(password string, identifiers ...string) error {
+	var violations []string
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if p.RequireUpper && !upper {
+		violations = append(violations, "must contain an uppercase letter")
+	}
+	if p.RequireLower && !lower {
+		violations = append(violations, "must contain a lowercase letter")
+	}
+	if p.RequireDigit && !digit {
+		violations = append(violations, "must contain a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		violations = append(violations, "must contain a symbol")
+	}
+
+	for _, identifier := range identifiers {
+		if identifier != "" && strings.EqualFold(password, identifier) {
+			violations = append(violations, "must not match the email or username")
+			break
+		}
+	}
+
+	if p.Breached != nil && p.Breached.Contains(password) {
+		violations = append(violations, "appears in a known data breach")
+	}
+
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package password
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy_Check(t *testing.T) {
+	policy := &Policy{MinLength: 8, RequireUpper: true, RequireLower: true, RequireDigit: true, RequireSymbol: true}
+
+	tests := []struct {
+		name       string
+		password   string
+		violations int
+	}{
+		{"valid", "Corr3ct-horse", 0},
+		{"too short", "Ab1-", 1},
+		{"no uppercase", "corr3ct-horse", 1},
+		{"no lowercase", "CORR3CT-HORSE", 1},
+		{"no digit", "Correct-horse", 1},
+		{"no symbol", "Corr3cthorse", 1},
+		{"only lowercase", "abc", 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check(tt.password)
+			if tt.violations == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			var policyErr *PolicyError
+			if assert.ErrorAs(t, err, &policyErr) {
+				assert.Len(t, policyErr.Violations, tt.violations)
+			}
+		})
+	}
+
+	t.Run("matches identifier", func(t *testing.T) {
+		err := (&Policy{}).Check("Alice@Example.com", "alice@example.com", "alice")
+		assert.EqualError(t, err, "password must not match the email or username")
+	})
+}
+
+func TestBreachedList(t *testing.T) {
+	// SHA-1 of "password" and "123456"
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	content := "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:9545824\n7c4a8d09ca3762af61e59520943dc26494f8941b\n\n"
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	list, err := LoadBreachedList(path)
+	assert.NoError(t, err)
+	assert.True(t, list.Contains("password"))
+	assert.True(t, list.Contains("123456"))
+	assert.False(t, list.Contains("Corr3ct-horse"))
+
+	err = (&Policy{Breached: list}).Check("password")
+	assert.EqualError(t, err, "password appears in a known data breach")
+
+	assert.NoError(t, os.WriteFile(path, []byte("not-a-hash\n"), 0o600))
+	_, err = LoadBreachedList(path)
+	assert.Error(t, err)
+}